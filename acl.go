@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"sort"
+	"strings"
+)
+
+//==============================================================================================================================
+//	 Access Control - The ACL maps invoke function names to the roles allowed to call them, the role being the "role"
+//					  attribute of the invoker's certificate. Invoke consults it before dispatching. Every function
+//					  has a default entry, set_acl overrides it and removing the override restores the default. The
+//					  role "*" opens a function to any invoker, the handler then decides who may act on what, e.g.
+//					  only the owner of a track updates it. A function that has no entry is refused. Invokers with the
+//					  admin role are never locked out, so they can always repair the ACL. Legacy function names are
+//					  checked under the function they map to.
+//==============================================================================================================================
+type AclEntry struct {
+	Function			string		`json:"function"`
+	Roles				[]string	`json:"roles"`
+}
+
+var aclKey = "_acl"
+var aclPublic = "*"
+
+// The roles of every invoke function until set_acl overrides them
+var defaultAcl = map[string][]string{
+	"init":							{adminRole},
+	"set_config":					{adminRole},
+	"set_acl":						{adminRole},
+	"migrate":						{adminRole},
+	"set_high_value_policy":		{adminRole},
+	"freeze_account":				{adminRole},
+	"unfreeze_account":				{adminRole},
+	"set_tax_withholding":			{adminRole},
+	"set_rate_card":				{adminRole},
+	"set_distributor_config":		{adminRole},
+	"run_dunning":					{adminRole},
+	"process_takedown":				{adminRole},
+	"close_period":					{adminRole},
+	"close_pool":					{adminRole},
+	"distribute_subscription_pool":	{adminRole},
+	"set_fx_rate":					{oracleRole},
+	"attest_content_liveness":		{verifierRole},
+	"resolve_dispute":				{arbiterRole},
+
+	// the platform account's operator functions, the handlers check the caller is the platform account
+	"reset_metrics":				{aclPublic},
+	"import_accounts":				{aclPublic},
+	"register_alias":				{aclPublic},
+	"import_works":					{aclPublic},
+	"verify_placeholder":			{aclPublic},
+	"select_audit_sample":			{aclPublic},
+	"record_audit_outcome":			{aclPublic},
+	"purge_sandbox":				{aclPublic},
+	"register_play_source":			{aclPublic},
+
+	// plays and previews, the handlers check the caller is the listener, a payer registered for the source or an admin
+	"register_track":				{aclPublic},
+	"register_preview":				{aclPublic},
+	"register_album_play":			{aclPublic},
+	"register_playlist_play":		{aclPublic},
+
+	// self-service, the handlers act for the invoker on what the invoker owns or is party to
+	"register_me":					{aclPublic},
+	"add_account":					{aclPublic},		// the handler lets only admins create accounts
+	"create_track":					{aclPublic},
+	"add_simple_track":				{aclPublic},
+	"update_track":					{aclPublic},
+	"deactivate_track":				{aclPublic},
+	"transfer_track_ownership":		{aclPublic},
+	"accept_track_ownership":		{aclPublic},
+	"record_work_for_hire":			{aclPublic},
+	"schedule_release":				{aclPublic},
+	"create_promotion":				{aclPublic},
+	"add_album":					{aclPublic},
+	"add_playlist":					{aclPublic},
+	"set_payment_terms":			{aclPublic},
+	"issue_credit_note":			{aclPublic},
+	"grant_manager_access":			{aclPublic},
+	"revoke_manager_access":		{aclPublic},
+	"add_artist_to_roster":			{aclPublic},
+	"remove_artist_from_roster":	{aclPublic},
+	"accept_invitation":			{aclPublic},
+	"decline_invitation":			{aclPublic},
+	"begin_upload":					{aclPublic},
+	"append_upload":				{aclPublic},
+	"commit_upload":				{aclPublic},
+	"request_license":				{aclPublic},
+	"approve_license":				{aclPublic},
+	"reject_license":				{aclPublic},
+	"claim_placeholder":			{aclPublic},
+	"set_payout_hold":				{aclPublic},
+	"set_payout_threshold":			{aclPublic},
+	"set_preferred_currency":		{aclPublic},
+	"set_notification_preferences":	{aclPublic},
+	"test_notification":			{aclPublic},
+	"buy_track":					{aclPublic},
+	"tip_artist":					{aclPublic},
+	"subscribe":					{aclPublic},
+	"fund_pool":					{aclPublic},
+	"grant_advance":				{aclPublic},
+	"propose_split":				{aclPublic},
+	"counter_split_offer":			{aclPublic},
+	"accept_split_offer":			{aclPublic},
+	"reject_split_offer":			{aclPublic},
+	"approve_split":				{aclPublic},
+	"reject_split":					{aclPublic},
+	"open_dispute":					{aclPublic},
+	"add_dispute_evidence":			{aclPublic},
+	"request_takedown":				{aclPublic},
+	"settle_payment":				{aclPublic},
+	"settle_account":				{aclPublic},
+	"net_payments":					{aclPublic},
+	"reprice_tracks":				{aclPublic},
+	"continue_repricing":			{aclPublic},
+	"convert_and_settle_payment":	{aclPublic},
+	"convert_balance":				{aclPublic},
+	"mint_sandbox_credits":			{aclPublic},
+}
+
+func get_acl(stub shim.ChaincodeStubInterface) (map[string][]string, error) {
+
+	acl := map[string][]string{}
+
+	bytes, err := get_state(stub, aclKey)
+	if err != nil {
+		return acl, errors.New("Failed to get " + aclKey)
+	}
+	if len(bytes) > 0 {
+		err = json.Unmarshal(bytes, &acl)
+		if err != nil {
+			return acl, errors.New("Could not unmarshal " + aclKey)
+		}
+	}
+
+	return acl, nil
+}
+
+// The roles allowed to call a function, the override set with set_acl or else the default
+func acl_roles(acl map[string][]string, function string) ([]string, bool) {
+
+	roles, listed := acl[function]
+	if !listed {
+		roles, listed = defaultAcl[function]
+	}
+
+	return roles, listed
+}
+
+// Verifies the invoker may call the function
+func (t *SimpleChaincode) check_acl(stub shim.ChaincodeStubInterface, function string) error {
+
+	acl, err := get_acl(stub)
+	if err != nil {
+		return err
+	}
+	roles, listed := acl_roles(acl, function)
+	for _, role := range roles {
+		if role == aclPublic {
+			return nil
+		}
+	}
+
+	identity, err := get_identity(stub)
+	if err != nil {
+		return err
+	}
+	if identity.Role == adminRole {
+		return nil
+	}
+	if !listed {
+		return new_error("forbidden", "acl.not_listed", map[string]string{"function": function})
+	}
+	for _, role := range roles {
+		if role == identity.Role {
+			return nil
+		}
+	}
+
+	return new_error("forbidden", "acl.role_not_allowed", map[string]string{"function": function, "roles": strings.Join(roles, ", ")})
+}
+
+//==============================================================================================================================
+//  Invoke Functions
+//==============================================================================================================================
+
+// Sets the roles allowed to call a function, no roles restores the default
+func (t *SimpleChaincode) set_acl(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0				1
+	//		function		roles (JSON array, ["*"] opens the function to any invoker, empty restores the default)
+
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting function and roles")
+	}
+
+	err := t.check_caller_role(stub, adminRole)
+	if err != nil {
+		return nil, err
+	}
+
+	var roles []string
+	if args[1] != "" {
+		err = json.Unmarshal([]byte(args[1]), &roles)
+		if err != nil {
+			return nil, errors.New("Could not unmarshal roles: " + err.Error())
+		}
+	}
+
+	acl, err := get_acl(stub)
+	if err != nil {
+		return nil, err
+	}
+	if len(roles) == 0 {
+		delete(acl, args[0])
+	} else {
+		acl[args[0]] = roles
+	}
+
+	aclBytes, _ := json.Marshal(acl)
+	err = put_state(stub, aclKey, aclBytes)
+	if err != nil {
+		return nil, errors.New("Error putting " + aclKey + " on ledger")
+	}
+
+	return nil, nil
+}
+
+//==============================================================================================================================
+//		Query Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) query_acl(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	acl, err := get_acl(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	var functions []string
+	for function := range defaultAcl {
+		functions = append(functions, function)
+	}
+	for function := range acl {
+		if _, ok := defaultAcl[function]; !ok {
+			functions = append(functions, function)
+		}
+	}
+	sort.Strings(functions)
+
+	entries := []AclEntry{}
+	for _, function := range functions {
+		roles, _ := acl_roles(acl, function)
+		entries = append(entries, AclEntry{Function: function, Roles: roles})
+	}
+
+	entriesBytes, _ := json.Marshal(entries)
+
+	return entriesBytes, nil
+}
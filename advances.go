@@ -0,0 +1,352 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"strconv"
+	"time"
+)
+
+//==============================================================================================================================
+//	 Advances - A label pays an artist on its roster an advance against future royalties. grant_advance moves the
+//				amount from the label's balance to the artist's at once, in the artist's currency, with the rate at which
+//				it is recouped. From then on settlement redirects that share of what the artist is credited, after
+//				withholding tax, to the label until the advance is recouped, recorded as a recoupment payment from
+//				the artist to the label. Advances are recouped oldest first, each at its own rate, and only from
+//				credits in the artist's currency. The artist's account keeps the total still unrecouped.
+//==============================================================================================================================
+type Advance struct {
+	Id					string		`json:"id"`
+	LabelId				string		`json:"labelId"`
+	ArtistId			string		`json:"artistId"`
+	Amount				int64		`json:"amount"`
+	Currency			string		`json:"currency"`				// ISO 4217 code, that of the artist's account
+	RecoupmentBps		int64		`json:"recoupmentBps"`			// share of the artist's credits redirected to the label
+	Recouped			int64		`json:"recouped"`
+	Outstanding			int64		`json:"outstanding"`
+	GrantedAt			string		`json:"grantedAt"`
+	RecoupedAt			string		`json:"recoupedAt,omitempty"`
+}
+
+type AdvanceSummary struct {
+	AccountId			string		`json:"accountId"`
+	Advances			[]Advance	`json:"advances"`				// granted to the account, or by it for a label
+	Outstanding			int64		`json:"outstanding"`
+}
+
+// What settlement redirects from a credit to recoup an advance
+type Recoupment struct {
+	AdvanceId			string
+	LabelId				string
+	Amount				int64
+}
+
+var advanceIndexStr = "_advances"
+var accountAdvancesIndexStr = "account_advances"
+
+// Per-account index of the advances granted to an artist or by a label, "account_advances~<accountId>~<advanceId>"
+func account_advances_index_str(accountId string) string {
+	return index_key(accountAdvancesIndexStr, accountId)
+}
+
+func get_advance(stub shim.ChaincodeStubInterface, advanceId string) (Advance, error) {
+
+	var advance Advance
+
+	bytes, err := get_state(stub, advanceId)
+	if err != nil || len(bytes) == 0 {
+		return advance, errors.New("Could not fetch advance " + advanceId)
+	}
+	err = json.Unmarshal(bytes, &advance)
+	if err != nil {
+		return advance, errors.New("Could not unmarshal advance " + advanceId)
+	}
+
+	return advance, nil
+}
+
+func put_advance(stub shim.ChaincodeStubInterface, advance Advance) error {
+
+	advanceBytes, _ := json.Marshal(advance)
+	err := put_state(stub, advance.Id, advanceBytes)
+	if err != nil {
+		return errors.New("Error putting advance " + advance.Id + " on ledger")
+	}
+
+	return nil
+}
+
+// The recoupments of a credit to the recipient, without recording them
+func advance_recoupments(stub shim.ChaincodeStubInterface, cfg Config, recipient Account, credited int64, currency string) ([]Recoupment, int64, error) {
+
+	if recipient.Unrecouped == 0 || credited <= 0 || currency != currency_or_default(cfg, recipient.Currency) {
+		return nil, 0, nil
+	}
+
+	advanceIds, err := get_index_ids(stub, account_advances_index_str(recipient.Id))
+	if err != nil {
+		return nil, 0, err
+	}
+	var recoupments []Recoupment
+	var total int64
+	for _, advanceId := range advanceIds {
+		advance, err := get_advance(stub, advanceId)
+		if err != nil {
+			return nil, 0, err
+		}
+		if advance.ArtistId != recipient.Id || advance.Outstanding == 0 {
+			continue
+		}
+		amount := credited * advance.RecoupmentBps / 10000
+		if amount > advance.Outstanding {
+			amount = advance.Outstanding
+		}
+		if amount > credited-total {
+			amount = credited - total
+		}
+		if amount <= 0 {
+			continue
+		}
+		recoupments = append(recoupments, Recoupment{AdvanceId: advance.Id, LabelId: advance.LabelId, Amount: amount})
+		total += amount
+	}
+
+	return recoupments, total, nil
+}
+
+// Pays the recoupments of a settled payment to the labels with recoupment payments from the recipient, recorded on
+// both accounts. A label is read unless it is the sender.
+func record_recoupments(stub shim.ChaincodeStubInterface, cfg Config, payment Payment, sender *Account, recipient *Account, recoupments []Recoupment, currency string, now time.Time) error {
+
+	// recouped before the funds reach the recipient, on the settlement chaincode they move from the sender
+	external := settles_through_chaincode(stub, cfg)
+	for _, r := range recoupments {
+		advance, err := get_advance(stub, r.AdvanceId)
+		if err != nil {
+			return err
+		}
+		advance.Recouped += r.Amount
+		advance.Outstanding -= r.Amount
+		if advance.Outstanding == 0 {
+			advance.RecoupedAt = now.Format(time.RFC3339)
+		}
+		err = put_advance(stub, advance)
+		if err != nil {
+			return err
+		}
+		recipient.Unrecouped -= r.Amount
+
+		var recoupment Payment
+		recoupment.RecipientId	= r.LabelId
+		recoupment.SenderId		= recipient.Id
+		recoupment.Amount		= r.Amount
+		recoupment.Currency		= currency
+		recoupment.Completed	= true
+		recoupment.CreatedAt	= now.Format(time.RFC3339)
+		recoupment.UpdatedAt	= recoupment.CreatedAt
+		recoupment.DueDate		= recoupment.CreatedAt
+		recoupment.Period		= payment.Period
+		recoupment.Reference	= payment.Reference
+		recoupment.Advance		= advance.Id
+		if external {
+			recoupment.TransferRef, err = transfer_through_chaincode(stub, cfg, payment.SenderId, r.LabelId, r.Amount, currency, payment.Reference)
+			if err != nil {
+				return err
+			}
+		}
+
+		recipient.PendingPayments = append(recipient.PendingPayments, recoupment)
+		if r.LabelId == sender.Id {
+			sender.PendingPayments = append(sender.PendingPayments, recoupment)
+			if !external {
+				adjust_balance(cfg, sender, currency, r.Amount)
+			}
+		} else {
+			label, err := get_account(stub, r.LabelId)
+			if err != nil {
+				return err
+			}
+			label.PendingPayments = append(label.PendingPayments, recoupment)
+			if !external {
+				adjust_balance(cfg, &label, currency, r.Amount)
+			}
+			err = put_account(stub, label)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = emit_event(stub, "PaymentSettled", recoupment)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//==============================================================================================================================
+//  Invoke Functions
+//==============================================================================================================================
+
+// The invoking label pays an artist on its roster an advance
+func (t *SimpleChaincode) grant_advance(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0				1			2
+	//		artistId		amount		recoupment rate (bps)
+
+	if len(args) < 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting artistId, amount and recoupment rate")
+	}
+	amount, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil || amount <= 0 {
+		return nil, errors.New("Invalid amount " + args[1] + ", expecting a positive amount")
+	}
+	rateBps, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil || rateBps <= 0 || rateBps > 10000 {
+		return nil, errors.New("Invalid recoupment rate " + args[2] + ", expecting basis points between 1 and 10000")
+	}
+
+	labelId, err := t.get_caller_username(stub)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := get_tx_time(stub, cfg)
+	if err != nil {
+		return nil, err
+	}
+	period, err := resolve_period(cfg, now)
+	if err != nil {
+		return nil, err
+	}
+
+	label, err := get_account(stub, labelId)
+	if err != nil {
+		return nil, err
+	}
+	if label.Type != "label" {
+		return nil, errors.New("Only labels can grant advances")
+	}
+	artist, err := get_account(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if artist.LabelId != label.Id {
+		return nil, errors.New("Artist " + artist.Id + " is not on the roster of " + label.Id)
+	}
+	err = check_account_not_frozen(label)
+	if err != nil {
+		return nil, err
+	}
+	err = check_account_not_frozen(artist)
+	if err != nil {
+		return nil, err
+	}
+	currency := currency_or_default(cfg, artist.Currency)
+	external := settles_through_chaincode(stub, cfg)
+	if !external && balance_in(cfg, label, currency) < amount {
+		return nil, errors.New("Insufficient " + currency + " balance to advance " + args[1])
+	}
+
+	advanceId, err := append_id(stub, advanceIndexStr, "adv", true)
+	if err != nil {
+		return nil, errors.New("Error creating new id for advance")
+	}
+	advance := Advance{Id: string(advanceId), LabelId: label.Id, ArtistId: artist.Id, Amount: amount, Currency: currency, RecoupmentBps: rateBps, Outstanding: amount, GrantedAt: now.Format(time.RFC3339)}
+
+	var payment Payment
+	payment.RecipientId	= artist.Id
+	payment.SenderId	= label.Id
+	payment.Amount		= amount
+	payment.Currency	= currency
+	payment.Completed	= true
+	payment.CreatedAt	= advance.GrantedAt
+	payment.UpdatedAt	= advance.GrantedAt
+	payment.DueDate		= advance.GrantedAt
+	payment.Period		= period.Id
+	payment.Reference	= advance.Id
+	payment.Advance		= advance.Id
+
+	if external {
+		payment.TransferRef, err = transfer_through_chaincode(stub, cfg, label.Id, artist.Id, amount, currency, advance.Id)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		adjust_balance(cfg, &label, currency, -amount)
+		adjust_balance(cfg, &artist, currency, amount)
+	}
+	label.PendingPayments = append(label.PendingPayments, payment)
+	artist.PendingPayments = append(artist.PendingPayments, payment)
+	artist.Unrecouped += amount
+	err = put_account(stub, label)
+	if err != nil {
+		return nil, err
+	}
+	err = put_account(stub, artist)
+	if err != nil {
+		return nil, err
+	}
+
+	err = put_advance(stub, advance)
+	if err != nil {
+		return nil, err
+	}
+	err = add_to_index(stub, account_advances_index_str(artist.Id), advance.Id)
+	if err != nil {
+		return nil, err
+	}
+	err = add_to_index(stub, account_advances_index_str(label.Id), advance.Id)
+	if err != nil {
+		return nil, err
+	}
+	err = emit_event(stub, "PaymentSettled", payment)
+	if err != nil {
+		return nil, err
+	}
+
+	advanceBytes, _ := json.Marshal(advance)
+
+	return advanceBytes, nil
+}
+
+//==============================================================================================================================
+//		Query Functions
+//==============================================================================================================================
+
+// The advances granted to an artist, or by a label, with what is still unrecouped
+func (t *SimpleChaincode) get_advances(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			1
+	//		accountId
+
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting accountId")
+	}
+
+	advanceIds, err := get_index_ids(stub, account_advances_index_str(args[1]))
+	if err != nil {
+		return nil, err
+	}
+
+	summary := AdvanceSummary{AccountId: args[1], Advances: []Advance{}}
+	for _, advanceId := range advanceIds {
+		advance, err := get_advance(stub, advanceId)
+		if err != nil {
+			return nil, err
+		}
+		summary.Advances = append(summary.Advances, advance)
+		summary.Outstanding += advance.Outstanding
+	}
+
+	summaryBytes, _ := json.Marshal(summary)
+
+	return summaryBytes, nil
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"time"
+)
+
+//==============================================================================================================================
+//	 Albums - A group of tracks sold and played as a bundle. An album play charges the bundle price once and spreads it
+//			  evenly over the member tracks, each track's part is then split among that track's beneficiaries.
+//==============================================================================================================================
+type Album struct {
+	Id					string		`json:"id"`
+	Title				string		`json:"title"`
+	Artist				string		`json:"artist"`
+	TrackIds			[]string	`json:"trackIds"`
+	BundlePrice			int64		`json:"bundlePrice"`
+	Owner				string		`json:"owner"`
+}
+
+var albumIndexStr = "album"
+
+func get_album(stub shim.ChaincodeStubInterface, albumId string) (Album, error) {
+
+	var album Album
+
+	bytes, err := get_state(stub, albumId)
+	if err != nil || len(bytes) == 0 {
+		return album, errors.New("Could not fetch album " + albumId)
+	}
+	err = json.Unmarshal(bytes, &album)
+	if err != nil {
+		return album, errors.New("Could not unmarshal album " + albumId)
+	}
+
+	return album, nil
+}
+
+// Spreads an amount evenly over n parts, the rounding remainder goes to the first part
+func even_shares(amount int64, n int) []int64 {
+
+	shares := make([]int64, n)
+	if n == 0 {
+		return shares
+	}
+	for i := range shares {
+		shares[i] = amount / int64(n)
+	}
+	shares[0] += amount % int64(n)
+
+	return shares
+}
+
+//==============================================================================================================================
+//  Invoke Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) add_album(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		album JSON object (as string)
+
+	if len(args) < 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting album JSON")
+	}
+
+	var album Album
+	err := json.Unmarshal([]byte(args[0]), &album)
+	if err != nil {
+		return nil, errors.New("Could not unmarshal album: " + err.Error())
+	}
+	if album.Id == "" {
+		return nil, errors.New("id is required")
+	}
+	err = check_record_id("album", album.Id)
+	if err != nil {
+		return nil, err
+	}
+	if len(album.TrackIds) == 0 {
+		return nil, errors.New("An album needs at least one track")
+	}
+	if album.BundlePrice < 0 {
+		return nil, errors.New("Bundle price cannot be negative")
+	}
+
+	existing, err := get_state(stub, album.Id)
+	if err != nil {
+		return nil, errors.New("Could not fetch " + album.Id)
+	}
+	if len(existing) > 0 {
+		return nil, errors.New("Album " + album.Id + " already exists")
+	}
+
+	seen := map[string]bool{}
+	for _, trackId := range album.TrackIds {
+		if seen[trackId] {
+			return nil, errors.New("Track " + trackId + " is listed twice")
+		}
+		seen[trackId] = true
+
+		bytes, err := get_state(stub, trackId)
+		if err != nil || len(bytes) == 0 {
+			return nil, errors.New("Could not fetch track " + trackId)
+		}
+	}
+
+	album.Owner, err = t.get_caller_username(stub)
+	if err != nil {
+		album.Owner = album.Artist
+	}
+
+	err = add_to_index(stub, albumIndexStr, album.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	albumBytes, _ := json.Marshal(album)
+	err = put_state(stub, album.Id, albumBytes)
+	if err != nil {
+		return nil, errors.New("Error putting album on ledger")
+	}
+
+	return nil, nil
+}
+
+func (t *SimpleChaincode) register_album_play(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0			1			2
+	//		albumId		played_by	source
+
+	if len(args) < 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting albumId, played_by and source")
+	}
+	err := t.check_play_submitter(stub, args[1], args[2])
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, distributor, err := t.resolve_tx_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := get_tx_time(stub, cfg)
+	if err != nil {
+		return nil, err
+	}
+	period, err := resolve_period(cfg, now)
+	if err != nil {
+		return nil, err
+	}
+
+	album, err := get_album(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	senderBytes, err := get_state(stub, args[1])
+	if err != nil || len(senderBytes) == 0 {
+		return nil, errors.New("Could not fetch account " + args[1])
+	}
+	var sender Account
+	err = json.Unmarshal(senderBytes, &sender)
+	if err != nil {
+		return nil, errors.New("Could not unmarshal account " + args[1])
+	}
+	dueDate, err := payment_due_date(cfg, sender, now)
+	if err != nil {
+		return nil, err
+	}
+	err = t.check_play_source(stub, args[2])
+	if err != nil {
+		return nil, err
+	}
+
+	playId, err := append_id(stub, playIndexStr, "pl", true)
+	if err != nil {
+		return nil, errors.New("Error creating new id for play")
+	}
+
+	new_payment := func(recipientId string, amount int64) Payment {
+		var payment Payment
+		payment.RecipientId	= recipientId
+		payment.SenderId	= sender.Id
+		payment.Amount		= amount
+		payment.Currency	= cfg.Currency
+		payment.CreatedAt	= now.Format(time.RFC3339)
+		payment.DueDate		= dueDate
+		payment.Period		= period.Id
+		payment.Reference	= string(playId)
+		payment.Source		= args[2]
+		return payment
+	}
+
+	// the bundle is priced in the platform currency, the play is paid in that of the submitter
+	platformCfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	price, err := convert_amount(stub, album.BundlePrice, platformCfg.Currency, cfg.Currency)
+	if err != nil {
+		return nil, err
+	}
+
+	platformFee, platformLine := platform_fee_line(cfg, price)
+	fee, feeLines := distributor_fee_lines(cfg, distributor, price-platformFee)
+	if platformLine != nil {
+		feeLines = append([]Payment{*platformLine}, feeLines...)
+	}
+	shares := even_shares(price-platformFee-fee, len(album.TrackIds))
+
+	var payments []Payment
+	for i, trackId := range album.TrackIds {
+
+		tr, err := fetch_track(stub, trackId)
+		if err != nil {
+			return nil, err
+		}
+		if !is_track_active(tr) {
+			return nil, errors.New("Track " + trackId + " is inactive and cannot be played")
+		}
+		err = check_track_released(stub, &tr, trackId, now)
+		if err != nil {
+			return nil, err
+		}
+
+		var trackTotal int64
+		for _, payout := range rights_payouts(cfg, track_split_at(tr, now), shares[i]) {
+			trackTotal += payout.Amount
+			payment := new_payment(payout.RecipientId, payout.Amount)
+			payment.Rights = payout.Rights
+			payments = append(payments, payment)
+		}
+
+		err = add_track_earnings(stub, trackId, period.Id, trackTotal)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, line := range feeLines {
+		if line.RecipientId != sender.Id {
+			payment := new_payment(line.RecipientId, line.Amount)
+			payment.Fee = line.Fee
+			payments = append(payments, payment)
+		}
+	}
+
+	// Each recipient is written once for all its lines, the sender is read back afterwards so a sender that is also a
+	// recipient keeps both sides
+	var playAmount int64
+	for _, payment := range payments {
+		playAmount += payment.Amount
+	}
+	err = append_to_recipients(stub, payments)
+	if err != nil {
+		return nil, err
+	}
+	err = append_pending_payments(stub, sender.Id, payments)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = append_id(stub, account_plays_index_str(sender.Id), string(playId), false)
+	if err != nil {
+		return nil, errors.New("Error indexing play for account " + sender.Id)
+	}
+
+	var play Play
+	play.Id				= string(playId)
+	play.AlbumId		= album.Id
+	play.PlayedBy		= sender.Id
+	play.Amount			= playAmount
+	play.Timestamp		= now.Format(time.RFC3339)
+	play.SubmittedAt	= now.Format(time.RFC3339)
+	play.CreatedAt		= play.SubmittedAt
+	play.UpdatedAt		= play.SubmittedAt
+	play.Period			= period.Id
+	play.Payments		= payments
+	play.Distributor	= distributor.DistributorId
+	play.Currency		= cfg.Currency
+	play.Source			= args[2]
+	err = seal_play_terms(stub, cfg, &play)
+	if err != nil {
+		return nil, err
+	}
+
+	playBytes, _ := json.Marshal(play)
+	err = put_state(stub, play.Id, playBytes)
+	if err != nil {
+		return nil, errors.New("Error putting play data on ledger")
+	}
+	err = add_to_index(stub, period_plays_index_str(play.Period), play.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	err = add_source_play(stub, play.Source, play.Period, playAmount)
+	if err != nil {
+		return nil, err
+	}
+
+	err = emit_event(stub, "PlayRegistered", play)
+	if err != nil {
+		return nil, err
+	}
+	err = emit_payments_created(stub, payments)
+	if err != nil {
+		return nil, err
+	}
+
+	return playId, nil
+}
+
+//==============================================================================================================================
+//		Query Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) query_album(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			1
+	//		albumId
+
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting albumId")
+	}
+
+	album, err := get_album(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	albumBytes, _ := json.Marshal(album)
+
+	return albumBytes, nil
+}
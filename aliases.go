@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"strings"
+	"time"
+)
+
+//==============================================================================================================================
+//	 Legacy Aliases - Entities migrated from the old system keep answering to their old ids. An alias maps a legacy id
+//					  within a namespace (the kind of entity in the old system, e.g. account or track) to the on-chain id.
+//					  A legacy id is aliased once per namespace, an on-chain id can have several aliases.
+//==============================================================================================================================
+type Alias struct {
+	Namespace			string		`json:"namespace"`
+	LegacyId			string		`json:"legacyId"`
+	Id					string		`json:"id"`				// on-chain id
+	CreatedAt			string		`json:"createdAt"`
+}
+
+var aliasIndexStr = "alias"
+var aliasesOfIndexStr = "aliases_of"
+
+// Aliases are kept as "alias~<namespace>~<legacyId>"
+func alias_key(namespace string, legacyId string) string {
+	return index_key(index_key(aliasIndexStr, namespace), legacyId)
+}
+
+// Per-entity index of its aliases, "aliases_of~<id>~<namespace>~<legacyId>"
+func aliases_of_index_str(id string) string {
+	return index_key(aliasesOfIndexStr, id)
+}
+
+func get_alias(stub shim.ChaincodeStubInterface, namespace string, legacyId string) (Alias, bool, error) {
+
+	var alias Alias
+
+	bytes, err := get_state(stub, alias_key(namespace, legacyId))
+	if err != nil {
+		return alias, false, errors.New("Failed to get alias " + namespace + "/" + legacyId)
+	}
+	if len(bytes) == 0 {
+		return alias, false, nil
+	}
+	err = json.Unmarshal(bytes, &alias)
+	if err != nil {
+		return alias, false, errors.New("Could not unmarshal alias " + namespace + "/" + legacyId)
+	}
+
+	return alias, true, nil
+}
+
+//==============================================================================================================================
+//  Invoke Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) register_alias(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0			1			2
+	//		namespace	legacyId	on-chain id
+
+	if len(args) < 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting namespace, legacyId and id")
+	}
+	if args[0] == "" || args[1] == "" || strings.Contains(args[0], "~") || strings.Contains(args[1], "~") {
+		return nil, errors.New("Invalid alias " + args[0] + "/" + args[1])
+	}
+
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	err = t.check_platform_access(stub, cfg, "register aliases")
+	if err != nil {
+		return nil, err
+	}
+	now, err := get_tx_time(stub, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	existing, found, err := get_alias(stub, args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		return nil, errors.New("Legacy id " + args[0] + "/" + args[1] + " is already an alias of " + existing.Id)
+	}
+	entity, err := get_state(stub, args[2])
+	if err != nil || len(entity) == 0 {
+		return nil, errors.New("Could not fetch " + args[2])
+	}
+
+	var alias Alias
+	alias.Namespace	= args[0]
+	alias.LegacyId	= args[1]
+	alias.Id		= args[2]
+	alias.CreatedAt	= now.Format(time.RFC3339)
+
+	aliasBytes, _ := json.Marshal(alias)
+	err = put_state(stub, alias_key(alias.Namespace, alias.LegacyId), aliasBytes)
+	if err != nil {
+		return nil, errors.New("Error putting alias on ledger")
+	}
+
+	err = add_to_index(stub, aliases_of_index_str(alias.Id), index_key(alias.Namespace, alias.LegacyId))
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+//==============================================================================================================================
+//		Query Functions
+//==============================================================================================================================
+
+// Returns the entity a legacy id is an alias of
+func (t *SimpleChaincode) get_by_legacy_id(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			1			2
+	//		namespace	legacyId
+
+	if len(args) < 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting namespace and legacyId")
+	}
+
+	alias, found, err := get_alias(stub, args[1], args[2])
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, errors.New("No alias found for legacy id " + args[1] + "/" + args[2])
+	}
+
+	entity, err := get_state(stub, alias.Id)
+	if err != nil || len(entity) == 0 {
+		return nil, errors.New("Could not fetch " + alias.Id)
+	}
+
+	return entity, nil
+}
+
+func (t *SimpleChaincode) get_aliases(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			1
+	//		on-chain id
+
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting id")
+	}
+
+	legacyIds, err := get_index_ids(stub, aliases_of_index_str(args[1]))
+	if err != nil {
+		return nil, err
+	}
+
+	aliases := []Alias{}
+	for _, legacyId := range legacyIds {
+		parts := strings.SplitN(legacyId, "~", 2)
+		alias, found, err := get_alias(stub, parts[0], parts[1])
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			aliases = append(aliases, alias)
+		}
+	}
+
+	aliasesBytes, _ := json.Marshal(aliases)
+
+	return aliasesBytes, nil
+}
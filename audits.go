@@ -0,0 +1,262 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"math/rand"
+	"strconv"
+	"time"
+)
+
+//==============================================================================================================================
+//	 Spot Audits - The platform draws a sample of the plays of a period for a manual audit of how they were distributed.
+//				   The sample is drawn with a random generator seeded by the tx id and the period, so every peer
+//				   selects the same plays. The selection is recorded with the seed, and the auditor records the outcome
+//				   of each selected play on it.
+//==============================================================================================================================
+type AuditRecord struct {
+	PlayId				string		`json:"playId"`
+	Outcome				string		`json:"outcome,omitempty"`		// passed, failed or inconclusive, empty until audited
+	Notes				string		`json:"notes,omitempty"`
+	AuditedAt			string		`json:"auditedAt,omitempty"`
+}
+
+type AuditSample struct {
+	Id					string			`json:"id"`
+	Period				string			`json:"period"`
+	Seed				string			`json:"seed"`				// tx id the sample was drawn with
+	Population			int				`json:"population"`			// plays in the period when drawn
+	Records				[]AuditRecord	`json:"records"`
+	SelectedAt			string			`json:"selectedAt"`
+}
+
+var AuditOutcomes = map[string]bool{
+	"passed":		true,
+	"failed":		true,
+	"inconclusive":	true,
+}
+
+var auditSampleIndexStr = "_audit_samples"
+var periodAuditSamplesIndexStr = "audit_samples"
+
+// Per-period index of the samples drawn from it, "audit_samples~<periodId>~<sampleId>"
+func period_audit_samples_index_str(periodId string) string {
+	return index_key(periodAuditSamplesIndexStr, periodId)
+}
+
+func get_audit_sample(stub shim.ChaincodeStubInterface, sampleId string) (AuditSample, error) {
+
+	var sample AuditSample
+
+	bytes, err := get_state(stub, sampleId)
+	if err != nil || len(bytes) == 0 {
+		return sample, errors.New("Could not fetch audit sample " + sampleId)
+	}
+	err = json.Unmarshal(bytes, &sample)
+	if err != nil {
+		return sample, errors.New("Could not unmarshal audit sample " + sampleId)
+	}
+
+	return sample, nil
+}
+
+func put_audit_sample(stub shim.ChaincodeStubInterface, sample AuditSample) error {
+
+	sampleBytes, _ := json.Marshal(sample)
+	err := put_state(stub, sample.Id, sampleBytes)
+	if err != nil {
+		return errors.New("Error putting audit sample " + sample.Id + " on ledger")
+	}
+
+	return nil
+}
+
+// Picks n of the ids with a generator seeded by the seed, in the order they were picked
+func sample_ids(ids []string, n int, seed string) []string {
+
+	digest := sha256.Sum256([]byte(seed))
+	rnd := rand.New(rand.NewSource(int64(binary.BigEndian.Uint64(digest[:8]))))
+
+	pool := append([]string{}, ids...)
+	if n > len(pool) {
+		n = len(pool)
+	}
+	for i := 0; i < n; i++ {
+		j := i + rnd.Intn(len(pool)-i)
+		pool[i], pool[j] = pool[j], pool[i]
+	}
+
+	return pool[:n]
+}
+
+//==============================================================================================================================
+//  Invoke Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) select_audit_sample(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0			1
+	//		periodId	sample size
+
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting periodId and sample size")
+	}
+	size, err := strconv.Atoi(args[1])
+	if err != nil || size < 1 || size > maxPageSize {
+		return nil, errors.New("Invalid sample size " + args[1] + ", expecting a number between 1 and " + strconv.Itoa(maxPageSize))
+	}
+
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	err = t.check_platform_access(stub, cfg, "select audit samples")
+	if err != nil {
+		return nil, err
+	}
+	now, err := get_tx_time(stub, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// the index lists the plays in key order, the same on every peer
+	playIds, err := get_index_ids(stub, period_plays_index_str(args[0]))
+	if err != nil {
+		return nil, err
+	}
+	if len(playIds) == 0 {
+		return nil, errors.New("No plays found for period " + args[0])
+	}
+
+	sampleId, err := append_id(stub, auditSampleIndexStr, "as", true)
+	if err != nil {
+		return nil, errors.New("Error creating new id for audit sample")
+	}
+	err = add_to_index(stub, period_audit_samples_index_str(args[0]), string(sampleId))
+	if err != nil {
+		return nil, err
+	}
+
+	var sample AuditSample
+	sample.Id			= string(sampleId)
+	sample.Period		= args[0]
+	sample.Seed			= stub.GetTxID()
+	sample.Population	= len(playIds)
+	sample.SelectedAt	= now.Format(time.RFC3339)
+	for _, playId := range sample_ids(playIds, size, sample.Seed+"~"+sample.Period) {
+		sample.Records = append(sample.Records, AuditRecord{PlayId: playId})
+	}
+
+	err = put_audit_sample(stub, sample)
+	if err != nil {
+		return nil, err
+	}
+
+	sampleBytes, _ := json.Marshal(sample)
+
+	return sampleBytes, nil
+}
+
+func (t *SimpleChaincode) record_audit_outcome(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0			1			2			3
+	//		sampleId	playId		outcome		notes (optional)
+
+	if len(args) < 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting sampleId, playId and outcome")
+	}
+	if !AuditOutcomes[args[2]] {
+		return nil, errors.New("Audit outcome not recognized: " + args[2])
+	}
+
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	err = t.check_platform_access(stub, cfg, "record audit outcomes")
+	if err != nil {
+		return nil, err
+	}
+	now, err := get_tx_time(stub, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	sample, err := get_audit_sample(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	for i, record := range sample.Records {
+		if record.PlayId != args[1] {
+			continue
+		}
+		sample.Records[i].Outcome	= args[2]
+		sample.Records[i].AuditedAt	= now.Format(time.RFC3339)
+		if len(args) > 3 {
+			sample.Records[i].Notes = args[3]
+		}
+
+		return nil, put_audit_sample(stub, sample)
+	}
+
+	return nil, errors.New("Play " + args[1] + " was not selected in audit sample " + args[0])
+}
+
+//==============================================================================================================================
+//		Query Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) query_audit_sample(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			1
+	//		sampleId
+
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting sampleId")
+	}
+
+	sample, err := get_audit_sample(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	sampleBytes, _ := json.Marshal(sample)
+
+	return sampleBytes, nil
+}
+
+func (t *SimpleChaincode) get_audit_samples(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			1
+	//		periodId
+
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting periodId")
+	}
+
+	sampleIds, err := get_index_ids(stub, period_audit_samples_index_str(args[1]))
+	if err != nil {
+		return nil, err
+	}
+
+	samples := []AuditSample{}
+	for _, sampleId := range sampleIds {
+		sample, err := get_audit_sample(stub, sampleId)
+		if err != nil {
+			return nil, err
+		}
+		samples = append(samples, sample)
+	}
+
+	samplesBytes, _ := json.Marshal(samples)
+
+	return samplesBytes, nil
+}
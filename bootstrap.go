@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+//==============================================================================================================================
+//	 Genesis Bootstrap - Init takes an optional bootstrap document that seeds a fresh deployment in the deploy
+//						 transaction: the platform configuration, the platform's admin account and any initial accounts
+//						 and tracks. Everything is created through the same functions invokes use, so it is validated
+//						 and indexed the same way. Indexes need no seeding, every index entry is a key of its own and
+//						 the id lists start out empty. A ledger that already has a configuration is not bootstrapped
+//						 again, a bootstrapped ledger starts at the current schema version.
+//==============================================================================================================================
+type Bootstrap struct {
+	Config				json.RawMessage		`json:"config,omitempty"`		// as for set_config, missing fields keep their defaults
+	Admin				*Account			`json:"admin,omitempty"`		// becomes the platform account unless the config names one
+	Accounts			[]Account			`json:"accounts,omitempty"`
+	Tracks				[]json.RawMessage	`json:"tracks,omitempty"`		// as for create_track
+}
+
+func (t *SimpleChaincode) bootstrap(stub shim.ChaincodeStubInterface, bootstrapJSON string) error {
+
+	var genesis Bootstrap
+	err := decode_payload("bootstrap", bootstrapJSON, &genesis)
+	if err != nil {
+		return err
+	}
+
+	existing, err := get_state(stub, configKey)
+	if err != nil {
+		return errors.New("Failed to get " + configKey)
+	}
+	if len(existing) > 0 {
+		return errors.New("The ledger has a configuration already and cannot be bootstrapped again")
+	}
+
+	cfg := default_config()
+	if len(genesis.Config) > 0 {
+		err = json.Unmarshal(genesis.Config, &cfg)
+		if err != nil {
+			return errors.New("Could not unmarshal config: " + err.Error())
+		}
+	}
+	if genesis.Admin != nil && cfg.PlatformAccountId == "" {
+		cfg.PlatformAccountId = genesis.Admin.Id
+	}
+	// the deploy transaction is authorized by the chaincode lifecycle, not by the admin role set_config asks for
+	cfgBytes, _ := json.Marshal(cfg)
+	err = store_config(stub, string(cfgBytes))
+	if err != nil {
+		return err
+	}
+
+	accounts := genesis.Accounts
+	if genesis.Admin != nil {
+		accounts = append([]Account{*genesis.Admin}, accounts...)
+	}
+	for _, account := range accounts {
+		accountBytes, _ := json.Marshal(account)
+		_, err = t.store_account(stub, []string{account.Id, string(accountBytes)})
+		if err != nil {
+			return wrap_error("Account "+account.Id+": ", err)
+		}
+	}
+
+	for _, track := range genesis.Tracks {
+		_, err = t.create_track(stub, []string{string(track)})
+		if err != nil {
+			return err
+		}
+	}
+
+	// everything was just written in the current schema, there is nothing to migrate
+	return put_schema_version(stub, currentSchemaVersion)
+}
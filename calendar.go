@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"strconv"
+	"time"
+)
+
+//==============================================================================================================================
+//	 Settlement Calendar - Resolves which settlement period an instant belongs to. Everything that is period based
+//						   (play attribution, statements, holdback windows) must go through resolve_period.
+//==============================================================================================================================
+type CalendarConfig struct {
+	Type				string			`json:"type"`			// monthly, quarterly or custom
+	Periods				[]CustomPeriod	`json:"periods"`		// explicit periods, only used by the custom calendar
+}
+
+type CustomPeriod struct {
+	Id					string		`json:"id"`
+	Start				string		`json:"start"`		// RFC3339, inclusive
+	End					string		`json:"end"`		// RFC3339, exclusive
+}
+
+type Period struct {
+	Id					string		`json:"id"`
+	Start				time.Time	`json:"start"`
+	End					time.Time	`json:"end"`
+}
+
+var CalendarTypes = map[string]bool{
+	"monthly":		true,
+	"quarterly":	true,
+	"custom":		true,
+}
+
+func validate_calendar(cal CalendarConfig) error {
+
+	if !CalendarTypes[cal.Type] {
+		return errors.New("Calendar type not recognized: " + cal.Type)
+	}
+	if cal.Type != "custom" {
+		return nil
+	}
+	if len(cal.Periods) == 0 {
+		return errors.New("A custom calendar needs at least one period")
+	}
+
+	var prevEnd time.Time
+	ids := map[string]bool{}
+	for i, p := range cal.Periods {
+		if p.Id == "" || ids[p.Id] {
+			return errors.New("Custom period " + strconv.Itoa(i) + " needs a unique id")
+		}
+		ids[p.Id] = true
+
+		start, err := time.Parse(time.RFC3339, p.Start)
+		if err != nil {
+			return errors.New("Invalid start for period " + p.Id + ", expecting RFC3339")
+		}
+		end, err := time.Parse(time.RFC3339, p.End)
+		if err != nil {
+			return errors.New("Invalid end for period " + p.Id + ", expecting RFC3339")
+		}
+		if !start.Before(end) {
+			return errors.New("Period " + p.Id + " must start before it ends")
+		}
+		if i > 0 && start.Before(prevEnd) {
+			return errors.New("Period " + p.Id + " overlaps the previous period, periods must be sorted")
+		}
+		prevEnd = end
+	}
+
+	return nil
+}
+
+// Returns the settlement period containing t according to the configured calendar
+func resolve_period(cfg Config, t time.Time) (Period, error) {
+
+	var period Period
+	loc := platform_location(cfg)
+	t = t.In(loc)
+
+	switch cfg.Calendar.Type {
+	case "monthly", "":
+		start := time.Date(t.Year(), t.Month(), cfg.PeriodStartDay, 0, 0, 0, 0, loc)
+		if t.Before(start) {
+			start = start.AddDate(0, -1, 0)
+		}
+		period.Start	= start
+		period.End		= start.AddDate(0, 1, 0)
+		period.Id		= fmt.Sprintf("%04d-%02d", start.Year(), int(start.Month()))
+
+	case "quarterly":
+		firstMonth := time.Month(((int(t.Month())-1)/3)*3 + 1)
+		start := time.Date(t.Year(), firstMonth, cfg.PeriodStartDay, 0, 0, 0, 0, loc)
+		if t.Before(start) {
+			start = start.AddDate(0, -3, 0)
+		}
+		period.Start	= start
+		period.End		= start.AddDate(0, 3, 0)
+		period.Id		= fmt.Sprintf("%04d-Q%d", start.Year(), (int(start.Month())-1)/3+1)
+
+	case "custom":
+		for _, p := range cfg.Calendar.Periods {
+			start, _ := time.Parse(time.RFC3339, p.Start)
+			end, _ := time.Parse(time.RFC3339, p.End)
+			if !t.Before(start) && t.Before(end) {
+				period.Id		= p.Id
+				period.Start	= start.In(loc)
+				period.End		= end.In(loc)
+				return period, nil
+			}
+		}
+		return period, errors.New("No custom period configured for " + t.Format(time.RFC3339))
+
+	default:
+		return period, errors.New("Calendar type not recognized: " + cfg.Calendar.Type)
+	}
+
+	return period, nil
+}
+
+// Returns the period immediately following p
+func resolve_next_period(cfg Config, p Period) (Period, error) {
+	return resolve_period(cfg, p.End)
+}
+
+// Returns the period immediately preceding p
+func resolve_previous_period(cfg Config, p Period) (Period, error) {
+	return resolve_period(cfg, p.Start.Add(-time.Nanosecond))
+}
+
+// Attributes a play to a settlement period. A play that happened in a period which has already closed is
+// attributed to that period when it is submitted within the grace window, and flagged late so it ends up on
+// the supplemental statement instead. Anything submitted later falls in the period it was submitted in.
+func attribute_period(cfg Config, playedAt time.Time, submittedAt time.Time) (Period, bool, error) {
+
+	submittedPeriod, err := resolve_period(cfg, submittedAt)
+	if err != nil {
+		return Period{}, false, err
+	}
+	if !playedAt.Before(submittedPeriod.Start) {
+		return submittedPeriod, false, nil
+	}
+
+	playedPeriod, err := resolve_period(cfg, playedAt)
+	if err != nil {
+		return Period{}, false, err
+	}
+	grace := time.Duration(cfg.GraceWindowMinutes) * time.Minute
+	if submittedAt.Before(playedPeriod.End.Add(grace)) {
+		return playedPeriod, true, nil
+	}
+
+	return submittedPeriod, false, nil
+}
+
+// Returns the settlement period the current transaction falls in
+func get_tx_period(stub shim.ChaincodeStubInterface, cfg Config) (Period, error) {
+
+	now, err := get_tx_time(stub, cfg)
+	if err != nil {
+		return Period{}, err
+	}
+
+	return resolve_period(cfg, now)
+}
+
+//==============================================================================================================================
+//		Query Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) get_period(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			1
+	//		instant (RFC3339, optional - defaults to the transaction time)
+
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	var period Period
+	if len(args) > 1 && args[1] != "" {
+		instant, err := time.Parse(time.RFC3339, args[1])
+		if err != nil {
+			return nil, errors.New("Invalid instant " + args[1] + ", expecting RFC3339")
+		}
+		period, err = resolve_period(cfg, instant)
+	} else {
+		period, err = get_tx_period(stub, cfg)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	periodBytes, _ := json.Marshal(period)
+
+	return periodBytes, nil
+}
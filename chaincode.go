@@ -1,18 +1,17 @@
 package main
 
 import (
-	"crypto/x509"
-	"encoding/asn1"
 	"encoding/json"
-	"encoding/pem"
 	"errors"
 	"fmt"
-	"github.com/hyperledger/fabric/core/chaincode/shim"
-	"io/ioutil"
-	"net/http"
-	"net/url"
-	"reflect"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"regexp"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
 //==============================================================================================================================
@@ -24,54 +23,164 @@ import (
 type SimpleChaincode struct {
 }
 
-type ECertResponse struct {
-	OK string `json:"OK"`
-}
-
 type Track struct {
 	Isrc     			string 			`json:"isrc"`
 	Iswc	 			string 			`json:"iswc"`
 	Ipi					string 			`json:"ipi"`
-	Beneficiaries 		[]Beneficiary	`json:"beneficiaries"`
-	Content				string			`json:"content"`   			// can be a hash of the content or the url to the content
-	Price				int64			`json:"price"`
+	Beneficiaries 		[]Beneficiary	`json:"beneficiaries"`			// flat split, for tracks without separate rights
+	Recording			*Recording		`json:"recording,omitempty"`		// master rights
+	Composition			*Composition	`json:"composition,omitempty"`	// publishing rights
+	Content				string			`json:"content"`   			// SHA-256 of the content, hex encoded
+	Price				int64			`json:"price"`				// default price of a play
+	TerritoryPrices		map[string]int64	`json:"territoryPrices,omitempty"`	// price of a play by ISO 3166 territory code
+	Currency			string			`json:"currency,omitempty"`		// ISO 4217 code of Price and TerritoryPrices, empty is the platform currency
+	Artist				string			`json:"artist"`				// account id of the performing artist
+	Title				string			`json:"title"`
+	Owner				string			`json:"owner"`				// account id of the registered owner, the only one allowed to update the track
+	Status				TrackStatus		`json:"status"`				// active, inactive or taken_down, empty is treated as active
+	DeactivatedAt		string			`json:"deactivatedAt,omitempty"`
+	TakenDownBy			string			`json:"takenDownBy,omitempty"`		// the granted takedown request that removed the track
+	PendingOwner		string			`json:"pendingOwner,omitempty"`	// offered the ownership, becomes Owner once accepted
+	DefaultSplit		bool			`json:"defaultSplit,omitempty"`	// registered by add_simple_track and still on the default split
+	WorkForHire			[]WorkForHire	`json:"workForHire,omitempty"`	// contributors paid a flat fee, recorded with record_work_for_hire
+	ReleaseAt			string			`json:"releaseAt,omitempty"`	// RFC3339 UTC instant the embargo lifts in every territory, empty is not embargoed
+	ReleasedAt			string			`json:"releasedAt,omitempty"`	// time of the first transaction on the track after ReleaseAt
+	ScheduledPrice		*ScheduledPrice	`json:"scheduledPrice,omitempty"`	// price set by a bulk re-pricing from its effective date
+	PriceHistory		[]PastPrice		`json:"priceHistory,omitempty"`	// prices replaced by re-pricings, for plays attributed before them
+	SplitSchedules		[]SplitSchedule	`json:"splitSchedules,omitempty"`	// splits taking effect later, in order of their effectiveFrom
+	SplitHistory		[]PastSplit		`json:"splitHistory,omitempty"`	// splits replaced by later ones, for plays from before them
+	CreatedAt			string			`json:"createdAt"`				// RFC3339 transaction time, stamped by put_track
+	UpdatedAt			string			`json:"updatedAt"`
+	TermsHash			string			`json:"termsHash,omitempty"`	// SHA-256 of the splits kept in the private collection, hex encoded
+	termsLoaded			bool										// the splits were read from the private collection
+}
+
+// Fields update_track may change, fields left out of the update keep their value
+type TrackUpdate struct {
+	Title				*string			`json:"title"`
+	Price				*int64			`json:"price"`
+	TerritoryPrices		map[string]int64	`json:"territoryPrices"`
+	Currency			*string			`json:"currency"`
+	Content				*string			`json:"content"`
+	Beneficiaries		[]Beneficiary	`json:"beneficiaries"`
+	Recording			*Recording		`json:"recording"`
+	Composition			*Composition	`json:"composition"`
+	EffectiveFrom		*string			`json:"effectiveFrom"`		// RFC3339, when the split change takes effect, empty is now
 }
 
 type Beneficiary struct {
 	AccountId		string			`json:"accountId"`
 	Percentage		int64			`json:"percentage"`
+	Placeholder		string			`json:"placeholder,omitempty"`	// email:<address> or ipi:<number> of someone not on the platform yet
 }
 
 type Account struct {
 	Id					string		`json:"id"`
 	Name				string		`json:"name"`
+	Type				string		`json:"type"`			// listener, artist or label, empty is a listener. Placeholder holding accounts are holding
 	Balance				int64		`json:"balance"`		// optional to keep balance - also bitpesa is possible
+	Currency			string		`json:"currency,omitempty"`	// ISO 4217 code of Balance, empty is the platform currency
+	Balances			map[string]int64	`json:"balances,omitempty"`	// what the account holds in other currencies, by ISO 4217 code
+	PreferredCurrency	string		`json:"preferredCurrency,omitempty"`	// payments settled with conversion are credited in it
 	PendingPayments		[]Payment	`json:"pendingPayments"`
+	PaymentTerms		string		`json:"paymentTerms"`	// terms agreed for payments this account owes, e.g. net-30
+	LabelId				string		`json:"labelId,omitempty"`	// label whose roster the account is on
+	LabelShare			int64		`json:"labelShare,omitempty"`	// percentage the label takes of new tracks
+	ClaimedBy			string		`json:"claimedBy,omitempty"`	// for holding accounts of placeholders, the account that claimed it
+	PayoutHoldUntil		string		`json:"payoutHoldUntil,omitempty"`	// RFC3339, payouts to the account are paused until then
+	PayoutHoldReason	string		`json:"payoutHoldReason,omitempty"`
+	Frozen				bool		`json:"frozen,omitempty"`		// frozen by an admin, nothing moves into or out of the account
+	FrozenReason		string		`json:"frozenReason,omitempty"`
+	FrozenAt			string		`json:"frozenAt,omitempty"`
+	TaxWithholding		*TaxWithholding	`json:"taxWithholding,omitempty"`	// tax withheld from payouts to the account, set by an admin
+	PayoutThreshold		int64		`json:"payoutThreshold,omitempty"`	// minimum payout in the account's currency, smaller settlements accrue
+	AccruedBalance		int64		`json:"accruedBalance,omitempty"`	// settled to the account but not paid out yet, below the threshold
+	Unrecouped			int64		`json:"unrecouped,omitempty"`	// advances to the account still to be recouped from its credits, see grant_advance
+	CertFingerprint		string		`json:"certFingerprint,omitempty"`	// certificate the account registered with via register_me, only it can act as the account
+	CreatedAt			string		`json:"createdAt"`		// RFC3339 transaction time, stamped by put_account
+	UpdatedAt			string		`json:"updatedAt"`
+	TermsHash			string		`json:"termsHash,omitempty"`	// SHA-256 of the label share kept in the private collection, hex encoded
+	termsLoaded			bool								// the label share was read from the private collection
+}
+
+type AccountPage struct {
+	Accounts			[]Account	`json:"accounts"`
+	Bookmark			string		`json:"bookmark"`		// pass back to get the next page, empty on the last page
 }
 
 type Payment struct {
 	RecipientId			string		`json:"recipient"`
 	SenderId			string		`json:"sender"`
 	Amount				int64		`json:"amount"`
+	Currency			string		`json:"currency,omitempty"`	// ISO 4217 code of Amount, empty is the platform currency
 	Completed			bool		`json:"completed"`
+	CreatedAt			string		`json:"createdAt"`
+	DueDate				string		`json:"dueDate"`		// RFC3339, derived from the sender's payment terms
+	Period				string		`json:"period"`			// settlement period the payment is reported in
+	Reference			string		`json:"reference"`		// the play (invoice) or credit note this payment originates from
+	CreditsInvoice		string		`json:"creditsInvoice,omitempty"`	// for credit note lines, the invoice being credited
+	Rights				string		`json:"rights,omitempty"`		// master or publishing, for plays of tracks with separate rights
+	UpdatedAt			string		`json:"updatedAt,omitempty"`	// RFC3339 transaction time the payment was last changed, e.g. settled
+	Source				string		`json:"source,omitempty"`		// for play payments, the source the play came from
+	Promotion			string		`json:"promotion,omitempty"`	// for promotion funding payments, the promotion the discount was given under
+	Fee					string		`json:"fee,omitempty"`		// "platform" for the platform fee taken off a play
+	Conversion			*FxConversion	`json:"conversion,omitempty"`	// the exchange rate the payment was settled at, when it was converted
+	Withheld			int64		`json:"withheld,omitempty"`		// tax withheld from the recipient at settlement, in the currency it was credited in
+	Net					int64		`json:"net,omitempty"`			// what the recipient was credited after the withheld tax
+	Withholding			string		`json:"withholding,omitempty"`	// for withholding payments, the jurisdiction the tax is withheld for
+	Accrued				bool		`json:"accrued,omitempty"`		// credited to the recipient's accrued balance, below its payout threshold
+	Payout				bool		`json:"payout,omitempty"`		// for payout payments, the release of an accrued balance into the balance
+	NettedInto			string		`json:"nettedInto,omitempty"`	// the netting that completed the payment without settling it, see net_payments
+	Recouped			int64		`json:"recouped,omitempty"`		// redirected from the recipient's credit to its label to recoup an advance
+	Advance				string		`json:"advance,omitempty"`		// for advance and recoupment payments, the advance
+	TransferRef			string		`json:"transferRef,omitempty"`	// the transfer that moved the funds, when settled through the settlement chaincode
 }
 
-//=================================================================================================================================
-//  Evaluation map - Equivalant to an enum for Golang
-//  Example:
-//  if(!SomeStatus[strings.ToUpper(status)]) { return nil, errors.New("Status not recognized") }
-//=================================================================================================================================
-var SomeStatus = map[string]bool{
-	"somestatus": true,
+type Play struct {
+	Id					string		`json:"id"`
+	TrackId				string		`json:"trackId"`
+	AlbumId				string		`json:"albumId,omitempty"`	// set instead of TrackId for album plays
+	PlaylistId			string		`json:"playlistId,omitempty"`	// playlist the track was played from
+	PlayedBy			string		`json:"playedBy"`
+	Amount				int64		`json:"amount"`
+	Timestamp			string		`json:"timestamp"`		// RFC3339 time the play happened
+	SubmittedAt			string		`json:"submittedAt"`	// RFC3339 transaction time the play was registered
+	Period				string		`json:"period"`			// settlement period the play is attributed to
+	Late				bool		`json:"late"`			// accrued to an already closed period within the grace window
+	Payments			[]Payment	`json:"payments"`		// the invoice lines, one per beneficiary
+	Credited			int64		`json:"credited"`		// total of the credit notes issued against this play
+	Distributor			string		`json:"distributor,omitempty"`	// white-label distributor the play was submitted through
+	Currency			string		`json:"currency"`
+	Territory			string		`json:"territory,omitempty"`	// where the play happened, decides the price charged
+	UsageType			string		`json:"usageType,omitempty"`	// stream, download, sync or radio, selects the rate card
+	Source				string		`json:"source"`			// app, partner DSP, broadcaster or venue the play came from
+	Promotion			string		`json:"promotion,omitempty"`	// promotion the play was discounted under
+	Discount			int64		`json:"discount,omitempty"`		// what the payer got off, owed to it by the promotion's funder
+	Subscription		string		`json:"subscription,omitempty"`	// tier of the subscription the play is covered by, paid from the pool instead
+	Flagged				string		`json:"flagged,omitempty"`		// the play rate limit the play exceeded, it was recorded without charging
+	CreatedAt			string		`json:"createdAt"`		// RFC3339 transaction time, the same as SubmittedAt
+	UpdatedAt			string		`json:"updatedAt"`		// changes when a credit note is issued against the play
+	TermsHash			string		`json:"termsHash,omitempty"`	// SHA-256 of the invoice lines kept in the private collection, hex encoded
+	termsLoaded			bool								// the invoice lines were read from the private collection
+}
+
+// A play as seen from the account that played it, with the running total of what the account owes
+type AccountPlay struct {
+	Play
+	RunningTotal		int64		`json:"runningTotal"`
 }
 
-//TODO:
-//-- when used with bluemix, add parameter to assign api url for CA
+type AccountPlays struct {
+	AccountId			string			`json:"accountId"`
+	Plays				[]AccountPlay	`json:"plays"`
+	TotalPlayed			int64			`json:"totalPlayed"`
+	TotalOwed			int64			`json:"totalOwed"`		// sum of the account's uncompleted payments as sender
+}
 
 //=================================================================================================================================
 //  Index collections - In order to create new IDs dynamically and in progressive sorting
 //  Example:
-//    signaturesAsBytes, err := stub.GetState(signaturesIndexStr)
+//    signaturesAsBytes, err := get_state(stub, signaturesIndexStr)
 //    if err != nil { return nil, errors.New("Failed to get Signatures Index") }
 //    fmt.Println("Signature index retrieved")
 //
@@ -87,52 +196,522 @@ var SomeStatus = map[string]bool{
 //    // append the new signature to the index
 //    signaturesIndex = append(signaturesIndex, newSignatureId)
 //    jsonAsBytes, _ := json.Marshal(signaturesIndex)
-//    err = stub.PutState(signaturesIndexStr, jsonAsBytes)
+//    err = put_state(stub, signaturesIndexStr, jsonAsBytes)
 //    if err != nil { return nil, errors.New("Error storing new signaturesIndex into ledger") }
+//
+//  Accounts and tracks are not kept in one growing array but indexed with a key per entity, see add_to_index
 //=================================================================================================================================
-var accountIndexStr = "_accounts"
-var trackIndexStr = "_tracks"
+var accountIndexStr = "account"
+var trackIndexStr = "track"
 var paymentIndexStr = "_payments"
+var defaultPageSize = 50
+var maxPageSize = 200
+var playIndexStr = "_plays"
+var artistTracksIndexStr = "artist"
+var titleIndexStr = "title"
+var isrcIndexStr = "isrc"		// "isrc~<isrc>" holds the id of the track registered for the recording
+var contentIndexStr = "content"	// "content~<hash>" holds the id of the track registered with the content
+
+var isrcPattern = regexp.MustCompile(`^[A-Z]{2}[A-Z0-9]{3}[0-9]{7}$`)
+var contentHashPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// Per-artist index of the artist's tracks
+func artist_tracks_index_str(artistId string) string {
+	return index_key(artistTracksIndexStr, artistId)
+}
+
+// Moves a track from the index of its old artist to that of its new one, oldArtist is empty for new tracks
+func index_track_artist(stub shim.ChaincodeStubInterface, trackId string, oldArtist string, newArtist string) error {
+
+	if oldArtist == newArtist {
+		return nil
+	}
+	if oldArtist != "" {
+		err := remove_from_index(stub, artist_tracks_index_str(oldArtist), trackId)
+		if err != nil {
+			return err
+		}
+	}
+	if newArtist != "" {
+		return add_to_index(stub, artist_tracks_index_str(newArtist), trackId)
+	}
+
+	return nil
+}
+
+// Per-account index of the plays initiated by that account
+func account_plays_index_str(accountId string) string {
+	return playIndexStr + "_" + accountId
+}
 
 //==============================================================================================================================
-//	Run - Called on chaincode invoke. Takes a function name passed and calls that function. Converts some
-//		  initial arguments passed to other things for use in the called function e.g. name -> ecert
+//	Invoke - Called on chaincode invoke. Takes the function name passed and calls that function. A query is invoked
+//			 as "query" with the query function name as its first argument, and is not meant to be submitted for
+//			 ordering. Converts some initial arguments passed to other things for use in the called function
+//			 e.g. name -> ecert
 //==============================================================================================================================
-func (t *SimpleChaincode) Run(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
-	fmt.Println("run is running " + function)
-	return t.Invoke(stub, function, args)
+func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface) pb.Response {
+
+	function, args := stub.GetFunctionAndParameters()
+	defer end_tx_writes(stub)
+
+	var result []byte
+	var err error
+	if function == "query" {
+		if len(args) == 0 {
+			return shim.Error("Incorrect number of arguments. Expecting the query function name")
+		}
+		result, err = t.query(stub, args[0], args)
+	} else {
+		result, err = t.invoke(stub, function, args)
+	}
+	if err != nil {
+		return shim.Error(err.Error())
+	}
+
+	return shim.Success(result)
 }
 
-func (t *SimpleChaincode) Invoke(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+func (t *SimpleChaincode) invoke(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
 	fmt.Println("invoke is running " + function)
 
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	err = begin_key_budget(stub)
+	if err != nil {
+		return nil, err
+	}
+	err = t.begin_sandbox(stub, cfg)
+	if err != nil {
+		end_key_budget(stub)
+		return nil, err
+	}
+	defer end_sandbox(stub)
+	defer clear_transient_args(stub)
+	var result []byte
+	args, err = resolve_transient_args(stub, function, args)
+	if err == nil {
+		err = check_payload_sizes(cfg, function, args)
+	}
+	if err == nil {
+		result, err = t.traced_invoke(stub, function, args)
+	}
+
+	// a handler may turn the guardrail error into its own, the guardrail is what the caller needs to see
+	if budgetErr := end_key_budget(stub); budgetErr != nil {
+		result, err = nil, budgetErr
+	}
+
+	clear_tx_ids(stub)
+	if err == nil {
+		err = flush_events(stub)
+	} else {
+		discard_events(stub)
+	}
+
+	if err != nil {
+		return nil, render_error(err)
+	}
+	err = record_metrics(stub, function)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// Runs an invoke under the correlation id the client supplied, if any
+func (t *SimpleChaincode) traced_invoke(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
+
+	correlationId, args := extract_correlation_id(args)
+	if correlationId == "" {
+		return t.idempotent_invoke(stub, function, args)
+	}
+
+	set_correlation_id(stub, correlationId)
+	defer clear_correlation_id(stub)
+
+	result, err := t.idempotent_invoke(stub, function, args)
+	if err != nil {
+		coded := *coded_error(err)
+		coded.CorrelationId = correlationId
+		return nil, &coded
+	}
+
+	err = t.record_audit_entry(stub, function, correlationId)
+	if err != nil {
+		return nil, err
+	}
+
+	return envelope_with_correlation_id(function, result, correlationId)
+}
+
+// Dispatches an invoke to the function handling it
+func (t *SimpleChaincode) invoke_function(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
+
+	if route, ok := legacyInvokeRoutes[function]; ok {
+		return t.call_legacy_route(stub, function, route, args, t.invoke_function)
+	}
+
+	err := t.check_acl(stub, function)
+	if err != nil {
+		return nil, err
+	}
+
 	if function == "init" {
-		return t.Init(stub, "init", args)
+		return t.init(stub, args)
 	} else if function == "add_account" {
-		return t.add_track(stub, args)
-	} else if function == "add_track" {
-		return t.add_track(stub, args)
+		return t.add_account(stub, args)
+	} else if function == "create_track" {
+		return t.create_track(stub, args)
+	} else if function == "update_track" {
+		return t.update_track(stub, args)
+	} else if function == "deactivate_track" {
+		return t.deactivate_track(stub, args)
+	} else if function == "transfer_track_ownership" {
+		return t.transfer_track_ownership(stub, args)
+	} else if function == "accept_track_ownership" {
+		return t.accept_track_ownership(stub, args)
 	} else if function == "register_track" {
 		return t.register_track(stub, args)
+	} else if function == "set_config" {
+		return t.set_config(stub, args)
+	} else if function == "set_payment_terms" {
+		return t.set_payment_terms(stub, args)
+	} else if function == "run_dunning" {
+		return t.run_dunning(stub, args)
+	} else if function == "issue_credit_note" {
+		return t.issue_credit_note(stub, args)
+	} else if function == "grant_manager_access" {
+		return t.grant_manager_access(stub, args)
+	} else if function == "revoke_manager_access" {
+		return t.revoke_manager_access(stub, args)
+	} else if function == "add_artist_to_roster" {
+		return t.add_artist_to_roster(stub, args)
+	} else if function == "remove_artist_from_roster" {
+		return t.remove_artist_from_roster(stub, args)
+	} else if function == "reset_metrics" {
+		return t.reset_metrics(stub, args)
+	} else if function == "begin_upload" {
+		return t.begin_upload(stub, args)
+	} else if function == "append_upload" {
+		return t.append_upload(stub, args)
+	} else if function == "commit_upload" {
+		return t.commit_upload(stub, args)
+	} else if function == "request_license" {
+		return t.request_license(stub, args)
+	} else if function == "approve_license" {
+		return t.approve_license(stub, args)
+	} else if function == "reject_license" {
+		return t.reject_license(stub, args)
+	} else if function == "register_preview" {
+		return t.register_preview(stub, args)
+	} else if function == "set_rate_card" {
+		return t.set_rate_card(stub, args)
+	} else if function == "import_accounts" {
+		return t.import_accounts(stub, args)
+	} else if function == "register_alias" {
+		return t.register_alias(stub, args)
+	} else if function == "import_works" {
+		return t.import_works(stub, args)
+	} else if function == "add_simple_track" {
+		return t.add_simple_track(stub, args)
+	} else if function == "verify_placeholder" {
+		return t.verify_placeholder(stub, args)
+	} else if function == "claim_placeholder" {
+		return t.claim_placeholder(stub, args)
+	} else if function == "set_payout_hold" {
+		return t.set_payout_hold(stub, args)
+	} else if function == "buy_track" {
+		return t.buy_track(stub, args)
+	} else if function == "tip_artist" {
+		return t.tip_artist(stub, args)
+	} else if function == "subscribe" {
+		return t.subscribe(stub, args)
+	} else if function == "distribute_subscription_pool" {
+		return t.distribute_subscription_pool(stub, args)
+	} else if function == "fund_pool" {
+		return t.fund_pool(stub, args)
+	} else if function == "close_pool" {
+		return t.close_pool(stub, args)
+	} else if function == "grant_advance" {
+		return t.grant_advance(stub, args)
+	} else if function == "approve_split" {
+		return t.approve_split(stub, args)
+	} else if function == "reject_split" {
+		return t.reject_split(stub, args)
+	} else if function == "open_dispute" {
+		return t.open_dispute(stub, args)
+	} else if function == "add_dispute_evidence" {
+		return t.add_dispute_evidence(stub, args)
+	} else if function == "resolve_dispute" {
+		return t.resolve_dispute(stub, args)
+	} else if function == "request_takedown" {
+		return t.request_takedown(stub, args)
+	} else if function == "process_takedown" {
+		return t.process_takedown(stub, args)
+	} else if function == "migrate" {
+		return t.migrate(stub, args)
+	} else if function == "set_high_value_policy" {
+		return t.set_high_value_policy(stub, args)
+	} else if function == "net_payments" {
+		return t.net_payments(stub, args)
+	} else if function == "settle_account" {
+		return t.settle_account(stub, args)
+	} else if function == "settle_payment" {
+		return t.settle_payment(stub, args)
+	} else if function == "propose_split" {
+		return t.propose_split(stub, args)
+	} else if function == "counter_split_offer" {
+		return t.counter_split_offer(stub, args)
+	} else if function == "accept_split_offer" {
+		return t.accept_split_offer(stub, args)
+	} else if function == "reject_split_offer" {
+		return t.reject_split_offer(stub, args)
+	} else if function == "record_work_for_hire" {
+		return t.record_work_for_hire(stub, args)
+	} else if function == "freeze_account" {
+		return t.freeze_account(stub, args)
+	} else if function == "unfreeze_account" {
+		return t.unfreeze_account(stub, args)
+	} else if function == "create_promotion" {
+		return t.create_promotion(stub, args)
+	} else if function == "schedule_release" {
+		return t.schedule_release(stub, args)
+	} else if function == "set_notification_preferences" {
+		return t.set_notification_preferences(stub, args)
+	} else if function == "test_notification" {
+		return t.test_notification(stub, args)
+	} else if function == "reprice_tracks" {
+		return t.reprice_tracks(stub, args)
+	} else if function == "continue_repricing" {
+		return t.continue_repricing(stub, args)
+	} else if function == "set_tax_withholding" {
+		return t.set_tax_withholding(stub, args)
+	} else if function == "set_payout_threshold" {
+		return t.set_payout_threshold(stub, args)
+	} else if function == "set_fx_rate" {
+		return t.set_fx_rate(stub, args)
+	} else if function == "set_preferred_currency" {
+		return t.set_preferred_currency(stub, args)
+	} else if function == "convert_and_settle_payment" {
+		return t.convert_and_settle_payment(stub, args)
+	} else if function == "convert_balance" {
+		return t.convert_balance(stub, args)
+	} else if function == "close_period" {
+		return t.close_period(stub, args)
+	} else if function == "register_me" {
+		return t.register_me(stub, args)
+	} else if function == "attest_content_liveness" {
+		return t.attest_content_liveness(stub, args)
+	} else if function == "set_acl" {
+		return t.set_acl(stub, args)
+	} else if function == "select_audit_sample" {
+		return t.select_audit_sample(stub, args)
+	} else if function == "record_audit_outcome" {
+		return t.record_audit_outcome(stub, args)
+	} else if function == "mint_sandbox_credits" {
+		return t.mint_sandbox_credits(stub, args)
+	} else if function == "purge_sandbox" {
+		return t.purge_sandbox(stub, args)
+	} else if function == "register_play_source" {
+		return t.register_play_source(stub, args)
+	} else if function == "accept_invitation" {
+		return t.accept_invitation(stub, args)
+	} else if function == "decline_invitation" {
+		return t.decline_invitation(stub, args)
+	} else if function == "set_distributor_config" {
+		return t.set_distributor_config(stub, args)
+	} else if function == "add_album" {
+		return t.add_album(stub, args)
+	} else if function == "register_album_play" {
+		return t.register_album_play(stub, args)
+	} else if function == "add_playlist" {
+		return t.add_playlist(stub, args)
+	} else if function == "register_playlist_play" {
+		return t.register_playlist_play(stub, args)
 	}
 
 	return nil, errors.New("Received unknown invoke function name")
 }
 
 //=================================================================================================================================
-//	Query - Called on chaincode query. Takes a function name passed and calls that function. Passes the
+//	query - Called by Invoke for "query". Takes a function name passed and calls that function. Passes the
 //  		initial arguments passed are passed on to the called function.
 //
 //  args[0] is the function name
 //=================================================================================================================================
-func (t *SimpleChaincode) Query(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
+func (t *SimpleChaincode) query(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
+
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, render_error(err)
+	}
+	err = t.begin_sandbox(stub, cfg)
+	if err != nil {
+		return nil, render_error(err)
+	}
+	defer end_sandbox(stub)
+
+	result, err := t.query_function(stub, function, args)
+	if err != nil {
+		return nil, render_error(err)
+	}
 
-	if function == "get_account" {
-		return t.get_account(stub, args[1])
+	return result, nil
+}
+
+// Dispatches a query to the function handling it
+func (t *SimpleChaincode) query_function(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
+
+	if route, ok := legacyQueryRoutes[function]; ok {
+		return t.call_legacy_route(stub, function, route, args, t.query_function)
+	}
+
+	if function == "read_account" {
+		return t.read_account(stub, args)
 	} else if function == "get_track" {
 		return t.get_track(stub, args)
 	} else if function == "get_all_tracks" {
 		return t.get_all_tracks(stub, args)
+	} else if function == "get_all_accounts" {
+		return t.get_all_accounts(stub, args)
+	} else if function == "get_tracks_by_artist" {
+		return t.get_tracks_by_artist(stub, args)
+	} else if function == "get_catalog_valuation" {
+		return t.get_catalog_valuation(stub, args)
+	} else if function == "search_tracks" {
+		return t.search_tracks(stub, args)
+	} else if function == "get_earnings_forecast" {
+		return t.get_earnings_forecast(stub, args)
+	} else if function == "get_plays_by_account" {
+		return t.get_plays_by_account(stub, args)
+	} else if function == "get_config" {
+		return t.query_config(stub, args)
+	} else if function == "get_period" {
+		return t.get_period(stub, args)
+	} else if function == "get_supplemental_statement" {
+		return t.get_supplemental_statement(stub, args)
+	} else if function == "get_dunning" {
+		return t.get_dunning(stub, args)
+	} else if function == "get_statement" {
+		return t.get_statement(stub, args)
+	} else if function == "get_credit_notes" {
+		return t.get_credit_notes(stub, args)
+	} else if function == "get_manager_dashboard" {
+		return t.get_manager_dashboard(stub, args)
+	} else if function == "get_invitations" {
+		return t.get_invitations(stub, args)
+	} else if function == "get_roster" {
+		return t.get_roster(stub, args)
+	} else if function == "get_metrics" {
+		return t.get_metrics(stub, args)
+	} else if function == "get_license" {
+		return t.query_license(stub, args)
+	} else if function == "get_track_licenses" {
+		return t.get_track_licenses(stub, args)
+	} else if function == "get_split_negotiation" {
+		return t.get_split_negotiation(stub, args)
+	} else if function == "get_preview_allowance" {
+		return t.get_preview_allowance(stub, args)
+	} else if function == "get_chart_feed" {
+		return t.get_chart_feed(stub, args)
+	} else if function == "get_rate_cards" {
+		return t.get_rate_cards(stub, args)
+	} else if function == "get_track_by_isrc" {
+		return t.get_track_by_isrc(stub, args)
+	} else if function == "get_message_catalog" {
+		return t.get_message_catalog(stub, args)
+	} else if function == "get_migration_journal" {
+		return t.get_migration_journal(stub, args)
+	} else if function == "get_tracks_by_iswc" {
+		return t.get_tracks_by_iswc(stub, args)
+	} else if function == "get_by_legacy_id" {
+		return t.get_by_legacy_id(stub, args)
+	} else if function == "get_aliases" {
+		return t.get_aliases(stub, args)
+	} else if function == "get_work" {
+		return t.query_work(stub, args)
+	} else if function == "verify_content" {
+		return t.verify_content(stub, args)
+	} else if function == "verify_track_provenance" {
+		return t.verify_track_provenance(stub, args)
+	} else if function == "get_acl" {
+		return t.query_acl(stub, args)
+	} else if function == "get_audit_sample" {
+		return t.query_audit_sample(stub, args)
+	} else if function == "get_audit_samples" {
+		return t.get_audit_samples(stub, args)
+	} else if function == "get_invoker_identity" {
+		return t.get_invoker_identity(stub, args)
+	} else if function == "get_play_sources" {
+		return t.get_play_sources(stub, args)
+	} else if function == "get_source_totals" {
+		return t.query_source_totals(stub, args)
+	} else if function == "get_label_catalog" {
+		return t.get_label_catalog(stub, args)
+	} else if function == "get_label_report" {
+		return t.get_label_report(stub, args)
+	} else if function == "get_track_history" {
+		return t.get_track_history(stub, args)
+	} else if function == "get_distributor_config" {
+		return t.query_distributor_config(stub, args)
+	} else if function == "get_effective_config" {
+		return t.get_effective_config(stub, args)
+	} else if function == "get_album" {
+		return t.query_album(stub, args)
+	} else if function == "get_playlist" {
+		return t.query_playlist(stub, args)
+	} else if function == "get_correlation_trace" {
+		return t.get_correlation_trace(stub, args)
+	} else if function == "get_recent_correlation_ids" {
+		return t.get_recent_correlation_ids(stub, args)
+	} else if function == "validate_invoke" {
+		return t.validate_invoke(stub, args)
+	} else if function == "get_promotion" {
+		return t.query_promotion(stub, args)
+	} else if function == "get_promotion_funding" {
+		return t.query_promotion_funding(stub, args)
+	} else if function == "get_exchange_rate" {
+		return t.query_exchange_rate(stub, args)
+	} else if function == "get_period_close" {
+		return t.query_period_close(stub, args)
+	} else if function == "get_content_liveness" {
+		return t.query_content_liveness(stub, args)
+	} else if function == "get_flagged_content" {
+		return t.get_flagged_content(stub, args)
+	} else if function == "get_notification_preferences" {
+		return t.query_notification_preferences(stub, args)
+	} else if function == "get_all_notification_preferences" {
+		return t.get_all_notification_preferences(stub, args)
+	} else if function == "get_repricing" {
+		return t.query_repricing(stub, args)
+	} else if function == "get_accrued_payouts" {
+		return t.get_accrued_payouts(stub, args)
+	} else if function == "get_research_export" {
+		return t.get_research_export(stub, args)
+	} else if function == "check_integrity" {
+		return t.check_integrity(stub, args)
+	} else if function == "get_purchases" {
+		return t.get_purchases(stub, args)
+	} else if function == "get_tips" {
+		return t.get_tips(stub, args)
+	} else if function == "get_subscription" {
+		return t.query_subscription(stub, args)
+	} else if function == "get_royalty_pools" {
+		return t.get_royalty_pools(stub, args)
+	} else if function == "get_advances" {
+		return t.get_advances(stub, args)
+	} else if function == "get_split_proposals" {
+		return t.get_split_proposals(stub, args)
+	} else if function == "get_disputes" {
+		return t.get_disputes(stub, args)
+	} else if function == "get_takedowns" {
+		return t.get_takedowns(stub, args)
+	} else if function == "get_schema_version" {
+		return t.query_schema_version(stub, args)
 	}
 
 	return nil, errors.New("Received unknown query function name")
@@ -153,329 +732,2255 @@ func main() {
 //  Init Function - Called when the user deploys the chaincode
 //==============================================================================================================================
 
-func (t *SimpleChaincode) Init(stub *shim.ChaincodeStub, function string, args []string) ([]byte, error) {
-	return nil, nil
-}
-
-//==============================================================================================================================
-//  Utility Functions
-//==============================================================================================================================
+func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface) pb.Response {
 
-// "create":  true -> create new ID, false -> append the id
-func append_id(stub *shim.ChaincodeStub, indexStr string, id string, create bool) ([]byte, error) {
+	_, args := stub.GetFunctionAndParameters()
 
-	indexAsBytes, err := stub.GetState(indexStr)
+	defer end_tx_writes(stub)
+	defer clear_transient_args(stub)
+	args, err := resolve_transient_args(stub, "init", args)
 	if err != nil {
-		return nil, errors.New("Failed to get " + indexStr)
+		return shim.Error(render_error(err).Error())
+	}
+	result, err := t.init(stub, args)
+	if err != nil {
+		return shim.Error(err.Error())
 	}
-	fmt.Println(indexStr + " retrieved")
 
-	// Unmarshal the index
-	var tmpIndex []string
-	json.Unmarshal(indexAsBytes, &tmpIndex)
-	fmt.Println(indexStr + " unmarshalled")
+	return shim.Success(result)
+}
+
+func (t *SimpleChaincode) init(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
 
-	// Create new id
-	var newId = id
-	if create {
-		newId += strconv.Itoa(len(tmpIndex) + 1)
+	//Args
+	//			0
+	//		bootstrap JSON object (as string, optional)
+
+	if len(args) == 0 || args[0] == "" {
+		return nil, nil
 	}
 
-	// append the new id to the index
-	tmpIndex = append(tmpIndex, newId)
-	jsonAsBytes, _ := json.Marshal(tmpIndex)
-	err = stub.PutState(indexStr, jsonAsBytes)
+	err := t.bootstrap(stub, args[0])
+	clear_tx_ids(stub)
 	if err != nil {
-		return nil, errors.New("Error storing new " + indexStr + " into ledger")
+		discard_events(stub)
+		return nil, render_error(err)
 	}
 
-	return []byte(newId), nil
-
+	return nil, flush_events(stub)
 }
 
 //==============================================================================================================================
-//  Certificate Authentication
+//  Utility Functions
 //==============================================================================================================================
 
-func (t *SimpleChaincode) get_ecert(stub *shim.ChaincodeStub, name string) ([]byte, error) {
+// Ids generated by the transactions in flight, by tx id
+var txIdCounters = struct {
+	sync.Mutex
+	counters map[string]int
+}{counters: map[string]int{}}
 
-	var cert ECertResponse
+// Generates an id from the tx id and a per transaction counter, "<prefix>_<txId>_<n>". The ids are unique across
+// transactions, and every peer executing the transaction generates the same ones.
+func new_tx_id(stub shim.ChaincodeStubInterface, prefix string) string {
 
-	response, err := http.Get("http://localhost:5000/registrar/" + name + "/ecert") // Calls out to the HyperLedger REST API to get the ecert of the user with that name
+	txIdCounters.Lock()
+	defer txIdCounters.Unlock()
 
-	if err != nil {
-		return nil, errors.New("Could not get ecert")
-	}
+	txIdCounters.counters[stub.GetTxID()]++
 
-	defer response.Body.Close()
-	contents, err := ioutil.ReadAll(response.Body) // Read the response from the http callout into the variable contents
+	return prefix + "_" + stub.GetTxID() + "_" + strconv.Itoa(txIdCounters.counters[stub.GetTxID()])
+}
 
-	if err != nil {
-		return nil, errors.New("Could not read body")
-	}
+func clear_tx_ids(stub shim.ChaincodeStubInterface) {
+	txIdCounters.Lock()
+	delete(txIdCounters.counters, stub.GetTxID())
+	txIdCounters.Unlock()
+}
 
-	err = json.Unmarshal(contents, &cert)
+// "create":  true -> create new ID, false -> append the id
+// A created id is unique by itself and nothing is written for it, an appended id gets a key of its own in the index
+// so concurrent transactions appending to it do not conflict.
+func append_id(stub shim.ChaincodeStubInterface, indexStr string, id string, create bool) ([]byte, error) {
+
+	if create {
+		return []byte(new_tx_id(stub, id)), nil
+	}
 
+	existing, err := get_state(stub, index_key(indexStr, id))
 	if err != nil {
-		return nil, errors.New("ECert not found for user: " + name)
+		return nil, errors.New("Failed to get " + indexStr)
+	}
+	if len(existing) > 0 {
+		return nil, already_exists(id)
+	}
+	err = add_to_index(stub, indexStr, id)
+	if err != nil {
+		return nil, err
 	}
 
-	return []byte(string(cert.OK)), nil
+	return []byte(id), nil
 }
 
-func (t *SimpleChaincode) get_cert_username(stub *shim.ChaincodeStub, encodedCert string) (string, error) {
-
-	decodedCert, err := url.QueryUnescape(encodedCert) // make % etc normal //
+// Returns the ids appended to an index, those appended while it was still kept as one JSON array first
+func get_appended_ids(stub shim.ChaincodeStubInterface, indexStr string) ([]string, error) {
 
+	indexAsBytes, err := get_state(stub, indexStr)
 	if err != nil {
-		return "", errors.New("Could not decode certificate")
+		return nil, errors.New("Failed to get " + indexStr)
 	}
+	var ids []string
+	json.Unmarshal(indexAsBytes, &ids)
 
-	pem, _ := pem.Decode([]byte(decodedCert)) // Make Plain text   //
-
-	x509Cert, err := x509.ParseCertificate(pem.Bytes)
-
+	appended, err := get_index_ids(stub, indexStr)
 	if err != nil {
-		return "", errors.New("Couldn't parse certificate")
+		return nil, err
 	}
 
-	return x509Cert.Subject.CommonName, nil
-
+	return append(ids, appended...), nil
 }
 
-func (t *SimpleChaincode) check_role(stub *shim.ChaincodeStub, encodedCert string) (int64, error) {
-	ECertSubjectRole := asn1.ObjectIdentifier{2, 1, 3, 4, 5, 6, 7}
+func get_account(stub shim.ChaincodeStubInterface, accountId string) (Account, error) {
 
-	decodedCert, err := url.QueryUnescape(encodedCert) // make % etc normal //
+	var account Account
 
-	if err != nil {
-		return -1, errors.New("Could not decode certificate")
+	bytes, err := get_state(stub, accountId)
+	if err != nil || len(bytes) == 0 {
+		return account, errors.New("Could not fetch account " + accountId)
 	}
-
-	pem, _ := pem.Decode([]byte(decodedCert)) // Make Plain text   //
-
-	x509Cert, err := x509.ParseCertificate(pem.Bytes) // Extract Certificate from argument //
-
+	err = json.Unmarshal(bytes, &account)
 	if err != nil {
-		return -1, errors.New("Couldn't parse certificate")
+		return account, errors.New("Could not unmarshal account " + accountId)
 	}
-
-	var role int64
-	for _, ext := range x509Cert.Extensions { // Get Role out of Certificate and return it //
-		if reflect.DeepEqual(ext.Id, ECertSubjectRole) {
-			role, err = strconv.ParseInt(string(ext.Value), 10, len(ext.Value)*8)
-
-			if err != nil {
-				return -1, errors.New("Failed parsing role: " + err.Error())
-			}
-			break
-		}
+	err = open_account_terms(stub, &account)
+	if err != nil {
+		return account, err
 	}
 
-	return role, nil
+	return account, nil
 }
 
-//==============================================================================================================================
-//  Invoke Functions
-//==============================================================================================================================
+// Puts an account on the ledger, stamped with the transaction time
+func put_account(stub shim.ChaincodeStubInterface, account Account) error {
 
-func (t *SimpleChaincode) add_account(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+	cfg, err := get_config(stub)
+	if err != nil {
+		return err
+	}
+	now, err := get_tx_time(stub, cfg)
+	if err != nil {
+		return err
+	}
 
-	//Args
-	//			0				1
-	//		  index		account JSON object (as string)
+	account.UpdatedAt = now.Format(time.RFC3339)
+	if account.CreatedAt == "" {
+		account.CreatedAt = account.UpdatedAt
+	}
 
-	id, err := append_id(stub, accountIndexStr, args[0], false)
+	err = validate_account_enums(account)
 	if err != nil {
-		return nil, errors.New("Error creating new id for user " + args[0])
+		return err
+	}
+	err = seal_account_terms(stub, cfg, &account)
+	if err != nil {
+		return err
 	}
 
-	err = stub.PutState(string(id), []byte(args[1]))
+	accountBytes, _ := json.Marshal(account)
+	err = put_state(stub, account.Id, accountBytes)
 	if err != nil {
-		return nil, errors.New("Error putting user data on ledger")
+		return errors.New("Error putting account " + account.Id + " on ledger")
 	}
 
-	return nil, nil
+	return nil
 }
 
-func (t *SimpleChaincode) add_track(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
-
-	// args
-	// 		0			1		2		3		4
-	//	   iswc	  isrc		price	main_ben	min_ben
-
-	price, err := strconv.Atoi(args[2])
-	if err != nil { return nil, errors.New("3rd arg must be a numeric string")}
+func fetch_track(stub shim.ChaincodeStubInterface, trackId string) (Track, error) {
 
-	ben_arr := `[{"accountId": "` + args[3] + `", "percentage":"75"}, {"accountId": "` + args[4] + `", "percentage":"25"}]`
-	str := `{"iswc": "` + args[0] + `", "isrc": "` + args[1] + `", "price": ` + strconv.Itoa(price) + `, "beneficiary":` + ben_arr + `"}`
-
-	//		isrc, price, beneficiaries,
-	// accountId, percentage
-	id, err := append_id(stub, trackIndexStr, args[0], false)
+	tr, err := get_public_track(stub, trackId)
 	if err != nil {
-		return nil, errors.New("Error creating new id for thing " + args[0])
+		return tr, err
 	}
-
-	err = stub.PutState(args[0], []byte(str))
+	err = open_track_terms(stub, trackId, &tr)
 	if err != nil {
-		return nil, errors.New("Error putting thing data on ledger")
+		return tr, err
 	}
 
-	return nil, nil
-
+	return tr, nil
 }
 
-// Register that a track is played by an account
-// Pay out to the benificiaries of the track
-func (t *SimpleChaincode) register_track(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+// The track as it is on the channel, without the terms kept in the private collection
+func get_public_track(stub shim.ChaincodeStubInterface, trackId string) (Track, error) {
 
-	// Args
-	// 0		1
-	// trackId	played_by
+	var tr Track
 
-	// 1. get track
-	trackBytes, err := stub.GetState(args[0])
-	if err != nil {
-		return nil, errors.New("Could not fetch track " + args[0])
+	bytes, err := get_state(stub, trackId)
+	if err != nil || len(bytes) == 0 {
+		return tr, errors.New("Could not fetch track " + trackId)
 	}
-	// 1b. Unmarshal track
-	var tr Track
-	err = json.Unmarshal(trackBytes, &tr)
+	err = json.Unmarshal(bytes, &tr)
 	if err != nil {
-		return nil, errors.New("Could not unmarshal track " )
+		return tr, errors.New("Could not unmarshal track " + trackId)
 	}
 
-	// 2. get played by account
-	playedByBytes, err := stub.GetState(args[1])
-	if err != nil {
-		return nil, errors.New("Could not fetch track ")
+	return tr, nil
+}
+
+// Index entries are keys of the form "<indexStr>~<id>". Every entity gets its own key so concurrent
+// creations never read and rewrite the same index value.
+func index_key(indexStr string, id string) string {
+	return indexStr + "~" + id
+}
+
+// Records are keyed by their id in the key space of the index keys and the platform's own "_" keys. An id chosen by
+// the caller can be neither, so it cannot contain "~" or start with "_".
+func check_record_id(object string, id string) error {
+
+	if strings.Contains(id, "~") || strings.HasPrefix(id, "_") {
+		return new_error("bad_arguments", "id.reserved", map[string]string{"object": object, "id": id})
 	}
-	// 2b. unmarshal account
-	var account_sender Account
-	err = json.Unmarshal(playedByBytes, &account_sender)
+
+	return nil
+}
+
+func add_to_index(stub shim.ChaincodeStubInterface, indexStr string, id string) error {
+
+	err := put_state(stub, index_key(indexStr, id), []byte{0x00})
 	if err != nil {
-		return nil, errors.New("Could not unmarshal account " )
+		return errors.New("Error storing " + id + " in " + indexStr + " index")
 	}
 
-	// Create array for payments by sender
-	var senderPayments []Payment
+	return nil
+}
 
-	// 3. loop through beneficiaries of track
-	for _, beneficiary := range tr.Beneficiaries {
+// Returns the ids in an index, in key order
+func get_index_ids(stub shim.ChaincodeStubInterface, indexStr string) ([]string, error) {
 
-		// 4. add a PendingPayment to their account
+	prefix := index_key(indexStr, "")
+	keysIter, err := range_query_state(stub, prefix, prefix+"\xff")
+	if err != nil {
+		return nil, errors.New("Failed to range query " + indexStr + " index")
+	}
+	defer keysIter.Close()
 
-		// 4a. get beneficiary account
-		bytes, err := stub.GetState(beneficiary.AccountId)
+	ids := []string{}
+	for keysIter.HasNext() {
+		key, _, err := keysIter.Next()
 		if err != nil {
-			return nil, errors.New("Unable to get thing with ID " )
+			return nil, errors.New("Failed to iterate " + indexStr + " index")
 		}
-		// 4b. unmarshal account
-		var account_recipient Account
-		json.Unmarshal(bytes, &account_recipient)
-
-		// 4c. calculate amount
-		var amount int64
-		amount = (beneficiary.Percentage / 100 ) * tr.Price
+		ids = append(ids, key[len(prefix):])
+	}
 
-		// 4d. create PendingPayment
-		var pendingPayment Payment
-		pendingPayment.Amount 		= amount
-		pendingPayment.Completed 	= false
-		pendingPayment.RecipientId 	= account_recipient.Id
-		pendingPayment.SenderId 	= account_sender.Id
+	return ids, nil
+}
 
-		// 4e. append PendingPayment to recipient
-		account_recipient.PendingPayments = append(account_recipient.PendingPayments, pendingPayment)
+func remove_from_index(stub shim.ChaincodeStubInterface, indexStr string, id string) error {
 
-		// 4f. push pendingpayment to senderpayments
-		senderPayments = append(senderPayments, pendingPayment)
+	err := del_state(stub, index_key(indexStr, id))
+	if err != nil {
+		return errors.New("Error removing " + id + " from " + indexStr + " index")
+	}
 
-		// 4g. Put beneficiary back in state
-		accReciptientBytes, _ := json.Marshal(account_recipient)
-		err = stub.PutState(account_recipient.Id, accReciptientBytes)
+	return nil
+}
 
-		// 4h. Append payment to payment index
+// Titles are indexed case-insensitively as "title~<title>~<trackId>" so a range over a prefix is a prefix search
+func title_index_id(title string, trackId string) string {
+	return strings.ToLower(strings.TrimSpace(title)) + "~" + trackId
+}
 
+func index_track_title(stub shim.ChaincodeStubInterface, trackId string, oldTitle string, newTitle string) error {
 
+	if oldTitle == newTitle {
+		return nil
 	}
-
-	// 5. append senderPayments to sender account
-	for _, payment := range senderPayments {
-		account_sender.PendingPayments = append(account_sender.PendingPayments, payment)
+	if oldTitle != "" {
+		err := remove_from_index(stub, titleIndexStr, title_index_id(oldTitle, trackId))
+		if err != nil {
+			return err
+		}
+	}
+	if newTitle != "" {
+		return add_to_index(stub, titleIndexStr, title_index_id(newTitle, trackId))
 	}
-	return nil, nil
-}
 
-//==============================================================================================================================
-//		Query Functions
-//==============================================================================================================================
+	return nil
+}
 
-func (t *SimpleChaincode) get_account(stub *shim.ChaincodeStub, userID string) ([]byte, error) {
+// Opens an iterator over an index starting right after the id bookmark, or at the start when bookmark is empty
+func index_iterator_after(stub shim.ChaincodeStubInterface, indexStr string, bookmark string) (stateRangeIterator, error) {
 
-	bytes, err := stub.GetState(userID)
+	prefix := index_key(indexStr, "")
+	startKey := prefix
+	if bookmark != "" {
+		startKey = index_key(indexStr, bookmark) + "\x00"
+	}
 
+	keysIter, err := range_query_state(stub, startKey, prefix+"\xff")
 	if err != nil {
-		return nil, errors.New("Could not retrieve information for this user")
+		return nil, errors.New("Failed to range query " + indexStr + " index")
 	}
 
-	return bytes, nil
+	return keysIter, nil
+}
+
+// Parses an optional page size argument
+func page_size_arg(args []string, i int) (int, error) {
+
+	if len(args) <= i || args[i] == "" {
+		return defaultPageSize, nil
+	}
+	size, err := strconv.Atoi(args[i])
+	if err != nil || size < 1 || size > maxPageSize {
+		return 0, errors.New("Page size must be a number between 1 and " + strconv.Itoa(maxPageSize))
+	}
 
+	return size, nil
 }
 
-func (t *SimpleChaincode) get_track(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+//==============================================================================================================================
+//  Invoke Functions
+//==============================================================================================================================
+
+// Creates an account or, with upsert, replaces its details. Admins create accounts, anyone else registers its own
+// with register_me. An account replaces its own details, an admin those of accounts not bound to a certificate.
+func (t *SimpleChaincode) add_account(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
 
 	//Args
-	//			1
-	//		thingID
+	//			0				1								2
+	//		  index		account JSON object (as string)		upsert (optional - the account itself or an admin replaces its details)
 
-	bytes, err := stub.GetState(args[1])
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting id and account JSON")
+	}
 
+	existing, err := get_state(stub, args[0])
 	if err != nil {
-		return nil, errors.New("Error getting from ledger")
+		return nil, errors.New("Failed to get account " + args[0])
+	}
+	if len(existing) == 0 && t.check_caller_role(stub, adminRole) != nil {
+		return nil, errors.New("Only an admin can create account " + args[0] + ", register your own account with register_me")
 	}
 
-	return bytes, nil
-
+	return t.store_account(stub, args)
 }
 
-func (t *SimpleChaincode) get_all_tracks(stub *shim.ChaincodeStub, args []string) ([]byte, error) {
+// Writes an account from its JSON, the caller checks of add_account aside. Bootstrapping creates the genesis accounts with it.
+func (t *SimpleChaincode) store_account(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
 
-	indexAsBytes, err := stub.GetState(trackIndexStr)
+	var account Account
+	err := decode_payload("account", args[1], &account)
 	if err != nil {
-		return nil, errors.New("Failed to get " + trackIndexStr)
+		return nil, err
 	}
-	fmt.Println(trackIndexStr + " retrieved")
-	s := string(indexAsBytes[:])
-	fmt.Println(s)
-
-	// Unmarshal the index
-	var trackIndex []string
-	errx := json.Unmarshal(indexAsBytes, &trackIndex)
-	if errx != nil {
-		fmt.Println(errx)
-		return nil, errors.New("Failed to get " + trackIndexStr)
+	account.Id = args[0]
+	err = validate_account_payload(account)
+	if err != nil {
+		return nil, err
+	}
+	if account.Type != "" && !AccountTypes[account.Type] {
+		return nil, errors.New("Account type not recognized: " + account.Type)
 	}
+	upsert := len(args) > 2 && args[2] == "upsert"
 
-	var tracks []Track
-	for _, track := range tracks {
+	account.CreatedAt		= ""
+	account.CertFingerprint	= ""
+	account.TaxWithholding	= nil
+	account.Unrecouped		= 0
+	account.AccruedBalance	= 0
 
-		bytes, err := stub.GetState(track.Iswc)
+	// accounts bound to a certificate are only the certificate holder's
+	existing, err := get_state(stub, args[0])
+	if err != nil {
+		return nil, errors.New("Failed to get account " + args[0])
+	}
+	if len(existing) == 0 {
+		err = validate_new_account_payload(account)
+		if err != nil {
+			return nil, err
+		}
+		// from schema version 2 on every account has a type
+		version, err := get_schema_version(stub)
 		if err != nil {
-			return nil, errors.New("Unable to get thing with ID: " + track.Iswc)
+			return nil, err
 		}
+		if account.Type == "" && version >= 2 {
+			return nil, field_required("account", "type")
+		}
+	} else {
+		if !upsert {
+			return nil, already_exists(args[0])
+		}
+		current, err := get_account(stub, args[0])
+		if err != nil {
+			return nil, err
+		}
+		caller, _ := t.get_caller_username(stub)
+		if current.CertFingerprint != "" && caller != current.Id {
+			return nil, errors.New("Account " + args[0] + " is bound to the certificate it registered with, only its holder can replace it")
+		}
+		if caller != current.Id && t.check_caller_role(stub, adminRole) != nil {
+			return nil, errors.New("Only account " + current.Id + " itself or an admin can replace it")
+		}
+		keep_account_ledger_fields(&account, current)
+	}
 
-		var t Track
-		json.Unmarshal(bytes, &t)
-		tracks = append(tracks, t)
+	err = add_to_index(stub, accountIndexStr, args[0])
+	if err != nil {
+		return nil, errors.New("Error creating new id for user " + args[0])
 	}
 
-	tracksAsJsonBytes, _ := json.Marshal(tracks)
+	err = put_account(stub, account)
 	if err != nil {
-		return nil, errors.New("Could not convert things to JSON ")
+		return nil, err
 	}
 
-	return tracksAsJsonBytes, nil
+	return nil, emit_event(stub, "AccountCreated", account)
+}
+
+// An upsert replaces what the account says about itself, what the ledger tracks for it is kept: its money and
+// debts, what admins and other functions set on it, and when it was created
+func keep_account_ledger_fields(account *Account, current Account) {
+	account.Balance				= current.Balance
+	account.Currency			= current.Currency
+	account.Balances			= current.Balances
+	account.PendingPayments		= current.PendingPayments
+	account.PaymentTerms		= current.PaymentTerms
+	account.LabelId				= current.LabelId
+	account.LabelShare			= current.LabelShare
+	account.ClaimedBy			= current.ClaimedBy
+	account.PayoutHoldUntil		= current.PayoutHoldUntil
+	account.PayoutHoldReason	= current.PayoutHoldReason
+	account.Frozen				= current.Frozen
+	account.FrozenReason		= current.FrozenReason
+	account.FrozenAt			= current.FrozenAt
+	account.TaxWithholding		= current.TaxWithholding
+	account.PayoutThreshold		= current.PayoutThreshold
+	account.AccruedBalance		= current.AccruedBalance
+	account.Unrecouped			= current.Unrecouped
+	account.CertFingerprint		= current.CertFingerprint
+	account.CreatedAt			= current.CreatedAt
+	account.TermsHash			= current.TermsHash
+	account.termsLoaded			= current.termsLoaded
 }
 
+// Pauses payouts to the invoker's own account until the release date, an empty release date lifts the hold
+func (t *SimpleChaincode) set_payout_hold(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0								1
+	//		release date (RFC3339)		reason (optional)
+
+	if len(args) < 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting release date")
+	}
+
+	accountId, err := t.get_caller_username(stub)
+	if err != nil {
+		return nil, err
+	}
+	account, err := get_account(stub, accountId)
+	if err != nil {
+		return nil, err
+	}
+
+	account.PayoutHoldUntil		= ""
+	account.PayoutHoldReason	= ""
+	if args[0] != "" {
+		cfg, err := get_config(stub)
+		if err != nil {
+			return nil, err
+		}
+		now, err := get_tx_time(stub, cfg)
+		if err != nil {
+			return nil, err
+		}
+		until, err := time.Parse(time.RFC3339, args[0])
+		if err != nil {
+			return nil, errors.New("Invalid release date " + args[0] + ", expecting RFC3339")
+		}
+		if !until.After(now) {
+			return nil, errors.New("Invalid release date " + args[0] + ", it must be in the future")
+		}
+		if cfg.MaxPayoutHoldDays > 0 && until.After(now.AddDate(0, 0, cfg.MaxPayoutHoldDays)) {
+			return nil, errors.New("Invalid release date " + args[0] + ", a payout hold lasts at most " + strconv.Itoa(cfg.MaxPayoutHoldDays) + " days")
+		}
+
+		account.PayoutHoldUntil = until.Format(time.RFC3339)
+		if len(args) > 1 {
+			account.PayoutHoldReason = args[1]
+		}
+	}
+
+	err = put_account(stub, account)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// Registers a new track. The track is keyed by its ISWC, the invoker becomes its owner. With upsert the owner of an
+// existing track can register it anew, replacing it. A split naming other accounts is proposed to them like a split
+// change of update_track and the proposal id is returned.
+func (t *SimpleChaincode) create_track(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	// args
+	// 		0								1
+	//	   track JSON object (as string)	upsert (optional - replaces an existing track)
+
+	if len(args) < 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting track JSON")
+	}
+
+	var tr Track
+	err := decode_payload("track", args[0], &tr)
+	if err != nil {
+		return nil, err
+	}
+	err = validate_track_payload(tr)
+	if err != nil {
+		return nil, err
+	}
+	// the track it replaces, empty for a new track
+	var previous Track
+	existing, err := get_state(stub, tr.Iswc)
+	if err != nil {
+		return nil, errors.New("Failed to get track " + tr.Iswc)
+	}
+	if len(existing) > 0 {
+		if len(args) < 2 || args[1] != "upsert" {
+			return nil, already_exists(tr.Iswc)
+		}
+		json.Unmarshal(existing, &previous)
+		err = t.check_track_owner(stub, previous, tr.Iswc)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// what the track has been through is kept when it is registered anew, a new track starts without it
+	tr.CreatedAt	= ""
+	tr.WorkForHire	= previous.WorkForHire
+	tr.ReleasedAt	= ""
+	if tr.ReleaseAt != "" {
+		tr.ReleaseAt, err = parse_release_at(tr.ReleaseAt)
+		if err != nil {
+			return nil, err
+		}
+	}
+	_, err = normalize_iswc(work_iswc(tr))
+	if err != nil {
+		return nil, err
+	}
+	tr.Isrc, err = normalize_isrc(tr.Isrc)
+	if err != nil {
+		return nil, err
+	}
+	existingId, err := get_track_id_by_isrc(stub, tr.Isrc)
+	if err != nil {
+		return nil, err
+	}
+	if existingId != "" && existingId != tr.Iswc {
+		return nil, new_error("bad_arguments", "isrc.duplicate", map[string]string{"isrc": tr.Isrc, "trackId": existingId})
+	}
+	if tr.Currency != "" && !is_currency_code(tr.Currency) {
+		return nil, errors.New("Invalid currency " + tr.Currency + ", expecting an ISO 4217 code")
+	}
+	if tr.Recording != nil && tr.Composition == nil {
+		tr.Composition, err = get_work(stub, tr.Iswc)
+		if err != nil {
+			return nil, err
+		}
+	}
+	tr.Content, err = normalize_content_hash(tr.Content)
+	if err != nil {
+		return nil, err
+	}
+	err = check_content_not_registered(stub, tr.Content, tr.Iswc)
+	if err != nil {
+		return nil, err
+	}
+	err = validate_territory_prices(tr.TerritoryPrices)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	err = check_price_policy(stub, cfg, tr.Currency, tr.Price, tr.TerritoryPrices)
+	if err != nil {
+		return nil, err
+	}
+	err = validate_track_rights(tr)
+	if err != nil {
+		return nil, err
+	}
+	if tr.Recording != nil {
+		tr.Recording.Isrc = tr.Isrc
+	}
+	if tr.Composition != nil && tr.Composition.Iswc == "" {
+		tr.Composition.Iswc = tr.Iswc
+	}
+	err = resolve_track_placeholders(stub, tr.Iswc, tr)
+	if err != nil {
+		return nil, err
+	}
+	if tr.Artist == "" {
+		tr.Artist = main_beneficiary(tr)
+	}
+	if tr.Artist == "" {
+		return nil, field_required("track", "artist")
+	}
+	// registering a track anew does not bring back a track that was retired or taken down
+	tr.Status			= TrackActive
+	tr.DeactivatedAt	= ""
+	tr.TakenDownBy		= ""
+	if len(existing) > 0 {
+		tr.Status			= previous.Status
+		tr.DeactivatedAt	= previous.DeactivatedAt
+		tr.TakenDownBy		= previous.TakenDownBy
+	}
+	tr.PendingOwner	= ""
+	tr.ScheduledPrice	= nil
+	tr.PriceHistory		= previous.PriceHistory
+	tr.SplitSchedules	= previous.SplitSchedules
+	tr.SplitHistory		= previous.SplitHistory
+
+	// The invoker registering the track owns it, fall back to the main beneficiary when there is no caller certificate
+	tr.Owner, err = t.get_caller_username(stub)
+	if err != nil {
+		tr.Owner = main_beneficiary(tr)
+	}
+
+	// Tracks of artists on a label roster get the label's default contract
+	artistBytes, err := get_state(stub, tr.Artist)
+	if err == nil && len(artistBytes) > 0 {
+		artist, err := get_account(stub, tr.Artist)
+		if err != nil {
+			return nil, err
+		}
+		if tr.Recording != nil {
+			tr.Recording.Beneficiaries = apply_label_contract(tr.Recording.Beneficiaries, artist.LabelId, artist.LabelShare)
+		} else {
+			tr.Beneficiaries = apply_label_contract(tr.Beneficiaries, artist.LabelId, artist.LabelShare)
+		}
+	}
+
+	// The split registered is a split change like any other: it only takes effect once everyone it names approved it.
+	// Until then a new track pays its owner and a registered one keeps the split in force.
+	now, err := get_tx_time(stub, cfg)
+	if err != nil {
+		return nil, err
+	}
+	change := SplitSchedule{Beneficiaries: tr.Beneficiaries, Recording: tr.Recording, Composition: tr.Composition}
+	if has_separate_rights(tr) {
+		change.Beneficiaries = []Beneficiary{}
+	}
+	propose := false
+	if len(existing) > 0 {
+		if has_separate_rights(previous) && !has_separate_rights(tr) {
+			return nil, errors.New("Track " + tr.Iswc + " has separate rights, register it with a recording and a composition")
+		}
+		registered, _ := json.Marshal(SplitSchedule{Beneficiaries: tr.Beneficiaries, Recording: tr.Recording, Composition: tr.Composition})
+		inForce, _ := json.Marshal(SplitSchedule{Beneficiaries: previous.Beneficiaries, Recording: previous.Recording, Composition: previous.Composition})
+		propose = string(registered) != string(inForce)
+		tr.Beneficiaries, tr.Recording, tr.Composition = previous.Beneficiaries, previous.Recording, previous.Composition
+		if tr.Recording != nil {
+			tr.Recording.Isrc = tr.Isrc
+		}
+	} else {
+		approvers := split_change_approvers(change)
+		if len(approvers) > 1 || (len(approvers) == 1 && approvers[0] != tr.Owner) {
+			tr.Beneficiaries, tr.Recording, tr.Composition = []Beneficiary{{AccountId: tr.Owner, Percentage: 100}}, nil, nil
+			tr.DefaultSplit = true
+			propose = true
+		}
+	}
+	var proposalId string
+	if propose {
+		proposalId, err = t.propose_split_change(stub, tr.Iswc, &tr, change, nil, now)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = add_to_index(stub, trackIndexStr, tr.Iswc)
+	if err != nil {
+		return nil, errors.New("Error creating new id for thing " + tr.Iswc)
+	}
+
+	err = index_track_artist(stub, tr.Iswc, previous.Artist, tr.Artist)
+	if err != nil {
+		return nil, err
+	}
+
+	err = index_track_title(stub, tr.Iswc, previous.Title, tr.Title)
+	if err != nil {
+		return nil, err
+	}
+
+	err = index_track_work(stub, tr.Iswc, work_iswc(previous), work_iswc(tr))
+	if err != nil {
+		return nil, err
+	}
+
+	if previous.Isrc != "" && previous.Isrc != tr.Isrc {
+		err = del_state(stub, index_key(isrcIndexStr, previous.Isrc))
+		if err != nil {
+			return nil, errors.New("Error removing recording " + previous.Isrc + " from index")
+		}
+	}
+	err = put_state(stub, index_key(isrcIndexStr, tr.Isrc), []byte(tr.Iswc))
+	if err != nil {
+		return nil, errors.New("Error indexing recording " + tr.Isrc)
+	}
+
+	err = index_track_content(stub, tr.Iswc, previous.Content, tr.Content)
+	if err != nil {
+		return nil, err
+	}
+
+	err = put_track(stub, tr.Iswc, tr)
+	if err != nil {
+		return nil, err
+	}
+	err = emit_event(stub, "TrackAdded", tr)
+	if err != nil {
+		return nil, err
+	}
+	if proposalId != "" {
+		return []byte(proposalId), nil
+	}
+
+	return nil, nil
+}
+
+// An ISRC is CC-XXX-YY-NNNNN: country code, registrant code, year and designation code. It is stored in
+// upper case without hyphens.
+func normalize_isrc(isrc string) (string, error) {
+
+	normalized := strings.ToUpper(strings.Replace(strings.TrimSpace(isrc), "-", "", -1))
+	if !isrcPattern.MatchString(normalized) {
+		return "", new_error("bad_arguments", "isrc.invalid", map[string]string{"isrc": isrc})
+	}
+
+	return normalized, nil
+}
+
+// Returns the track registered for the recording, empty when there is none
+func get_track_id_by_isrc(stub shim.ChaincodeStubInterface, isrc string) (string, error) {
+
+	bytes, err := get_state(stub, index_key(isrcIndexStr, isrc))
+	if err != nil {
+		return "", errors.New("Failed to get isrc index")
+	}
+
+	return string(bytes), nil
+}
+
+// The same audio registered twice would be charged for twice, rejects content registered as another track
+func check_content_not_registered(stub shim.ChaincodeStubInterface, content string, trackId string) error {
+
+	bytes, err := get_state(stub, index_key(contentIndexStr, content))
+	if err != nil {
+		return errors.New("Failed to get content index")
+	}
+	if len(bytes) > 0 && string(bytes) != trackId {
+		return new_error("bad_arguments", "content.duplicate", map[string]string{"content": content, "trackId": string(bytes)})
+	}
+
+	return nil
+}
+
+// Moves a track in the content index from the hash it had to the one it has now
+func index_track_content(stub shim.ChaincodeStubInterface, trackId string, oldContent string, newContent string) error {
+
+	if oldContent == newContent {
+		return nil
+	}
+	if oldContent != "" {
+		err := del_state(stub, index_key(contentIndexStr, oldContent))
+		if err != nil {
+			return errors.New("Error removing content " + oldContent + " from index")
+		}
+	}
+	err := put_state(stub, index_key(contentIndexStr, newContent), []byte(trackId))
+	if err != nil {
+		return errors.New("Error indexing content " + newContent)
+	}
+
+	return nil
+}
+
+// Content is registered as the hex encoded SHA-256 of the audio, stored in lower case
+func normalize_content_hash(hash string) (string, error) {
+
+	normalized := strings.ToLower(strings.TrimSpace(hash))
+	if !contentHashPattern.MatchString(normalized) {
+		return "", new_error("bad_arguments", "content.invalid_hash", nil)
+	}
+
+	return normalized, nil
+}
+
+// Territory prices are keyed by ISO 3166 alpha-2 code
+func validate_territory_prices(prices map[string]int64) error {
+
+	for territory, price := range prices {
+		err := validate_territory(territory)
+		if err != nil {
+			return err
+		}
+		if price < 0 {
+			return errors.New("Price for territory " + territory + " cannot be negative")
+		}
+	}
+
+	return nil
+}
+
+// Price of a play of the track in a territory at the time it was played, the default price when the territory has none of its own
+func track_price(tr Track, territory string, playedAt time.Time) int64 {
+
+	price, territoryPrices := tr.Price, tr.TerritoryPrices
+	if tr.ScheduledPrice != nil {
+		effectiveAt, _ := time.Parse(time.RFC3339, tr.ScheduledPrice.EffectiveAt)
+		if !playedAt.Before(effectiveAt) {
+			price, territoryPrices = tr.ScheduledPrice.Price, tr.ScheduledPrice.TerritoryPrices
+		}
+	}
+	for _, past := range tr.PriceHistory {
+		until, _ := time.Parse(time.RFC3339, past.Until)
+		if playedAt.Before(until) {
+			price, territoryPrices = past.Price, past.TerritoryPrices
+			break
+		}
+	}
+
+	if territoryPrice, ok := territoryPrices[territory]; ok {
+		return territoryPrice
+	}
+
+	return price
+}
+
+// The split of a track registered without a split sheet: everything to the owner, less the platform's share
+func default_split(cfg Config, ownerId string) []Beneficiary {
+
+	if cfg.PlatformAccountId == "" || cfg.SimpleTrackPlatformPercent == 0 || cfg.PlatformAccountId == ownerId {
+		return []Beneficiary{{AccountId: ownerId, Percentage: 100}}
+	}
+
+	return []Beneficiary{
+		{AccountId: ownerId, Percentage: 100 - cfg.SimpleTrackPlatformPercent},
+		{AccountId: cfg.PlatformAccountId, Percentage: cfg.SimpleTrackPlatformPercent},
+	}
+}
+
+// Registers a track of an independent artist without a split sheet. The invoker is artist and owner and gets the
+// default split, update_track replaces it with a full split sheet later on while the track keeps its earnings.
+func (t *SimpleChaincode) add_simple_track(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	// args
+	// 		0		1		2		3		4
+	//	   iswc	  isrc	  price	  title	  content (SHA-256, hex)
+
+	if len(args) < 5 {
+		return nil, errors.New("Incorrect number of arguments. Expecting iswc, isrc, price, title and content")
+	}
+	price, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		return nil, errors.New("Invalid price " + args[2])
+	}
+
+	owner, err := t.get_caller_username(stub)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	var tr Track
+	tr.Iswc				= args[0]
+	tr.Isrc				= args[1]
+	tr.Price			= price
+	tr.Title			= args[3]
+	tr.Content			= args[4]
+	tr.Artist			= owner
+	tr.Beneficiaries	= default_split(cfg, owner)
+	tr.DefaultSplit		= true
+
+	trackBytes, _ := json.Marshal(tr)
+
+	return t.create_track(stub, []string{string(trackBytes)})
+}
+
+func validate_beneficiaries(beneficiaries []Beneficiary) error {
+
+	if len(beneficiaries) == 0 {
+		return new_error("bad_arguments", "splits.empty", nil)
+	}
+
+	var total int64
+	for _, b := range beneficiaries {
+		if b.AccountId == "" && b.Placeholder == "" {
+			return new_error("bad_arguments", "splits.account_missing", nil)
+		}
+		if b.Percentage <= 0 {
+			return new_error("bad_arguments", "splits.not_positive", nil)
+		}
+		total += b.Percentage
+	}
+	if total != 100 {
+		return new_error("bad_arguments", "splits.total_not_100", nil)
+	}
+
+	return nil
+}
+
+// Verifies the invoker is the registered owner of the track
+func (t *SimpleChaincode) check_track_owner(stub shim.ChaincodeStubInterface, tr Track, trackId string) error {
+
+	caller, err := t.get_caller_username(stub)
+	if err != nil {
+		return err
+	}
+	if tr.Owner == "" || caller != tr.Owner {
+		return errors.New("Only the owner of track " + trackId + " can change it")
+	}
+
+	return nil
+}
+
+func is_track_active(tr Track) bool {
+	return tr.Status == "" || tr.Status == TrackActive
+}
+
+func (t *SimpleChaincode) update_track(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	// args
+	// 		0			1
+	//	   trackId		update JSON object (as string) with any of title, price, territoryPrices, content, beneficiaries, recording, composition, effectiveFrom
+
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting trackId and update JSON")
+	}
+
+	tr, err := fetch_track(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.check_track_owner(stub, tr, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	var update TrackUpdate
+	err = json.Unmarshal([]byte(args[1]), &update)
+	if err != nil {
+		return nil, errors.New("Could not unmarshal track update: " + err.Error())
+	}
+
+	if update.Title != nil {
+		err = index_track_title(stub, args[0], tr.Title, *update.Title)
+		if err != nil {
+			return nil, err
+		}
+		tr.Title = *update.Title
+	}
+	// a re-pricing that took effect becomes the price being updated
+	if update.Price != nil || update.TerritoryPrices != nil {
+		cfg, err := get_config(stub)
+		if err != nil {
+			return nil, err
+		}
+		now, err := get_tx_time(stub, cfg)
+		if err != nil {
+			return nil, err
+		}
+		apply_scheduled_price(&tr, now)
+	}
+	if update.Price != nil {
+		if *update.Price < 0 {
+			return nil, errors.New("Price cannot be negative")
+		}
+		tr.Price = *update.Price
+	}
+	if update.TerritoryPrices != nil {
+		err = validate_territory_prices(update.TerritoryPrices)
+		if err != nil {
+			return nil, err
+		}
+		tr.TerritoryPrices = update.TerritoryPrices
+	}
+	if update.Currency != nil {
+		if *update.Currency != "" && !is_currency_code(*update.Currency) {
+			return nil, errors.New("Invalid currency " + *update.Currency + ", expecting an ISO 4217 code")
+		}
+		tr.Currency = *update.Currency
+	}
+	if update.Price != nil || update.TerritoryPrices != nil || update.Currency != nil {
+		cfg, err := get_config(stub)
+		if err != nil {
+			return nil, err
+		}
+		err = check_price_policy(stub, cfg, tr.Currency, tr.Price, tr.TerritoryPrices)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if update.Content != nil {
+		content, err := normalize_content_hash(*update.Content)
+		if err != nil {
+			return nil, err
+		}
+		err = check_content_not_registered(stub, content, args[0])
+		if err != nil {
+			return nil, err
+		}
+		err = index_track_content(stub, args[0], tr.Content, content)
+		if err != nil {
+			return nil, err
+		}
+		tr.Content = content
+	}
+	// a split change only takes effect once every account in the new split approved it
+	var proposalId string
+	if update.Beneficiaries != nil || update.Recording != nil || update.Composition != nil {
+		cfg, err := get_config(stub)
+		if err != nil {
+			return nil, err
+		}
+		now, err := get_tx_time(stub, cfg)
+		if err != nil {
+			return nil, err
+		}
+		change := SplitSchedule{Beneficiaries: update.Beneficiaries, Recording: update.Recording, Composition: update.Composition}
+		proposalId, err = t.propose_split_change(stub, args[0], &tr, change, update.EffectiveFrom, now)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = put_track(stub, args[0], tr)
+	if err != nil {
+		return nil, err
+	}
+	if proposalId != "" {
+		return []byte(proposalId), nil
+	}
+
+	return nil, nil
+}
+
+// Retires a track: it stays queryable for historical statements but no longer accepts plays
+func (t *SimpleChaincode) deactivate_track(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	// args
+	// 		0
+	//	   trackId
+
+	if len(args) < 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting trackId")
+	}
+
+	trackBytes, err := get_state(stub, args[0])
+	if err != nil || len(trackBytes) == 0 {
+		return nil, errors.New("Could not fetch track " + args[0])
+	}
+	var tr Track
+	err = json.Unmarshal(trackBytes, &tr)
+	if err != nil {
+		return nil, errors.New("Could not unmarshal track " + args[0])
+	}
+
+	err = t.check_track_owner(stub, tr, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if !is_track_active(tr) {
+		return nil, errors.New("Track " + args[0] + " is already inactive")
+	}
+
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := get_tx_time(stub, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	tr.Status			= TrackInactive
+	tr.DeactivatedAt	= now.Format(time.RFC3339)
+
+	err = put_track(stub, args[0], tr)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// Offers the ownership of a track to another account. The transfer only happens once the new owner accepts.
+func (t *SimpleChaincode) transfer_track_ownership(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	// args
+	// 		0			1
+	//	   trackId		newOwnerAccountId
+
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting trackId and newOwnerAccountId")
+	}
+
+	trackBytes, err := get_state(stub, args[0])
+	if err != nil || len(trackBytes) == 0 {
+		return nil, errors.New("Could not fetch track " + args[0])
+	}
+	var tr Track
+	err = json.Unmarshal(trackBytes, &tr)
+	if err != nil {
+		return nil, errors.New("Could not unmarshal track " + args[0])
+	}
+
+	err = t.check_track_owner(stub, tr, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if args[1] == tr.Owner {
+		return nil, errors.New("Account " + args[1] + " already owns track " + args[0])
+	}
+	err = check_not_frozen(stub, tr.Owner)
+	if err != nil {
+		return nil, err
+	}
+	err = check_not_frozen(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	tr.PendingOwner = args[1]
+
+	err = put_track(stub, args[0], tr)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// Accepts a pending ownership offer, the invoker must be the account the track was offered to
+func (t *SimpleChaincode) accept_track_ownership(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	// args
+	// 		0
+	//	   trackId
+
+	if len(args) < 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting trackId")
+	}
+
+	trackBytes, err := get_state(stub, args[0])
+	if err != nil || len(trackBytes) == 0 {
+		return nil, errors.New("Could not fetch track " + args[0])
+	}
+	var tr Track
+	err = json.Unmarshal(trackBytes, &tr)
+	if err != nil {
+		return nil, errors.New("Could not unmarshal track " + args[0])
+	}
+
+	caller, err := t.get_caller_username(stub)
+	if err != nil {
+		return nil, err
+	}
+	if tr.PendingOwner == "" || caller != tr.PendingOwner {
+		return nil, errors.New("Track " + args[0] + " has not been offered to " + caller)
+	}
+	err = check_not_frozen(stub, tr.Owner)
+	if err != nil {
+		return nil, err
+	}
+	err = check_not_frozen(stub, caller)
+	if err != nil {
+		return nil, err
+	}
+
+	tr.Owner		= tr.PendingOwner
+	tr.PendingOwner	= ""
+
+	err = put_track(stub, args[0], tr)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// Register that a track is played by an account
+// Pay out to the benificiaries of the track
+func (t *SimpleChaincode) register_track(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	// Args
+	// 0		1			2																	3						4											5			6
+	// trackId	played_by	played_at (RFC3339, optional - for plays synced late from offline clients)	territory (optional)	usageType (optional - defaults to stream)	source		promotionId (optional)
+
+	if len(args) < 6 {
+		return nil, errors.New("Incorrect number of arguments. Expecting trackId, played_by, played_at, territory, usageType and source")
+	}
+	err := t.check_play_submitter(stub, args[1], args[5])
+	if err != nil {
+		return nil, err
+	}
+
+	return t.record_track_play(stub, args, nil)
+}
+
+// Records a play of a track, optionally played from a playlist whose curator gets a cut
+func (t *SimpleChaincode) record_track_play(stub shim.ChaincodeStubInterface, args []string, playlist *Playlist) ([]byte, error) {
+
+	// 0. resolve the configuration of the submitter, when the play happened and when it is submitted
+	cfg, distributor, err := t.resolve_tx_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	submittedAt, err := get_tx_time(stub, cfg)
+	if err != nil {
+		return nil, err
+	}
+	playedAt := submittedAt
+	if len(args) > 2 && args[2] != "" {
+		playedAt, err = time.Parse(time.RFC3339, args[2])
+		if err != nil {
+			return nil, errors.New("Invalid played_at " + args[2] + ", expecting RFC3339")
+		}
+		if playedAt.After(submittedAt) {
+			return nil, errors.New("played_at cannot be in the future")
+		}
+	}
+	period, late, err := attribute_period(cfg, playedAt, submittedAt)
+	if err != nil {
+		return nil, err
+	}
+	// late plays no longer accrue to a period that has been closed
+	if late {
+		closed, err := is_period_closed(stub, period.Id)
+		if err != nil {
+			return nil, err
+		}
+		if closed {
+			period, err = resolve_period(cfg, submittedAt)
+			if err != nil {
+				return nil, err
+			}
+			late = false
+		}
+	}
+
+	// the play is the invoice every payment below refers to
+	playId, err := append_id(stub, playIndexStr, "pl", true)
+	if err != nil {
+		return nil, errors.New("Error creating new id for play")
+	}
+
+	// 1. get track
+	tr, err := fetch_track(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if tr.Status == TrackTakenDown {
+		return nil, errors.New("Track " + args[0] + " has been taken down by " + tr.TakenDownBy + " and cannot be played")
+	}
+	if !is_track_active(tr) {
+		return nil, errors.New("Track " + args[0] + " is inactive and cannot be played")
+	}
+	err = check_track_released(stub, &tr, args[0], submittedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	// 2. get played by account
+	playedByBytes, err := get_state(stub, args[1])
+	if err != nil {
+		return nil, errors.New("Could not fetch track ")
+	}
+	// 2b. unmarshal account
+	var account_sender Account
+	err = json.Unmarshal(playedByBytes, &account_sender)
+	if err != nil {
+		return nil, errors.New("Could not unmarshal account " )
+	}
+	err = check_account_not_frozen(account_sender)
+	if err != nil {
+		return nil, err
+	}
+
+	// Every play is tagged with the source it came from
+	source := args[5]
+	err = t.check_play_source(stub, source)
+	if err != nil {
+		return nil, err
+	}
+
+	// Payments are due according to the terms agreed with the sender
+	dueDate, err := payment_due_date(cfg, account_sender, submittedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	// Plays submitted through a distributor pay its fee first, the beneficiaries share the rest
+	// The territory of the play decides its price
+	territory := cfg.DefaultTerritory
+	if len(args) > 3 && args[3] != "" {
+		territory = args[3]
+	}
+	if territory != "" {
+		err = validate_territory(territory)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// and its usage type the rate card applied to that price
+	usageType := defaultUsageType
+	if len(args) > 4 && args[4] != "" {
+		usageType = args[4]
+	}
+	if !UsageTypes[usageType] {
+		return nil, errors.New("Usage type not recognized: " + usageType)
+	}
+	rateCard, err := get_rate_card(stub, usageType)
+	if err != nil {
+		return nil, err
+	}
+	price := apply_rate_card(rateCard, track_price(tr, territory, playedAt))
+
+	// the track is priced in its own currency, the play is paid in that of the submitter
+	platformCfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	price, err = convert_amount(stub, price, track_currency(platformCfg, tr), cfg.Currency)
+	if err != nil {
+		return nil, err
+	}
+
+	// A play covered by a subscription is not charged, the subscription pool of the period pays for it
+	subscription, err := get_subscription(stub, account_sender.Id)
+	if err != nil {
+		return nil, err
+	}
+	var covered string
+	if is_subscribed(subscription, playedAt) {
+		covered, price = subscription.Tier, 0
+	}
+
+	// A play over the play rate limits is rejected, or flagged and not charged
+	flagged, err := check_play_rate(stub, platformCfg, account_sender.Id, args[0], playedAt)
+	if err != nil {
+		return nil, err
+	}
+	if flagged != "" {
+		price = 0
+	}
+
+	// A play under a promotion is discounted, the funder of the promotion bears the discount
+	var promotion Promotion
+	var funderId string
+	if len(args) > 6 && args[6] != "" {
+		promotion, err = get_promotion(stub, args[6])
+		if err != nil {
+			return nil, err
+		}
+		funderId, err = promotion_funder(platformCfg, promotion, tr, args[0], distributor.DistributorId, submittedAt)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the platform takes its fee off the gross first, the distributor fee is on what is left
+	platformFee, platformLine := platform_fee_line(cfg, price)
+	fee, feeLines := distributor_fee_lines(cfg, distributor, price-platformFee)
+	if platformLine != nil {
+		feeLines = append([]Payment{*platformLine}, feeLines...)
+	}
+	distributable := price - platformFee - fee
+
+	// Plays from a playlist give the curator its cut of what is left
+	if playlist != nil {
+		cut := curator_cut(cfg, *playlist, distributable)
+		if cut > 0 {
+			feeLines = append(feeLines, Payment{RecipientId: playlist.Curator, Amount: cut})
+			distributable -= cut
+		}
+	}
+
+	// Create array for payments by sender
+	var senderPayments []Payment
+
+	// 3. loop through beneficiaries of track, over both rights when the track has separate rights
+	payouts := rights_payouts(cfg, track_split_at(tr, playedAt), distributable)
+	if covered != "" || flagged != "" {
+		payouts = nil
+	}
+	for _, payout := range payouts {
+
+		// 4. create a PendingPayment for each beneficiary, amount and rights follow from the split
+		var pendingPayment Payment
+		pendingPayment.Amount 		= payout.Amount
+		pendingPayment.Currency 	= cfg.Currency
+		pendingPayment.Completed 	= false
+		pendingPayment.RecipientId 	= payout.RecipientId
+		pendingPayment.Rights 		= payout.Rights
+		pendingPayment.SenderId 	= account_sender.Id
+		pendingPayment.CreatedAt 	= submittedAt.Format(time.RFC3339)
+		pendingPayment.DueDate 		= dueDate
+		pendingPayment.Period 		= period.Id
+		pendingPayment.Reference 	= string(playId)
+		pendingPayment.Source 		= source
+
+		senderPayments = append(senderPayments, pendingPayment)
+	}
+
+	// 4a. the platform fee, distributor fee and curator cut, a distributor playing through its own storefront just keeps its part
+	for _, line := range feeLines {
+		if line.RecipientId == account_sender.Id {
+			continue
+		}
+		line.SenderId 	= account_sender.Id
+		line.Currency 	= cfg.Currency
+		line.CreatedAt 	= submittedAt.Format(time.RFC3339)
+		line.DueDate 	= dueDate
+		line.Period 	= period.Id
+		line.Reference 	= string(playId)
+		line.Source 	= source
+
+		senderPayments = append(senderPayments, line)
+	}
+
+	// 4b. append the payments to their recipients, each recipient in one write whether it is a beneficiary with both
+	// rights, the platform, the distributor or the curator, or several of those. A player that is a recipient holds
+	// both copies of its payment, its copy as recipient goes on the sender account written below.
+	var recipientPayments []Payment
+	for _, payment := range senderPayments {
+		if payment.RecipientId == account_sender.Id {
+			err = check_account_not_frozen(account_sender)
+			if err != nil {
+				return nil, err
+			}
+			account_sender.PendingPayments = append(account_sender.PendingPayments, payment)
+			continue
+		}
+		recipientPayments = append(recipientPayments, payment)
+	}
+	err = append_to_recipients(stub, recipientPayments)
+	if err != nil {
+		return nil, err
+	}
+
+	// 4c. the funder of the promotion owes the payer the discount, the payer still owes the full price
+	var discount int64
+	if promotion.Id != "" {
+		discount = price * promotion.DiscountBps / 10000
+	}
+	if discount > 0 && funderId != account_sender.Id {
+		funder, err := get_account(stub, funderId)
+		if err != nil {
+			return nil, err
+		}
+		funderDueDate, err := payment_due_date(cfg, funder, submittedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		var funding Payment
+		funding.RecipientId	= account_sender.Id
+		funding.SenderId	= funderId
+		funding.Amount		= discount
+		funding.Currency	= cfg.Currency
+		funding.CreatedAt	= submittedAt.Format(time.RFC3339)
+		funding.DueDate		= funderDueDate
+		funding.Period		= period.Id
+		funding.Reference	= string(playId)
+		funding.Promotion	= promotion.Id
+
+		err = append_pending_payment(stub, funderId, funding)
+		if err != nil {
+			return nil, err
+		}
+		account_sender.PendingPayments = append(account_sender.PendingPayments, funding)
+
+		err = add_promotion_funding(stub, promotion.Id, period.Id, funderId, cfg.Currency, discount)
+		if err != nil {
+			return nil, err
+		}
+		err = emit_event(stub, "PaymentCreated", funding)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// 5. append senderPayments to sender account
+	var playAmount int64
+	for _, payment := range senderPayments {
+		account_sender.PendingPayments = append(account_sender.PendingPayments, payment)
+		playAmount += payment.Amount
+	}
+	err = put_account(stub, account_sender)
+	if err != nil {
+		return nil, err
+	}
+
+	// 6. record the play and index it under the account that played it
+	_, err = append_id(stub, account_plays_index_str(account_sender.Id), string(playId), false)
+	if err != nil {
+		return nil, errors.New("Error indexing play for account " + account_sender.Id)
+	}
+
+	var play Play
+	play.Id			= string(playId)
+	play.TrackId	= args[0]
+	play.PlayedBy	= account_sender.Id
+	play.Amount		= playAmount
+	play.Timestamp	= playedAt.Format(time.RFC3339)
+	play.SubmittedAt	= submittedAt.Format(time.RFC3339)
+	play.CreatedAt	= play.SubmittedAt
+	play.UpdatedAt	= play.SubmittedAt
+	play.Period		= period.Id
+	play.Late		= late
+	play.Payments	= senderPayments
+	play.Distributor	= distributor.DistributorId
+	play.Currency	= cfg.Currency
+	play.Territory	= territory
+	play.UsageType	= usageType
+	play.Source		= source
+	play.Promotion	= promotion.Id
+	play.Discount	= discount
+	play.Subscription	= covered
+	play.Flagged		= flagged
+	if playlist != nil {
+		play.PlaylistId = playlist.Id
+	}
+	err = seal_play_terms(stub, cfg, &play)
+	if err != nil {
+		return nil, err
+	}
+
+	playBytes, _ := json.Marshal(play)
+	err = put_state(stub, play.Id, playBytes)
+	if err != nil {
+		return nil, errors.New("Error putting play data on ledger")
+	}
+	err = add_to_index(stub, period_plays_index_str(play.Period), play.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	// 7. keep the per period earnings of the track and totals of the source up to date
+	err = add_track_earnings(stub, args[0], period.Id, playAmount)
+	if err != nil {
+		return nil, err
+	}
+	err = add_source_play(stub, source, period.Id, playAmount)
+	if err != nil {
+		return nil, err
+	}
+
+	// 8. late plays accrue to their closed period through the supplemental statement
+	if late {
+		err = add_late_accrual(stub, play, senderPayments)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = emit_event(stub, "PlayRegistered", play)
+	if err != nil {
+		return nil, err
+	}
+	err = emit_payments_created(stub, senderPayments)
+	if err != nil {
+		return nil, err
+	}
+
+	return playId, nil
+}
+
+// Marks the first open copy of a payment on the account completed, returns it
+func complete_payment(account *Account, reference string, senderId string, recipientId string, rights string, now time.Time) (*Payment, bool) {
+
+	for i, payment := range account.PendingPayments {
+		if payment.Completed || payment.Reference != reference || payment.SenderId != senderId || payment.RecipientId != recipientId {
+			continue
+		}
+		if rights != "" && payment.Rights != rights {
+			continue
+		}
+		account.PendingPayments[i].Completed = true
+		account.PendingPayments[i].UpdatedAt = now.Format(time.RFC3339)
+		return &account.PendingPayments[i], true
+	}
+
+	return nil, false
+}
+
+// The sender settles a pending payment, moving its amount from the sender's balance to the recipient's
+func (t *SimpleChaincode) settle_payment(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0				1					2
+	//		reference		recipientId		rights (optional, when the recipient has both)
+
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting reference and recipientId")
+	}
+	var rights string
+	if len(args) > 2 {
+		rights = args[2]
+	}
+
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := get_tx_time(stub, cfg)
+	if err != nil {
+		return nil, err
+	}
+	senderId, err := t.get_caller_username(stub)
+	if err != nil {
+		return nil, err
+	}
+	sender, err := get_account(stub, senderId)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = settle_open_payment(stub, cfg, &sender, args[0], args[1], rights, now, false)
+
+	return nil, err
+}
+
+type AccountSettlement struct {
+	AccountId			string				`json:"accountId"`
+	Settled				int					`json:"settled"`
+	Skipped				int					`json:"skipped"`
+	Failures			[]string			`json:"failures,omitempty"`	// why payments were skipped
+	Paid				map[string]int64	`json:"paid"`				// by currency, settled payments the account owed
+	Received			map[string]int64	`json:"received"`			// by currency, what the account was credited
+	Net					map[string]int64	`json:"net"`				// by currency, received less paid
+	SettledAt			string				`json:"settledAt"`
+}
+
+// Settles every open payment of an account in one transaction and nets what moved. The account settles what it owes,
+// an admin also settles what is owed to it. Payments that cannot be settled are skipped and reported.
+func (t *SimpleChaincode) settle_account(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		accountId
+
+	if len(args) < 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting accountId")
+	}
+
+	caller, err := t.get_caller_username(stub)
+	if err != nil {
+		return nil, err
+	}
+	admin := t.check_caller_role(stub, adminRole) == nil
+	if caller != args[0] && !admin {
+		return nil, errors.New("Only " + args[0] + " or an admin can settle account " + args[0])
+	}
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := get_tx_time(stub, cfg)
+	if err != nil {
+		return nil, err
+	}
+	account, err := get_account(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	// an account paying itself holds both copies of a payment, only one of them is settled
+	var open []Payment
+	selfCopies := map[string]int{}
+	for _, payment := range account.PendingPayments {
+		if payment.Completed {
+			continue
+		}
+		if payment.SenderId == account.Id && payment.RecipientId == account.Id {
+			key := payment.Reference + "~" + payment.Rights
+			selfCopies[key]++
+			if selfCopies[key]%2 == 0 {
+				continue
+			}
+		}
+		if payment.SenderId == account.Id || admin {
+			open = append(open, payment)
+		}
+	}
+
+	summary := AccountSettlement{AccountId: account.Id, Paid: map[string]int64{}, Received: map[string]int64{}, Net: map[string]int64{}, SettledAt: now.Format(time.RFC3339)}
+	for _, payment := range open {
+		// every settlement writes the accounts it touches, the sender is read back with what the settlements before
+		// it wrote, get_state answers with the transaction's own writes
+		sender, err := get_account(stub, payment.SenderId)
+		if err != nil {
+			return nil, err
+		}
+		settled, err := settle_open_payment(stub, cfg, &sender, payment.Reference, payment.RecipientId, payment.Rights, now, false)
+		if err != nil {
+			summary.Skipped++
+			summary.Failures = append(summary.Failures, payment.Reference+" from "+payment.SenderId+" to "+payment.RecipientId+": "+err.Error())
+			continue
+		}
+		summary.Settled++
+
+		if settled.SenderId == account.Id {
+			currency := payment_currency(cfg, settled)
+			summary.Paid[currency] += settled.Amount
+			summary.Net[currency] -= settled.Amount
+		}
+		if settled.RecipientId == account.Id {
+			currency, credited := payment_currency(cfg, settled), settled.Amount
+			if settled.Conversion != nil {
+				currency, credited = settled.Conversion.To, settled.Conversion.Converted
+			}
+			if settled.Withheld > 0 {
+				credited = settled.Net
+			}
+			credited -= settled.Recouped
+			summary.Received[currency] += credited
+			summary.Net[currency] += credited
+		}
+	}
+
+	summaryBytes, _ := json.Marshal(summary)
+
+	return summaryBytes, nil
+}
+
+// Settles an open payment the sender owes, moving its amount from the sender's balance to the recipient's. With
+// convert the recipient is credited in its preferred currency, converted at the recorded exchange rate. The sender
+// is only updated when the payment is settled, so a caller settling several payments can skip one that fails. The
+// recipient is read through get_state, so it carries what earlier settlements of the transaction credited it.
+func settle_open_payment(stub shim.ChaincodeStubInterface, cfg Config, sender *Account, reference string, recipientId string, rights string, now time.Time, convert bool) (Payment, error) {
+
+	settled := *sender
+	settled.PendingPayments = append([]Payment{}, sender.PendingPayments...)
+
+	senderCopy, found := complete_payment(&settled, reference, settled.Id, recipientId, rights, now)
+	if !found {
+		return Payment{}, errors.New("No open payment " + reference + " from " + settled.Id + " to " + recipientId)
+	}
+	payment := *senderCopy
+
+	// an account paying itself holds both copies of the payment
+	recipient := &settled
+	if payment.RecipientId != settled.Id {
+		account, err := get_account(stub, payment.RecipientId)
+		if err != nil {
+			return payment, err
+		}
+		recipient = &account
+	}
+	err := check_account_not_frozen(settled)
+	if err != nil {
+		return payment, err
+	}
+	err = check_account_not_frozen(*recipient)
+	if err != nil {
+		return payment, err
+	}
+	if is_payout_held(*recipient, now) {
+		return payment, errors.New("Payouts to " + recipient.Id + " are on hold until " + recipient.PayoutHoldUntil)
+	}
+	err = check_payment_payout_eligible(stub, cfg, payment, now)
+	if err != nil {
+		return payment, err
+	}
+	err = check_payment_not_disputed(stub, payment)
+	if err != nil {
+		return payment, err
+	}
+	err = check_high_value_settlement(stub, cfg, payment)
+	if err != nil {
+		return payment, err
+	}
+	external := settles_through_chaincode(stub, cfg)
+	currency := payment_currency(cfg, payment)
+	credited, creditedCurrency := payment.Amount, currency
+	if convert && !external && recipient.PreferredCurrency != "" && recipient.PreferredCurrency != currency {
+		payment.Conversion, err = amount_conversion(stub, payment.Amount, currency, recipient.PreferredCurrency)
+		if err != nil {
+			return payment, err
+		}
+		credited, creditedCurrency = payment.Conversion.Converted, payment.Conversion.To
+		senderCopy.Conversion = payment.Conversion
+	}
+	withheld, err := tax_withheld(cfg, *recipient, credited)
+	if err != nil {
+		return payment, err
+	}
+	if withheld > 0 {
+		payment.Withheld, payment.Net = withheld, credited-withheld
+		senderCopy.Withheld, senderCopy.Net = payment.Withheld, payment.Net
+	}
+	recoupments, recouped, err := advance_recoupments(stub, cfg, *recipient, credited-withheld, creditedCurrency)
+	if err != nil {
+		return payment, err
+	}
+	payment.Recouped = recouped
+	senderCopy.Recouped = recouped
+	payment.Accrued = !external && accrues_payout(cfg, *recipient, creditedCurrency)
+	senderCopy.Accrued = payment.Accrued
+	if external {
+		payment.TransferRef, err = transfer_through_chaincode(stub, cfg, payment.SenderId, recipient.Id, credited-withheld-recouped, currency, payment.Reference)
+		if err != nil {
+			return payment, err
+		}
+		senderCopy.TransferRef = payment.TransferRef
+	}
+	recipientCopy, _ := complete_payment(recipient, payment.Reference, payment.SenderId, payment.RecipientId, payment.Rights, now)
+	if recipientCopy != nil {
+		recipientCopy.Conversion = payment.Conversion
+		recipientCopy.Withheld, recipientCopy.Net = payment.Withheld, payment.Net
+		recipientCopy.Recouped = payment.Recouped
+		recipientCopy.Accrued = payment.Accrued
+		recipientCopy.TransferRef = payment.TransferRef
+	}
+
+	// withholding and payouts are recorded once both copies are stamped, recording them appends to the accounts
+	if !external {
+		adjust_balance(cfg, &settled, currency, -payment.Amount)
+		err = credit_payout(stub, cfg, recipient, payment.Period, creditedCurrency, credited-withheld-recouped, now)
+		if err != nil {
+			return payment, err
+		}
+	}
+	if withheld > 0 {
+		err = record_withholding(stub, cfg, payment, &settled, recipient, withheld, creditedCurrency, now)
+		if err != nil {
+			return payment, err
+		}
+	}
+	if recouped > 0 {
+		err = record_recoupments(stub, cfg, payment, &settled, recipient, recoupments, creditedCurrency, now)
+		if err != nil {
+			return payment, err
+		}
+	}
+
+	err = put_account(stub, settled)
+	if err != nil {
+		return payment, err
+	}
+	if recipient.Id != settled.Id {
+		err = put_account(stub, *recipient)
+		if err != nil {
+			return payment, err
+		}
+	}
+	*sender = settled
+
+	return payment, emit_event(stub, "PaymentSettled", payment)
+}
+
+//==============================================================================================================================
+//		Query Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) read_account(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			1
+	//		accountId
+
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting accountId")
+	}
+
+	bytes, err := get_state(stub, args[1])
+
+	if err != nil {
+		return nil, errors.New("Could not retrieve information for this user")
+	}
+	if len(bytes) == 0 {
+		return nil, errors.New("Account " + args[1] + " not found")
+	}
+
+	return bytes, nil
+
+}
+
+func (t *SimpleChaincode) get_track(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			1
+	//		thingID
+
+	bytes, err := get_state(stub, args[1])
+
+	if err != nil {
+		return nil, errors.New("Error getting from ledger")
+	}
+	if len(bytes) == 0 {
+		return bytes, nil
+	}
+	tr, err := read_track(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	bytes, _ = json.Marshal(tr)
+
+	return bytes, nil
+
+}
+
+type ContentVerification struct {
+	TrackId				string		`json:"trackId"`
+	Hash				string		`json:"hash"`
+	Matches				bool		`json:"matches"`
+	Owner				string		`json:"owner"`
+}
+
+// Confirms whether content matches the hash registered for a track, the ledger serves as proof of registration
+func (t *SimpleChaincode) verify_content(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			1			2
+	//		trackId		hash (SHA-256, hex)
+
+	if len(args) < 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting trackId and hash")
+	}
+
+	hash, err := normalize_content_hash(args[2])
+	if err != nil {
+		return nil, err
+	}
+	tr, err := read_track(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	var verification ContentVerification
+	verification.TrackId	= args[1]
+	verification.Hash		= hash
+	verification.Matches	= tr.Content == hash
+	verification.Owner		= tr.Owner
+
+	verificationBytes, _ := json.Marshal(verification)
+
+	return verificationBytes, nil
+}
+
+func (t *SimpleChaincode) get_track_by_isrc(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			1
+	//		isrc
+
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting isrc")
+	}
+
+	isrc, err := normalize_isrc(args[1])
+	if err != nil {
+		return nil, err
+	}
+	trackId, err := get_track_id_by_isrc(stub, isrc)
+	if err != nil {
+		return nil, err
+	}
+	if trackId == "" {
+		return nil, errors.New("No track found for isrc " + isrc)
+	}
+	tr, err := read_track(stub, trackId)
+	if err != nil {
+		return nil, err
+	}
+	trackBytes, _ := json.Marshal(tr)
+
+	return trackBytes, nil
+}
+
+func (t *SimpleChaincode) get_all_tracks(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	trackIndex, err := get_index_ids(stub, trackIndexStr)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println(trackIndexStr + " index retrieved")
+
+	tracks := []Track{}
+	for _, trackId := range trackIndex {
+
+		bytes, err := get_state(stub, trackId)
+		if err != nil {
+			return nil, errors.New("Unable to get thing with ID: " + trackId)
+		}
+
+		var t Track
+		json.Unmarshal(bytes, &t)
+		tracks = append(tracks, t)
+	}
+
+	tracksAsJsonBytes, err := json.Marshal(tracks)
+	if err != nil {
+		return nil, errors.New("Could not convert things to JSON ")
+	}
+
+	return tracksAsJsonBytes, nil
+}
+
+func (t *SimpleChaincode) get_tracks_by_artist(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			1
+	//		artistId
+
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting artistId")
+	}
+
+	trackIndex, err := get_index_ids(stub, artist_tracks_index_str(args[1]))
+	if err != nil {
+		return nil, err
+	}
+
+	tracks := []Track{}
+	for _, trackId := range trackIndex {
+
+		bytes, err := get_state(stub, trackId)
+		if err != nil {
+			return nil, errors.New("Unable to get track with ID: " + trackId)
+		}
+
+		var t Track
+		json.Unmarshal(bytes, &t)
+		tracks = append(tracks, t)
+	}
+
+	tracksAsJsonBytes, _ := json.Marshal(tracks)
+
+	return tracksAsJsonBytes, nil
+}
+
+func (t *SimpleChaincode) search_tracks(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			1					2
+	//		title prefix		max results (optional)
+
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting title prefix")
+	}
+	limit, err := page_size_arg(args, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := index_key(titleIndexStr, strings.ToLower(strings.TrimSpace(args[1])))
+	keysIter, err := range_query_state(stub, prefix, prefix+"\xff")
+	if err != nil {
+		return nil, errors.New("Failed to range query " + titleIndexStr + " index")
+	}
+	defer keysIter.Close()
+
+	tracks := []Track{}
+	for keysIter.HasNext() && len(tracks) < limit {
+		key, _, err := keysIter.Next()
+		if err != nil {
+			return nil, errors.New("Failed to iterate " + titleIndexStr + " index")
+		}
+		trackId := key[strings.LastIndex(key, "~")+1:]
+
+		bytes, err := get_state(stub, trackId)
+		if err != nil {
+			return nil, errors.New("Unable to get track with ID: " + trackId)
+		}
+
+		var t Track
+		json.Unmarshal(bytes, &t)
+		tracks = append(tracks, t)
+	}
+
+	tracksAsJsonBytes, _ := json.Marshal(tracks)
+
+	return tracksAsJsonBytes, nil
+}
+
+func (t *SimpleChaincode) get_all_accounts(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			1				2						3
+	//		bookmark		page size (optional)	filter (optional) - "pending" for accounts with pending payments,
+	//												"payable" for those of them that are not frozen or on a payout hold
+
+	var bookmark string
+	if len(args) > 1 {
+		bookmark = args[1]
+	}
+	pageSize, err := page_size_arg(args, 2)
+	if err != nil {
+		return nil, err
+	}
+	filter := ""
+	if len(args) > 3 {
+		filter = args[3]
+	}
+	var now time.Time
+	if filter == "payable" {
+		cfg, err := get_config(stub)
+		if err != nil {
+			return nil, err
+		}
+		now, err = get_tx_time(stub, cfg)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	keysIter, err := index_iterator_after(stub, accountIndexStr, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer keysIter.Close()
+
+	var page AccountPage
+	page.Accounts = []Account{}
+	prefix := index_key(accountIndexStr, "")
+	more := false
+
+	for keysIter.HasNext() {
+		key, _, err := keysIter.Next()
+		if err != nil {
+			return nil, errors.New("Failed to iterate " + accountIndexStr + " index")
+		}
+		accountId := key[len(prefix):]
+
+		// the page is full and there is at least one more account, so hand out a bookmark
+		if len(page.Accounts) == pageSize {
+			more = true
+			break
+		}
+		page.Bookmark = accountId
+
+		bytes, err := get_state(stub, accountId)
+		if err != nil {
+			return nil, errors.New("Unable to get account with ID: " + accountId)
+		}
+		var account Account
+		json.Unmarshal(bytes, &account)
+
+		if (filter == "pending" || filter == "payable") && !has_pending_payments(account) {
+			continue
+		}
+		if filter == "payable" && (is_payout_held(account, now) || account.Frozen) {
+			continue
+		}
+		page.Accounts = append(page.Accounts, account)
+	}
+	if !more {
+		page.Bookmark = ""
+	}
+
+	pageBytes, _ := json.Marshal(page)
+
+	return pageBytes, nil
+}
+
+// Accruals continue during a payout hold, only paying out waits for the release date
+func is_payout_held(account Account, now time.Time) bool {
+
+	if account.PayoutHoldUntil == "" {
+		return false
+	}
+	until, err := time.Parse(time.RFC3339, account.PayoutHoldUntil)
+	if err != nil {
+		return false
+	}
+
+	return now.Before(until)
+}
+
+func has_pending_payments(account Account) bool {
+	for _, payment := range account.PendingPayments {
+		if !payment.Completed && payment.Amount != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (t *SimpleChaincode) get_plays_by_account(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			1
+	//		accountId
+
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting accountId")
+	}
+	accountId := args[1]
+
+	accountBytes, err := get_state(stub, accountId)
+	if err != nil {
+		return nil, errors.New("Could not fetch account " + accountId)
+	}
+	var account Account
+	err = json.Unmarshal(accountBytes, &account)
+	if err != nil {
+		return nil, errors.New("Could not unmarshal account " + accountId)
+	}
+
+	playIndex, err := get_appended_ids(stub, account_plays_index_str(accountId))
+	if err != nil {
+		return nil, errors.New("Failed to get plays for account " + accountId)
+	}
+
+	var result AccountPlays
+	result.AccountId = accountId
+	result.Plays = []AccountPlay{}
+
+	var plays []Play
+	for _, playId := range playIndex {
+
+		bytes, err := get_state(stub, playId)
+		if err != nil {
+			return nil, errors.New("Unable to get play with ID: " + playId)
+		}
+
+		var p Play
+		json.Unmarshal(bytes, &p)
+		plays = append(plays, p)
+	}
+
+	// the index is in id order, the running total is in the order the plays were registered
+	sort.SliceStable(plays, func(i, j int) bool { return plays[i].SubmittedAt < plays[j].SubmittedAt })
+	for _, p := range plays {
+
+		result.TotalPlayed += p.Amount
+		result.Plays = append(result.Plays, AccountPlay{Play: p, RunningTotal: result.TotalPlayed})
+	}
+
+	// What is still owed are the payments this account sent that are not completed yet
+	for _, payment := range account.PendingPayments {
+		if payment.SenderId == accountId && !payment.Completed {
+			result.TotalOwed += payment.Amount
+		}
+	}
+
+	resultAsBytes, _ := json.Marshal(result)
+
+	return resultAsBytes, nil
+}
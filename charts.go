@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"sort"
+	"strconv"
+)
+
+//==============================================================================================================================
+//	 Chart Feed - Play counts per track per territory for a period, in the shape chart companies take submissions in.
+//				  Only qualified plays count: plays of a single track that were charged and not fully credited.
+//				  The feed can only be read by identities enrolled with the charts role.
+//==============================================================================================================================
+type ChartEntry struct {
+	Territory			string		`json:"territory"`
+	Position			int			`json:"position"`			// rank within the territory
+	TrackId				string		`json:"trackId"`
+	Isrc				string		`json:"isrc"`
+	Title				string		`json:"title"`
+	Artist				string		`json:"artist"`
+	Plays				int64		`json:"plays"`
+}
+
+type ChartFeed struct {
+	Period				string			`json:"period"`
+	Entries				[]ChartEntry	`json:"entries"`
+}
+
+var ChartCsvHeader = []string{"period", "territory", "position", "isrc", "title", "artist", "plays"}
+
+var ChartFormats = map[string]bool{
+	"json":		true,
+	"csv":		true,
+}
+
+var periodPlaysIndexStr = "period_plays"
+
+// Per-period index of the plays attributed to the period, "period_plays~<periodId>~<playId>"
+func period_plays_index_str(periodId string) string {
+	return index_key(periodPlaysIndexStr, periodId)
+}
+
+func chart_qualified(play Play) bool {
+	return play.TrackId != "" && play.Flagged == "" && play.Amount > 0 && play.Credited < play.Amount
+}
+
+// Counts the qualified plays of the period per territory and track, ranked by plays within each territory
+func build_chart_feed(stub shim.ChaincodeStubInterface, periodId string, territory string) (ChartFeed, error) {
+
+	feed := ChartFeed{Period: periodId, Entries: []ChartEntry{}}
+
+	playIds, err := get_index_ids(stub, period_plays_index_str(periodId))
+	if err != nil {
+		return feed, err
+	}
+
+	counts := map[string]map[string]int64{}
+	for _, playId := range playIds {
+		bytes, err := get_state(stub, playId)
+		if err != nil || len(bytes) == 0 {
+			return feed, errors.New("Could not fetch play " + playId)
+		}
+		var play Play
+		json.Unmarshal(bytes, &play)
+
+		if !chart_qualified(play) || (territory != "" && play.Territory != territory) {
+			continue
+		}
+		if counts[play.Territory] == nil {
+			counts[play.Territory] = map[string]int64{}
+		}
+		counts[play.Territory][play.TrackId]++
+	}
+
+	tracks := map[string]Track{}
+	for playTerritory, trackCounts := range counts {
+		var entries []ChartEntry
+		for trackId, plays := range trackCounts {
+			tr, ok := tracks[trackId]
+			if !ok {
+				tr, err = fetch_track(stub, trackId)
+				if err != nil {
+					return feed, err
+				}
+				tracks[trackId] = tr
+			}
+			entries = append(entries, ChartEntry{Territory: playTerritory, TrackId: trackId, Isrc: tr.Isrc, Title: tr.Title, Artist: tr.Artist, Plays: plays})
+		}
+		sort.SliceStable(entries, func(i, j int) bool {
+			if entries[i].Plays != entries[j].Plays {
+				return entries[i].Plays > entries[j].Plays
+			}
+			return entries[i].TrackId < entries[j].TrackId
+		})
+		for i := range entries {
+			entries[i].Position = i + 1
+		}
+		feed.Entries = append(feed.Entries, entries...)
+	}
+
+	sort.SliceStable(feed.Entries, func(i, j int) bool {
+		if feed.Entries[i].Territory != feed.Entries[j].Territory {
+			return feed.Entries[i].Territory < feed.Entries[j].Territory
+		}
+		return feed.Entries[i].Position < feed.Entries[j].Position
+	})
+
+	return feed, nil
+}
+
+func chart_csv(feed ChartFeed) ([]byte, error) {
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+
+	w.Write(ChartCsvHeader)
+	for _, entry := range feed.Entries {
+		w.Write([]string{
+			feed.Period,
+			entry.Territory,
+			strconv.Itoa(entry.Position),
+			entry.Isrc,
+			entry.Title,
+			entry.Artist,
+			strconv.FormatInt(entry.Plays, 10),
+		})
+	}
+	w.Flush()
+
+	if err := w.Error(); err != nil {
+		return nil, errors.New("Could not render chart feed as CSV")
+	}
+
+	return buf.Bytes(), nil
+}
+
+//==============================================================================================================================
+//		Query Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) get_chart_feed(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			1			2								3
+	//		periodId	territory (optional - all)		format (optional - json or csv)
+
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting periodId")
+	}
+
+	err := t.check_caller_role(stub, "charts")
+	if err != nil {
+		return nil, err
+	}
+
+	territory := ""
+	if len(args) > 2 {
+		territory = args[2]
+	}
+	format := "json"
+	if len(args) > 3 && args[3] != "" {
+		format = args[3]
+	}
+	if !ChartFormats[format] {
+		return nil, errors.New("Chart feed format not recognized: " + format)
+	}
+
+	feed, err := build_chart_feed(stub, args[1], territory)
+	if err != nil {
+		return nil, err
+	}
+
+	if format == "csv" {
+		return chart_csv(feed)
+	}
+
+	feedBytes, _ := json.Marshal(feed)
+
+	return feedBytes, nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"strconv"
+)
+
+//==============================================================================================================================
+//	 Compatibility Layer - Deprecated function names keep working by mapping their arguments onto the current handlers.
+//						   Their responses are wrapped in an envelope carrying a deprecation warning. A deployment can
+//						   switch the legacy routes off with the disableLegacyRoutes config flag.
+//==============================================================================================================================
+type LegacyRoute struct {
+	Target				string
+	Adapt				func(args []string) ([]string, error)
+}
+
+type ResponseEnvelope struct {
+	Data				json.RawMessage	`json:"data"`
+	Warnings			[]string		`json:"warnings"`
+	CorrelationId		string			`json:"correlationId,omitempty"`
+}
+
+var legacyInvokeRoutes = map[string]LegacyRoute{
+	"add_track":		{Target: "create_track", Adapt: legacy_add_track_args},
+	"invite_artist":	{Target: "add_artist_to_roster", Adapt: same_args},
+	"set_exchange_rate":	{Target: "set_fx_rate", Adapt: same_args},
+}
+
+var legacyQueryRoutes = map[string]LegacyRoute{
+	"get_account":	{Target: "read_account", Adapt: same_args},
+}
+
+func same_args(args []string) ([]string, error) {
+	return args, nil
+}
+
+// add_track took positional arguments and always split 75/25 between a main and a minor beneficiary
+func legacy_add_track_args(args []string) ([]string, error) {
+
+	// args
+	// 		0			1		2		3		4			5											6					7
+	//	   iswc	  isrc		price	main_ben	min_ben		artist (optional - defaults to main_ben)	title				content (SHA-256, hex)
+
+	// the original add_track took only the first five, a track now needs a title and its content which cannot be made up
+	if len(args) >= 5 && len(args) < 8 {
+		return nil, new_error("deprecated", "legacy.add_track_args", map[string]string{"target": "create_track"})
+	}
+	if len(args) < 8 {
+		return nil, errors.New("Incorrect number of arguments. Expecting iswc, isrc, price, main_ben, min_ben, artist, title and content")
+	}
+	price, err := strconv.Atoi(args[2])
+	if err != nil { return nil, errors.New("3rd arg must be a numeric string")}
+
+	var tr Track
+	tr.Iswc				= args[0]
+	tr.Isrc				= args[1]
+	tr.Price			= int64(price)
+	tr.Beneficiaries	= []Beneficiary{{AccountId: args[3], Percentage: 75}, {AccountId: args[4], Percentage: 25}}
+	tr.Artist			= args[5]
+	if tr.Artist == "" {
+		tr.Artist = args[3]
+	}
+	tr.Title			= args[6]
+	tr.Content			= args[7]
+
+	trackBytes, _ := json.Marshal(tr)
+
+	return []string{string(trackBytes)}, nil
+}
+
+// Calls the handler a legacy function maps to and wraps its result in an envelope with a deprecation warning
+func (t *SimpleChaincode) call_legacy_route(stub shim.ChaincodeStubInterface, function string, route LegacyRoute, args []string,
+	dispatch func(shim.ChaincodeStubInterface, string, []string) ([]byte, error)) ([]byte, error) {
+
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.DisableLegacyRoutes {
+		return nil, errors.New("Function " + function + " has been removed, use " + route.Target)
+	}
+
+	adapted, err := route.Adapt(args)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := dispatch(stub, route.Target, adapted)
+	if err != nil {
+		return nil, err
+	}
+
+	envelope := new_envelope(result)
+	envelope.Warnings = []string{"Function " + function + " is deprecated, use " + route.Target}
+
+	return json.Marshal(envelope)
+}
+
+// Wraps a handler result in an envelope, results that are not JSON are carried as a JSON string
+func new_envelope(result []byte) ResponseEnvelope {
+
+	var envelope ResponseEnvelope
+	envelope.Warnings = []string{}
+	if len(result) == 0 {
+		envelope.Data = json.RawMessage("null")
+	} else if json.Valid(result) {
+		envelope.Data = json.RawMessage(result)
+	} else {
+		envelope.Data, _ = json.Marshal(string(result))
+	}
+
+	return envelope
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"strconv"
+)
+
+//==============================================================================================================================
+//	 Platform Configuration - A single JSON document kept on the ledger under configKey. Every setting has a default
+//							  so a fresh deployment works without calling set_config first.
+//==============================================================================================================================
+type Config struct {
+	UtcOffsetMinutes	int				`json:"utcOffsetMinutes"`	// fixed offset of the platform timezone, e.g. 180 for EAT
+	PeriodStartDay		int				`json:"periodStartDay"`		// day of the month a settlement period starts (1-28)
+	Calendar			CalendarConfig	`json:"calendar"`
+	GraceWindowMinutes	int				`json:"graceWindowMinutes"`	// how long after a period closes late plays still attribute to it
+	DefaultPaymentTerms	string			`json:"defaultPaymentTerms"`	// terms for payers without agreed terms, e.g. net-30
+	LateInterestBps		int64			`json:"lateInterestBps"`		// interest charged per started 30 days overdue, in basis points
+	Currency			string			`json:"currency"`				// ISO 4217 code all amounts are denominated in
+	TaxRateBps			int64			`json:"taxRateBps"`				// sales tax included in prices, in basis points
+	ValuationMultiplePercent	int64	`json:"valuationMultiplePercent"`	// catalog value as a multiple of trailing earnings, 800 = 8x
+	PlatformAccountId	string			`json:"platformAccountId"`		// account receiving the platform's share of fees
+	PlatformFeeBps		int64			`json:"platformFeeBps"`			// fee the platform account takes off every play before it is split, in basis points
+	TaxAccountId		string			`json:"taxAccountId"`			// account receiving the tax withheld from payouts
+	DefaultTerritory	string			`json:"defaultTerritory"`		// ISO 3166 code used when a play has no territory
+	DisableLegacyRoutes	bool			`json:"disableLegacyRoutes"`	// reject deprecated function names instead of mapping them
+	MaxCuratorShareBps	int64			`json:"maxCuratorShareBps"`		// cap on the cut playlist curators can take of a play
+	MasterShareBps		int64			`json:"masterShareBps"`			// part of a play going to master rights, publishing gets the rest
+	MaxKeysReadPerTx	int				`json:"maxKeysReadPerTx"`		// invokes reading more keys are aborted, 0 is no limit
+	MaxKeysWrittenPerTx	int				`json:"maxKeysWrittenPerTx"`	// invokes writing more keys are aborted, 0 is no limit
+	MaxPayloadBytes		int				`json:"maxPayloadBytes"`		// largest invoke argument accepted, 0 is no limit
+	MaxUploadBytes		int				`json:"maxUploadBytes"`			// largest document a chunked upload can assemble, 0 is no limit
+	PreviewPlaysPerTrack	int			`json:"previewPlaysPerTrack"`	// free previews per listener per track, 0 is no limit
+	PreviewSecondsPerTrack	int			`json:"previewSecondsPerTrack"`	// free preview seconds per listener per track, 0 is no limit
+	SimpleTrackPlatformPercent	int64	`json:"simpleTrackPlatformPercent"`	// platform account's share in the default split of add_simple_track
+	MaxPayoutHoldDays	int				`json:"maxPayoutHoldDays"`		// longest payout hold an account can set, 0 is no limit
+	Sandbox				bool			`json:"sandbox"`				// every transaction runs in the platform sandbox with synthetic funds
+	ContentAttestationMaxAgeHours	int	`json:"contentAttestationMaxAgeHours"`	// content liveness attestations older than this are stale, 0 never
+	ExcludeUnliveContent	bool		`json:"excludeUnliveContent"`	// withhold payouts of plays of tracks whose content is failing or stale
+	MinPrice			int64			`json:"minPrice"`				// floor on the price of a play of a track, in the platform currency
+	MaxPrice			int64			`json:"maxPrice"`				// ceiling on the price of a play of a track, 0 is no ceiling
+	TerritoryPriceLimits	map[string]PriceLimits	`json:"territoryPriceLimits,omitempty"`	// floor and ceiling on territory prices, by ISO 3166 code
+	ResearchKeyHash		string			`json:"researchKeyHash"`		// SHA-256 of the key research exports pseudonymize accounts with, hex encoded
+	SubscriptionTiers	map[string]int64	`json:"subscriptionTiers,omitempty"`	// fee per period of each subscription tier, in the platform currency
+	MaxPlaysPerTrackPerHour	int			`json:"maxPlaysPerTrackPerHour"`	// plays of one track an account can make in an hour, 0 is no limit
+	MaxPlaysPerHour		int				`json:"maxPlaysPerHour"`		// plays of any track an account can make in an hour, 0 is no limit
+	PlayRateAction		string			`json:"playRateAction"`			// reject or flag plays over the limits, empty rejects
+	PrivateCollection	string			`json:"privateCollection"`		// private data collection deal terms are kept in, empty keeps them public
+	HighValuePaymentAmount	int64		`json:"highValuePaymentAmount"`	// settling payments of this much needs HighValueEndorsers too, 0 is off
+	HighValueEndorsers	[]string		`json:"highValueEndorsers,omitempty"`	// MSP ids, both set with set_high_value_policy only
+	SettlementChaincode	string			`json:"settlementChaincode"`	// token chaincode settlements move funds on, empty settles on account balances
+	SettlementChannel	string			`json:"settlementChannel"`		// channel of the settlement chaincode, empty is this channel
+	SettlementFunction	string			`json:"settlementFunction"`		// function of the settlement chaincode moving funds
+}
+
+// Price policy of a territory, replaces minPrice and maxPrice for the prices of that territory
+type PriceLimits struct {
+	Min					int64			`json:"min"`
+	Max					int64			`json:"max"`					// 0 is no ceiling
+}
+
+var configKey = "_config"
+
+func default_config() Config {
+	var cfg Config
+	cfg.UtcOffsetMinutes	= 0
+	cfg.PeriodStartDay		= 1
+	cfg.Calendar.Type		= "monthly"
+	cfg.DefaultPaymentTerms	= "net-30"
+	cfg.Currency			= "USD"
+	cfg.ValuationMultiplePercent	= 800
+	cfg.MaxCuratorShareBps	= 2000
+	cfg.MasterShareBps		= 8000
+	cfg.MaxKeysReadPerTx	= 10000
+	cfg.MaxKeysWrittenPerTx	= 2000
+	cfg.MaxPayloadBytes		= 64 * 1024
+	cfg.MaxUploadBytes		= 1024 * 1024
+	cfg.PreviewPlaysPerTrack	= 3
+	cfg.PreviewSecondsPerTrack	= 90
+	cfg.SimpleTrackPlatformPercent	= 10
+	cfg.MaxPayoutHoldDays	= 365
+	cfg.ContentAttestationMaxAgeHours	= 7 * 24
+	cfg.SettlementFunction	= "transfer"
+	return cfg
+}
+
+func validate_config(cfg Config) error {
+	if cfg.UtcOffsetMinutes < -12*60 || cfg.UtcOffsetMinutes > 14*60 {
+		return errors.New("utcOffsetMinutes must be between -720 and 840")
+	}
+	if cfg.PeriodStartDay < 1 || cfg.PeriodStartDay > 28 {
+		return errors.New("periodStartDay must be between 1 and 28")
+	}
+	if cfg.GraceWindowMinutes < 0 {
+		return errors.New("graceWindowMinutes cannot be negative")
+	}
+	if cfg.DefaultTerritory != "" && !Territories[cfg.DefaultTerritory] {
+		return errors.New("defaultTerritory " + cfg.DefaultTerritory + " is not an ISO 3166 alpha-2 code")
+	}
+	if _, ok := PaymentTermsDays[cfg.DefaultPaymentTerms]; !ok {
+		return errors.New("Payment terms not recognized: " + cfg.DefaultPaymentTerms)
+	}
+	if cfg.LateInterestBps < 0 {
+		return errors.New("lateInterestBps cannot be negative")
+	}
+	if len(cfg.Currency) != 3 {
+		return errors.New("currency must be a 3 letter ISO 4217 code")
+	}
+	if cfg.TaxRateBps < 0 || cfg.TaxRateBps > 10000 {
+		return errors.New("taxRateBps must be between 0 and 10000")
+	}
+	if cfg.ValuationMultiplePercent < 0 {
+		return errors.New("valuationMultiplePercent cannot be negative")
+	}
+	if cfg.MaxCuratorShareBps < 0 || cfg.MaxCuratorShareBps > 10000 {
+		return errors.New("maxCuratorShareBps must be between 0 and 10000")
+	}
+	if cfg.MasterShareBps < 0 || cfg.MasterShareBps > 10000 {
+		return errors.New("masterShareBps must be between 0 and 10000")
+	}
+	if cfg.MaxKeysReadPerTx < 0 || cfg.MaxKeysWrittenPerTx < 0 {
+		return errors.New("maxKeysReadPerTx and maxKeysWrittenPerTx cannot be negative")
+	}
+	if cfg.MaxPayloadBytes < 0 || cfg.MaxUploadBytes < 0 {
+		return errors.New("maxPayloadBytes and maxUploadBytes cannot be negative")
+	}
+	if cfg.PreviewPlaysPerTrack < 0 || cfg.PreviewSecondsPerTrack < 0 {
+		return errors.New("previewPlaysPerTrack and previewSecondsPerTrack cannot be negative")
+	}
+	if cfg.SimpleTrackPlatformPercent < 0 || cfg.SimpleTrackPlatformPercent >= 100 {
+		return errors.New("simpleTrackPlatformPercent must be between 0 and 99")
+	}
+	if cfg.MaxPayoutHoldDays < 0 {
+		return errors.New("maxPayoutHoldDays cannot be negative")
+	}
+	if cfg.PlatformFeeBps < 0 || cfg.PlatformFeeBps > 10000 {
+		return errors.New("platformFeeBps must be between 0 and 10000")
+	}
+	if cfg.PlatformFeeBps > 0 && cfg.PlatformAccountId == "" {
+		return errors.New("platformFeeBps requires a platformAccountId to pay the fee to")
+	}
+	if cfg.MinPrice < 0 || cfg.MaxPrice < 0 || (cfg.MaxPrice > 0 && cfg.MaxPrice < cfg.MinPrice) {
+		return errors.New("minPrice and maxPrice cannot be negative and maxPrice, when set, cannot be below minPrice")
+	}
+	for territory, limits := range cfg.TerritoryPriceLimits {
+		err := validate_territory(territory)
+		if err != nil {
+			return err
+		}
+		if limits.Min < 0 || limits.Max < 0 || (limits.Max > 0 && limits.Max < limits.Min) {
+			return errors.New("Price limits of territory " + territory + " cannot be negative and max, when set, cannot be below min")
+		}
+	}
+	if cfg.ResearchKeyHash != "" && !contentHashPattern.MatchString(cfg.ResearchKeyHash) {
+		return errors.New("researchKeyHash must be the SHA-256 of the research key, hex encoded in lower case")
+	}
+	for tier, fee := range cfg.SubscriptionTiers {
+		if fee <= 0 {
+			return errors.New("Fee of subscription tier " + tier + " must be positive")
+		}
+	}
+	if cfg.MaxPlaysPerTrackPerHour < 0 || cfg.MaxPlaysPerHour < 0 {
+		return errors.New("maxPlaysPerTrackPerHour and maxPlaysPerHour cannot be negative")
+	}
+	if cfg.PlayRateAction != "" && cfg.PlayRateAction != playRateReject && cfg.PlayRateAction != playRateFlag {
+		return errors.New("playRateAction must be reject or flag")
+	}
+	if cfg.ContentAttestationMaxAgeHours < 0 {
+		return errors.New("contentAttestationMaxAgeHours cannot be negative")
+	}
+	if cfg.SettlementChaincode != "" && cfg.SettlementFunction == "" {
+		return errors.New("settlementFunction is required with a settlementChaincode")
+	}
+	return validate_calendar(cfg.Calendar)
+}
+
+// Reads the platform configuration from the ledger, falling back to the defaults when none is stored
+func get_config(stub shim.ChaincodeStubInterface) (Config, error) {
+
+	cfg := default_config()
+
+	bytes, err := get_state(stub, configKey)
+	if err != nil {
+		return cfg, errors.New("Failed to get " + configKey)
+	}
+	if len(bytes) == 0 {
+		return cfg, nil
+	}
+
+	err = json.Unmarshal(bytes, &cfg)
+	if err != nil {
+		return cfg, errors.New("Could not unmarshal " + configKey)
+	}
+
+	return cfg, nil
+}
+
+// The platform fee on the gross amount of a play, taken before anything else. Returns the fee and its payment line,
+// only recipient, amount and fee are filled in.
+func platform_fee_line(cfg Config, gross int64) (int64, *Payment) {
+
+	fee := gross * cfg.PlatformFeeBps / 10000
+	if fee <= 0 || cfg.PlatformAccountId == "" {
+		return 0, nil
+	}
+
+	return fee, &Payment{RecipientId: cfg.PlatformAccountId, Amount: fee, Fee: "platform"}
+}
+
+// Checks the prices of a track against the price policy. The limits are in the platform currency, prices in another
+// currency are converted at the recorded rate to compare them.
+func check_price_policy(stub shim.ChaincodeStubInterface, cfg Config, currency string, price int64, territoryPrices map[string]int64) error {
+
+	if cfg.MinPrice == 0 && cfg.MaxPrice == 0 && len(cfg.TerritoryPriceLimits) == 0 {
+		return nil
+	}
+
+	check := func(territory string, price int64, limits PriceLimits) error {
+		converted, err := convert_amount(stub, price, currency_or_default(cfg, currency), cfg.Currency)
+		if err != nil {
+			return err
+		}
+		params := map[string]string{"price": strconv.FormatInt(price, 10), "currency": currency_or_default(cfg, currency), "territory": territory}
+		if converted < limits.Min {
+			params["limit"] = strconv.FormatInt(limits.Min, 10) + " " + cfg.Currency
+			return new_error("policy_violation", "price.below_floor", params)
+		}
+		if limits.Max > 0 && converted > limits.Max {
+			params["limit"] = strconv.FormatInt(limits.Max, 10) + " " + cfg.Currency
+			return new_error("policy_violation", "price.above_ceiling", params)
+		}
+		return nil
+	}
+
+	platformLimits := PriceLimits{Min: cfg.MinPrice, Max: cfg.MaxPrice}
+	err := check("default", price, platformLimits)
+	if err != nil {
+		return err
+	}
+	for territory, territoryPrice := range territoryPrices {
+		limits, ok := cfg.TerritoryPriceLimits[territory]
+		if !ok {
+			limits = platformLimits
+		}
+		err = check(territory, territoryPrice, limits)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+//==============================================================================================================================
+//  Invoke Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) set_config(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		config JSON object (as string)
+
+	if len(args) < 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting config JSON")
+	}
+
+	// the configuration names the platform account and switches the sandbox, only admins may change it
+	err := t.check_caller_role(stub, adminRole)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, store_config(stub, args[0])
+}
+
+// Validates a configuration document and puts it on the ledger, callers check who may do so
+func store_config(stub shim.ChaincodeStubInterface, cfgJSON string) error {
+
+	cfg := default_config()
+	err := json.Unmarshal([]byte(cfgJSON), &cfg)
+	if err != nil {
+		return errors.New("Could not unmarshal config: " + err.Error())
+	}
+
+	err = validate_config(cfg)
+	if err != nil {
+		return err
+	}
+	current, err := get_config(stub)
+	if err != nil {
+		return err
+	}
+	if current.PrivateCollection != "" && cfg.PrivateCollection != current.PrivateCollection {
+		return errors.New("privateCollection is " + current.PrivateCollection + " and cannot be changed, the deal terms are kept there")
+	}
+	// the high-value policy goes with the endorsement policy of its gate, set_high_value_policy changes both
+	cfg.HighValuePaymentAmount, cfg.HighValueEndorsers = current.HighValuePaymentAmount, current.HighValueEndorsers
+
+	cfgBytes, _ := json.Marshal(cfg)
+	err = put_state(stub, configKey, cfgBytes)
+	if err != nil {
+		return errors.New("Error putting config on ledger")
+	}
+	fmt.Println(configKey + " updated")
+
+	return nil
+}
+
+//==============================================================================================================================
+//		Query Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) query_config(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	cfgBytes, _ := json.Marshal(cfg)
+
+	return cfgBytes, nil
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"strconv"
+	"time"
+)
+
+//==============================================================================================================================
+//	 Credit Notes - A play is the invoice issued to the account that played the track. When usage was over-reported
+//					(or goods were returned) a credit note is issued against that invoice. Its lines are negative payments
+//					between the same parties, so they net against what the payer owes next and show up as negative lines
+//					on both parties' statements. Every credit note keeps a link to the invoice it credits. A credit
+//					note is issued by one of the beneficiaries of the invoice or by an admin.
+//==============================================================================================================================
+type CreditNote struct {
+	Id					string		`json:"id"`
+	InvoiceId			string		`json:"invoiceId"`
+	PayerId				string		`json:"payerId"`
+	Amount				int64		`json:"amount"`		// credited total, the lines carry it as negative amounts
+	Reason				string		`json:"reason"`
+	Period				string		`json:"period"`
+	IssuedAt			string		`json:"issuedAt"`
+	Lines				[]Payment	`json:"lines"`
+}
+
+var creditNoteIndexStr = "_credit_notes"
+
+// Per-invoice index of the credit notes issued against it
+func invoice_credit_notes_index_str(invoiceId string) string {
+	return creditNoteIndexStr + "_" + invoiceId
+}
+
+// Appends a payment to the pending payments of an account and puts the account back on the ledger
+func append_pending_payment(stub shim.ChaincodeStubInterface, accountId string, payment Payment) error {
+	return append_pending_payments(stub, accountId, []Payment{payment})
+}
+
+// Appends payments to the pending payments of their recipients, each recipient is read and written once however many
+// of the payments it gets
+func append_to_recipients(stub shim.ChaincodeStubInterface, payments []Payment) error {
+
+	var recipientIds []string
+	byRecipient := map[string][]Payment{}
+	for _, payment := range payments {
+		if _, ok := byRecipient[payment.RecipientId]; !ok {
+			recipientIds = append(recipientIds, payment.RecipientId)
+		}
+		byRecipient[payment.RecipientId] = append(byRecipient[payment.RecipientId], payment)
+	}
+	for _, recipientId := range recipientIds {
+		err := append_pending_payments(stub, recipientId, byRecipient[recipientId])
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Appends payments to the pending payments of an account and puts the account back on the ledger
+func append_pending_payments(stub shim.ChaincodeStubInterface, accountId string, payments []Payment) error {
+
+	bytes, err := get_state(stub, accountId)
+	if err != nil || len(bytes) == 0 {
+		return errors.New("Could not fetch account " + accountId)
+	}
+	var account Account
+	err = json.Unmarshal(bytes, &account)
+	if err != nil {
+		return errors.New("Could not unmarshal account " + accountId)
+	}
+	err = check_account_not_frozen(account)
+	if err != nil {
+		return err
+	}
+
+	account.PendingPayments = append(account.PendingPayments, payments...)
+
+	err = put_account(stub, account)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+//==============================================================================================================================
+//  Invoke Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) issue_credit_note(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0				1			2
+	//		invoiceId		amount		reason
+
+	if len(args) < 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting invoiceId, amount and reason")
+	}
+	amount, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil || amount <= 0 {
+		return nil, errors.New("2nd arg must be a positive numeric string")
+	}
+
+	invoiceBytes, err := get_state(stub, args[0])
+	if err != nil || len(invoiceBytes) == 0 {
+		return nil, errors.New("Could not fetch invoice " + args[0])
+	}
+	var invoice Play
+	err = json.Unmarshal(invoiceBytes, &invoice)
+	if err != nil {
+		return nil, errors.New("Could not unmarshal invoice " + args[0])
+	}
+	err = open_play_terms(stub, &invoice)
+	if err != nil {
+		return nil, err
+	}
+
+	// the credit is taken from what the beneficiaries were paid, so one of them has to issue it
+	caller, err := t.get_caller_username(stub)
+	if err != nil {
+		return nil, err
+	}
+	beneficiary := false
+	for _, line := range invoice.Payments {
+		if line.RecipientId == caller {
+			beneficiary = true
+			break
+		}
+	}
+	if !beneficiary && t.check_caller_role(stub, adminRole) != nil {
+		return nil, errors.New("Only a beneficiary of invoice " + invoice.Id + " or an admin can issue a credit note against it")
+	}
+	if invoice.Credited+amount > invoice.Amount {
+		return nil, errors.New("Credit exceeds the uncredited amount " + strconv.FormatInt(invoice.Amount-invoice.Credited, 10) + " of invoice " + invoice.Id)
+	}
+
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := get_tx_time(stub, cfg)
+	if err != nil {
+		return nil, err
+	}
+	period, err := resolve_period(cfg, now)
+	if err != nil {
+		return nil, err
+	}
+
+	creditNoteId, err := append_id(stub, creditNoteIndexStr, "cn", true)
+	if err != nil {
+		return nil, errors.New("Error creating new id for credit note")
+	}
+	_, err = append_id(stub, invoice_credit_notes_index_str(invoice.Id), string(creditNoteId), false)
+	if err != nil {
+		return nil, errors.New("Error indexing credit note for invoice " + invoice.Id)
+	}
+
+	var note CreditNote
+	note.Id			= string(creditNoteId)
+	note.InvoiceId	= invoice.Id
+	note.PayerId	= invoice.PlayedBy
+	note.Amount		= amount
+	note.Reason		= args[2]
+	note.Period		= period.Id
+	note.IssuedAt	= now.Format(time.RFC3339)
+
+	// Credit every invoice line pro rata, the rounding remainder goes to the first line
+	var allocated int64
+	for _, line := range invoice.Payments {
+		var credit Payment
+		credit.RecipientId		= line.RecipientId
+		credit.SenderId			= line.SenderId
+		credit.Amount			= -(amount * line.Amount / invoice.Amount)
+		credit.CreatedAt		= note.IssuedAt
+		credit.Period			= period.Id
+		credit.Reference		= note.Id
+		credit.CreditsInvoice	= invoice.Id
+		credit.Source			= line.Source
+		credit.Currency			= line.Currency
+
+		allocated -= credit.Amount
+		note.Lines = append(note.Lines, credit)
+	}
+	if len(note.Lines) > 0 {
+		note.Lines[0].Amount -= amount - allocated
+	}
+
+	for _, credit := range note.Lines {
+		err = append_pending_payment(stub, credit.RecipientId, credit)
+		if err != nil {
+			return nil, err
+		}
+		err = append_pending_payment(stub, credit.SenderId, credit)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = emit_payments_created(stub, note.Lines)
+	if err != nil {
+		return nil, err
+	}
+
+	// Credits reduce the earnings of the period the invoice was attributed to
+	if invoice.TrackId != "" {
+		err = add_track_earnings(stub, invoice.TrackId, invoice.Period, -amount)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	invoice.Credited	+= amount
+	invoice.UpdatedAt	= note.IssuedAt
+	err = seal_play_terms(stub, cfg, &invoice)
+	if err != nil {
+		return nil, err
+	}
+	invoiceBytes, _ = json.Marshal(invoice)
+	err = put_state(stub, invoice.Id, invoiceBytes)
+	if err != nil {
+		return nil, errors.New("Error putting invoice " + invoice.Id + " back on ledger")
+	}
+
+	noteBytes, _ := json.Marshal(note)
+	err = put_state(stub, note.Id, noteBytes)
+	if err != nil {
+		return nil, errors.New("Error putting credit note on ledger")
+	}
+
+	return creditNoteId, nil
+}
+
+//==============================================================================================================================
+//		Query Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) get_credit_notes(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			1
+	//		invoiceId
+
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting invoiceId")
+	}
+
+	noteIndex, err := get_appended_ids(stub, invoice_credit_notes_index_str(args[1]))
+	if err != nil {
+		return nil, errors.New("Failed to get credit notes for invoice " + args[1])
+	}
+
+	notes := []CreditNote{}
+	for _, noteId := range noteIndex {
+
+		bytes, err := get_state(stub, noteId)
+		if err != nil {
+			return nil, errors.New("Unable to get credit note with ID: " + noteId)
+		}
+
+		var note CreditNote
+		json.Unmarshal(bytes, &note)
+		notes = append(notes, note)
+	}
+
+	notesBytes, _ := json.Marshal(notes)
+
+	return notesBytes, nil
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"strconv"
+	"time"
+)
+
+//==============================================================================================================================
+//	 Currencies - Royalties are paid in several currencies, so every amount carries an ISO 4217 currency code: a track's
+//				  prices are in the track's currency, a payment is in its own currency and an account keeps its balance
+//				  in its own currency plus a balance per other currency it holds. An empty code is the platform currency.
+//				  Amounts in different currencies are never added up: a play priced in another currency than the
+//				  one it is paid in is converted at the recorded exchange rate, and is rejected when there is none,
+//				  and an account converts a balance into another currency with convert_balance.
+//
+//				  Exchange rates are recorded by oracles, invokers whose certificate has role "oracle", with the time
+//				  the oracle observed them. A payment settled with convert_and_settle_payment, or by close_period, is
+//				  credited to the recipient in its preferred currency, and carries the rate it was converted at.
+//==============================================================================================================================
+type ExchangeRate struct {
+	From				string		`json:"from"`
+	To					string		`json:"to"`
+	RateMicros			int64		`json:"rateMicros"`		// millionths of a unit of To per unit of From
+	AsOf				string		`json:"asOf"`			// RFC3339 time the oracle observed the rate
+	SetBy				string		`json:"setBy"`
+	SetAt				string		`json:"setAt"`
+}
+
+// The conversion a payment was settled with
+type FxConversion struct {
+	From				string		`json:"from"`
+	To					string		`json:"to"`
+	RateMicros			int64		`json:"rateMicros"`
+	AsOf				string		`json:"asOf"`
+	Converted			int64		`json:"converted"`		// what the recipient was credited, in To
+}
+
+var oracleRole = "oracle"
+var exchangeRateKeyPrefix = "_exchange_rate_"
+
+func exchange_rate_key(from string, to string) string {
+	return exchangeRateKeyPrefix + from + "_" + to
+}
+
+func is_currency_code(code string) bool {
+
+	if len(code) != 3 {
+		return false
+	}
+	for _, c := range code {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// The currency of an amount, an empty code is the platform currency
+func currency_or_default(cfg Config, currency string) string {
+	if currency == "" {
+		return cfg.Currency
+	}
+	return currency
+}
+
+func track_currency(cfg Config, tr Track) string {
+	return currency_or_default(cfg, tr.Currency)
+}
+
+func payment_currency(cfg Config, payment Payment) string {
+	return currency_or_default(cfg, payment.Currency)
+}
+
+// Returns what the account holds in the currency
+func balance_in(cfg Config, account Account, currency string) int64 {
+
+	if currency == currency_or_default(cfg, account.Currency) {
+		return account.Balance
+	}
+
+	return account.Balances[currency]
+}
+
+// Adds the amount, in the currency, to the balance of the account
+func adjust_balance(cfg Config, account *Account, currency string, amount int64) {
+
+	if currency == currency_or_default(cfg, account.Currency) {
+		account.Balance += amount
+		return
+	}
+	if account.Balances == nil {
+		account.Balances = map[string]int64{}
+	}
+	account.Balances[currency] += amount
+	if account.Balances[currency] == 0 {
+		delete(account.Balances, currency)
+	}
+}
+
+// Moves every balance of an account onto another account, currency by currency
+func move_balances(cfg Config, from *Account, to *Account) {
+
+	adjust_balance(cfg, to, currency_or_default(cfg, from.Currency), from.Balance)
+	for currency, amount := range from.Balances {
+		adjust_balance(cfg, to, currency, amount)
+	}
+	from.Balance	= 0
+	from.Balances	= nil
+}
+
+func get_exchange_rate(stub shim.ChaincodeStubInterface, from string, to string) (ExchangeRate, bool, error) {
+
+	var rate ExchangeRate
+
+	bytes, err := get_state(stub, exchange_rate_key(from, to))
+	if err != nil {
+		return rate, false, errors.New("Failed to get exchange rate from " + from + " to " + to)
+	}
+	if len(bytes) == 0 {
+		return rate, false, nil
+	}
+	err = json.Unmarshal(bytes, &rate)
+	if err != nil {
+		return rate, false, errors.New("Could not unmarshal exchange rate from " + from + " to " + to)
+	}
+
+	return rate, true, nil
+}
+
+// Converts an amount between currencies at the recorded rate, rounding down
+func convert_amount(stub shim.ChaincodeStubInterface, amount int64, from string, to string) (int64, error) {
+
+	if from == to {
+		return amount, nil
+	}
+
+	conversion, err := amount_conversion(stub, amount, from, to)
+	if err != nil {
+		return 0, err
+	}
+
+	return conversion.Converted, nil
+}
+
+// Converts an amount at the recorded rate, keeping the rate it was converted at
+func amount_conversion(stub shim.ChaincodeStubInterface, amount int64, from string, to string) (*FxConversion, error) {
+
+	rate, found, err := get_exchange_rate(stub, from, to)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, errors.New("No exchange rate from " + from + " to " + to + ", amounts in " + from + " cannot be used as " + to + " without converting them")
+	}
+
+	return &FxConversion{From: from, To: to, RateMicros: rate.RateMicros, AsOf: rate.AsOf, Converted: amount * rate.RateMicros / 1000000}, nil
+}
+
+//==============================================================================================================================
+//  Invoke Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) set_fx_rate(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0			1			2												3
+	//		from		to			rate (millionths of a unit of to per unit of from)	as of (RFC3339, optional - defaults to the transaction time)
+
+	if len(args) < 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting from, to and rate")
+	}
+	if !is_currency_code(args[0]) || !is_currency_code(args[1]) || args[0] == args[1] {
+		return nil, errors.New("Invalid currencies " + args[0] + " and " + args[1] + ", expecting two different ISO 4217 codes")
+	}
+	rateMicros, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil || rateMicros <= 0 {
+		return nil, errors.New("Invalid rate " + args[2] + ", expecting a positive number of millionths")
+	}
+
+	err = t.check_caller_role(stub, oracleRole)
+	if err != nil {
+		return nil, err
+	}
+	caller, err := t.get_caller_username(stub)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := get_tx_time(stub, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	rate := ExchangeRate{From: args[0], To: args[1], RateMicros: rateMicros, AsOf: now.Format(time.RFC3339), SetBy: caller, SetAt: now.Format(time.RFC3339)}
+	if len(args) > 3 && args[3] != "" {
+		asOf, err := time.Parse(time.RFC3339, args[3])
+		if err != nil {
+			return nil, errors.New("Invalid as of " + args[3] + ", expecting RFC3339")
+		}
+		if asOf.After(now) {
+			return nil, errors.New("Invalid as of " + args[3] + ", it cannot be in the future")
+		}
+		rate.AsOf = asOf.Format(time.RFC3339)
+	}
+
+	// an observation older than the recorded one does not replace it
+	current, found, err := get_exchange_rate(stub, rate.From, rate.To)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		currentAsOf, _ := time.Parse(time.RFC3339, current.AsOf)
+		asOf, _ := time.Parse(time.RFC3339, rate.AsOf)
+		if asOf.Before(currentAsOf) {
+			return nil, errors.New("Invalid as of " + rate.AsOf + ", a rate as of " + current.AsOf + " is already recorded")
+		}
+	}
+
+	rateBytes, _ := json.Marshal(rate)
+	err = put_state(stub, exchange_rate_key(rate.From, rate.To), rateBytes)
+	if err != nil {
+		return nil, errors.New("Error putting exchange rate from " + rate.From + " to " + rate.To + " on ledger")
+	}
+
+	return nil, nil
+}
+
+// Sets the currency the invoker wants converted payments credited in, empty to take payments as they come
+func (t *SimpleChaincode) set_preferred_currency(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		currency (ISO 4217, empty to clear)
+
+	if len(args) < 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting currency")
+	}
+	if args[0] != "" && !is_currency_code(args[0]) {
+		return nil, errors.New("Invalid currency " + args[0] + ", expecting an ISO 4217 code")
+	}
+
+	accountId, err := t.get_caller_username(stub)
+	if err != nil {
+		return nil, err
+	}
+	account, err := get_account(stub, accountId)
+	if err != nil {
+		return nil, err
+	}
+	account.PreferredCurrency = args[0]
+
+	return nil, put_account(stub, account)
+}
+
+// The sender settles a pending payment, the recipient is credited in its preferred currency at the recorded rate
+func (t *SimpleChaincode) convert_and_settle_payment(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0				1					2
+	//		reference		recipientId		rights (optional, when the recipient has both)
+
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting reference and recipientId")
+	}
+	var rights string
+	if len(args) > 2 {
+		rights = args[2]
+	}
+
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	if settles_through_chaincode(stub, cfg) {
+		return nil, errors.New("Payments are settled on " + cfg.SettlementChaincode + " in their own currency and cannot be converted, use settle_payment")
+	}
+	now, err := get_tx_time(stub, cfg)
+	if err != nil {
+		return nil, err
+	}
+	senderId, err := t.get_caller_username(stub)
+	if err != nil {
+		return nil, err
+	}
+	sender, err := get_account(stub, senderId)
+	if err != nil {
+		return nil, err
+	}
+
+	payment, err := settle_open_payment(stub, cfg, &sender, args[0], args[1], rights, now, true)
+	if err != nil {
+		return nil, err
+	}
+
+	paymentBytes, _ := json.Marshal(payment)
+
+	return paymentBytes, nil
+}
+
+// Converts part of the invoker's balance in one currency into another currency
+func (t *SimpleChaincode) convert_balance(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0			1			2
+	//		from		to			amount (in from)
+
+	if len(args) < 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting from, to and amount")
+	}
+	amount, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil || amount <= 0 {
+		return nil, errors.New("Invalid amount " + args[2])
+	}
+
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	if settles_through_chaincode(stub, cfg) {
+		return nil, errors.New("Balances are held on " + cfg.SettlementChaincode + ", converting them is up to that chaincode")
+	}
+	accountId, err := t.get_caller_username(stub)
+	if err != nil {
+		return nil, err
+	}
+	account, err := get_account(stub, accountId)
+	if err != nil {
+		return nil, err
+	}
+	err = check_account_not_frozen(account)
+	if err != nil {
+		return nil, err
+	}
+	if balance_in(cfg, account, args[0]) < amount {
+		return nil, errors.New("Insufficient " + args[0] + " balance to convert " + args[2])
+	}
+
+	converted, err := convert_amount(stub, amount, args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	adjust_balance(cfg, &account, args[0], -amount)
+	adjust_balance(cfg, &account, args[1], converted)
+
+	err = put_account(stub, account)
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(strconv.FormatInt(converted, 10)), nil
+}
+
+//==============================================================================================================================
+//		Query Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) query_exchange_rate(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			1			2
+	//		from		to
+
+	if len(args) < 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting from and to")
+	}
+
+	rate, found, err := get_exchange_rate(stub, args[1], args[2])
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, errors.New("No exchange rate from " + args[1] + " to " + args[2])
+	}
+
+	rateBytes, _ := json.Marshal(rate)
+
+	return rateBytes, nil
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"time"
+)
+
+//==============================================================================================================================
+//	 Disputes - A party that disagrees with a payment or with the rights to a track opens a dispute on it. A payment is
+//				disputed by the reference it originates from (the play, purchase or pool), by an account with an open
+//				payment of that reference, a track by anyone claiming a share of it. While the dispute is open nothing
+//				referencing it settles: no payment of the reference, and no payment of a play or purchase of the
+//				disputed track, neither by settlement nor by netting. The parties add the hashes of their evidence and
+//				an arbiter resolves the dispute, upholding or dismissing it. Disputes are never deleted, they are the
+//				paper trail of the disagreement. A target has one open dispute at a time.
+//==============================================================================================================================
+type Dispute struct {
+	Id					string				`json:"id"`
+	TargetId			string				`json:"targetId"`				// a track, or the reference of the disputed payments
+	TargetType			string				`json:"targetType"`				// track or payment
+	OpenedBy			string				`json:"openedBy"`
+	Parties				[]string			`json:"parties"`				// the opener and the accounts on the other side
+	Reason				string				`json:"reason"`
+	Evidence			[]DisputeEvidence	`json:"evidence"`
+	Status				string				`json:"status"`					// open, upheld or dismissed
+	Resolution			string				`json:"resolution,omitempty"`
+	ResolvedBy			string				`json:"resolvedBy,omitempty"`
+	OpenedAt			string				`json:"openedAt"`
+	ResolvedAt			string				`json:"resolvedAt,omitempty"`
+}
+
+type DisputeEvidence struct {
+	Hash				string		`json:"hash"`					// SHA-256 of the document
+	Note				string		`json:"note,omitempty"`
+	AddedBy				string		`json:"addedBy"`
+	AddedAt				string		`json:"addedAt"`
+}
+
+var arbiterRole = "arbiter"
+
+var disputeIndexStr = "_disputes"
+var targetDisputesIndexStr = "target_disputes"
+var partyOpenDisputesIndexStr = "party_open_disputes"
+
+// Per-target index of the disputes opened on the track or payment reference, "target_disputes~<targetId>~<disputeId>"
+func target_disputes_index_str(targetId string) string {
+	return index_key(targetDisputesIndexStr, targetId)
+}
+
+// Per-account index of the open disputes the account is a party to, "party_open_disputes~<accountId>~<disputeId>"
+func party_open_disputes_index_str(accountId string) string {
+	return index_key(partyOpenDisputesIndexStr, accountId)
+}
+
+// Holds the id of the open dispute on a target
+func open_dispute_key(targetId string) string {
+	return "_dispute_open_" + targetId
+}
+
+func get_dispute(stub shim.ChaincodeStubInterface, disputeId string) (Dispute, error) {
+
+	var dispute Dispute
+
+	bytes, err := get_state(stub, disputeId)
+	if err != nil || len(bytes) == 0 {
+		return dispute, errors.New("Could not fetch dispute " + disputeId)
+	}
+	err = json.Unmarshal(bytes, &dispute)
+	if err != nil {
+		return dispute, errors.New("Could not unmarshal dispute " + disputeId)
+	}
+
+	return dispute, nil
+}
+
+func put_dispute(stub shim.ChaincodeStubInterface, dispute Dispute) error {
+
+	disputeBytes, _ := json.Marshal(dispute)
+	err := put_state(stub, dispute.Id, disputeBytes)
+	if err != nil {
+		return errors.New("Error putting dispute " + dispute.Id + " on ledger")
+	}
+
+	return nil
+}
+
+func check_not_disputed(stub shim.ChaincodeStubInterface, targetId string) error {
+
+	disputeId, err := get_state(stub, open_dispute_key(targetId))
+	if err != nil {
+		return errors.New("Failed to get disputes of " + targetId)
+	}
+	if len(disputeId) > 0 {
+		return errors.New(targetId + " is under dispute in " + string(disputeId))
+	}
+
+	return nil
+}
+
+// A payment cannot settle while its reference, or the track its play or purchase is of, is under dispute
+func check_payment_not_disputed(stub shim.ChaincodeStubInterface, payment Payment) error {
+
+	err := check_not_disputed(stub, payment.Reference)
+	if err != nil {
+		return err
+	}
+
+	bytes, err := get_state(stub, payment.Reference)
+	if err != nil || len(bytes) == 0 {
+		return nil
+	}
+	var origin struct {
+		TrackId		string		`json:"trackId"`
+	}
+	json.Unmarshal(bytes, &origin)
+	if origin.TrackId == "" {
+		return nil
+	}
+
+	return check_not_disputed(stub, origin.TrackId)
+}
+
+//==============================================================================================================================
+//  Invoke Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) open_dispute(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0				1			2
+	//		targetId		reason		evidence hash (optional)
+
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting targetId and reason")
+	}
+	if args[1] == "" {
+		return nil, errors.New("A dispute needs a reason")
+	}
+
+	caller, err := t.get_caller_username(stub)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := get_tx_time(stub, cfg)
+	if err != nil {
+		return nil, err
+	}
+	err = check_not_disputed(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	var evidence []DisputeEvidence
+	if len(args) > 2 && args[2] != "" {
+		hash, err := normalize_content_hash(args[2])
+		if err != nil {
+			return nil, err
+		}
+		evidence = append(evidence, DisputeEvidence{Hash: hash, AddedBy: caller, AddedAt: now.Format(time.RFC3339)})
+	}
+
+	dispute := Dispute{TargetId: args[0], OpenedBy: caller, Parties: []string{caller}, Reason: args[1], Evidence: []DisputeEvidence{}, Status: "open", OpenedAt: now.Format(time.RFC3339)}
+	dispute.Evidence = append(dispute.Evidence, evidence...)
+
+	indexed, err := get_state(stub, index_key(trackIndexStr, args[0]))
+	if err != nil {
+		return nil, errors.New("Failed to get track " + args[0])
+	}
+	if len(indexed) > 0 {
+		tr, err := fetch_track(stub, args[0])
+		if err != nil {
+			return nil, err
+		}
+		// a work for hire contributor has no claim on the track unless it brings new evidence
+		hashes := []string{}
+		for _, e := range evidence {
+			hashes = append(hashes, e.Hash)
+		}
+		err = check_work_for_hire_claim(tr, args[0], caller, hashes)
+		if err != nil {
+			return nil, err
+		}
+		dispute.TargetType = "track"
+		if tr.Owner != "" && tr.Owner != caller {
+			dispute.Parties = append(dispute.Parties, tr.Owner)
+		}
+	} else {
+		// a payment is disputed by a party to it, the other side is every counterparty of the reference
+		account, err := get_account(stub, caller)
+		if err != nil {
+			return nil, err
+		}
+		for _, payment := range account.PendingPayments {
+			if payment.Completed || payment.Reference != args[0] {
+				continue
+			}
+			for _, party := range []string{payment.SenderId, payment.RecipientId} {
+				if !contains_id(dispute.Parties, party) {
+					dispute.Parties = append(dispute.Parties, party)
+				}
+			}
+			dispute.TargetType = "payment"
+		}
+		if dispute.TargetType == "" {
+			return nil, errors.New(args[0] + " is neither a track nor the reference of an open payment of " + caller)
+		}
+	}
+
+	disputeId, err := append_id(stub, disputeIndexStr, "dsp", true)
+	if err != nil {
+		return nil, errors.New("Error creating new id for dispute")
+	}
+	dispute.Id = string(disputeId)
+
+	err = put_dispute(stub, dispute)
+	if err != nil {
+		return nil, err
+	}
+	err = add_to_index(stub, target_disputes_index_str(dispute.TargetId), dispute.Id)
+	if err != nil {
+		return nil, err
+	}
+	for _, party := range dispute.Parties {
+		err = add_to_index(stub, party_open_disputes_index_str(party), dispute.Id)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = put_state(stub, open_dispute_key(dispute.TargetId), disputeId)
+	if err != nil {
+		return nil, errors.New("Error recording dispute of " + dispute.TargetId)
+	}
+	err = emit_event(stub, "DisputeOpened", dispute)
+	if err != nil {
+		return nil, err
+	}
+
+	return disputeId, nil
+}
+
+func (t *SimpleChaincode) add_dispute_evidence(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0				1					2
+	//		disputeId		evidence hash		note (optional)
+
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting disputeId and evidence hash")
+	}
+
+	caller, err := t.get_caller_username(stub)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := get_tx_time(stub, cfg)
+	if err != nil {
+		return nil, err
+	}
+	dispute, err := get_dispute(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if dispute.Status != "open" {
+		return nil, errors.New("Dispute " + args[0] + " is already " + dispute.Status)
+	}
+	if !contains_id(dispute.Parties, caller) {
+		return nil, errors.New("Only the parties to dispute " + args[0] + " can add evidence")
+	}
+	hash, err := normalize_content_hash(args[1])
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range dispute.Evidence {
+		if e.Hash == hash {
+			return nil, errors.New("Evidence " + hash + " is already on dispute " + args[0])
+		}
+	}
+
+	evidence := DisputeEvidence{Hash: hash, AddedBy: caller, AddedAt: now.Format(time.RFC3339)}
+	if len(args) > 2 {
+		evidence.Note = args[2]
+	}
+	dispute.Evidence = append(dispute.Evidence, evidence)
+
+	return nil, put_dispute(stub, dispute)
+}
+
+// An arbiter closes an open dispute, which releases the settlements it held back
+func (t *SimpleChaincode) resolve_dispute(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0				1							2
+	//		disputeId		decision (upheld|dismissed)		resolution
+
+	if len(args) < 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting disputeId, decision and resolution")
+	}
+	if args[1] != "upheld" && args[1] != "dismissed" {
+		return nil, errors.New("Decision not recognized: " + args[1] + ", expecting upheld or dismissed")
+	}
+
+	err := t.check_caller_role(stub, arbiterRole)
+	if err != nil {
+		return nil, err
+	}
+	caller, err := t.get_caller_username(stub)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := get_tx_time(stub, cfg)
+	if err != nil {
+		return nil, err
+	}
+	dispute, err := get_dispute(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if dispute.Status != "open" {
+		return nil, errors.New("Dispute " + args[0] + " is already " + dispute.Status)
+	}
+	if contains_id(dispute.Parties, caller) {
+		return nil, errors.New("A party to dispute " + args[0] + " cannot resolve it")
+	}
+
+	dispute.Status		= args[1]
+	dispute.Resolution	= args[2]
+	dispute.ResolvedBy	= caller
+	dispute.ResolvedAt	= now.Format(time.RFC3339)
+
+	err = put_dispute(stub, dispute)
+	if err != nil {
+		return nil, err
+	}
+	err = del_state(stub, open_dispute_key(dispute.TargetId))
+	if err != nil {
+		return nil, errors.New("Error clearing dispute of " + dispute.TargetId)
+	}
+	for _, party := range dispute.Parties {
+		err = remove_from_index(stub, party_open_disputes_index_str(party), dispute.Id)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = emit_event(stub, "DisputeResolved", dispute)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+//==============================================================================================================================
+//		Query Functions
+//==============================================================================================================================
+
+// Returns the disputes opened on a track or payment reference in the order they were opened
+func (t *SimpleChaincode) get_disputes(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			1
+	//		targetId
+
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting targetId")
+	}
+
+	disputeIds, err := get_index_ids(stub, target_disputes_index_str(args[1]))
+	if err != nil {
+		return nil, err
+	}
+
+	disputes := []Dispute{}
+	for _, disputeId := range disputeIds {
+		dispute, err := get_dispute(stub, disputeId)
+		if err != nil {
+			return nil, err
+		}
+		disputes = append(disputes, dispute)
+	}
+
+	disputesBytes, _ := json.Marshal(disputes)
+
+	return disputesBytes, nil
+}
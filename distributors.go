@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+//==============================================================================================================================
+//	 Distributors - A distributor runs a white-label storefront on top of the platform. Its overrides are resolved at
+//					transaction time from the identity submitting the transaction. Plays submitted by a distributor pay
+//					the distributor fee first, which is shared between the distributor and the platform account.
+//==============================================================================================================================
+type DistributorConfig struct {
+	DistributorId		string		`json:"distributorId"`		// account id of the distributor, also its enrollment name
+	FeeBps				int64		`json:"feeBps"`				// fee on every play submitted through the distributor
+	PlatformShareBps	int64		`json:"platformShareBps"`	// part of that fee going to the platform account
+	Currency			string		`json:"currency"`			// overrides the platform currency when set
+	DefaultTerritory	string		`json:"defaultTerritory"`	// overrides the platform default territory when set
+	BrandingHash		string		`json:"brandingHash"`		// hash of the storefront branding metadata kept off-chain
+	Sandbox				bool		`json:"sandbox"`			// the distributor's transactions run in its sandbox with synthetic funds
+}
+
+var distributorKeyPrefix = "_distributor_"
+
+func validate_distributor_config(dist DistributorConfig) error {
+
+	if dist.DistributorId == "" {
+		return errors.New("distributorId is required")
+	}
+	if dist.FeeBps < 0 || dist.FeeBps > 10000 {
+		return errors.New("feeBps must be between 0 and 10000")
+	}
+	if dist.PlatformShareBps < 0 || dist.PlatformShareBps > 10000 {
+		return errors.New("platformShareBps must be between 0 and 10000")
+	}
+	if dist.Currency != "" && len(dist.Currency) != 3 {
+		return errors.New("currency must be a 3 letter ISO 4217 code")
+	}
+
+	return nil
+}
+
+// Returns the configuration of a distributor and whether one is registered
+func get_distributor_config(stub shim.ChaincodeStubInterface, distributorId string) (DistributorConfig, bool, error) {
+
+	var dist DistributorConfig
+
+	bytes, err := get_state(stub, distributorKeyPrefix + distributorId)
+	if err != nil {
+		return dist, false, errors.New("Failed to get configuration of distributor " + distributorId)
+	}
+	if len(bytes) == 0 {
+		return dist, false, nil
+	}
+
+	err = json.Unmarshal(bytes, &dist)
+	if err != nil {
+		return dist, false, errors.New("Could not unmarshal configuration of distributor " + distributorId)
+	}
+
+	return dist, true, nil
+}
+
+// Resolves the configuration in effect for the current transaction: the platform configuration with the overrides of
+// the submitting distributor applied. The distributor id is empty when the submitter is not a distributor.
+func (t *SimpleChaincode) resolve_tx_config(stub shim.ChaincodeStubInterface) (Config, DistributorConfig, error) {
+
+	var dist DistributorConfig
+
+	cfg, err := get_config(stub)
+	if err != nil {
+		return cfg, dist, err
+	}
+
+	caller, err := t.get_caller_username(stub)
+	if err != nil {
+		// without a caller certificate nobody can be identified as distributor
+		return cfg, dist, nil
+	}
+	dist, found, err := get_distributor_config(stub, caller)
+	if err != nil || !found {
+		return cfg, DistributorConfig{}, err
+	}
+
+	if dist.Currency != "" {
+		cfg.Currency = dist.Currency
+	}
+	if dist.DefaultTerritory != "" {
+		cfg.DefaultTerritory = dist.DefaultTerritory
+	}
+
+	return cfg, dist, nil
+}
+
+// Splits the distributor fee on a gross amount. Returns the fee and one payment line per fee recipient,
+// only recipient and amount are filled in.
+func distributor_fee_lines(cfg Config, dist DistributorConfig, gross int64) (int64, []Payment) {
+
+	if dist.DistributorId == "" || dist.FeeBps == 0 {
+		return 0, nil
+	}
+
+	fee := gross * dist.FeeBps / 10000
+	platformCut := fee * dist.PlatformShareBps / 10000
+	if cfg.PlatformAccountId == "" {
+		platformCut = 0
+	}
+
+	var lines []Payment
+	if fee-platformCut > 0 {
+		lines = append(lines, Payment{RecipientId: dist.DistributorId, Amount: fee - platformCut})
+	}
+	if platformCut > 0 {
+		lines = append(lines, Payment{RecipientId: cfg.PlatformAccountId, Amount: platformCut})
+	}
+
+	return fee, lines
+}
+
+//==============================================================================================================================
+//  Invoke Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) set_distributor_config(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		distributor config JSON object (as string)
+
+	if len(args) < 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting distributor config JSON")
+	}
+
+	var dist DistributorConfig
+	err := json.Unmarshal([]byte(args[0]), &dist)
+	if err != nil {
+		return nil, errors.New("Could not unmarshal distributor config: " + err.Error())
+	}
+	err = validate_distributor_config(dist)
+	if err != nil {
+		return nil, err
+	}
+
+	bytes, err := get_state(stub, dist.DistributorId)
+	if err != nil || len(bytes) == 0 {
+		return nil, errors.New("Could not fetch distributor account " + dist.DistributorId)
+	}
+
+	distBytes, _ := json.Marshal(dist)
+	err = put_state(stub, distributorKeyPrefix+dist.DistributorId, distBytes)
+	if err != nil {
+		return nil, errors.New("Error putting distributor config on ledger")
+	}
+
+	return nil, nil
+}
+
+//==============================================================================================================================
+//		Query Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) query_distributor_config(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			1
+	//		distributorId
+
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting distributorId")
+	}
+
+	dist, found, err := get_distributor_config(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, errors.New("No configuration for distributor " + args[1])
+	}
+
+	distBytes, _ := json.Marshal(dist)
+
+	return distBytes, nil
+}
+
+// Returns the configuration that applies to transactions submitted by the invoker
+func (t *SimpleChaincode) get_effective_config(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	cfg, _, err := t.resolve_tx_config(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	cfgBytes, _ := json.Marshal(cfg)
+
+	return cfgBytes, nil
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"time"
+)
+
+//==============================================================================================================================
+//	 Payment Terms & Dunning - Every payer settles under agreed terms (net-30, net-60, ...). Outstanding payments past
+//							   their due date are overdue, accrue late interest and put the payer in an age bucket.
+//							   run_dunning escalates payers as they move into older buckets, and resets a payer
+//							   whose overdue payments are settled so it escalates afresh when it falls behind
+//							   again. A payer's terms are set by an admin or by an account the payer owes.
+//==============================================================================================================================
+type DunningEntry struct {
+	PayerId				string		`json:"payerId"`
+	Bucket				string		`json:"bucket"`
+	Level				int			`json:"level"`			// index of the bucket + 1, 0 means not overdue
+	DaysOverdue			int64		`json:"daysOverdue"`	// age of the oldest overdue payment
+	OverdueAmount		int64		`json:"overdueAmount"`
+	LateInterest		int64		`json:"lateInterest"`
+}
+
+type DunningReport struct {
+	AsOf				string						`json:"asOf"`
+	Buckets				map[string][]DunningEntry	`json:"buckets"`
+}
+
+type DunningEscalation struct {
+	PayerId				string		`json:"payerId"`
+	FromLevel			int			`json:"fromLevel"`
+	ToLevel				int			`json:"toLevel"`
+	Bucket				string		`json:"bucket"`
+}
+
+var PaymentTermsDays = map[string]int{
+	"net-0":	0,
+	"net-15":	15,
+	"net-30":	30,
+	"net-60":	60,
+	"net-90":	90,
+}
+
+// Age buckets by days overdue, in escalation order
+var DunningBuckets = []struct {
+	Name	string
+	MinDays	int64
+}{
+	{"1-30", 1},
+	{"31-60", 31},
+	{"61-90", 61},
+	{"90+", 91},
+}
+
+var dunningLevelKeyPrefix = "_dunning_level_"
+
+// Due date for a payment owed by sender and created at createdAt
+func payment_due_date(cfg Config, sender Account, createdAt time.Time) (string, error) {
+
+	terms := sender.PaymentTerms
+	if terms == "" {
+		terms = cfg.DefaultPaymentTerms
+	}
+	days, ok := PaymentTermsDays[terms]
+	if !ok {
+		return "", errors.New("Payment terms not recognized: " + terms)
+	}
+
+	return createdAt.AddDate(0, 0, days).Format(time.RFC3339), nil
+}
+
+// Whole days a payment is overdue at now, 0 when it is not due yet or already completed
+func days_overdue(payment Payment, now time.Time) int64 {
+
+	if payment.Completed || payment.DueDate == "" {
+		return 0
+	}
+	due, err := time.Parse(time.RFC3339, payment.DueDate)
+	if err != nil || !now.After(due) {
+		return 0
+	}
+
+	return int64(now.Sub(due).Hours()/24) + 1
+}
+
+// Simple late interest: LateInterestBps of the amount for every started 30 days overdue
+func late_interest(cfg Config, payment Payment, now time.Time) int64 {
+
+	days := days_overdue(payment, now)
+	if days == 0 {
+		return 0
+	}
+	months := (days + 29) / 30
+
+	return payment.Amount * cfg.LateInterestBps * months / 10000
+}
+
+func dunning_level(daysOverdue int64) (int, string) {
+
+	level, bucket := 0, ""
+	for i, b := range DunningBuckets {
+		if daysOverdue >= b.MinDays {
+			level, bucket = i+1, b.Name
+		}
+	}
+
+	return level, bucket
+}
+
+// Computes the dunning position of a payer from the payments it still owes
+func dunning_entry(cfg Config, payer Account, now time.Time) DunningEntry {
+
+	var entry DunningEntry
+	entry.PayerId = payer.Id
+
+	for _, payment := range payer.PendingPayments {
+		if payment.SenderId != payer.Id {
+			continue
+		}
+		days := days_overdue(payment, now)
+		if days == 0 {
+			continue
+		}
+		entry.OverdueAmount += payment.Amount
+		entry.LateInterest += late_interest(cfg, payment, now)
+		if days > entry.DaysOverdue {
+			entry.DaysOverdue = days
+		}
+	}
+	entry.Level, entry.Bucket = dunning_level(entry.DaysOverdue)
+
+	return entry
+}
+
+// Computes the dunning entries of every overdue payer
+func overdue_payers(stub shim.ChaincodeStubInterface, cfg Config, now time.Time) ([]DunningEntry, error) {
+
+	accountIndex, err := get_index_ids(stub, accountIndexStr)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []DunningEntry{}
+	for _, accountId := range accountIndex {
+
+		bytes, err := get_state(stub, accountId)
+		if err != nil {
+			return nil, errors.New("Unable to get account with ID: " + accountId)
+		}
+		var account Account
+		json.Unmarshal(bytes, &account)
+
+		entry := dunning_entry(cfg, account, now)
+		if entry.Level > 0 {
+			entries = append(entries, entry)
+		}
+	}
+
+	return entries, nil
+}
+
+// Clears the dunning level of every payer that has one but is no longer among the overdue payers
+func reset_current_payers(stub shim.ChaincodeStubInterface, entries []DunningEntry) error {
+
+	overdue := map[string]bool{}
+	for _, entry := range entries {
+		overdue[entry.PayerId] = true
+	}
+
+	keysIter, err := range_query_state(stub, dunningLevelKeyPrefix, dunningLevelKeyPrefix+"\xff")
+	if err != nil {
+		return errors.New("Failed to range query dunning levels")
+	}
+	var current []string
+	for keysIter.HasNext() {
+		key, _, err := keysIter.Next()
+		if err != nil {
+			keysIter.Close()
+			return errors.New("Failed to iterate dunning levels")
+		}
+		if !overdue[key[len(dunningLevelKeyPrefix):]] {
+			current = append(current, key)
+		}
+	}
+	keysIter.Close()
+
+	for _, key := range current {
+		err = del_state(stub, key)
+		if err != nil {
+			return errors.New("Error resetting dunning level " + key)
+		}
+	}
+
+	return nil
+}
+
+//==============================================================================================================================
+//  Invoke Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) set_payment_terms(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0				1
+	//		accountId		terms (e.g. net-30)
+
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting accountId and terms")
+	}
+	if _, ok := PaymentTermsDays[args[1]]; !ok {
+		return nil, errors.New("Payment terms not recognized: " + args[1])
+	}
+
+	bytes, err := get_state(stub, args[0])
+	if err != nil || len(bytes) == 0 {
+		return nil, errors.New("Could not fetch account " + args[0])
+	}
+	var account Account
+	err = json.Unmarshal(bytes, &account)
+	if err != nil {
+		return nil, errors.New("Could not unmarshal account " + args[0])
+	}
+
+	// the terms are agreed between the payer and those it pays
+	caller, err := t.get_caller_username(stub)
+	if err != nil {
+		return nil, err
+	}
+	counterparty := false
+	for _, payment := range account.PendingPayments {
+		if payment.SenderId == account.Id && payment.RecipientId == caller {
+			counterparty = true
+			break
+		}
+	}
+	if !counterparty && t.check_caller_role(stub, adminRole) != nil {
+		return nil, errors.New("Only an admin or an account " + account.Id + " pays can set its payment terms")
+	}
+
+	account.PaymentTerms = args[1]
+
+	err = put_account(stub, account)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// Moves every overdue payer to its current dunning level and emits a DunningEscalated event listing the
+// payers that moved into an older bucket since the previous run. Payers that are current again are reset.
+func (t *SimpleChaincode) run_dunning(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := get_tx_time(stub, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := overdue_payers(stub, cfg, now)
+	if err != nil {
+		return nil, err
+	}
+
+	err = reset_current_payers(stub, entries)
+	if err != nil {
+		return nil, err
+	}
+
+	escalations := []DunningEscalation{}
+	for _, entry := range entries {
+
+		levelBytes, err := get_state(stub, dunningLevelKeyPrefix + entry.PayerId)
+		if err != nil {
+			return nil, errors.New("Failed to get dunning level for " + entry.PayerId)
+		}
+		var previous int
+		json.Unmarshal(levelBytes, &previous)
+
+		if entry.Level <= previous {
+			continue
+		}
+
+		escalations = append(escalations, DunningEscalation{PayerId: entry.PayerId, FromLevel: previous, ToLevel: entry.Level, Bucket: entry.Bucket})
+
+		levelBytes, _ = json.Marshal(entry.Level)
+		err = put_state(stub, dunningLevelKeyPrefix+entry.PayerId, levelBytes)
+		if err != nil {
+			return nil, errors.New("Error storing dunning level for " + entry.PayerId)
+		}
+	}
+
+	escalationBytes, _ := json.Marshal(escalations)
+	if len(escalations) > 0 {
+		err = emit_event(stub, "DunningEscalated", escalations)
+		if err != nil {
+			return nil, err
+		}
+	}
+	fmt.Printf("dunning escalated %d payers\n", len(escalations))
+
+	return escalationBytes, nil
+}
+
+//==============================================================================================================================
+//		Query Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) get_dunning(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := get_tx_time(stub, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := overdue_payers(stub, cfg, now)
+	if err != nil {
+		return nil, err
+	}
+
+	var report DunningReport
+	report.AsOf = now.Format(time.RFC3339)
+	report.Buckets = map[string][]DunningEntry{}
+	for _, b := range DunningBuckets {
+		report.Buckets[b.Name] = []DunningEntry{}
+	}
+	for _, entry := range entries {
+		report.Buckets[entry.Bucket] = append(report.Buckets[entry.Bucket], entry)
+	}
+
+	reportBytes, _ := json.Marshal(report)
+
+	return reportBytes, nil
+}
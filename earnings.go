@@ -0,0 +1,378 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"strconv"
+	"time"
+)
+
+//==============================================================================================================================
+//	 Earnings Aggregates - The gross amount earned per track per settlement period is kept under its own key, so
+//						   history based reports never have to scan every play.
+//==============================================================================================================================
+type TrackValuation struct {
+	TrackId				string		`json:"trackId"`
+	TrailingEarnings	int64		`json:"trailingEarnings"`	// earned in the trailing twelve months
+	PriorEarnings		int64		`json:"priorEarnings"`		// earned in the twelve months before that
+	GrowthBps			int64		`json:"growthBps"`
+	Valuation			int64		`json:"valuation"`
+}
+
+type CatalogValuation struct {
+	ArtistId			string				`json:"artistId"`
+	WindowStart			string				`json:"windowStart"`
+	WindowEnd			string				`json:"windowEnd"`
+	MultiplePercent		int64				`json:"multiplePercent"`
+	Tracks				[]TrackValuation	`json:"tracks"`
+	TrailingEarnings	int64				`json:"trailingEarnings"`
+	PriorEarnings		int64				`json:"priorEarnings"`
+	GrowthBps			int64				`json:"growthBps"`
+	Valuation			int64				`json:"valuation"`
+}
+
+type PeriodEarnings struct {
+	Period				string		`json:"period"`
+	Amount				int64		`json:"amount"`
+}
+
+type TrackForecast struct {
+	TrackId				string				`json:"trackId"`
+	History				[]PeriodEarnings	`json:"history"`		// oldest first
+	Projected			int64				`json:"projected"`
+	Share				int64				`json:"share"`			// percentage of the track the account is entitled to
+	ProjectedShare		int64				`json:"projectedShare"`
+}
+
+type EarningsForecast struct {
+	Kind				string				`json:"kind"`			// track or account
+	Id					string				`json:"id"`
+	Method				string				`json:"method"`
+	NextPeriod			string				`json:"nextPeriod"`
+	Tracks				[]TrackForecast		`json:"tracks"`
+	Projected			int64				`json:"projected"`
+}
+
+var ForecastMethods = map[string]bool{
+	"moving_average":	true,
+	"linear":			true,
+}
+
+var defaultForecastWindow = 6
+
+var trackEarningsKeyPrefix = "_earnings_"
+var trackLifetimeEarningsKeyPrefix = "_earnings_total_"
+
+func track_earnings_key(trackId string, periodId string) string {
+	return trackEarningsKeyPrefix + trackId + "_" + periodId
+}
+
+func get_track_lifetime_earnings(stub shim.ChaincodeStubInterface, trackId string) (int64, error) {
+
+	bytes, err := get_state(stub, trackLifetimeEarningsKeyPrefix + trackId)
+	if err != nil {
+		return 0, errors.New("Failed to get lifetime earnings of track " + trackId)
+	}
+	var earnings int64
+	json.Unmarshal(bytes, &earnings)
+
+	return earnings, nil
+}
+
+func get_track_earnings(stub shim.ChaincodeStubInterface, trackId string, periodId string) (int64, error) {
+
+	bytes, err := get_state(stub, track_earnings_key(trackId, periodId))
+	if err != nil {
+		return 0, errors.New("Failed to get earnings of track " + trackId + " for period " + periodId)
+	}
+	var earnings int64
+	json.Unmarshal(bytes, &earnings)
+
+	return earnings, nil
+}
+
+// Adds amount (which may be negative, e.g. for credit notes) to the earnings of a track in a period
+func add_track_earnings(stub shim.ChaincodeStubInterface, trackId string, periodId string, amount int64) error {
+
+	earnings, err := get_track_earnings(stub, trackId, periodId)
+	if err != nil {
+		return err
+	}
+	earnings += amount
+
+	earningsBytes, _ := json.Marshal(earnings)
+	err = put_state(stub, track_earnings_key(trackId, periodId), earningsBytes)
+	if err != nil {
+		return errors.New("Error storing earnings of track " + trackId + " for period " + periodId)
+	}
+
+	lifetime, err := get_track_lifetime_earnings(stub, trackId)
+	if err != nil {
+		return err
+	}
+	lifetime += amount
+
+	lifetimeBytes, _ := json.Marshal(lifetime)
+	err = put_state(stub, trackLifetimeEarningsKeyPrefix+trackId, lifetimeBytes)
+	if err != nil {
+		return errors.New("Error storing lifetime earnings of track " + trackId)
+	}
+
+	return nil
+}
+
+// Returns the completed periods that start within the twelve months before end, most recent first
+func trailing_year_periods(cfg Config, end time.Time) []Period {
+
+	var periods []Period
+	windowStart := end.AddDate(-1, 0, 0)
+
+	current, err := resolve_period(cfg, end.Add(-time.Nanosecond))
+	for err == nil && !current.Start.Before(windowStart) && !current.End.After(end) {
+		periods = append(periods, current)
+		current, err = resolve_previous_period(cfg, current)
+	}
+
+	return periods
+}
+
+func sum_track_earnings(stub shim.ChaincodeStubInterface, trackId string, periods []Period) (int64, error) {
+
+	var total int64
+	for _, p := range periods {
+		earnings, err := get_track_earnings(stub, trackId, p.Id)
+		if err != nil {
+			return 0, err
+		}
+		total += earnings
+	}
+
+	return total, nil
+}
+
+func growth_bps(current int64, prior int64) int64 {
+	if prior <= 0 {
+		return 0
+	}
+	return (current - prior) * 10000 / prior
+}
+
+// Returns up to n completed periods before current, oldest first
+func previous_periods(cfg Config, current Period, n int) []Period {
+
+	periods := make([]Period, 0, n)
+	p, err := resolve_previous_period(cfg, current)
+	for err == nil && len(periods) < n {
+		periods = append([]Period{p}, periods...)
+		p, err = resolve_previous_period(cfg, p)
+	}
+
+	return periods
+}
+
+// Projects the value following the series ys. Integer arithmetic only, so every peer gets the same result.
+func project_next(method string, ys []int64) int64 {
+
+	n := int64(len(ys))
+	if n == 0 {
+		return 0
+	}
+
+	var sumX, sumY, sumXY, sumXX int64
+	for i, y := range ys {
+		x := int64(i)
+		sumX += x
+		sumY += y
+		sumXY += x * y
+		sumXX += x * x
+	}
+
+	projected := sumY / n
+	if method == "linear" && n > 1 {
+		// least squares line through (i, ys[i]) evaluated at x = n
+		num := n*sumXY - sumX*sumY
+		den := n*sumXX - sumX*sumX
+		projected = (sumY*den - num*sumX + num*n*n) / (n * den)
+	}
+	if projected < 0 {
+		projected = 0
+	}
+
+	return projected
+}
+
+func forecast_track(stub shim.ChaincodeStubInterface, trackId string, periods []Period, method string) (TrackForecast, error) {
+
+	var forecast TrackForecast
+	forecast.TrackId = trackId
+	forecast.History = []PeriodEarnings{}
+
+	var ys []int64
+	for _, p := range periods {
+		earnings, err := get_track_earnings(stub, trackId, p.Id)
+		if err != nil {
+			return forecast, err
+		}
+		forecast.History = append(forecast.History, PeriodEarnings{Period: p.Id, Amount: earnings})
+		ys = append(ys, earnings)
+	}
+	forecast.Projected = project_next(method, ys)
+
+	return forecast, nil
+}
+
+//==============================================================================================================================
+//		Query Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) get_catalog_valuation(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			1				2
+	//		artistId		multiple in percent (optional - defaults to the configured multiple, 800 = 8x)
+
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting artistId")
+	}
+
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	multiple := cfg.ValuationMultiplePercent
+	if len(args) > 2 && args[2] != "" {
+		multiple, err = strconv.ParseInt(args[2], 10, 64)
+		if err != nil || multiple < 0 {
+			return nil, errors.New("Multiple must be a non-negative numeric string")
+		}
+	}
+
+	// The window ends where the current period starts, so only complete periods are valued
+	currentPeriod, err := get_tx_period(stub, cfg)
+	if err != nil {
+		return nil, err
+	}
+	trailing := trailing_year_periods(cfg, currentPeriod.Start)
+	prior := trailing_year_periods(cfg, currentPeriod.Start.AddDate(-1, 0, 0))
+
+	trackIndex, err := get_index_ids(stub, artist_tracks_index_str(args[1]))
+	if err != nil {
+		return nil, err
+	}
+
+	var report CatalogValuation
+	report.ArtistId			= args[1]
+	report.WindowStart		= currentPeriod.Start.AddDate(-1, 0, 0).Format(time.RFC3339)
+	report.WindowEnd		= currentPeriod.Start.Format(time.RFC3339)
+	report.MultiplePercent	= multiple
+	report.Tracks			= []TrackValuation{}
+
+	for _, trackId := range trackIndex {
+
+		var tv TrackValuation
+		tv.TrackId = trackId
+		tv.TrailingEarnings, err = sum_track_earnings(stub, trackId, trailing)
+		if err != nil {
+			return nil, err
+		}
+		tv.PriorEarnings, err = sum_track_earnings(stub, trackId, prior)
+		if err != nil {
+			return nil, err
+		}
+		tv.GrowthBps	= growth_bps(tv.TrailingEarnings, tv.PriorEarnings)
+		tv.Valuation	= tv.TrailingEarnings * multiple / 100
+
+		report.TrailingEarnings += tv.TrailingEarnings
+		report.PriorEarnings += tv.PriorEarnings
+		report.Tracks = append(report.Tracks, tv)
+	}
+	report.GrowthBps	= growth_bps(report.TrailingEarnings, report.PriorEarnings)
+	report.Valuation	= report.TrailingEarnings * multiple / 100
+
+	reportBytes, _ := json.Marshal(report)
+
+	return reportBytes, nil
+}
+
+func (t *SimpleChaincode) get_earnings_forecast(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			1						2					3										4
+	//		kind (track|account)	trackId/accountId	method (optional - moving_average|linear)	window in periods (optional)
+
+	if len(args) < 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting kind and id")
+	}
+	kind, id := args[1], args[2]
+	if kind != "track" && kind != "account" {
+		return nil, errors.New("Kind must be track or account")
+	}
+	method := "moving_average"
+	if len(args) > 3 && args[3] != "" {
+		method = args[3]
+	}
+	if !ForecastMethods[method] {
+		return nil, errors.New("Forecast method not recognized: " + method)
+	}
+	window := defaultForecastWindow
+	if len(args) > 4 && args[4] != "" {
+		w, err := strconv.Atoi(args[4])
+		if err != nil || w < 1 || w > 36 {
+			return nil, errors.New("Window must be a number of periods between 1 and 36")
+		}
+		window = w
+	}
+
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	currentPeriod, err := get_tx_period(stub, cfg)
+	if err != nil {
+		return nil, err
+	}
+	periods := previous_periods(cfg, currentPeriod, window)
+
+	var report EarningsForecast
+	report.Kind			= kind
+	report.Id			= id
+	report.Method		= method
+	report.NextPeriod	= currentPeriod.Id
+	report.Tracks		= []TrackForecast{}
+
+	// An account is forecast over its catalog, weighted by its share in each track
+	trackIds := []string{id}
+	if kind == "account" {
+		trackIds, err = get_index_ids(stub, artist_tracks_index_str(id))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, trackId := range trackIds {
+
+		forecast, err := forecast_track(stub, trackId, periods, method)
+		if err != nil {
+			return nil, err
+		}
+
+		forecast.Share = 100
+		if kind == "account" {
+			tr, err := fetch_track(stub, trackId)
+			if err != nil {
+				return nil, err
+			}
+
+			forecast.Share = beneficiary_share(cfg, tr, id)
+		}
+		forecast.ProjectedShare = forecast.Projected * forecast.Share / 100
+
+		report.Projected += forecast.ProjectedShare
+		report.Tracks = append(report.Tracks, forecast)
+	}
+
+	reportBytes, _ := json.Marshal(report)
+
+	return reportBytes, nil
+}
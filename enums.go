@@ -0,0 +1,225 @@
+package main
+
+import (
+	"errors"
+	"strings"
+)
+
+//==============================================================================================================================
+//	 Enumerations - The statuses and categories documents carry, each a string type with its values as constants and a
+//					set to validate against. Documents are checked when they are written, so an unknown value is
+//					rejected instead of being stored and misread later: put_track checks the status and territories
+//					of a track, put_account the type of the account and the rights of its payments, and licenses are
+//					checked before they are stored, as are takedown requests.
+//==============================================================================================================================
+type TrackStatus string
+
+const (
+	TrackActive			TrackStatus = "active"
+	TrackInactive		TrackStatus = "inactive"
+	TrackTakenDown		TrackStatus = "taken_down"		// removed by a granted takedown request
+)
+
+var TrackStatuses = map[TrackStatus]bool{
+	TrackActive:	true,
+	TrackInactive:	true,
+	TrackTakenDown:	true,
+}
+
+// Payments carry no status of their own, it follows from how they were completed
+type PaymentStatus string
+
+const (
+	PaymentOpen			PaymentStatus = "open"
+	PaymentCompleted	PaymentStatus = "completed"
+	PaymentNetted		PaymentStatus = "netted"			// completed by net_payments without settling
+)
+
+var PaymentStatuses = map[PaymentStatus]bool{
+	PaymentOpen:		true,
+	PaymentCompleted:	true,
+	PaymentNetted:		true,
+}
+
+type LicenseType string
+
+const (
+	LicenseFilm			LicenseType = "film"
+	LicenseTv			LicenseType = "tv"
+	LicenseAdvertising	LicenseType = "advertising"
+	LicenseGame			LicenseType = "game"
+	LicenseOnline		LicenseType = "online"
+)
+
+var LicenseTypes = map[LicenseType]bool{
+	LicenseFilm:		true,
+	LicenseTv:			true,
+	LicenseAdvertising:	true,
+	LicenseGame:		true,
+	LicenseOnline:		true,
+}
+
+type LicenseStatus string
+
+const (
+	LicenseRequested	LicenseStatus = "requested"
+	LicenseApproved		LicenseStatus = "approved"
+	LicenseRejected		LicenseStatus = "rejected"
+)
+
+var LicenseStatuses = map[LicenseStatus]bool{
+	LicenseRequested:	true,
+	LicenseApproved:	true,
+	LicenseRejected:	true,
+}
+
+type TakedownReason string
+
+const (
+	TakedownCopyright	TakedownReason = "copyright"
+	TakedownTrademark	TakedownReason = "trademark"
+	TakedownPrivacy		TakedownReason = "privacy"
+	TakedownDefamation	TakedownReason = "defamation"
+	TakedownUnlawful	TakedownReason = "unlawful"
+	TakedownOther		TakedownReason = "other"
+)
+
+var TakedownReasons = map[TakedownReason]bool{
+	TakedownCopyright:	true,
+	TakedownTrademark:	true,
+	TakedownPrivacy:	true,
+	TakedownDefamation:	true,
+	TakedownUnlawful:	true,
+	TakedownOther:		true,
+}
+
+type TakedownStatus string
+
+const (
+	TakedownRequested	TakedownStatus = "requested"
+	TakedownGranted		TakedownStatus = "granted"
+	TakedownDenied		TakedownStatus = "denied"
+)
+
+var TakedownStatuses = map[TakedownStatus]bool{
+	TakedownRequested:	true,
+	TakedownGranted:	true,
+	TakedownDenied:		true,
+}
+
+// The rights a royalty is paid for, on tracks with separate master and publishing rights
+type RoyaltyType string
+
+const (
+	RoyaltyMaster		RoyaltyType = "master"
+	RoyaltyPublishing	RoyaltyType = "publishing"
+)
+
+var RoyaltyTypes = map[RoyaltyType]bool{
+	RoyaltyMaster:		true,
+	RoyaltyPublishing:	true,
+}
+
+// Account types an account can be created with, holding accounts are only created for placeholders
+var AccountTypes = map[string]bool{
+	"listener":	true,
+	"artist":	true,
+	"label":	true,
+}
+
+var holdingAccountType = "holding"
+
+// ISO 3166-1 alpha-2 codes
+var Territories = code_set("AD AE AF AG AI AL AM AO AQ AR AS AT AU AW AX AZ BA BB BD BE BF BG BH BI BJ BL BM BN BO BQ BR BS BT BV BW BY BZ " +
+	"CA CC CD CF CG CH CI CK CL CM CN CO CR CU CV CW CX CY CZ DE DJ DK DM DO DZ EC EE EG EH ER ES ET FI FJ FK FM FO FR GA GB GD GE " +
+	"GF GG GH GI GL GM GN GP GQ GR GS GT GU GW GY HK HM HN HR HT HU ID IE IL IM IN IO IQ IR IS IT JE JM JO JP KE KG KH KI KM KN KP " +
+	"KR KW KY KZ LA LB LC LI LK LR LS LT LU LV LY MA MC MD ME MF MG MH MK ML MM MN MO MP MQ MR MS MT MU MV MW MX MY MZ NA NC NE NF " +
+	"NG NI NL NO NP NR NU NZ OM PA PE PF PG PH PK PL PM PN PR PS PT PW PY QA RE RO RS RU RW SA SB SC SD SE SG SH SI SJ SK SL SM SN " +
+	"SO SR SS ST SV SX SY SZ TC TD TF TG TH TJ TK TL TM TN TO TR TT TV TW TZ UA UG UM US UY UZ VA VC VE VG VI VN VU WF WS YE YT ZA " +
+	"ZM ZW")
+
+func code_set(codes string) map[string]bool {
+
+	set := map[string]bool{}
+	for _, code := range strings.Fields(codes) {
+		set[code] = true
+	}
+
+	return set
+}
+
+func payment_status(payment Payment) PaymentStatus {
+
+	if !payment.Completed {
+		return PaymentOpen
+	}
+	if payment.NettedInto != "" {
+		return PaymentNetted
+	}
+
+	return PaymentCompleted
+}
+
+func validate_territory(territory string) error {
+
+	if !Territories[territory] {
+		return errors.New("Territory " + territory + " is not an ISO 3166 alpha-2 code")
+	}
+
+	return nil
+}
+
+// Checks the statuses and categories of a track before it is written
+func validate_track_enums(tr Track) error {
+
+	if tr.Status != "" && !TrackStatuses[tr.Status] {
+		return errors.New("Track status not recognized: " + string(tr.Status))
+	}
+
+	return validate_territory_prices(tr.TerritoryPrices)
+}
+
+// Checks the statuses and categories of an account and its payments before it is written
+func validate_account_enums(account Account) error {
+
+	if account.Type != "" && account.Type != holdingAccountType && !AccountTypes[account.Type] {
+		return errors.New("Account type not recognized: " + account.Type)
+	}
+	for _, payment := range account.PendingPayments {
+		if payment.Rights != "" && !RoyaltyTypes[RoyaltyType(payment.Rights)] {
+			return errors.New("Rights not recognized on payment " + payment.Reference + ": " + payment.Rights)
+		}
+	}
+
+	return nil
+}
+
+// Checks the statuses and categories of a license before it is written
+func validate_license_enums(license License) error {
+
+	if !LicenseTypes[license.UsageType] {
+		return errors.New("Usage type not recognized: " + string(license.UsageType))
+	}
+	if !LicenseStatuses[license.Status] {
+		return errors.New("License status not recognized: " + string(license.Status))
+	}
+
+	if license.Territory != "" {
+		return validate_territory(license.Territory)
+	}
+
+	return nil
+}
+
+// Checks the statuses and categories of a takedown request before it is written
+func validate_takedown_enums(takedown Takedown) error {
+
+	if !TakedownReasons[takedown.Reason] {
+		return errors.New("Takedown reason not recognized: " + string(takedown.Reason))
+	}
+	if !TakedownStatuses[takedown.Status] {
+		return errors.New("Takedown status not recognized: " + string(takedown.Status))
+	}
+
+	return nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"sort"
+	"strings"
+)
+
+//==============================================================================================================================
+//	 Errors - Invokes and queries fail with a JSON error carrying a code, a message key with its parameters and the English
+//			  message. Apps look the message key up in a message catalog to show the error in the user's language, the
+//			  English catalog is served by get_message_catalog as the reference for translations. Errors raised without
+//			  a code are classified by their message and get the generic key of their code.
+//==============================================================================================================================
+type ChaincodeError struct {
+	Code				string				`json:"code"`
+	MessageKey			string				`json:"messageKey"`
+	Params				map[string]string	`json:"params,omitempty"`
+	Message				string				`json:"message"`
+	CorrelationId		string				`json:"correlationId,omitempty"`
+}
+
+type CatalogEntry struct {
+	MessageKey			string		`json:"messageKey"`
+	Template			string		`json:"template"`
+}
+
+func (e *ChaincodeError) Error() string {
+	return e.Message
+}
+
+// English message templates by message key, {name} is replaced by the parameter of that name
+var MessageCatalog = map[string]string{
+	"error.bad_arguments":			"The request is invalid: {detail}",
+	"error.forbidden":				"You are not allowed to do this: {detail}",
+	"error.not_found":				"Not found: {detail}",
+	"error.unknown_function":		"Unknown function: {detail}",
+	"error.guardrail":				"The request touches too much data: {detail}",
+	"error.deprecated":				"This function has been retired: {detail}",
+	"error.failed":					"The request failed: {detail}",
+	"error.policy_violation":		"The request violates a platform policy: {detail}",
+	"error.already_exists":			"Already exists: {detail}",
+	"payload.malformed":			"The {object} is not a valid JSON document: {detail}",
+	"field.required":				"The {object} field {field} is required",
+	"field.type":					"The {object} field {field} must be a {expected}",
+	"field.negative":				"The {object} field {field} cannot be negative",
+	"field.not_positive":			"The {object} field {field} must be positive",
+	"field.invalid":				"The {object} field {field} has an invalid value {value}",
+	"id.reserved":					"The {object} id {id} cannot contain ~ or start with _, those keys are reserved for indexes",
+	"field.not_allowed":			"The {object} field {field} is kept by the ledger and cannot be set",
+	"splits.empty":					"A track needs at least one beneficiary",
+	"splits.account_missing":		"Every beneficiary needs an accountId or a placeholder",
+	"splits.not_positive":			"Beneficiary percentages must be positive",
+	"splits.total_not_100":			"Beneficiary percentages must total 100",
+	"isrc.invalid":					"Invalid isrc {isrc}, expecting CC-XXX-YY-NNNNN",
+	"isrc.duplicate":				"Recording {isrc} is already registered as track {trackId}",
+	"iswc.invalid":					"Invalid iswc {iswc}, expecting T-DDD.DDD.DDD-C",
+	"iswc.check_digit":				"Invalid iswc {iswc}, the check digit does not match",
+	"content.invalid_hash":			"Content must be the SHA-256 of the content, hex encoded",
+	"content.duplicate":			"Content {content} is already registered as track {trackId}",
+	"price.below_floor":			"The {territory} price {price} {currency} is below the platform minimum of {limit}",
+	"price.above_ceiling":			"The {territory} price {price} {currency} is above the platform maximum of {limit}",
+	"guardrail.max_reads":			"Transaction exceeded maxKeysReadPerTx ({max} keys), use the paginated functions for this amount of data",
+	"guardrail.max_writes":			"Transaction exceeded maxKeysWrittenPerTx ({max} keys), use the paginated functions for this amount of data",
+	"acl.role_not_allowed":			"Only invokers with one of the roles {roles} can call {function}",
+	"acl.not_listed":				"{function} has no ACL entry, an admin has to grant it with set_acl",
+	"payload.too_large":			"Argument {argument} of {function} is {size} bytes, over maxPayloadBytes ({max}). Send it with begin_upload, append_upload and commit_upload",
+	"transient.missing":			"Argument {argument} of {function} refers to transient field {field}, which the proposal does not carry",
+	"legacy.add_track_args":		"add_track with iswc, isrc, price, main_ben and min_ben is no longer supported, a track needs a title and content: pass artist, title and content as well or use {target}",
+	"terms.salt_missing":			"The terms {key} are sealed for the first time and need a random salt in transient field {field}",
+}
+
+// Creates an error with a code and a message key from the catalog
+func new_error(code string, messageKey string, params map[string]string) error {
+
+	message := MessageCatalog[messageKey]
+	for name, value := range params {
+		message = strings.Replace(message, "{"+name+"}", value, -1)
+	}
+
+	return &ChaincodeError{Code: code, MessageKey: messageKey, Params: params, Message: message}
+}
+
+// Creating something under an id that is taken fails instead of overwriting it
+func already_exists(id string) error {
+	return new_error("already_exists", "error.already_exists", map[string]string{"detail": id})
+}
+
+// Classifies an error raised without a code by its message
+func error_code(err error) string {
+
+	if coded, ok := err.(*ChaincodeError); ok {
+		return coded.Code
+	}
+
+	msg := err.Error()
+
+	switch {
+	case strings.HasPrefix(msg, "Incorrect number of arguments"), strings.HasPrefix(msg, "Invalid"):
+		return "bad_arguments"
+	case strings.HasPrefix(msg, "Only "), strings.Contains(msg, "not allowed"):
+		return "forbidden"
+	case strings.HasPrefix(msg, "Could not fetch"), strings.Contains(msg, "not found"):
+		return "not_found"
+	case strings.HasPrefix(msg, "Received unknown"):
+		return "unknown_function"
+	case strings.HasPrefix(msg, "Transaction exceeded"):
+		return "guardrail"
+	}
+
+	return "failed"
+}
+
+// Gives any error a code and message key
+func coded_error(err error) *ChaincodeError {
+
+	if coded, ok := err.(*ChaincodeError); ok {
+		return coded
+	}
+
+	code := error_code(err)
+
+	return &ChaincodeError{Code: code, MessageKey: "error." + code, Params: map[string]string{"detail": err.Error()}, Message: err.Error()}
+}
+
+// Keeps the code of an error while adding context in front of its message
+func wrap_error(prefix string, err error) error {
+
+	coded := *coded_error(err)
+	coded.Message = prefix + coded.Message
+
+	return &coded
+}
+
+// Renders an error as the JSON returned to the client
+func render_error(err error) error {
+
+	if err == nil {
+		return nil
+	}
+
+	errBytes, _ := json.Marshal(coded_error(err))
+
+	return errors.New(string(errBytes))
+}
+
+//==============================================================================================================================
+//		Query Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) get_message_catalog(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	var keys []string
+	for key := range MessageCatalog {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	catalog := []CatalogEntry{}
+	for _, key := range keys {
+		catalog = append(catalog, CatalogEntry{MessageKey: key, Template: MessageCatalog[key]})
+	}
+
+	catalogBytes, _ := json.Marshal(catalog)
+
+	return catalogBytes, nil
+}
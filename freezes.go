@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"time"
+)
+
+//==============================================================================================================================
+//	 Account Freezes - An admin freezes an account for fraud investigations and legal holds. Nothing moves into or out
+//					   of a frozen account until it is unfrozen: plays it pays for or is paid by, payments appended to
+//					   it, settlements, track ownership transfers and placeholder claims are refused.
+//==============================================================================================================================
+var adminRole = "admin"
+
+func check_account_not_frozen(account Account) error {
+
+	if account.Frozen {
+		return errors.New("Account " + account.Id + " is frozen: " + account.FrozenReason)
+	}
+
+	return nil
+}
+
+func check_not_frozen(stub shim.ChaincodeStubInterface, accountId string) error {
+
+	account, err := get_account(stub, accountId)
+	if err != nil {
+		return err
+	}
+
+	return check_account_not_frozen(account)
+}
+
+func (t *SimpleChaincode) set_account_frozen(stub shim.ChaincodeStubInterface, accountId string, frozen bool, reason string) error {
+
+	err := t.check_caller_role(stub, adminRole)
+	if err != nil {
+		return err
+	}
+	cfg, err := get_config(stub)
+	if err != nil {
+		return err
+	}
+	now, err := get_tx_time(stub, cfg)
+	if err != nil {
+		return err
+	}
+
+	account, err := get_account(stub, accountId)
+	if err != nil {
+		return err
+	}
+	if account.Frozen == frozen {
+		if frozen {
+			return errors.New("Account " + accountId + " is already frozen")
+		}
+		return errors.New("Account " + accountId + " is not frozen")
+	}
+
+	account.Frozen			= frozen
+	account.FrozenReason	= reason
+	account.FrozenAt		= ""
+	if frozen {
+		account.FrozenAt = now.Format(time.RFC3339)
+	}
+
+	return put_account(stub, account)
+}
+
+//==============================================================================================================================
+//  Invoke Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) freeze_account(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0			1
+	//		accountId	reason
+
+	if len(args) < 2 || args[1] == "" {
+		return nil, errors.New("Incorrect number of arguments. Expecting accountId and reason")
+	}
+
+	return nil, t.set_account_frozen(stub, args[0], true, args[1])
+}
+
+func (t *SimpleChaincode) unfreeze_account(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		accountId
+
+	if len(args) < 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting accountId")
+	}
+
+	return nil, t.set_account_frozen(stub, args[0], false, "")
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"errors"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"strconv"
+	"sync"
+)
+
+//==============================================================================================================================
+//	 Guardrails - An invoke may read at most MaxKeysReadPerTx keys and write at most MaxKeysWrittenPerTx keys, keys
+//				  returned by a range query count as reads. A transaction going over either limit is aborted with an
+//				  error naming the limit, so callers of work that grows with the ledger move to the paginated functions
+//				  instead of running into a timeout. All ledger access goes through get_state, put_state, del_state and
+//				  range_query_state, or their private data variants, so the keys are counted, and so they land in the
+//				  sandbox of a sandboxed transaction. Queries are not limited.
+//==============================================================================================================================
+type keyBudget struct {
+	reads				int
+	writes				int
+	maxReads			int
+	maxWrites			int
+	exceeded			error
+}
+
+// Key budgets of the transactions in flight, by tx id
+var txKeyBudgets = struct {
+	sync.Mutex
+	budgets map[string]*keyBudget
+}{budgets: map[string]*keyBudget{}}
+
+// Starts counting the keys the current transaction reads and writes
+func begin_key_budget(stub shim.ChaincodeStubInterface) error {
+
+	cfg, err := get_config(stub)
+	if err != nil {
+		return err
+	}
+
+	txKeyBudgets.Lock()
+	txKeyBudgets.budgets[stub.GetTxID()] = &keyBudget{maxReads: cfg.MaxKeysReadPerTx, maxWrites: cfg.MaxKeysWrittenPerTx}
+	txKeyBudgets.Unlock()
+
+	return nil
+}
+
+// Stops counting for the current transaction, returns the guardrail error if a limit was exceeded
+func end_key_budget(stub shim.ChaincodeStubInterface) error {
+
+	txKeyBudgets.Lock()
+	defer txKeyBudgets.Unlock()
+
+	budget, ok := txKeyBudgets.budgets[stub.GetTxID()]
+	delete(txKeyBudgets.budgets, stub.GetTxID())
+	if !ok {
+		return nil
+	}
+
+	return budget.exceeded
+}
+
+// Counts keys against the budget of the transaction, a limit of 0 means no limit
+func charge_keys(stub shim.ChaincodeStubInterface, reads int, writes int) error {
+
+	txKeyBudgets.Lock()
+	defer txKeyBudgets.Unlock()
+
+	budget, ok := txKeyBudgets.budgets[stub.GetTxID()]
+	if !ok {
+		return nil
+	}
+	if budget.exceeded != nil {
+		return budget.exceeded
+	}
+
+	budget.reads	+= reads
+	budget.writes	+= writes
+	if budget.maxReads > 0 && budget.reads > budget.maxReads {
+		budget.exceeded = new_error("guardrail", "guardrail.max_reads", map[string]string{"max": strconv.Itoa(budget.maxReads)})
+	} else if budget.maxWrites > 0 && budget.writes > budget.maxWrites {
+		budget.exceeded = new_error("guardrail", "guardrail.max_writes", map[string]string{"max": strconv.Itoa(budget.maxWrites)})
+	}
+
+	return budget.exceeded
+}
+
+func get_state(stub shim.ChaincodeStubInterface, key string) ([]byte, error) {
+
+	err := charge_keys(stub, 1, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	sandbox := current_sandbox(stub)
+	if sandbox == "" {
+		return ledger_get(stub, key)
+	}
+
+	value, err := ledger_get(stub, sandbox+key)
+	if err != nil || len(value) > 0 || !is_sandbox_shared_key(key) {
+		return value, err
+	}
+
+	return ledger_get(stub, key)
+}
+
+func put_state(stub shim.ChaincodeStubInterface, key string, value []byte) error {
+
+	err := charge_keys(stub, 0, 1)
+	if err != nil {
+		return err
+	}
+
+	return ledger_put(stub, current_sandbox(stub)+key, value)
+}
+
+func del_state(stub shim.ChaincodeStubInterface, key string) error {
+
+	err := charge_keys(stub, 0, 1)
+	if err != nil {
+		return err
+	}
+
+	return ledger_del(stub, current_sandbox(stub)+key)
+}
+
+// Puts a key-level endorsement policy on a key, an empty policy leaves the key to the chaincode's policy
+func set_endorsement_policy(stub shim.ChaincodeStubInterface, key string, policy []byte) error {
+
+	err := charge_keys(stub, 0, 1)
+	if err != nil {
+		return err
+	}
+	err = ledger_set_validation(stub, current_sandbox(stub)+key, policy)
+	if err != nil {
+		return errors.New("Error setting the endorsement policy of " + key)
+	}
+
+	return nil
+}
+
+// Reads a key of a private data collection, counted and sandboxed like the public keys
+func get_private_state(stub shim.ChaincodeStubInterface, collection string, key string) ([]byte, error) {
+
+	err := charge_keys(stub, 1, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return ledger_private_get(stub, collection, current_sandbox(stub)+key)
+}
+
+func put_private_state(stub shim.ChaincodeStubInterface, collection string, key string, value []byte) error {
+
+	err := charge_keys(stub, 0, 1)
+	if err != nil {
+		return err
+	}
+
+	return ledger_private_put(stub, collection, current_sandbox(stub)+key, value)
+}
+
+func del_private_state(stub shim.ChaincodeStubInterface, collection string, key string) error {
+
+	err := charge_keys(stub, 0, 1)
+	if err != nil {
+		return err
+	}
+
+	return ledger_private_del(stub, collection, current_sandbox(stub)+key)
+}
+
+// Range iterator handing out keys and values, over the shim's iterator of key value pairs
+type stateRangeIterator interface {
+	HasNext() bool
+	Next() (string, []byte, error)
+	Close() error
+}
+
+type kvRangeIterator struct {
+	shim.StateQueryIteratorInterface
+}
+
+func (it kvRangeIterator) Next() (string, []byte, error) {
+
+	kv, err := it.StateQueryIteratorInterface.Next()
+	if err != nil {
+		return "", nil, err
+	}
+
+	return kv.Key, kv.Value, nil
+}
+
+// Range iterator counting every key it returns as a read
+type countedRangeIterator struct {
+	stateRangeIterator
+	stub				shim.ChaincodeStubInterface
+}
+
+func (it countedRangeIterator) Next() (string, []byte, error) {
+
+	err := charge_keys(it.stub, 1, 0)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return it.stateRangeIterator.Next()
+}
+
+func range_query_state(stub shim.ChaincodeStubInterface, startKey string, endKey string) (stateRangeIterator, error) {
+
+	sandbox := current_sandbox(stub)
+
+	kvIter, err := stub.GetStateByRange(sandbox+startKey, sandbox+endKey)
+	if err != nil {
+		return nil, err
+	}
+	var keysIter stateRangeIterator = kvRangeIterator{kvIter}
+	if sandbox != "" {
+		keysIter = sandboxRangeIterator{keysIter, sandbox}
+	}
+
+	return countedRangeIterator{keysIter, stub}, nil
+}
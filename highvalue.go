@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric-chaincode-go/pkg/statebased"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"strconv"
+)
+
+//==============================================================================================================================
+//	 High-Value Settlements - Payments live inside the accounts of their sender and recipient and have no key of their
+//							  own, so the extra endorsement is put on a gate instead. set_high_value_policy puts a
+//							  state-based endorsement policy on the gate keys requiring a peer of every one of the
+//							  configured organizations. Settling a payment of at least highValuePaymentAmount, in the
+//							  platform currency, writes one of the gate keys, so the settlement only commits when it is
+//							  endorsed by those organizations as well as by the chaincode's own policy. The gate is
+//							  spread over metricShards keys and written without reading it, so high-value settlements do
+//							  not conflict with each other. Changing the policy writes the gate keys too, it needs the
+//							  endorsement of the organizations currently configured. Sandboxed transactions move
+//							  synthetic funds and are not gated.
+//==============================================================================================================================
+var highValueGateStr = "_high_value_gate"
+
+func high_value_gate_key(shard int) string {
+	return index_key(highValueGateStr, strconv.Itoa(shard))
+}
+
+// Writes a gate key when the payment is high-value, so its settlement needs the extra endorsements
+func check_high_value_settlement(stub shim.ChaincodeStubInterface, cfg Config, payment Payment) error {
+
+	if cfg.HighValuePaymentAmount <= 0 || current_sandbox(stub) != "" {
+		return nil
+	}
+
+	amount, err := convert_amount(stub, payment.Amount, payment_currency(cfg, payment), cfg.Currency)
+	if err != nil {
+		return err
+	}
+	if amount < cfg.HighValuePaymentAmount {
+		return nil
+	}
+
+	err = put_state(stub, high_value_gate_key(metric_shard(stub)), []byte(stub.GetTxID()))
+	if err != nil {
+		return errors.New("Error putting " + highValueGateStr + " on ledger")
+	}
+
+	return nil
+}
+
+//==============================================================================================================================
+//  Invoke Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) set_high_value_policy(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0											1
+	//		amount (platform currency, 0 turns it off)		organizations (JSON array of MSP ids)
+
+	if len(args) < 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting amount and organizations")
+	}
+	amount, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil || amount < 0 {
+		return nil, errors.New("Invalid amount " + args[0] + ", expecting a non-negative number")
+	}
+	var orgs []string
+	if len(args) > 1 && args[1] != "" {
+		err = json.Unmarshal([]byte(args[1]), &orgs)
+		if err != nil {
+			return nil, errors.New("Invalid organizations, expecting a JSON array of MSP ids")
+		}
+	}
+	if amount > 0 && len(orgs) == 0 {
+		return nil, errors.New("A high-value policy needs at least one organization to endorse settlements")
+	}
+
+	err = t.check_caller_role(stub, adminRole)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	// an empty policy hands the gate back to the chaincode's endorsement policy
+	var policy []byte
+	if amount > 0 {
+		ep, err := statebased.NewStateEP(nil)
+		if err != nil {
+			return nil, errors.New("Could not create endorsement policy")
+		}
+		err = ep.AddOrgs(statebased.RoleTypePeer, orgs...)
+		if err != nil {
+			return nil, errors.New("Invalid organizations: " + err.Error())
+		}
+		policy, err = ep.Policy()
+		if err != nil {
+			return nil, errors.New("Could not marshal endorsement policy")
+		}
+	}
+	for shard := 0; shard < metricShards; shard++ {
+		key := high_value_gate_key(shard)
+		err = put_state(stub, key, []byte(stub.GetTxID()))
+		if err != nil {
+			return nil, errors.New("Error putting " + key + " on ledger")
+		}
+		err = set_endorsement_policy(stub, key, policy)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	cfg.HighValuePaymentAmount	= amount
+	cfg.HighValueEndorsers		= orgs
+	cfgBytes, _ := json.Marshal(cfg)
+	err = put_state(stub, configKey, cfgBytes)
+	if err != nil {
+		return nil, errors.New("Error putting config on ledger")
+	}
+
+	return nil, nil
+}
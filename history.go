@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"sort"
+	"time"
+)
+
+//==============================================================================================================================
+//	 Track History - Every version of a track is kept with the transaction that wrote it, so a dispute about what
+//					 the split was at the time of a play can be settled from the ledger. The versions are read from the
+//					 peer's history database with GetHistoryForKey, peers need enableHistoryDatabase on (the default).
+//					 Only committed versions are listed, a track written earlier in the same transaction is not.
+//==============================================================================================================================
+type TrackVersion struct {
+	TxId				string		`json:"txId"`
+	Timestamp			string		`json:"timestamp"`
+	Track				Track		`json:"track"`
+}
+
+func get_track_versions(stub shim.ChaincodeStubInterface, trackId string) ([]TrackVersion, error) {
+
+	historyIter, err := stub.GetHistoryForKey(trackId)
+	if err != nil {
+		return nil, errors.New("Failed to get history of track " + trackId)
+	}
+	defer historyIter.Close()
+
+	versions := []TrackVersion{}
+	writtenAt := map[string]time.Time{}
+	for historyIter.HasNext() {
+		modification, err := historyIter.Next()
+		if err != nil {
+			return nil, errors.New("Failed to iterate history of track " + trackId)
+		}
+		if modification.IsDelete {
+			continue
+		}
+		var version TrackVersion
+		version.TxId = modification.TxId
+		if modification.Timestamp != nil {
+			at := time.Unix(modification.Timestamp.Seconds, int64(modification.Timestamp.Nanos)).UTC()
+			version.Timestamp		= at.Format(time.RFC3339)
+			writtenAt[version.TxId]	= at
+		}
+		json.Unmarshal(modification.Value, &version.Track)
+		versions = append(versions, version)
+	}
+
+	// the history database lists the newest version first, the oldest is the registration
+	sort.SliceStable(versions, func(i, j int) bool {
+		return writtenAt[versions[i].TxId].Before(writtenAt[versions[j].TxId])
+	})
+
+	return versions, nil
+}
+
+// Writes a track to the ledger, the history database keeps the version it replaces
+func put_track(stub shim.ChaincodeStubInterface, trackId string, tr Track) error {
+
+	cfg, err := get_config(stub)
+	if err != nil {
+		return err
+	}
+	now, err := get_tx_time(stub, cfg)
+	if err != nil {
+		return err
+	}
+
+	err = validate_track_enums(tr)
+	if err != nil {
+		return err
+	}
+
+	tr.UpdatedAt = now.Format(time.RFC3339)
+	if tr.CreatedAt == "" {
+		tr.CreatedAt = tr.UpdatedAt
+	}
+	err = seal_track_terms(stub, cfg, trackId, &tr)
+	if err != nil {
+		return err
+	}
+
+	trackBytes, _ := json.Marshal(tr)
+	err = put_state(stub, trackId, trackBytes)
+	if err != nil {
+		return errors.New("Error putting track " + trackId + " on ledger")
+	}
+
+	return nil
+}
+
+//==============================================================================================================================
+//		Query Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) get_track_history(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			1
+	//		trackId
+
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting trackId")
+	}
+
+	versions, err := get_track_versions(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	versionBytes, _ := json.Marshal(versions)
+
+	return versionBytes, nil
+}
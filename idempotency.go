@@ -0,0 +1,134 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"strings"
+	"time"
+)
+
+//==============================================================================================================================
+//	 Idempotency Keys - A client that timed out cannot tell whether its invoke made it onto the ledger, and retrying a
+//						register_track or settle_payment blindly charges twice. Any invoke can carry a client chosen key
+//						as its last argument, written as "idempotencyKey=<key>" and before a correlation id when it has
+//						both. The first successful invoke with a key records its result under the caller and the key,
+//						an invoke replaying the key returns that result without running again. A key replayed with a
+//						different function or different arguments is rejected, it is a client bug rather than a retry.
+//						Values an invoke took from its transient data stay out of the record: the arguments are hashed
+//						with the transient references, and the result goes to the private collection, so a replay
+//						only answers with it on peers of collection members. Without a collection it is not kept.
+//==============================================================================================================================
+type IdempotencyRecord struct {
+	Key					string		`json:"key"`
+	Caller				string		`json:"caller"`
+	Function			string		`json:"function"`
+	ArgsHash			string		`json:"argsHash"`				// SHA-256 of the arguments, hex encoded
+	Result				[]byte		`json:"result"`
+	ResultPrivate		bool		`json:"resultPrivate,omitempty"`	// the invoke used transient arguments, the result is not on the channel
+	TxId				string		`json:"txId"`
+	RecordedAt			string		`json:"recordedAt"`
+}
+
+var idempotencyArgPrefix = "idempotencyKey="
+
+func idempotency_record_key(caller string, key string) string {
+	return "_idempotency_" + caller + "_" + key
+}
+
+// Splits a trailing idempotency key argument off the arguments of an invoke
+func extract_idempotency_key(args []string) (string, []string) {
+
+	if len(args) == 0 || !strings.HasPrefix(args[len(args)-1], idempotencyArgPrefix) {
+		return "", args
+	}
+
+	return strings.TrimPrefix(args[len(args)-1], idempotencyArgPrefix), args[:len(args)-1]
+}
+
+func args_hash(args []string) string {
+
+	argsBytes, _ := json.Marshal(args)
+	digest := sha256.Sum256(argsBytes)
+
+	return hex.EncodeToString(digest[:])
+}
+
+// Returns the result of an invoke with transient arguments, kept in the private collection if there is one
+func get_private_idempotency_result(stub shim.ChaincodeStubInterface, record IdempotencyRecord) ([]byte, error) {
+
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.PrivateCollection != "" {
+		result, err := get_private_state(stub, cfg.PrivateCollection, idempotency_record_key(record.Caller, record.Key))
+		if err == nil && len(result) > 0 {
+			return result, nil
+		}
+	}
+
+	return nil, errors.New("Idempotency key " + record.Key + " already ran in transaction " + record.TxId + ", its result used transient arguments and is not available on this peer")
+}
+
+// Runs an invoke once per idempotency key of the caller, a replay answers with the result of the first run
+func (t *SimpleChaincode) idempotent_invoke(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
+
+	key, args := extract_idempotency_key(args)
+	if key == "" {
+		return t.invoke_function(stub, function, args)
+	}
+
+	caller, _ := t.get_caller_username(stub)
+	bytes, err := get_state(stub, idempotency_record_key(caller, key))
+	if err != nil {
+		return nil, errors.New("Failed to get idempotency key " + key)
+	}
+	if len(bytes) > 0 {
+		var record IdempotencyRecord
+		err = json.Unmarshal(bytes, &record)
+		if err != nil {
+			return nil, errors.New("Could not unmarshal idempotency key " + key)
+		}
+		if record.Function != function || record.ArgsHash != args_hash(unresolved_transient_args(stub, args)) {
+			return nil, errors.New("Idempotency key " + key + " was already used for another request in transaction " + record.TxId)
+		}
+		if record.ResultPrivate {
+			return get_private_idempotency_result(stub, record)
+		}
+		return record.Result, nil
+	}
+
+	result, err := t.invoke_function(stub, function, args)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := get_tx_time(stub, cfg)
+	if err != nil {
+		return nil, err
+	}
+	record := IdempotencyRecord{Key: key, Caller: caller, Function: function, ArgsHash: args_hash(unresolved_transient_args(stub, args)), Result: result, TxId: stub.GetTxID(), RecordedAt: now.Format(time.RFC3339)}
+	if uses_transient_args(stub) {
+		record.Result, record.ResultPrivate = nil, true
+		if cfg.PrivateCollection != "" {
+			err = put_private_state(stub, cfg.PrivateCollection, idempotency_record_key(caller, key), result)
+			if err != nil {
+				return nil, errors.New("Error recording the result of idempotency key " + key + " in collection " + cfg.PrivateCollection)
+			}
+		}
+	}
+	recordBytes, _ := json.Marshal(record)
+	err = put_state(stub, idempotency_record_key(caller, key), recordBytes)
+	if err != nil {
+		return nil, errors.New("Error recording idempotency key " + key)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+//==============================================================================================================================
+//	 Identity - The invoker is identified by the certificate that signed the transaction, read from the creator of the
+//				proposal so every peer sees the same identity without calling out to the CA. The certificate's common
+//				name is the username, which is also the id of the invoker's account. The role is the "role" attribute
+//				the invoker was enrolled with. Accounts created with register_me are bound to the certificate that
+//				registered them: a caller whose certificate has another fingerprint is not taken to be that account.
+//==============================================================================================================================
+type Identity struct {
+	Id					string		`json:"id"`					// "x509::<subject>::<issuer>", unique per certificate holder
+	CommonName			string		`json:"commonName"`
+	Role				string		`json:"role,omitempty"`
+	Fingerprint			string		`json:"fingerprint"`		// SHA-256 of the DER encoded certificate, hex encoded
+}
+
+// Reads the identity of the invoker of the current transaction from its certificate
+func get_identity(stub shim.ChaincodeStubInterface) (Identity, error) {
+
+	var identity Identity
+
+	x509Cert, err := cid.GetX509Certificate(stub)
+	if err != nil || x509Cert == nil {
+		return identity, errors.New("Could not get caller certificate")
+	}
+
+	identity.Id			= "x509::" + x509Cert.Subject.String() + "::" + x509Cert.Issuer.String()
+	identity.CommonName	= x509Cert.Subject.CommonName
+	identity.Fingerprint	= cert_fingerprint(x509Cert.Raw)
+
+	role, found, err := cid.GetAttributeValue(stub, "role")
+	if err == nil && found {
+		identity.Role = role
+	}
+
+	return identity, nil
+}
+
+func cert_fingerprint(cert []byte) string {
+	digest := sha256.Sum256(cert)
+	return hex.EncodeToString(digest[:])
+}
+
+// Verifies the invoker holds the certificate the account named by its common name is bound to, if it is bound
+func check_certificate_binding(stub shim.ChaincodeStubInterface, identity Identity) error {
+
+	bytes, err := get_state(stub, identity.CommonName)
+	if err != nil || len(bytes) == 0 {
+		return nil
+	}
+	var account Account
+	err = json.Unmarshal(bytes, &account)
+	if err != nil || account.CertFingerprint == "" {
+		return nil
+	}
+	if account.CertFingerprint != identity.Fingerprint {
+		return errors.New("Only the certificate account " + account.Id + " registered with can act as it")
+	}
+
+	return nil
+}
+
+// Returns the username (certificate CN) of the invoker of the current transaction
+func (t *SimpleChaincode) get_caller_username(stub shim.ChaincodeStubInterface) (string, error) {
+
+	identity, err := get_identity(stub)
+	if err != nil {
+		return "", err
+	}
+	err = check_certificate_binding(stub, identity)
+	if err != nil {
+		return "", err
+	}
+
+	return identity.CommonName, nil
+}
+
+// Operator functions are for the platform account, nobody can call them before one is configured
+func (t *SimpleChaincode) check_platform_access(stub shim.ChaincodeStubInterface, cfg Config, what string) error {
+
+	if cfg.PlatformAccountId == "" {
+		return errors.New("No platform account is configured, an admin has to set platformAccountId before anyone can " + what)
+	}
+
+	caller, err := t.get_caller_username(stub)
+	if err != nil {
+		return err
+	}
+	if caller != cfg.PlatformAccountId {
+		return errors.New("Only the platform account can " + what)
+	}
+
+	return nil
+}
+
+// Verifies the invoker was enrolled with the role, read from the role attribute of its certificate
+func (t *SimpleChaincode) check_caller_role(stub shim.ChaincodeStubInterface, role string) error {
+
+	callerRole, _, err := cid.GetAttributeValue(stub, "role")
+	if err != nil {
+		return errors.New("Could not read the role of the caller")
+	}
+	if callerRole != role {
+		return errors.New("Only identities with the " + role + " role can do this")
+	}
+
+	return nil
+}
+
+//==============================================================================================================================
+//  Invoke Functions
+//==============================================================================================================================
+
+// Creates the invoker's own account, its id is the common name of the invoker's certificate and it is bound to that certificate
+func (t *SimpleChaincode) register_me(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		account JSON object (as string, optional: name, type and paymentTerms)
+
+	var account Account
+	if len(args) > 0 && args[0] != "" {
+		err := json.Unmarshal([]byte(args[0]), &account)
+		if err != nil {
+			return nil, errors.New("Could not unmarshal account: " + err.Error())
+		}
+	}
+	if account.Type != "" && !AccountTypes[account.Type] {
+		return nil, errors.New("Account type not recognized: " + account.Type)
+	}
+
+	identity, err := get_identity(stub)
+	if err != nil {
+		return nil, err
+	}
+	if identity.CommonName == "" {
+		return nil, errors.New("Invalid certificate, it has no common name to derive the account id from")
+	}
+	existing, err := get_state(stub, identity.CommonName)
+	if err != nil {
+		return nil, errors.New("Failed to get account " + identity.CommonName)
+	}
+	if len(existing) > 0 {
+		return nil, errors.New("Account " + identity.CommonName + " already exists")
+	}
+
+	registered := Account{Id: identity.CommonName, Name: account.Name, Type: account.Type, PaymentTerms: account.PaymentTerms}
+	registered.CertFingerprint = identity.Fingerprint
+
+	err = add_to_index(stub, accountIndexStr, registered.Id)
+	if err != nil {
+		return nil, errors.New("Error creating new id for user " + registered.Id)
+	}
+	err = put_account(stub, registered)
+	if err != nil {
+		return nil, err
+	}
+
+	accountBytes, _ := json.Marshal(registered)
+
+	return accountBytes, emit_event(stub, "AccountCreated", registered)
+}
+
+//==============================================================================================================================
+//		Query Functions
+//==============================================================================================================================
+
+// Returns the identity the chaincode sees for the invoker, for clients to check their enrollment
+func (t *SimpleChaincode) get_invoker_identity(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	identity, err := get_identity(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	identityBytes, _ := json.Marshal(identity)
+
+	return identityBytes, nil
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"strconv"
+)
+
+//==============================================================================================================================
+//	 Referential Integrity - Nothing stops a key from being written that refers to an account or track that is not on
+//							 the ledger, or from outliving what it refers to: imports, legacy routes and the sandbox
+//							 purge can all leave orphans behind. check_integrity lets an admin scan for them, a page of
+//							 tracks or accounts at a time, and reports every dangling reference with the invoke that
+//							 repairs it.
+//								tracks		owner, artist and beneficiaries of the splits must be accounts
+//								accounts	counterparties of payments must be accounts, and the play a play payment
+//											refers to must be on the ledger with its track or album
+//==============================================================================================================================
+type IntegrityRepair struct {
+	Function			string		`json:"function"`
+	Args				[]string	`json:"args"`
+}
+
+type IntegrityIssue struct {
+	Kind				string			`json:"kind"`				// what is dangling, e.g. track_owner or payment_track
+	EntityId			string			`json:"entityId"`			// the track or account holding the reference
+	Missing				string			`json:"missing"`			// the key that is not on the ledger
+	Detail				string			`json:"detail"`
+	Repair				IntegrityRepair	`json:"repair"`
+}
+
+type IntegrityReport struct {
+	Scope				string				`json:"scope"`
+	Scanned				int					`json:"scanned"`
+	Issues				[]IntegrityIssue	`json:"issues"`
+	Bookmark			string				`json:"bookmark"`		// pass back to scan the next page, empty on the last page
+}
+
+var IntegrityScopes = map[string]string{
+	"tracks":	trackIndexStr,
+	"accounts":	accountIndexStr,
+}
+
+// Looks up whether keys are on the ledger, remembering the answers for the rest of the scan
+type KeyChecker struct {
+	stub				shim.ChaincodeStubInterface
+	seen				map[string]bool
+}
+
+func (c *KeyChecker) exists(key string) (bool, error) {
+
+	if found, ok := c.seen[key]; ok {
+		return found, nil
+	}
+	bytes, err := get_state(c.stub, key)
+	if err != nil {
+		return false, errors.New("Failed to get " + key)
+	}
+	c.seen[key] = len(bytes) > 0
+
+	return c.seen[key], nil
+}
+
+// Reports an account a track refers to when it is missing
+func check_track_account(c *KeyChecker, report *IntegrityReport, trackId string, kind string, accountId string, repair IntegrityRepair) error {
+
+	if accountId == "" {
+		return nil
+	}
+	found, err := c.exists(accountId)
+	if err != nil || found {
+		return err
+	}
+	report.Issues = append(report.Issues, IntegrityIssue{Kind: kind, EntityId: trackId, Missing: accountId, Detail: "Track " + trackId + " refers to account " + accountId + " which does not exist", Repair: repair})
+
+	return nil
+}
+
+func check_track_integrity(c *KeyChecker, report *IntegrityReport, trackId string) error {
+
+	tr, err := fetch_track(c.stub, trackId)
+	if err != nil {
+		report.Issues = append(report.Issues, IntegrityIssue{Kind: "track_missing", EntityId: trackId, Missing: trackId, Detail: "Track " + trackId + " is indexed but not on the ledger",
+			Repair: IntegrityRepair{Function: "create_track", Args: []string{"<track JSON with iswc " + trackId + ">"}}})
+		return nil
+	}
+
+	recreate := func(accountId string) IntegrityRepair {
+		return IntegrityRepair{Function: "add_account", Args: []string{accountId, `{"id":"` + accountId + `"}`}}
+	}
+	err = check_track_account(c, report, trackId, "track_owner", tr.Owner, recreate(tr.Owner))
+	if err != nil {
+		return err
+	}
+	err = check_track_account(c, report, trackId, "track_artist", tr.Artist, recreate(tr.Artist))
+	if err != nil {
+		return err
+	}
+
+	// a beneficiary that is gone is best replaced by the owner with a new split sheet
+	resplit := IntegrityRepair{Function: "update_track", Args: []string{trackId, `{"beneficiaries":[...]}`}}
+	beneficiaries := tr.Beneficiaries
+	if tr.Recording != nil {
+		beneficiaries = append(append([]Beneficiary{}, tr.Recording.Beneficiaries...), beneficiaries...)
+	}
+	if tr.Composition != nil {
+		beneficiaries = append(beneficiaries, tr.Composition.Beneficiaries...)
+	}
+	for _, b := range beneficiaries {
+		err = check_track_account(c, report, trackId, "split_beneficiary", b.AccountId, resplit)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func check_account_integrity(c *KeyChecker, report *IntegrityReport, accountId string) error {
+
+	account, err := get_account(c.stub, accountId)
+	if err != nil {
+		report.Issues = append(report.Issues, IntegrityIssue{Kind: "account_missing", EntityId: accountId, Missing: accountId, Detail: "Account " + accountId + " is indexed but not on the ledger",
+			Repair: IntegrityRepair{Function: "add_account", Args: []string{accountId, `{"id":"` + accountId + `"}`}}})
+		return nil
+	}
+
+	reported := map[string]bool{}
+	for _, payment := range account.PendingPayments {
+		counterparty := payment.RecipientId
+		if counterparty == account.Id {
+			counterparty = payment.SenderId
+		}
+		if counterparty != "" && !reported[counterparty] {
+			found, err := c.exists(counterparty)
+			if err != nil {
+				return err
+			}
+			if !found {
+				reported[counterparty] = true
+				report.Issues = append(report.Issues, IntegrityIssue{Kind: "payment_counterparty", EntityId: account.Id, Missing: counterparty, Detail: "Payment " + payment.Reference + " of " + account.Id + " is with account " + counterparty + " which does not exist",
+					Repair: IntegrityRepair{Function: "add_account", Args: []string{counterparty, `{"id":"` + counterparty + `"}`}}})
+			}
+		}
+
+		// play payments refer to the play, which refers to what was played
+		if payment.Source == "" || reported[payment.Reference] {
+			continue
+		}
+		reported[payment.Reference] = true
+		creditNote := IntegrityRepair{Function: "issue_credit_note", Args: []string{payment.Reference, strconv.FormatInt(payment.Amount, 10), "dangling reference"}}
+
+		playBytes, err := get_state(c.stub, payment.Reference)
+		if err != nil {
+			return errors.New("Failed to get play " + payment.Reference)
+		}
+		if len(playBytes) == 0 {
+			report.Issues = append(report.Issues, IntegrityIssue{Kind: "payment_play", EntityId: account.Id, Missing: payment.Reference, Detail: "Payment " + payment.Reference + " of " + account.Id + " refers to a play which does not exist", Repair: creditNote})
+			continue
+		}
+		var play Play
+		json.Unmarshal(playBytes, &play)
+		played := play.TrackId
+		if played == "" {
+			played = play.AlbumId
+		}
+		if played == "" {
+			continue
+		}
+		found, err := c.exists(played)
+		if err != nil {
+			return err
+		}
+		if !found {
+			report.Issues = append(report.Issues, IntegrityIssue{Kind: "payment_track", EntityId: account.Id, Missing: played, Detail: "Payment " + payment.Reference + " of " + account.Id + " is for a play of " + played + " which does not exist", Repair: creditNote})
+		}
+	}
+
+	return nil
+}
+
+//==============================================================================================================================
+//		Query Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) check_integrity(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			1						2				3
+	//		scope (tracks or		bookmark		page size (optional)
+	//		accounts)
+
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting scope")
+	}
+	indexStr, ok := IntegrityScopes[args[1]]
+	if !ok {
+		return nil, errors.New("Integrity scope not recognized: " + args[1])
+	}
+	var bookmark string
+	if len(args) > 2 {
+		bookmark = args[2]
+	}
+	pageSize, err := page_size_arg(args, 3)
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.check_caller_role(stub, adminRole)
+	if err != nil {
+		return nil, err
+	}
+
+	keysIter, err := index_iterator_after(stub, indexStr, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer keysIter.Close()
+
+	report := IntegrityReport{Scope: args[1], Issues: []IntegrityIssue{}}
+	checker := &KeyChecker{stub: stub, seen: map[string]bool{}}
+	prefix := index_key(indexStr, "")
+	more := false
+
+	for keysIter.HasNext() {
+		key, _, err := keysIter.Next()
+		if err != nil {
+			return nil, errors.New("Failed to iterate " + indexStr + " index")
+		}
+		if report.Scanned == pageSize {
+			more = true
+			break
+		}
+		id := key[len(prefix):]
+		report.Bookmark = id
+		report.Scanned++
+
+		if report.Scope == "tracks" {
+			err = check_track_integrity(checker, &report, id)
+		} else {
+			err = check_account_integrity(checker, &report, id)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !more {
+		report.Bookmark = ""
+	}
+
+	reportBytes, _ := json.Marshal(report)
+
+	return reportBytes, nil
+}
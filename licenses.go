@@ -0,0 +1,366 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"strconv"
+	"time"
+)
+
+//==============================================================================================================================
+//	 Sync Licenses - A licensee (film, TV, advertising, games) requests the right to use a track for a fee and a term.
+//					 The owner of the track approves or rejects the request. On approval the licensee owes the fee to the
+//					 track's beneficiaries as pending payments, split over the rights like a play, and the term starts.
+//==============================================================================================================================
+type License struct {
+	Id					string		`json:"id"`
+	Licensee			string		`json:"licensee"`
+	TrackId				string		`json:"trackId"`
+	UsageType			LicenseType	`json:"usageType"`
+	Territory			string		`json:"territory"`			// ISO 3166 code, defaults to the platform territory
+	Fee					int64		`json:"fee"`
+	TermMonths			int			`json:"termMonths"`
+	Status				LicenseStatus	`json:"status"`			// requested, approved or rejected
+	RequestedAt			string		`json:"requestedAt"`
+	DecidedAt			string		`json:"decidedAt,omitempty"`
+	StartsAt			string		`json:"startsAt,omitempty"`	// set on approval
+	EndsAt				string		`json:"endsAt,omitempty"`
+	Payments			[]Payment	`json:"payments,omitempty"`
+	TermsHash			string		`json:"termsHash,omitempty"`	// SHA-256 of the fee kept in the private collection, hex encoded
+	termsLoaded			bool									// the fee was read from the private collection
+}
+
+var licenseIndexStr = "_licenses"
+var trackLicensesIndexStr = "track_licenses"
+
+// Per-track index of the licenses requested for the track, "track_licenses~<trackId>~<licenseId>"
+func track_licenses_index_str(trackId string) string {
+	return index_key(trackLicensesIndexStr, trackId)
+}
+
+func get_license(stub shim.ChaincodeStubInterface, licenseId string) (License, error) {
+
+	var license License
+
+	bytes, err := get_state(stub, licenseId)
+	if err != nil || len(bytes) == 0 {
+		return license, errors.New("Could not fetch license " + licenseId)
+	}
+	err = json.Unmarshal(bytes, &license)
+	if err != nil {
+		return license, errors.New("Could not unmarshal license " + licenseId)
+	}
+	err = open_license_terms(stub, &license)
+	if err != nil {
+		return license, err
+	}
+
+	return license, nil
+}
+
+func put_license(stub shim.ChaincodeStubInterface, license License) error {
+
+	err := validate_license_enums(license)
+	if err != nil {
+		return err
+	}
+	cfg, err := get_config(stub)
+	if err != nil {
+		return err
+	}
+	err = seal_license_terms(stub, cfg, &license)
+	if err != nil {
+		return err
+	}
+
+	licenseBytes, _ := json.Marshal(license)
+	err = put_state(stub, license.Id, licenseBytes)
+	if err != nil {
+		return errors.New("Error putting license " + license.Id + " on ledger")
+	}
+
+	return nil
+}
+
+// Fetches a license awaiting a decision, the invoker must own its track
+func (t *SimpleChaincode) get_license_to_decide(stub shim.ChaincodeStubInterface, licenseId string) (License, error) {
+
+	license, err := get_license(stub, licenseId)
+	if err != nil {
+		return license, err
+	}
+	if license.Status != LicenseRequested {
+		return license, errors.New("License " + licenseId + " is already " + string(license.Status))
+	}
+	tr, err := fetch_track(stub, license.TrackId)
+	if err != nil {
+		return license, err
+	}
+	err = t.check_track_owner(stub, tr, license.TrackId)
+	if err != nil {
+		return license, err
+	}
+
+	return license, nil
+}
+
+//==============================================================================================================================
+//  Invoke Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) request_license(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0			1			2		3				4
+	//		trackId		usageType		fee		term in months	territory (optional)
+
+	if len(args) < 4 {
+		return nil, errors.New("Incorrect number of arguments. Expecting trackId, usageType, fee and term in months")
+	}
+	if !LicenseTypes[LicenseType(args[1])] {
+		return nil, errors.New("Usage type not recognized: " + args[1])
+	}
+	fee, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil || fee < 0 {
+		return nil, errors.New("Invalid fee " + args[2])
+	}
+	termMonths, err := strconv.Atoi(args[3])
+	if err != nil || termMonths <= 0 {
+		return nil, errors.New("Invalid term " + args[3] + ", expecting a positive number of months")
+	}
+
+	tr, err := fetch_track(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if !is_track_active(tr) {
+		return nil, errors.New("Track " + args[0] + " is inactive and cannot be licensed")
+	}
+
+	licensee, err := t.get_caller_username(stub)
+	if err != nil {
+		return nil, err
+	}
+	_, err = get_account(stub, licensee)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := get_tx_time(stub, cfg)
+	if err != nil {
+		return nil, err
+	}
+	err = check_track_released(stub, &tr, args[0], now)
+	if err != nil {
+		return nil, err
+	}
+
+	licenseId, err := append_id(stub, licenseIndexStr, "lic", true)
+	if err != nil {
+		return nil, errors.New("Error creating new id for license")
+	}
+	err = add_to_index(stub, track_licenses_index_str(args[0]), string(licenseId))
+	if err != nil {
+		return nil, err
+	}
+
+	var license License
+	license.Id			= string(licenseId)
+	license.Licensee	= licensee
+	license.TrackId		= args[0]
+	license.UsageType	= LicenseType(args[1])
+	license.Fee			= fee
+	license.TermMonths	= termMonths
+	license.Territory	= cfg.DefaultTerritory
+	if len(args) > 4 && args[4] != "" {
+		license.Territory = args[4]
+	}
+	license.Status		= LicenseRequested
+	license.RequestedAt	= now.Format(time.RFC3339)
+
+	err = put_license(stub, license)
+	if err != nil {
+		return nil, err
+	}
+
+	return licenseId, nil
+}
+
+func (t *SimpleChaincode) approve_license(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		licenseId
+
+	if len(args) < 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting licenseId")
+	}
+
+	license, err := t.get_license_to_decide(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	tr, err := fetch_track(stub, license.TrackId)
+	if err != nil {
+		return nil, err
+	}
+	licensee, err := get_account(stub, license.Licensee)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := get_tx_time(stub, cfg)
+	if err != nil {
+		return nil, err
+	}
+	period, err := resolve_period(cfg, now)
+	if err != nil {
+		return nil, err
+	}
+	dueDate, err := payment_due_date(cfg, licensee, now)
+	if err != nil {
+		return nil, err
+	}
+
+	// The licensee owes the fee to the beneficiaries
+	var payments []Payment
+	for _, payout := range rights_payouts(cfg, track_split_at(tr, now), license.Fee) {
+		var payment Payment
+		payment.RecipientId	= payout.RecipientId
+		payment.SenderId	= licensee.Id
+		payment.Amount		= payout.Amount
+		payment.Currency	= cfg.Currency
+		payment.CreatedAt	= now.Format(time.RFC3339)
+		payment.DueDate		= dueDate
+		payment.Period		= period.Id
+		payment.Reference	= license.Id
+		payment.Rights		= payout.Rights
+
+		payments = append(payments, payment)
+	}
+
+	// a beneficiary holding both rights gets both payments in one write, a licensee that is one reads its copies back
+	// as recipient before adding those it sends
+	err = append_to_recipients(stub, payments)
+	if err != nil {
+		return nil, err
+	}
+	err = append_pending_payments(stub, licensee.Id, payments)
+	if err != nil {
+		return nil, err
+	}
+	var paid int64
+	for _, payment := range payments {
+		paid += payment.Amount
+	}
+
+	err = add_track_earnings(stub, license.TrackId, period.Id, paid)
+	if err != nil {
+		return nil, err
+	}
+
+	license.Status		= LicenseApproved
+	license.DecidedAt	= now.Format(time.RFC3339)
+	license.StartsAt	= now.Format(time.RFC3339)
+	license.EndsAt		= now.AddDate(0, license.TermMonths, 0).Format(time.RFC3339)
+	license.Payments	= payments
+
+	err = emit_payments_created(stub, payments)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, put_license(stub, license)
+}
+
+func (t *SimpleChaincode) reject_license(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		licenseId
+
+	if len(args) < 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting licenseId")
+	}
+
+	license, err := t.get_license_to_decide(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := get_tx_time(stub, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	license.Status		= LicenseRejected
+	license.DecidedAt	= now.Format(time.RFC3339)
+
+	return nil, put_license(stub, license)
+}
+
+//==============================================================================================================================
+//		Query Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) query_license(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			1
+	//		licenseId
+
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting licenseId")
+	}
+
+	license, err := get_license(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	licenseBytes, _ := json.Marshal(license)
+
+	return licenseBytes, nil
+}
+
+func (t *SimpleChaincode) get_track_licenses(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			1
+	//		trackId
+
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting trackId")
+	}
+
+	licenseIds, err := get_index_ids(stub, track_licenses_index_str(args[1]))
+	if err != nil {
+		return nil, err
+	}
+
+	licenses := []License{}
+	for _, licenseId := range licenseIds {
+		license, err := get_license(stub, licenseId)
+		if err != nil {
+			return nil, err
+		}
+		licenses = append(licenses, license)
+	}
+
+	licensesBytes, _ := json.Marshal(licenses)
+
+	return licensesBytes, nil
+}
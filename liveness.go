@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"strconv"
+	"time"
+)
+
+//==============================================================================================================================
+//	 Content Liveness - Chaincode cannot fetch URLs, so verifiers, invokers whose certificate has role "verifier", check
+//						off-chain whether the content of a track is still retrievable at its URL or CID and attest the
+//						result on the ledger. The attestation is signed by the verifier's transaction and stamped with
+//						its time. A track is live while its latest attestation says the content was retrievable and is
+//						younger than contentAttestationMaxAgeHours, failing when it says it was not and stale when it is
+//						too old. Failing and stale tracks are flagged by get_flagged_content, and when the configuration
+//						sets excludeUnliveContent their play payments are not settled until a verifier attests them live.
+//						Tracks no verifier has attested yet are unattested and not excluded.
+//==============================================================================================================================
+type ContentAttestation struct {
+	Location			string		`json:"location"`			// URL or CID the verifier retrieved the content from
+	Retrievable			bool		`json:"retrievable"`
+	Detail				string		`json:"detail,omitempty"`	// e.g. the HTTP status or error the verifier got
+	Verifier			string		`json:"verifier"`
+	TxId				string		`json:"txId"`				// transaction the verifier signed the attestation with
+	AttestedAt			string		`json:"attestedAt"`
+}
+
+type ContentLiveness struct {
+	TrackId				string					`json:"trackId"`
+	Status				string					`json:"status"`			// live, failing, stale or unattested, derived when read
+	Attestations		[]ContentAttestation	`json:"attestations"`	// most recent last
+}
+
+var verifierRole = "verifier"
+var contentLivenessKeyPrefix = "_content_liveness_"
+var maxContentAttestations = 20
+
+func content_liveness_key(trackId string) string {
+	return contentLivenessKeyPrefix + trackId
+}
+
+func get_content_liveness(stub shim.ChaincodeStubInterface, trackId string) (ContentLiveness, error) {
+
+	liveness := ContentLiveness{TrackId: trackId, Attestations: []ContentAttestation{}}
+
+	bytes, err := get_state(stub, content_liveness_key(trackId))
+	if err != nil {
+		return liveness, errors.New("Could not fetch content liveness of track " + trackId)
+	}
+	if len(bytes) > 0 {
+		err = json.Unmarshal(bytes, &liveness)
+		if err != nil {
+			return liveness, errors.New("Could not unmarshal content liveness of track " + trackId)
+		}
+	}
+
+	return liveness, nil
+}
+
+// Derives the liveness status of a track from its latest attestation
+func content_liveness_status(cfg Config, liveness ContentLiveness, now time.Time) string {
+
+	if len(liveness.Attestations) == 0 {
+		return "unattested"
+	}
+	latest := liveness.Attestations[len(liveness.Attestations)-1]
+	if !latest.Retrievable {
+		return "failing"
+	}
+	if cfg.ContentAttestationMaxAgeHours > 0 {
+		attestedAt, err := time.Parse(time.RFC3339, latest.AttestedAt)
+		if err != nil || now.Sub(attestedAt) > time.Duration(cfg.ContentAttestationMaxAgeHours)*time.Hour {
+			return "stale"
+		}
+	}
+
+	return "live"
+}
+
+func is_content_flagged(status string) bool {
+	return status == "failing" || status == "stale"
+}
+
+// Verifies the plays of a track are eligible for payout, which they are not when the configuration excludes unlive content
+func check_content_payout_eligible(stub shim.ChaincodeStubInterface, cfg Config, trackId string, now time.Time) error {
+
+	if !cfg.ExcludeUnliveContent {
+		return nil
+	}
+
+	liveness, err := get_content_liveness(stub, trackId)
+	if err != nil {
+		return err
+	}
+	status := content_liveness_status(cfg, liveness, now)
+	if is_content_flagged(status) {
+		return errors.New("Content of track " + trackId + " is " + status + ", its plays are not paid out until a verifier attests it live")
+	}
+
+	return nil
+}
+
+// Verifies the play a payment originates from is eligible for payout, payments not originating from a track play are
+func check_payment_payout_eligible(stub shim.ChaincodeStubInterface, cfg Config, payment Payment, now time.Time) error {
+
+	if !cfg.ExcludeUnliveContent || payment.Source == "" {
+		return nil
+	}
+
+	bytes, err := get_state(stub, payment.Reference)
+	if err != nil || len(bytes) == 0 {
+		return errors.New("Could not fetch play " + payment.Reference)
+	}
+	var play Play
+	err = json.Unmarshal(bytes, &play)
+	if err != nil {
+		return errors.New("Could not unmarshal play " + payment.Reference)
+	}
+	if play.TrackId == "" {
+		return nil
+	}
+
+	return check_content_payout_eligible(stub, cfg, play.TrackId, now)
+}
+
+//==============================================================================================================================
+//  Invoke Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) attest_content_liveness(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0			1				2					3
+	//		trackId		location		retrievable			detail (optional)
+	//					(URL or CID)	(true or false)
+
+	if len(args) < 3 || args[1] == "" {
+		return nil, errors.New("Incorrect number of arguments. Expecting trackId, location and retrievable")
+	}
+	retrievable, err := strconv.ParseBool(args[2])
+	if err != nil {
+		return nil, errors.New("Invalid retrievable " + args[2] + ", expecting true or false")
+	}
+
+	err = t.check_caller_role(stub, verifierRole)
+	if err != nil {
+		return nil, err
+	}
+	verifier, err := t.get_caller_username(stub)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := get_tx_time(stub, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = fetch_track(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	liveness, err := get_content_liveness(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	var attestation ContentAttestation
+	attestation.Location	= args[1]
+	attestation.Retrievable	= retrievable
+	attestation.Verifier	= verifier
+	attestation.TxId		= stub.GetTxID()
+	attestation.AttestedAt	= now.Format(time.RFC3339)
+	if len(args) > 3 {
+		attestation.Detail = args[3]
+	}
+
+	liveness.Attestations = append(liveness.Attestations, attestation)
+	if len(liveness.Attestations) > maxContentAttestations {
+		liveness.Attestations = liveness.Attestations[len(liveness.Attestations)-maxContentAttestations:]
+	}
+	liveness.Status = content_liveness_status(cfg, liveness, now)
+
+	livenessBytes, _ := json.Marshal(liveness)
+	err = put_state(stub, content_liveness_key(args[0]), livenessBytes)
+	if err != nil {
+		return nil, errors.New("Error putting content liveness of track " + args[0] + " on ledger")
+	}
+
+	return livenessBytes, emit_event(stub, "ContentAttested", liveness)
+}
+
+//==============================================================================================================================
+//		Query Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) query_content_liveness(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			1
+	//		trackId
+
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting trackId")
+	}
+
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := get_tx_time(stub, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	liveness, err := get_content_liveness(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	liveness.Status = content_liveness_status(cfg, liveness, now)
+
+	livenessBytes, _ := json.Marshal(liveness)
+
+	return livenessBytes, nil
+}
+
+// Lists the tracks whose content is failing or stale
+func (t *SimpleChaincode) get_flagged_content(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := get_tx_time(stub, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	trackIds, err := get_index_ids(stub, trackIndexStr)
+	if err != nil {
+		return nil, err
+	}
+
+	flagged := []ContentLiveness{}
+	for _, trackId := range trackIds {
+		liveness, err := get_content_liveness(stub, trackId)
+		if err != nil {
+			return nil, err
+		}
+		liveness.Status = content_liveness_status(cfg, liveness, now)
+		if is_content_flagged(liveness.Status) {
+			flagged = append(flagged, liveness)
+		}
+	}
+
+	flaggedBytes, _ := json.Marshal(flagged)
+
+	return flaggedBytes, nil
+}
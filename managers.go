@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"sort"
+)
+
+//==============================================================================================================================
+//	 Managers - An artist can delegate read access to a manager account. A manager gets one dashboard aggregated over
+//				every artist that delegated to them, with the open disputes any of them is a party to.
+//==============================================================================================================================
+type ArtistSummary struct {
+	ArtistId			string		`json:"artistId"`
+	TotalEarnings		int64		`json:"totalEarnings"`		// lifetime gross earnings of the artist's catalog
+	TotalTips			int64		`json:"totalTips"`			// lifetime tips the artist received, not part of the earnings
+	PendingPayouts		int64		`json:"pendingPayouts"`		// uncompleted payments the artist is still to receive
+	Tracks				int			`json:"tracks"`
+}
+
+type DashboardTrack struct {
+	TrackId				string		`json:"trackId"`
+	ArtistId			string		`json:"artistId"`
+	Title				string		`json:"title"`
+	TotalEarnings		int64		`json:"totalEarnings"`
+}
+
+type ManagerDashboard struct {
+	ManagerId			string				`json:"managerId"`
+	Artists				[]ArtistSummary		`json:"artists"`
+	TopTracks			[]DashboardTrack	`json:"topTracks"`
+	TotalEarnings		int64				`json:"totalEarnings"`
+	TotalTips			int64				`json:"totalTips"`
+	PendingPayouts		int64				`json:"pendingPayouts"`
+	OpenDisputes		int					`json:"openDisputes"`
+	Disputes			[]Dispute			`json:"disputes"`				// the open disputes, each once however many artists are party to it
+}
+
+var managedArtistsIndexStr = "manages"
+var dashboardTopTracks = 10
+
+// Per-manager index of the artists that delegated access to the manager
+func managed_artists_index_str(managerId string) string {
+	return index_key(managedArtistsIndexStr, managerId)
+}
+
+func pending_incoming(account Account) int64 {
+	var total int64
+	for _, payment := range account.PendingPayments {
+		if payment.RecipientId == account.Id && !payment.Completed {
+			total += payment.Amount
+		}
+	}
+	return total
+}
+
+// Summarizes the earnings of an artist and returns the artist's tracks with their lifetime earnings
+func artist_summary(stub shim.ChaincodeStubInterface, artistId string) (ArtistSummary, []DashboardTrack, error) {
+
+	var summary ArtistSummary
+	summary.ArtistId = artistId
+	tracks := []DashboardTrack{}
+
+	bytes, err := get_state(stub, artistId)
+	if err != nil {
+		return summary, nil, errors.New("Unable to get account with ID: " + artistId)
+	}
+	var account Account
+	json.Unmarshal(bytes, &account)
+	summary.PendingPayouts = pending_incoming(account)
+	summary.TotalTips, err = get_account_tips_total(stub, artistId)
+	if err != nil {
+		return summary, nil, err
+	}
+
+	trackIds, err := get_index_ids(stub, artist_tracks_index_str(artistId))
+	if err != nil {
+		return summary, nil, err
+	}
+	for _, trackId := range trackIds {
+
+		earnings, err := get_track_lifetime_earnings(stub, trackId)
+		if err != nil {
+			return summary, nil, err
+		}
+
+		bytes, err := get_state(stub, trackId)
+		if err != nil {
+			return summary, nil, errors.New("Unable to get track with ID: " + trackId)
+		}
+		var tr Track
+		json.Unmarshal(bytes, &tr)
+
+		summary.TotalEarnings += earnings
+		summary.Tracks++
+		tracks = append(tracks, DashboardTrack{TrackId: trackId, ArtistId: artistId, Title: tr.Title, TotalEarnings: earnings})
+	}
+
+	return summary, tracks, nil
+}
+
+// The n best earning tracks. The stable sort keeps index order for ties, so every peer returns the same list.
+func top_tracks(tracks []DashboardTrack, n int) []DashboardTrack {
+
+	sort.SliceStable(tracks, func(i, j int) bool { return tracks[i].TotalEarnings > tracks[j].TotalEarnings })
+	if len(tracks) > n {
+		tracks = tracks[:n]
+	}
+
+	return tracks
+}
+
+//==============================================================================================================================
+//  Invoke Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) grant_manager_access(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		managerId
+
+	if len(args) < 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting managerId")
+	}
+
+	artistId, err := t.get_caller_username(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	bytes, err := get_state(stub, args[0])
+	if err != nil || len(bytes) == 0 {
+		return nil, errors.New("Could not fetch manager account " + args[0])
+	}
+
+	return nil, add_to_index(stub, managed_artists_index_str(args[0]), artistId)
+}
+
+func (t *SimpleChaincode) revoke_manager_access(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		managerId
+
+	if len(args) < 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting managerId")
+	}
+
+	artistId, err := t.get_caller_username(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, remove_from_index(stub, managed_artists_index_str(args[0]), artistId)
+}
+
+//==============================================================================================================================
+//		Query Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) get_manager_dashboard(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			1
+	//		managerId
+
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting managerId")
+	}
+	managerId := args[1]
+
+	caller, err := t.get_caller_username(stub)
+	if err != nil {
+		return nil, err
+	}
+	if caller != managerId {
+		return nil, errors.New("Only manager " + managerId + " can see this dashboard")
+	}
+
+	artistIds, err := get_index_ids(stub, managed_artists_index_str(managerId))
+	if err != nil {
+		return nil, err
+	}
+
+	var dashboard ManagerDashboard
+	dashboard.ManagerId = managerId
+	dashboard.Artists = []ArtistSummary{}
+	dashboard.Disputes = []Dispute{}
+	tracks := []DashboardTrack{}
+	disputeIds := []string{}
+
+	for _, artistId := range artistIds {
+
+		summary, artistTracks, err := artist_summary(stub, artistId)
+		if err != nil {
+			return nil, err
+		}
+
+		dashboard.TotalEarnings += summary.TotalEarnings
+		dashboard.TotalTips += summary.TotalTips
+		dashboard.PendingPayouts += summary.PendingPayouts
+		dashboard.Artists = append(dashboard.Artists, summary)
+		tracks = append(tracks, artistTracks...)
+
+		artistDisputeIds, err := get_index_ids(stub, party_open_disputes_index_str(artistId))
+		if err != nil {
+			return nil, err
+		}
+		for _, disputeId := range artistDisputeIds {
+			if !contains_id(disputeIds, disputeId) {
+				disputeIds = append(disputeIds, disputeId)
+			}
+		}
+	}
+
+	for _, disputeId := range disputeIds {
+		dispute, err := get_dispute(stub, disputeId)
+		if err != nil {
+			return nil, err
+		}
+		dashboard.Disputes = append(dashboard.Disputes, dispute)
+	}
+	dashboard.OpenDisputes = len(dashboard.Disputes)
+
+	dashboard.TopTracks = top_tracks(tracks, dashboardTopTracks)
+
+	dashboardBytes, _ := json.Marshal(dashboard)
+
+	return dashboardBytes, nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"hash/fnv"
+	"strconv"
+	"strings"
+)
+
+//==============================================================================================================================
+//	 Metrics - Every invoke that succeeds bumps the invocation counter of its function in the current period. A counter
+//			   is spread over metricShards keys, the shard is picked from the tx id so concurrent invokes of the same
+//			   function rarely touch the same key. Counters start at zero every period and an operator can reset the
+//			   counters of a period. Failed invokes are not counted: a failed transaction commits none of its writes,
+//			   so failures are only visible to the client that got the error and to the peer logs.
+//==============================================================================================================================
+type FunctionMetrics struct {
+	Function			string				`json:"function"`
+	Invocations			int64				`json:"invocations"`
+}
+
+type MetricsReport struct {
+	Period				string				`json:"period"`
+	Functions			[]FunctionMetrics	`json:"functions"`
+}
+
+var metricsIndexStr = "metrics"
+var metricShards = 8
+
+// Counter keys are "metrics~<period>~<function>~<counter>~<shard>", the counter is "invocations"
+func metrics_index_str(periodId string) string {
+	return index_key(metricsIndexStr, periodId)
+}
+
+func metric_shard(stub shim.ChaincodeStubInterface) int {
+	h := fnv.New32a()
+	h.Write([]byte(stub.GetTxID()))
+	return int(h.Sum32() % uint32(metricShards))
+}
+
+func increment_counter(stub shim.ChaincodeStubInterface, key string) error {
+
+	bytes, err := get_state(stub, key)
+	if err != nil {
+		return errors.New("Failed to get counter " + key)
+	}
+
+	var count int64
+	if len(bytes) > 0 {
+		count, _ = strconv.ParseInt(string(bytes), 10, 64)
+	}
+
+	err = put_state(stub, key, []byte(strconv.FormatInt(count+1, 10)))
+	if err != nil {
+		return errors.New("Error storing counter " + key)
+	}
+
+	return nil
+}
+
+// Counts a successful invoke of the function
+func record_metrics(stub shim.ChaincodeStubInterface, function string) error {
+
+	cfg, err := get_config(stub)
+	if err != nil {
+		return err
+	}
+	period, err := get_tx_period(stub, cfg)
+	if err != nil {
+		return err
+	}
+
+	shard := strconv.Itoa(metric_shard(stub))
+	prefix := index_key(metrics_index_str(period.Id), function)
+
+	return increment_counter(stub, index_key(index_key(prefix, "invocations"), shard))
+}
+
+// Resolves the period argument at position i, defaulting to the period of the transaction
+func metrics_period_arg(stub shim.ChaincodeStubInterface, cfg Config, args []string, i int) (string, error) {
+
+	if len(args) > i && args[i] != "" {
+		return args[i], nil
+	}
+
+	period, err := get_tx_period(stub, cfg)
+	if err != nil {
+		return "", err
+	}
+
+	return period.Id, nil
+}
+
+//==============================================================================================================================
+//  Invoke Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) reset_metrics(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		periodId (optional - defaults to the current period)
+
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	err = t.check_platform_access(stub, cfg, "access metrics")
+	if err != nil {
+		return nil, err
+	}
+	periodId, err := metrics_period_arg(stub, cfg, args, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := get_index_ids(stub, metrics_index_str(periodId))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range keys {
+		err = del_state(stub, index_key(metrics_index_str(periodId), key))
+		if err != nil {
+			return nil, errors.New("Error deleting counter " + key)
+		}
+	}
+
+	return nil, nil
+}
+
+//==============================================================================================================================
+//		Query Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) get_metrics(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			1
+	//		periodId (optional - defaults to the current period)
+
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	err = t.check_platform_access(stub, cfg, "access metrics")
+	if err != nil {
+		return nil, err
+	}
+	periodId, err := metrics_period_arg(stub, cfg, args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := index_key(metrics_index_str(periodId), "")
+	keysIter, err := range_query_state(stub, prefix, prefix+"\xff")
+	if err != nil {
+		return nil, errors.New("Failed to range query metrics of period " + periodId)
+	}
+	defer keysIter.Close()
+
+	var report MetricsReport
+	report.Period		= periodId
+	report.Functions	= []FunctionMetrics{}
+
+	byFunction := map[string]int{}
+	for keysIter.HasNext() {
+		key, value, err := keysIter.Next()
+		if err != nil {
+			return nil, errors.New("Failed to iterate metrics of period " + periodId)
+		}
+
+		// <function>~<counter>~<shard>
+		parts := strings.Split(key[len(prefix):], "~")
+		if len(parts) != 3 {
+			continue
+		}
+		count, _ := strconv.ParseInt(string(value), 10, 64)
+
+		i, ok := byFunction[parts[0]]
+		if !ok {
+			i = len(report.Functions)
+			byFunction[parts[0]] = i
+			report.Functions = append(report.Functions, FunctionMetrics{Function: parts[0]})
+		}
+
+		if parts[1] == "invocations" {
+			report.Functions[i].Invocations += count
+		}
+	}
+
+	reportBytes, _ := json.Marshal(report)
+
+	return reportBytes, nil
+}
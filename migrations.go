@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"strconv"
+	"time"
+)
+
+//==============================================================================================================================
+//	 Migrations - Accounts moving over from a legacy system are imported in numbered chunks under a migration id. Each
+//				  account comes with its opening balance and the pending payments carried over, and gets a journal entry
+//				  recording what was imported so opening balances can be audited. A chunk that was already imported is
+//				  acknowledged without importing it again, so an interrupted import resumes by resending from the chunk
+//				  it stopped at.
+//==============================================================================================================================
+type Migration struct {
+	Id					string		`json:"id"`
+	Chunks				int			`json:"chunks"`			// chunks imported so far, the next chunk to send has this index
+	Accounts			int			`json:"accounts"`
+	OpeningBalances		int64		`json:"openingBalances"`
+	StartedAt			string		`json:"startedAt"`
+	UpdatedAt			string		`json:"updatedAt"`
+}
+
+type MigrationJournalEntry struct {
+	MigrationId			string		`json:"migrationId"`
+	Chunk				int			`json:"chunk"`
+	AccountId			string		`json:"accountId"`
+	OpeningBalance		int64		`json:"openingBalance"`
+	Carryovers			int			`json:"carryovers"`			// pending payments carried over
+	CarryoverTotal		int64		`json:"carryoverTotal"`
+	ImportedAt			string		`json:"importedAt"`
+	TxId				string		`json:"txId"`
+}
+
+type MigrationJournalPage struct {
+	Migration			Migration					`json:"migration"`
+	Entries				[]MigrationJournalEntry		`json:"entries"`
+	Bookmark			string						`json:"bookmark,omitempty"`
+}
+
+var migrationJournalIndexStr = "migration_journal"
+
+func migration_key(migrationId string) string {
+	return "_migration_" + migrationId
+}
+
+// Journal entries are kept as "migration_journal~<migrationId>~<accountId>"
+func migration_journal_index_str(migrationId string) string {
+	return index_key(migrationJournalIndexStr, migrationId)
+}
+
+func get_migration(stub shim.ChaincodeStubInterface, migrationId string) (Migration, error) {
+
+	migration := Migration{Id: migrationId}
+
+	bytes, err := get_state(stub, migration_key(migrationId))
+	if err != nil {
+		return migration, errors.New("Failed to get migration " + migrationId)
+	}
+	if len(bytes) > 0 {
+		json.Unmarshal(bytes, &migration)
+	}
+
+	return migration, nil
+}
+
+//==============================================================================================================================
+//  Invoke Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) import_accounts(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0				1				2
+	//		migrationId		chunk index		JSON array of accounts, balance is the opening balance and
+	//										pendingPayments the payments carried over
+
+	if len(args) < 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting migrationId, chunk index and accounts")
+	}
+
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	err = t.check_platform_access(stub, cfg, "import accounts")
+	if err != nil {
+		return nil, err
+	}
+	now, err := get_tx_time(stub, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	migration, err := get_migration(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	chunk, err := strconv.Atoi(args[1])
+	if err != nil || chunk < 0 || chunk > migration.Chunks {
+		return nil, errors.New("Invalid chunk index " + args[1] + ", expecting " + strconv.Itoa(migration.Chunks))
+	}
+
+	// a chunk resent after an interrupted import was already imported
+	if chunk < migration.Chunks {
+		migrationBytes, _ := json.Marshal(migration)
+		return migrationBytes, nil
+	}
+
+	var accounts []Account
+	err = json.Unmarshal([]byte(args[2]), &accounts)
+	if err != nil {
+		return nil, errors.New("Could not unmarshal accounts: " + err.Error())
+	}
+
+	for _, account := range accounts {
+		if account.Id == "" {
+			return nil, errors.New("Every imported account needs an id")
+		}
+		err = check_record_id("account", account.Id)
+		if err != nil {
+			return nil, err
+		}
+		if account.Type != "" && !AccountTypes[account.Type] {
+			return nil, errors.New("Account type not recognized: " + account.Type)
+		}
+		existing, err := get_state(stub, account.Id)
+		if err != nil {
+			return nil, errors.New("Could not fetch " + account.Id)
+		}
+		if len(existing) > 0 {
+			return nil, errors.New("Account " + account.Id + " already exists")
+		}
+
+		var entry MigrationJournalEntry
+		entry.MigrationId		= migration.Id
+		entry.Chunk				= chunk
+		entry.AccountId			= account.Id
+		entry.OpeningBalance	= account.Balance
+		entry.Carryovers		= len(account.PendingPayments)
+		entry.ImportedAt		= now.Format(time.RFC3339)
+		entry.TxId				= stub.GetTxID()
+		for _, payment := range account.PendingPayments {
+			entry.CarryoverTotal += payment.Amount
+		}
+
+		err = add_to_index(stub, accountIndexStr, account.Id)
+		if err != nil {
+			return nil, err
+		}
+		err = put_account(stub, account)
+		if err != nil {
+			return nil, err
+		}
+		entryBytes, _ := json.Marshal(entry)
+		err = put_state(stub, index_key(migration_journal_index_str(migration.Id), account.Id), entryBytes)
+		if err != nil {
+			return nil, errors.New("Error putting journal entry of account " + account.Id + " on ledger")
+		}
+
+		err = emit_event(stub, "AccountCreated", account)
+		if err != nil {
+			return nil, err
+		}
+
+		migration.Accounts++
+		migration.OpeningBalances += account.Balance
+	}
+
+	migration.Chunks++
+	if migration.StartedAt == "" {
+		migration.StartedAt = now.Format(time.RFC3339)
+	}
+	migration.UpdatedAt = now.Format(time.RFC3339)
+
+	migrationBytes, _ := json.Marshal(migration)
+	err = put_state(stub, migration_key(migration.Id), migrationBytes)
+	if err != nil {
+		return nil, errors.New("Error putting migration " + migration.Id + " on ledger")
+	}
+
+	return migrationBytes, nil
+}
+
+//==============================================================================================================================
+//		Query Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) get_migration_journal(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			1				2						3
+	//		migrationId		bookmark (optional)		page size (optional)
+
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting migrationId")
+	}
+	var bookmark string
+	if len(args) > 2 {
+		bookmark = args[2]
+	}
+	pageSize, err := page_size_arg(args, 3)
+	if err != nil {
+		return nil, err
+	}
+
+	var page MigrationJournalPage
+	page.Migration, err = get_migration(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	page.Entries = []MigrationJournalEntry{}
+
+	indexStr := migration_journal_index_str(args[1])
+	keysIter, err := index_iterator_after(stub, indexStr, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer keysIter.Close()
+
+	prefix := index_key(indexStr, "")
+	more := false
+
+	for keysIter.HasNext() {
+		key, value, err := keysIter.Next()
+		if err != nil {
+			return nil, errors.New("Failed to iterate " + indexStr + " index")
+		}
+
+		// the page is full and there is at least one more entry, so hand out a bookmark
+		if len(page.Entries) == pageSize {
+			more = true
+			break
+		}
+		page.Bookmark = key[len(prefix):]
+
+		var entry MigrationJournalEntry
+		json.Unmarshal(value, &entry)
+		page.Entries = append(page.Entries, entry)
+	}
+	if !more {
+		page.Bookmark = ""
+	}
+
+	pageBytes, _ := json.Marshal(page)
+
+	return pageBytes, nil
+}
@@ -0,0 +1,434 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"sort"
+	"time"
+)
+
+//==============================================================================================================================
+//	 Split Negotiations - Before a split is finalized the parties exchange offers: proposed percentages that expire at a
+//						  set time. A party answers an open offer by accepting it, rejecting it or countering it with an
+//						  offer of its own. The parties are the owner of the track and every account in the current or the
+//						  proposed split. Once all of them accepted, the split is applied to the track, for the master or
+//						  publishing rights when the offer names them. Offers are never deleted, so the history leading to
+//						  the signed split stays on the ledger as evidence.
+//==============================================================================================================================
+type SplitOffer struct {
+	Id					string			`json:"id"`
+	TrackId				string			`json:"trackId"`
+	Rights				string			`json:"rights,omitempty"`		// master or publishing, empty for the flat split
+	Proposer			string			`json:"proposer"`
+	Beneficiaries		[]Beneficiary	`json:"beneficiaries"`			// the proposed split
+	Parties				[]string		`json:"parties"`
+	AcceptedBy			[]string		`json:"acceptedBy"`
+	Status				string			`json:"status"`				// open, accepted, rejected, countered or expired
+	CounterTo			string			`json:"counterTo,omitempty"`	// the offer this one counters
+	CounteredBy			string			`json:"counteredBy,omitempty"`
+	ProposedAt			string			`json:"proposedAt"`
+	ExpiresAt			string			`json:"expiresAt"`
+	DecidedAt			string			`json:"decidedAt,omitempty"`
+}
+
+var splitOfferIndexStr = "_split_offers"
+var trackSplitOffersIndexStr = "split_offers"
+
+// Per-track index of the split offers made for the track, "split_offers~<trackId>~<offerId>"
+func track_split_offers_index_str(trackId string) string {
+	return index_key(trackSplitOffersIndexStr, trackId)
+}
+
+func get_split_offer(stub shim.ChaincodeStubInterface, offerId string) (SplitOffer, error) {
+
+	var offer SplitOffer
+
+	bytes, err := get_state(stub, offerId)
+	if err != nil || len(bytes) == 0 {
+		return offer, errors.New("Could not fetch split offer " + offerId)
+	}
+	err = json.Unmarshal(bytes, &offer)
+	if err != nil {
+		return offer, errors.New("Could not unmarshal split offer " + offerId)
+	}
+
+	return offer, nil
+}
+
+func put_split_offer(stub shim.ChaincodeStubInterface, offer SplitOffer) error {
+
+	offerBytes, _ := json.Marshal(offer)
+	err := put_state(stub, offer.Id, offerBytes)
+	if err != nil {
+		return errors.New("Error putting split offer " + offer.Id + " on ledger")
+	}
+
+	return nil
+}
+
+// The split of the track the offer would replace
+func offered_split(tr Track, rights string) ([]Beneficiary, error) {
+
+	if rights == "" {
+		return tr.Beneficiaries, nil
+	}
+	if rights == "master" && tr.Recording != nil {
+		return tr.Recording.Beneficiaries, nil
+	}
+	if rights == "publishing" && tr.Composition != nil {
+		return tr.Composition.Beneficiaries, nil
+	}
+
+	return nil, errors.New("Track has no " + rights + " rights to negotiate")
+}
+
+// An open offer that has not expired yet
+func is_offer_open(offer SplitOffer, now time.Time) bool {
+
+	if offer.Status != "open" {
+		return false
+	}
+	expiresAt, err := time.Parse(time.RFC3339, offer.ExpiresAt)
+
+	return err == nil && now.Before(expiresAt)
+}
+
+func is_party(offer SplitOffer, accountId string) bool {
+	for _, party := range offer.Parties {
+		if party == accountId {
+			return true
+		}
+	}
+	return false
+}
+
+// Builds an offer from the invoker for the track, the invoker accepts its own offer
+func (t *SimpleChaincode) new_split_offer(stub shim.ChaincodeStubInterface, tr Track, trackId string, rights string, beneficiariesJSON string, expires string, now time.Time) (SplitOffer, error) {
+
+	var offer SplitOffer
+
+	proposer, err := t.get_caller_username(stub)
+	if err != nil {
+		return offer, err
+	}
+	current, err := offered_split(tr, rights)
+	if err != nil {
+		return offer, err
+	}
+
+	err = json.Unmarshal([]byte(beneficiariesJSON), &offer.Beneficiaries)
+	if err != nil {
+		return offer, errors.New("Could not unmarshal beneficiaries: " + err.Error())
+	}
+	err = validate_beneficiaries(offer.Beneficiaries)
+	if err != nil {
+		return offer, err
+	}
+	expiresAt, err := time.Parse(time.RFC3339, expires)
+	if err != nil {
+		return offer, errors.New("Invalid expiry " + expires + ", expecting RFC3339")
+	}
+	if !expiresAt.After(now) {
+		return offer, errors.New("Invalid expiry " + expires + ", it must be in the future")
+	}
+
+	parties := []string{tr.Owner}
+	for _, b := range append(append([]Beneficiary{}, current...), offer.Beneficiaries...) {
+		if b.Placeholder != "" {
+			return offer, errors.New("Placeholder " + b.Placeholder + " has to be claimed before it can negotiate a split")
+		}
+		parties = append(parties, b.AccountId)
+	}
+	for _, party := range parties {
+		if party != "" && !is_party(offer, party) {
+			offer.Parties = append(offer.Parties, party)
+		}
+	}
+	if !is_party(offer, proposer) {
+		return offer, errors.New("Only the owner of track " + trackId + " and the accounts in its split can propose a split")
+	}
+
+	offerId, err := append_id(stub, splitOfferIndexStr, "so", true)
+	if err != nil {
+		return offer, errors.New("Error creating new id for split offer")
+	}
+	err = add_to_index(stub, track_split_offers_index_str(trackId), string(offerId))
+	if err != nil {
+		return offer, err
+	}
+
+	offer.Id			= string(offerId)
+	offer.TrackId		= trackId
+	offer.Rights		= rights
+	offer.Proposer		= proposer
+	offer.AcceptedBy	= []string{proposer}
+	offer.Status		= "open"
+	offer.ProposedAt	= now.Format(time.RFC3339)
+	offer.ExpiresAt		= expiresAt.Format(time.RFC3339)
+
+	return offer, nil
+}
+
+// Fetches an offer the invoker can still answer, returns the invoker as well
+func (t *SimpleChaincode) get_offer_to_answer(stub shim.ChaincodeStubInterface, offerId string, now time.Time) (SplitOffer, string, error) {
+
+	offer, err := get_split_offer(stub, offerId)
+	if err != nil {
+		return offer, "", err
+	}
+	if offer.Status != "open" {
+		return offer, "", errors.New("Split offer " + offerId + " is already " + offer.Status)
+	}
+	if !is_offer_open(offer, now) {
+		return offer, "", errors.New("Split offer " + offerId + " expired at " + offer.ExpiresAt)
+	}
+
+	caller, err := t.get_caller_username(stub)
+	if err != nil {
+		return offer, "", err
+	}
+	if !is_party(offer, caller) {
+		return offer, "", errors.New("Only the parties to split offer " + offerId + " can answer it")
+	}
+
+	return offer, caller, nil
+}
+
+//==============================================================================================================================
+//  Invoke Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) propose_split(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0				1						2					3
+	//		trackId		beneficiaries JSON		expiry (RFC3339)	rights (optional, master or publishing)
+
+	if len(args) < 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting trackId, beneficiaries and expiry")
+	}
+	var rights string
+	if len(args) > 3 {
+		rights = args[3]
+	}
+
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := get_tx_time(stub, cfg)
+	if err != nil {
+		return nil, err
+	}
+	tr, err := fetch_track(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	offer, err := t.new_split_offer(stub, tr, args[0], rights, args[1], args[2], now)
+	if err != nil {
+		return nil, err
+	}
+
+	err = put_split_offer(stub, offer)
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(offer.Id), nil
+}
+
+func (t *SimpleChaincode) counter_split_offer(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0					1					2
+	//		offerId		beneficiaries JSON		expiry (RFC3339)
+
+	if len(args) < 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting offerId, beneficiaries and expiry")
+	}
+
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := get_tx_time(stub, cfg)
+	if err != nil {
+		return nil, err
+	}
+	offer, _, err := t.get_offer_to_answer(stub, args[0], now)
+	if err != nil {
+		return nil, err
+	}
+	tr, err := fetch_track(stub, offer.TrackId)
+	if err != nil {
+		return nil, err
+	}
+
+	counter, err := t.new_split_offer(stub, tr, offer.TrackId, offer.Rights, args[1], args[2], now)
+	if err != nil {
+		return nil, err
+	}
+	counter.CounterTo = offer.Id
+
+	offer.Status		= "countered"
+	offer.CounteredBy	= counter.Id
+	offer.DecidedAt		= now.Format(time.RFC3339)
+
+	err = put_split_offer(stub, offer)
+	if err != nil {
+		return nil, err
+	}
+	err = put_split_offer(stub, counter)
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(counter.Id), nil
+}
+
+func (t *SimpleChaincode) accept_split_offer(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		offerId
+
+	if len(args) < 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting offerId")
+	}
+
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := get_tx_time(stub, cfg)
+	if err != nil {
+		return nil, err
+	}
+	offer, caller, err := t.get_offer_to_answer(stub, args[0], now)
+	if err != nil {
+		return nil, err
+	}
+
+	accepted := false
+	for _, accountId := range offer.AcceptedBy {
+		accepted = accepted || accountId == caller
+	}
+	if !accepted {
+		offer.AcceptedBy = append(offer.AcceptedBy, caller)
+	}
+	if len(offer.AcceptedBy) < len(offer.Parties) {
+		return nil, put_split_offer(stub, offer)
+	}
+
+	// every party signed, the offer becomes the split of the track
+	tr, err := fetch_track(stub, offer.TrackId)
+	if err != nil {
+		return nil, err
+	}
+	updated := tr
+	if offer.Rights == "master" {
+		recording := *tr.Recording
+		recording.Beneficiaries = offer.Beneficiaries
+		updated.Recording = &recording
+	} else if offer.Rights == "publishing" {
+		composition := *tr.Composition
+		composition.Beneficiaries = offer.Beneficiaries
+		updated.Composition = &composition
+	} else {
+		updated.Beneficiaries = offer.Beneficiaries
+	}
+	err = validate_track_rights(updated)
+	if err != nil {
+		return nil, err
+	}
+	updated.DefaultSplit = false
+
+	err = put_track(stub, offer.TrackId, updated)
+	if err != nil {
+		return nil, err
+	}
+
+	offer.Status	= "accepted"
+	offer.DecidedAt	= now.Format(time.RFC3339)
+
+	return nil, put_split_offer(stub, offer)
+}
+
+func (t *SimpleChaincode) reject_split_offer(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		offerId
+
+	if len(args) < 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting offerId")
+	}
+
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := get_tx_time(stub, cfg)
+	if err != nil {
+		return nil, err
+	}
+	offer, _, err := t.get_offer_to_answer(stub, args[0], now)
+	if err != nil {
+		return nil, err
+	}
+
+	offer.Status	= "rejected"
+	offer.DecidedAt	= now.Format(time.RFC3339)
+
+	return nil, put_split_offer(stub, offer)
+}
+
+//==============================================================================================================================
+//		Query Functions
+//==============================================================================================================================
+
+// Returns the offers made for a track in the order they were made
+func (t *SimpleChaincode) get_split_negotiation(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			1
+	//		trackId
+
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting trackId")
+	}
+
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := get_tx_time(stub, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	offerIds, err := get_index_ids(stub, track_split_offers_index_str(args[1]))
+	if err != nil {
+		return nil, err
+	}
+
+	offers := []SplitOffer{}
+	for _, offerId := range offerIds {
+		offer, err := get_split_offer(stub, offerId)
+		if err != nil {
+			return nil, err
+		}
+		// expiry is not written back, an offer past it reads as expired
+		if offer.Status == "open" && !is_offer_open(offer, now) {
+			offer.Status = "expired"
+		}
+		offers = append(offers, offer)
+	}
+	sort.SliceStable(offers, func(i, j int) bool { return offers[i].ProposedAt < offers[j].ProposedAt })
+
+	offersBytes, _ := json.Marshal(offers)
+
+	return offersBytes, nil
+}
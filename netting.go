@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"sort"
+	"time"
+)
+
+//==============================================================================================================================
+//	 Payment Netting - Two accounts that owe each other, a streaming service and a big label for instance, offset their
+//					   open payments instead of settling every one of them. net_payments completes the open payments
+//					   between the two accounts without moving any balance, marking them netted into a netting, and
+//					   replaces them with a single open net payment from the account that owed more, one per currency
+//					   since amounts in different currencies are never added up. Either account, or an admin, can net.
+//==============================================================================================================================
+type Netting struct {
+	Id					string		`json:"id"`
+	AccountA			string		`json:"accountA"`
+	AccountB			string		`json:"accountB"`
+	Netted				int			`json:"netted"`				// open payments offset
+	Payments			[]Payment	`json:"payments"`			// the net payments replacing them, none when they cancelled out
+	NettedBy			string		`json:"nettedBy"`
+	NettedAt			string		`json:"nettedAt"`
+}
+
+var nettingIndexStr = "_nettings"
+
+//==============================================================================================================================
+//  Invoke Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) net_payments(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0				1
+	//		accountA		accountB
+
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting accountA and accountB")
+	}
+	if args[0] == args[1] {
+		return nil, errors.New("Invalid accounts, an account cannot net payments with itself")
+	}
+
+	caller, err := t.get_caller_username(stub)
+	if err != nil {
+		return nil, err
+	}
+	if caller != args[0] && caller != args[1] && t.check_caller_role(stub, adminRole) != nil {
+		return nil, errors.New("Only " + args[0] + ", " + args[1] + " or an admin can net their payments")
+	}
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := get_tx_time(stub, cfg)
+	if err != nil {
+		return nil, err
+	}
+	period, err := resolve_period(cfg, now)
+	if err != nil {
+		return nil, err
+	}
+
+	accountA, err := get_account(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	accountB, err := get_account(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	err = check_account_not_frozen(accountA)
+	if err != nil {
+		return nil, err
+	}
+	err = check_account_not_frozen(accountB)
+	if err != nil {
+		return nil, err
+	}
+
+	nettingId, err := append_id(stub, nettingIndexStr, "net", true)
+	if err != nil {
+		return nil, errors.New("Error creating new id for netting")
+	}
+	netting := Netting{Id: string(nettingId), AccountA: accountA.Id, AccountB: accountB.Id, Payments: []Payment{}, NettedBy: caller, NettedAt: now.Format(time.RFC3339)}
+
+	// what A owes B less what B owes A, by currency
+	owed := map[string]int64{}
+	var open []Payment
+	for _, payment := range accountA.PendingPayments {
+		if payment.Completed {
+			continue
+		}
+		// a disputed payment waits for the dispute to be resolved
+		if check_payment_not_disputed(stub, payment) != nil {
+			continue
+		}
+		if payment.SenderId == accountA.Id && payment.RecipientId == accountB.Id {
+			owed[payment_currency(cfg, payment)] += payment.Amount
+		} else if payment.SenderId == accountB.Id && payment.RecipientId == accountA.Id {
+			owed[payment_currency(cfg, payment)] -= payment.Amount
+		} else {
+			continue
+		}
+		open = append(open, payment)
+	}
+	if len(open) == 0 {
+		return nil, errors.New("No open payments between " + accountA.Id + " and " + accountB.Id + " to net")
+	}
+
+	for _, payment := range open {
+		copyA, _ := complete_payment(&accountA, payment.Reference, payment.SenderId, payment.RecipientId, payment.Rights, now)
+		copyA.NettedInto = netting.Id
+		copyB, found := complete_payment(&accountB, payment.Reference, payment.SenderId, payment.RecipientId, payment.Rights, now)
+		if found {
+			copyB.NettedInto = netting.Id
+		}
+		netting.Netted++
+	}
+
+	currencies := []string{}
+	for currency := range owed {
+		currencies = append(currencies, currency)
+	}
+	sort.Strings(currencies)
+
+	for _, currency := range currencies {
+		amount := owed[currency]
+		if amount == 0 {
+			continue
+		}
+		sender, recipient := &accountA, &accountB
+		if amount < 0 {
+			sender, recipient, amount = &accountB, &accountA, -amount
+		}
+		dueDate, err := payment_due_date(cfg, *sender, now)
+		if err != nil {
+			return nil, err
+		}
+
+		var net Payment
+		net.SenderId	= sender.Id
+		net.RecipientId	= recipient.Id
+		net.Amount		= amount
+		net.Currency	= currency
+		net.CreatedAt	= now.Format(time.RFC3339)
+		net.DueDate		= dueDate
+		net.Period		= period.Id
+		net.Reference	= netting.Id
+
+		sender.PendingPayments = append(sender.PendingPayments, net)
+		recipient.PendingPayments = append(recipient.PendingPayments, net)
+		netting.Payments = append(netting.Payments, net)
+	}
+
+	err = put_account(stub, accountA)
+	if err != nil {
+		return nil, err
+	}
+	err = put_account(stub, accountB)
+	if err != nil {
+		return nil, err
+	}
+
+	nettingBytes, _ := json.Marshal(netting)
+	err = put_state(stub, netting.Id, nettingBytes)
+	if err != nil {
+		return nil, errors.New("Error putting netting " + netting.Id + " on ledger")
+	}
+	err = emit_payments_created(stub, netting.Payments)
+	if err != nil {
+		return nil, err
+	}
+
+	return nettingBytes, nil
+}
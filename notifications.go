@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"strings"
+	"time"
+)
+
+//==============================================================================================================================
+//	 Notification Preferences - The off-chain relay listening to chaincode events delivers them to account holders'
+//								webhooks. Each account stores its delivery preferences on the ledger so the relay reads
+//								them from the same source of truth: the event types it wants, empty for all of them, the
+//								SHA-256 of its endpoint identifier, the endpoint itself stays off-chain with the relay, and
+//								whether delivery is enabled. test_notification emits a TestNotification event the relay
+//								delivers to the endpoint regardless of the event types, to verify it.
+//==============================================================================================================================
+type NotificationPreferences struct {
+	AccountId			string		`json:"accountId"`
+	EventTypes			[]string	`json:"eventTypes"`			// event names to deliver, empty for every event
+	EndpointHash		string		`json:"endpointHash"`		// SHA-256 of the endpoint identifier, hex encoded
+	Enabled				bool		`json:"enabled"`
+	UpdatedAt			string		`json:"updatedAt"`
+}
+
+type NotificationPreferencesPage struct {
+	Preferences			[]NotificationPreferences	`json:"preferences"`
+	Bookmark			string						`json:"bookmark"`		// pass back to get the next page, empty on the last page
+}
+
+type TestNotification struct {
+	AccountId			string		`json:"accountId"`
+	EndpointHash		string		`json:"endpointHash"`
+	Nonce				string		`json:"nonce"`				// the tx id, for the endpoint to echo back
+}
+
+// Events the relay can deliver
+var NotificationEventTypes = map[string]bool{
+	"AccountCreated":	true,
+	"TrackAdded":		true,
+	"TrackReleased":	true,
+	"PlayRegistered":	true,
+	"PaymentCreated":	true,
+	"PaymentSettled":	true,
+	"DunningEscalated":	true,
+	"ContentAttested":	true,
+	"PeriodClosed":		true,
+}
+
+var notificationPreferencesIndexStr = "notification_preferences"
+
+func notification_preferences_key(accountId string) string {
+	return "_notification_preferences_" + accountId
+}
+
+func get_notification_preferences(stub shim.ChaincodeStubInterface, accountId string) (NotificationPreferences, bool, error) {
+
+	var prefs NotificationPreferences
+
+	bytes, err := get_state(stub, notification_preferences_key(accountId))
+	if err != nil {
+		return prefs, false, errors.New("Failed to get notification preferences of " + accountId)
+	}
+	if len(bytes) == 0 {
+		return prefs, false, nil
+	}
+	err = json.Unmarshal(bytes, &prefs)
+	if err != nil {
+		return prefs, false, errors.New("Could not unmarshal notification preferences of " + accountId)
+	}
+
+	return prefs, true, nil
+}
+
+//==============================================================================================================================
+//  Invoke Functions
+//==============================================================================================================================
+
+// Sets the notification preferences of the invoker's account
+func (t *SimpleChaincode) set_notification_preferences(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		preferences JSON object (as string, eventTypes, endpointHash and enabled)
+
+	if len(args) < 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting preferences JSON")
+	}
+
+	var prefs NotificationPreferences
+	err := json.Unmarshal([]byte(args[0]), &prefs)
+	if err != nil {
+		return nil, errors.New("Could not unmarshal preferences: " + err.Error())
+	}
+	for _, eventType := range prefs.EventTypes {
+		if !NotificationEventTypes[eventType] {
+			return nil, errors.New("Event type not recognized: " + eventType)
+		}
+	}
+	prefs.EndpointHash = strings.ToLower(strings.TrimSpace(prefs.EndpointHash))
+	if !contentHashPattern.MatchString(prefs.EndpointHash) {
+		return nil, errors.New("Invalid endpointHash, expecting the hex encoded SHA-256 of the endpoint identifier")
+	}
+
+	accountId, err := t.get_caller_username(stub)
+	if err != nil {
+		return nil, err
+	}
+	_, err = get_account(stub, accountId)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := get_tx_time(stub, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	prefs.AccountId	= accountId
+	prefs.UpdatedAt	= now.Format(time.RFC3339)
+	if prefs.EventTypes == nil {
+		prefs.EventTypes = []string{}
+	}
+
+	prefsBytes, _ := json.Marshal(prefs)
+	err = put_state(stub, notification_preferences_key(accountId), prefsBytes)
+	if err != nil {
+		return nil, errors.New("Error putting notification preferences of " + accountId + " on ledger")
+	}
+	err = add_to_index(stub, notificationPreferencesIndexStr, accountId)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// Emits a synthetic event for the relay to deliver to the invoker's endpoint
+func (t *SimpleChaincode) test_notification(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	accountId, err := t.get_caller_username(stub)
+	if err != nil {
+		return nil, err
+	}
+	prefs, found, err := get_notification_preferences(stub, accountId)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, errors.New("Account " + accountId + " has no notification endpoint to test")
+	}
+
+	notification := TestNotification{AccountId: accountId, EndpointHash: prefs.EndpointHash, Nonce: stub.GetTxID()}
+
+	return []byte(notification.Nonce), emit_event(stub, "TestNotification", notification)
+}
+
+//==============================================================================================================================
+//		Query Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) query_notification_preferences(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			1
+	//		accountId
+
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting accountId")
+	}
+
+	prefs, found, err := get_notification_preferences(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, errors.New("Account " + args[1] + " has no notification preferences")
+	}
+
+	prefsBytes, _ := json.Marshal(prefs)
+
+	return prefsBytes, nil
+}
+
+// Pages through the preferences of every account, for the relay to load them
+func (t *SimpleChaincode) get_all_notification_preferences(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			1				2
+	//		bookmark		page size (optional)
+
+	var bookmark string
+	if len(args) > 1 {
+		bookmark = args[1]
+	}
+	pageSize, err := page_size_arg(args, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	keysIter, err := index_iterator_after(stub, notificationPreferencesIndexStr, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer keysIter.Close()
+
+	var page NotificationPreferencesPage
+	page.Preferences = []NotificationPreferences{}
+	prefix := index_key(notificationPreferencesIndexStr, "")
+	more := false
+
+	for keysIter.HasNext() {
+		key, _, err := keysIter.Next()
+		if err != nil {
+			return nil, errors.New("Failed to iterate " + notificationPreferencesIndexStr + " index")
+		}
+		if len(page.Preferences) == pageSize {
+			more = true
+			break
+		}
+		accountId := key[len(prefix):]
+		page.Bookmark = accountId
+
+		prefs, _, err := get_notification_preferences(stub, accountId)
+		if err != nil {
+			return nil, err
+		}
+		page.Preferences = append(page.Preferences, prefs)
+	}
+	if !more {
+		page.Bookmark = ""
+	}
+
+	pageBytes, _ := json.Marshal(page)
+
+	return pageBytes, nil
+}
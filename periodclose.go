@@ -0,0 +1,364 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"strconv"
+	"time"
+)
+
+//==============================================================================================================================
+//	 Period Close - An admin closes a settlement period that has ended with one invoke, close_period, instead of a
+//					sequence of separate calls. The close runs its steps in order:
+//						freeze		the period is marked closed, late plays no longer accrue to it but fall in the
+//									period they are submitted in
+//						payouts		every open payment of the period is settled, promotion funding included,
+//									payments that cannot be (frozen or held accounts, unlive content) are skipped
+//									and reported
+//						statements	the statement of every account is stored, get_statement serves it from then on
+//						events		PeriodClosed is emitted with the status of every step
+//					A close touches every account, so each invoke works through a batch of accounts and records where
+//					it stopped. The admin calls close_period again until the close is done, a call on a finished close
+//					just returns it.
+//==============================================================================================================================
+type PeriodCloseStep struct {
+	Name				string		`json:"name"`
+	Status				string		`json:"status"`				// pending, running or done
+	Processed			int			`json:"processed"`			// accounts processed, payments settled for the payouts step
+	Skipped				int			`json:"skipped"`			// payments the payouts step could not settle
+	Failures			[]string	`json:"failures,omitempty"`	// why payments were skipped, the first maxPeriodCloseFailures
+	Cursor				string		`json:"cursor,omitempty"`	// last account the step processed
+	CompletedAt			string		`json:"completedAt,omitempty"`
+}
+
+type PeriodClose struct {
+	Period				string				`json:"period"`
+	Status				string				`json:"status"`			// running or done
+	Steps				[]PeriodCloseStep	`json:"steps"`
+	StartedBy			string				`json:"startedBy"`
+	StartedAt			string				`json:"startedAt"`
+	UpdatedAt			string				`json:"updatedAt"`
+	CompletedAt			string				`json:"completedAt,omitempty"`
+}
+
+var PeriodCloseSteps = []string{"freeze", "payouts", "statements", "events"}
+
+var defaultPeriodCloseBatch = 100
+var maxPeriodCloseFailures = 50
+var maxPeriodsBack = 60
+var periodStatementIndexStr = "period_statements"
+
+func period_close_key(periodId string) string {
+	return "_period_close_" + periodId
+}
+
+// Statements stored at close are kept as "period_statements~<periodId>~<accountId>"
+func period_statement_key(periodId string, accountId string) string {
+	return index_key(index_key(periodStatementIndexStr, periodId), accountId)
+}
+
+func get_period_close(stub shim.ChaincodeStubInterface, periodId string) (PeriodClose, bool, error) {
+
+	var periodClose PeriodClose
+
+	bytes, err := get_state(stub, period_close_key(periodId))
+	if err != nil {
+		return periodClose, false, errors.New("Failed to get close of period " + periodId)
+	}
+	if len(bytes) == 0 {
+		return periodClose, false, nil
+	}
+	err = json.Unmarshal(bytes, &periodClose)
+	if err != nil {
+		return periodClose, false, errors.New("Could not unmarshal close of period " + periodId)
+	}
+
+	return periodClose, true, nil
+}
+
+// True once the freeze step of the period's close has run
+func is_period_closed(stub shim.ChaincodeStubInterface, periodId string) (bool, error) {
+
+	periodClose, found, err := get_period_close(stub, periodId)
+	if err != nil || !found {
+		return false, err
+	}
+
+	return periodClose.Steps[0].Status == "done", nil
+}
+
+// Looks a period that has ended up by its id, going back from the current period
+func find_ended_period(cfg Config, periodId string, now time.Time) (Period, error) {
+
+	period, err := resolve_period(cfg, now)
+	if err != nil {
+		return period, err
+	}
+	if period.Id == periodId {
+		return period, errors.New("Period " + periodId + " has not ended yet")
+	}
+	for i := 0; i < maxPeriodsBack; i++ {
+		period, err = resolve_previous_period(cfg, period)
+		if err != nil {
+			break
+		}
+		if period.Id == periodId {
+			return period, nil
+		}
+	}
+
+	return period, errors.New("Invalid period " + periodId + ", expecting one of the last " + strconv.Itoa(maxPeriodsBack) + " periods that ended")
+}
+
+// Runs a step over the next accounts after its cursor, returns the number of accounts it got through
+func run_period_close_accounts(stub shim.ChaincodeStubInterface, step *PeriodCloseStep, batch int, process func(accountId string) error) (int, error) {
+
+	keysIter, err := index_iterator_after(stub, accountIndexStr, step.Cursor)
+	if err != nil {
+		return 0, err
+	}
+	defer keysIter.Close()
+
+	prefix := index_key(accountIndexStr, "")
+	var accountIds []string
+	for keysIter.HasNext() && len(accountIds) <= batch {
+		key, _, err := keysIter.Next()
+		if err != nil {
+			return 0, errors.New("Failed to iterate " + accountIndexStr + " index")
+		}
+		accountIds = append(accountIds, key[len(prefix):])
+	}
+
+	// one account more than the batch was read to learn whether the step is done
+	done := len(accountIds) <= batch
+	if !done {
+		accountIds = accountIds[:batch]
+	}
+	for _, accountId := range accountIds {
+		err = process(accountId)
+		if err != nil {
+			return 0, err
+		}
+		step.Cursor = accountId
+	}
+	if done {
+		step.Status = "done"
+	}
+
+	return len(accountIds), nil
+}
+
+// Settles the open payments of the period the account owes. An account credited by an earlier sender of the batch is
+// read with that credit, get_state answers with the writes of the transaction.
+func close_account_payouts(stub shim.ChaincodeStubInterface, cfg Config, step *PeriodCloseStep, periodId string, accountId string, now time.Time) error {
+
+	sender, err := get_account(stub, accountId)
+	if err != nil {
+		return err
+	}
+
+	var open []Payment
+	for _, payment := range sender.PendingPayments {
+		if !payment.Completed && payment.Period == periodId && payment.SenderId == sender.Id {
+			open = append(open, payment)
+		}
+	}
+	for _, payment := range open {
+		_, err = settle_open_payment(stub, cfg, &sender, payment.Reference, payment.RecipientId, payment.Rights, now, true)
+		if err != nil {
+			step.Skipped++
+			if len(step.Failures) < maxPeriodCloseFailures {
+				step.Failures = append(step.Failures, payment.Reference+" to "+payment.RecipientId+": "+err.Error())
+			}
+			continue
+		}
+		step.Processed++
+	}
+
+	return nil
+}
+
+// Stores the statement of the period of an account that took part in payments during it
+func close_account_statement(stub shim.ChaincodeStubInterface, periodId string, accountId string) (bool, error) {
+
+	statement, err := build_statement(stub, accountId, periodId)
+	if err != nil {
+		return false, err
+	}
+	if len(statement.Lines) == 0 {
+		return false, nil
+	}
+
+	statementBytes, _ := json.Marshal(statement)
+	err = put_state(stub, period_statement_key(periodId, accountId), statementBytes)
+	if err != nil {
+		return false, errors.New("Error putting statement of " + accountId + " for period " + periodId + " on ledger")
+	}
+
+	return true, nil
+}
+
+// Returns the statement stored when the period was closed, if it was
+func get_closed_statement(stub shim.ChaincodeStubInterface, accountId string, periodId string) (Statement, bool, error) {
+
+	var statement Statement
+
+	bytes, err := get_state(stub, period_statement_key(periodId, accountId))
+	if err != nil {
+		return statement, false, errors.New("Failed to get statement of " + accountId + " for period " + periodId)
+	}
+	if len(bytes) == 0 {
+		return statement, false, nil
+	}
+	err = json.Unmarshal(bytes, &statement)
+	if err != nil {
+		return statement, false, errors.New("Could not unmarshal statement of " + accountId + " for period " + periodId)
+	}
+
+	return statement, true, nil
+}
+
+//==============================================================================================================================
+//  Invoke Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) close_period(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0				1
+	//		periodId		batch size (optional, accounts per call)
+
+	if len(args) < 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting periodId")
+	}
+	batch := defaultPeriodCloseBatch
+	if len(args) > 1 && args[1] != "" {
+		size, err := strconv.Atoi(args[1])
+		if err != nil || size < 1 {
+			return nil, errors.New("Invalid batch size " + args[1])
+		}
+		batch = size
+	}
+
+	err := t.check_caller_role(stub, adminRole)
+	if err != nil {
+		return nil, err
+	}
+	caller, err := t.get_caller_username(stub)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := get_tx_time(stub, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	periodClose, found, err := get_period_close(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if found && periodClose.Status == "done" {
+		periodCloseBytes, _ := json.Marshal(periodClose)
+		return periodCloseBytes, nil
+	}
+	if !found {
+		_, err = find_ended_period(cfg, args[0], now)
+		if err != nil {
+			return nil, err
+		}
+		periodClose = PeriodClose{Period: args[0], Status: "running", StartedBy: caller, StartedAt: now.Format(time.RFC3339)}
+		for _, name := range PeriodCloseSteps {
+			periodClose.Steps = append(periodClose.Steps, PeriodCloseStep{Name: name, Status: "pending"})
+		}
+	}
+
+	for i := range periodClose.Steps {
+		if batch == 0 {
+			break
+		}
+		step := &periodClose.Steps[i]
+		if step.Status == "done" {
+			continue
+		}
+		step.Status = "running"
+
+		var used int
+		switch step.Name {
+		case "freeze":
+			step.Status, used = "done", 1
+
+		case "payouts":
+			used, err = run_period_close_accounts(stub, step, batch, func(accountId string) error {
+				return close_account_payouts(stub, cfg, step, periodClose.Period, accountId, now)
+			})
+
+		case "statements":
+			used, err = run_period_close_accounts(stub, step, batch, func(accountId string) error {
+				stored, err := close_account_statement(stub, periodClose.Period, accountId)
+				if stored {
+					step.Processed++
+				}
+				return err
+			})
+
+		case "events":
+			step.Status, used = "done", 1
+			periodClose.Status		= "done"
+			periodClose.CompletedAt	= now.Format(time.RFC3339)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if step.Status == "done" {
+			step.CompletedAt = now.Format(time.RFC3339)
+		}
+		batch -= used
+		if batch < 0 {
+			batch = 0
+		}
+	}
+	periodClose.UpdatedAt = now.Format(time.RFC3339)
+
+	periodCloseBytes, _ := json.Marshal(periodClose)
+	err = put_state(stub, period_close_key(periodClose.Period), periodCloseBytes)
+	if err != nil {
+		return nil, errors.New("Error putting close of period " + periodClose.Period + " on ledger")
+	}
+	if periodClose.Status == "done" {
+		return periodCloseBytes, emit_event(stub, "PeriodClosed", periodClose)
+	}
+
+	return periodCloseBytes, nil
+}
+
+//==============================================================================================================================
+//		Query Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) query_period_close(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			1
+	//		periodId
+
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting periodId")
+	}
+
+	periodClose, found, err := get_period_close(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, errors.New("Period " + args[1] + " has not been closed")
+	}
+
+	periodCloseBytes, _ := json.Marshal(periodClose)
+
+	return periodCloseBytes, nil
+}
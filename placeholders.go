@@ -0,0 +1,248 @@
+package main
+
+import (
+	"errors"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"regexp"
+)
+
+//==============================================================================================================================
+//	 Placeholder Beneficiaries - A split sheet can name someone who is not on the platform yet by an identifier instead
+//								 of an account: "email:<address>" or "ipi:<number>". Their royalties accrue to a holding
+//								 account for the placeholder. Once the person registers and proves the identifier they
+//								 claim it: the holding account's payments and balance move to their account and the
+//								 splits naming the placeholder are rewired to it. The identifier is proven by the platform
+//								 verifying it for the account, or for an IPI by the account's alias in the "ipi" namespace.
+//==============================================================================================================================
+var placeholderPattern = regexp.MustCompile(`^(email:[^@\s]+@[^@\s]+|ipi:[0-9]{9,11})$`)
+
+var placeholderTracksIndexStr = "placeholder_tracks"
+
+func holding_account_id(placeholder string) string {
+	return "_holding_" + placeholder
+}
+
+// Per-placeholder index of the tracks naming it, "placeholder_tracks~<placeholder>~<trackId>"
+func placeholder_tracks_index_str(placeholder string) string {
+	return index_key(placeholderTracksIndexStr, placeholder)
+}
+
+func placeholder_verified_key(placeholder string) string {
+	return "_placeholder_verified_" + placeholder
+}
+
+// Points the placeholder beneficiaries of a split at the holding accounts of their placeholders
+func resolve_placeholders(stub shim.ChaincodeStubInterface, trackId string, beneficiaries []Beneficiary) error {
+
+	for i, b := range beneficiaries {
+		if b.Placeholder == "" {
+			continue
+		}
+		if !placeholderPattern.MatchString(b.Placeholder) {
+			return errors.New("Invalid placeholder " + b.Placeholder + ", expecting email:<address> or ipi:<number>")
+		}
+		beneficiaries[i].AccountId = holding_account_id(b.Placeholder)
+
+		bytes, err := get_state(stub, beneficiaries[i].AccountId)
+		if err != nil {
+			return errors.New("Could not fetch " + beneficiaries[i].AccountId)
+		}
+		if len(bytes) == 0 {
+			holding := Account{Id: beneficiaries[i].AccountId, Name: b.Placeholder, Type: "holding"}
+			err = put_account(stub, holding)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = add_to_index(stub, placeholder_tracks_index_str(b.Placeholder), trackId)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Resolves the placeholders in every split of a track
+func resolve_track_placeholders(stub shim.ChaincodeStubInterface, trackId string, tr Track) error {
+
+	err := resolve_placeholders(stub, trackId, tr.Beneficiaries)
+	if err != nil {
+		return err
+	}
+	if tr.Recording != nil {
+		err = resolve_placeholders(stub, trackId, tr.Recording.Beneficiaries)
+		if err != nil {
+			return err
+		}
+	}
+	if tr.Composition != nil {
+		err = resolve_placeholders(stub, trackId, tr.Composition.Beneficiaries)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func rewire_placeholder(beneficiaries []Beneficiary, placeholder string, accountId string) {
+	for i, b := range beneficiaries {
+		if b.Placeholder == placeholder {
+			beneficiaries[i].AccountId		= accountId
+			beneficiaries[i].Placeholder	= ""
+		}
+	}
+}
+
+// Verifies the invoker proved the placeholder identifier
+func (t *SimpleChaincode) check_placeholder_proof(stub shim.ChaincodeStubInterface, placeholder string, accountId string) error {
+
+	verified, err := get_state(stub, placeholder_verified_key(placeholder))
+	if err != nil {
+		return errors.New("Failed to get verification of " + placeholder)
+	}
+	if string(verified) == accountId {
+		return nil
+	}
+
+	if len(placeholder) > 4 && placeholder[:4] == "ipi:" {
+		alias, found, err := get_alias(stub, ipiAliasNamespace, placeholder[4:])
+		if err != nil {
+			return err
+		}
+		if found && alias.Id == accountId {
+			return nil
+		}
+	}
+
+	return errors.New("Only an account that proved " + placeholder + " can claim it")
+}
+
+//==============================================================================================================================
+//  Invoke Functions
+//==============================================================================================================================
+
+// The platform confirms an account proved a placeholder identifier, e.g. by a confirmation email
+func (t *SimpleChaincode) verify_placeholder(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0				1
+	//		placeholder		accountId
+
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting placeholder and accountId")
+	}
+
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	err = t.check_platform_access(stub, cfg, "verify placeholders")
+	if err != nil {
+		return nil, err
+	}
+	_, err = get_account(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	err = put_state(stub, placeholder_verified_key(args[0]), []byte(args[1]))
+	if err != nil {
+		return nil, errors.New("Error putting verification of " + args[0] + " on ledger")
+	}
+
+	return nil, nil
+}
+
+func (t *SimpleChaincode) claim_placeholder(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		placeholder
+
+	if len(args) < 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting placeholder")
+	}
+	placeholder := args[0]
+
+	accountId, err := t.get_caller_username(stub)
+	if err != nil {
+		return nil, err
+	}
+	err = t.check_placeholder_proof(stub, placeholder, accountId)
+	if err != nil {
+		return nil, err
+	}
+
+	holding, err := get_account(stub, holding_account_id(placeholder))
+	if err != nil {
+		return nil, err
+	}
+	if holding.ClaimedBy != "" {
+		return nil, errors.New("Placeholder " + placeholder + " was already claimed by " + holding.ClaimedBy)
+	}
+	account, err := get_account(stub, accountId)
+	if err != nil {
+		return nil, err
+	}
+	err = check_account_not_frozen(account)
+	if err != nil {
+		return nil, err
+	}
+
+	// the accrued royalties move to the claimant, payers' copies keep the holding account which forwards to it
+	for _, payment := range holding.PendingPayments {
+		if payment.RecipientId == holding.Id {
+			payment.RecipientId = account.Id
+		}
+		account.PendingPayments = append(account.PendingPayments, payment)
+	}
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	move_balances(cfg, &holding, &account)
+	holding.PendingPayments	= nil
+	holding.ClaimedBy	= account.Id
+
+	err = put_account(stub, account)
+	if err != nil {
+		return nil, err
+	}
+	err = put_account(stub, holding)
+	if err != nil {
+		return nil, err
+	}
+
+	// rewire the splits naming the placeholder
+	trackIds, err := get_index_ids(stub, placeholder_tracks_index_str(placeholder))
+	if err != nil {
+		return nil, err
+	}
+	for _, trackId := range trackIds {
+		tr, err := fetch_track(stub, trackId)
+		if err != nil {
+			return nil, err
+		}
+		rewire_placeholder(tr.Beneficiaries, placeholder, account.Id)
+		if tr.Recording != nil {
+			rewire_placeholder(tr.Recording.Beneficiaries, placeholder, account.Id)
+		}
+		if tr.Composition != nil {
+			rewire_placeholder(tr.Composition.Beneficiaries, placeholder, account.Id)
+		}
+
+		err = put_track(stub, trackId, tr)
+		if err != nil {
+			return nil, err
+		}
+		err = remove_from_index(stub, placeholder_tracks_index_str(placeholder), trackId)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"strconv"
+)
+
+//==============================================================================================================================
+//	 Playlists - An ordered list of tracks put together by a curator. A track played from a playlist pays its
+//				 beneficiaries as usual, minus the curator's cut which goes to the curator account.
+//==============================================================================================================================
+type Playlist struct {
+	Id					string		`json:"id"`
+	Title				string		`json:"title"`
+	Curator				string		`json:"curator"`			// account id of the curator
+	TrackIds			[]string	`json:"trackIds"`
+	CuratorShareBps		int64		`json:"curatorShareBps"`
+}
+
+var playlistIndexStr = "playlist"
+
+func get_playlist(stub shim.ChaincodeStubInterface, playlistId string) (Playlist, error) {
+
+	var playlist Playlist
+
+	bytes, err := get_state(stub, playlistId)
+	if err != nil || len(bytes) == 0 {
+		return playlist, errors.New("Could not fetch playlist " + playlistId)
+	}
+	err = json.Unmarshal(bytes, &playlist)
+	if err != nil {
+		return playlist, errors.New("Could not unmarshal playlist " + playlistId)
+	}
+
+	return playlist, nil
+}
+
+// The curator's cut of an amount, never more than the configured maximum
+func curator_cut(cfg Config, playlist Playlist, amount int64) int64 {
+
+	bps := playlist.CuratorShareBps
+	if bps > cfg.MaxCuratorShareBps {
+		bps = cfg.MaxCuratorShareBps
+	}
+
+	return amount * bps / 10000
+}
+
+//==============================================================================================================================
+//  Invoke Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) add_playlist(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		playlist JSON object (as string)
+
+	if len(args) < 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting playlist JSON")
+	}
+
+	var playlist Playlist
+	err := json.Unmarshal([]byte(args[0]), &playlist)
+	if err != nil {
+		return nil, errors.New("Could not unmarshal playlist: " + err.Error())
+	}
+	if playlist.Id == "" {
+		return nil, errors.New("id is required")
+	}
+	err = check_record_id("playlist", playlist.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	if playlist.CuratorShareBps < 0 || playlist.CuratorShareBps > cfg.MaxCuratorShareBps {
+		return nil, errors.New("curatorShareBps must be between 0 and the configured maximum of " + strconv.FormatInt(cfg.MaxCuratorShareBps, 10))
+	}
+
+	// The curator is whoever creates the playlist
+	curator, err := t.get_caller_username(stub)
+	if err == nil {
+		playlist.Curator = curator
+	}
+	curatorBytes, err := get_state(stub, playlist.Curator)
+	if err != nil || len(curatorBytes) == 0 {
+		return nil, errors.New("Could not fetch curator account " + playlist.Curator)
+	}
+
+	existing, err := get_state(stub, playlist.Id)
+	if err != nil {
+		return nil, errors.New("Could not fetch " + playlist.Id)
+	}
+	if len(existing) > 0 {
+		return nil, errors.New("Playlist " + playlist.Id + " already exists")
+	}
+
+	for _, trackId := range playlist.TrackIds {
+		bytes, err := get_state(stub, trackId)
+		if err != nil || len(bytes) == 0 {
+			return nil, errors.New("Could not fetch track " + trackId)
+		}
+	}
+
+	err = add_to_index(stub, playlistIndexStr, playlist.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	playlistBytes, _ := json.Marshal(playlist)
+	err = put_state(stub, playlist.Id, playlistBytes)
+	if err != nil {
+		return nil, errors.New("Error putting playlist on ledger")
+	}
+
+	return nil, nil
+}
+
+func (t *SimpleChaincode) register_playlist_play(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0				1			2			3								4						5						6			7
+	//		playlistId		trackId		played_by	played_at (RFC3339, optional)	territory (optional)	usageType (optional)	source		promotionId (optional)
+
+	if len(args) < 7 {
+		return nil, errors.New("Incorrect number of arguments. Expecting playlistId, trackId, played_by, played_at, territory, usageType and source")
+	}
+	err := t.check_play_submitter(stub, args[2], args[6])
+	if err != nil {
+		return nil, err
+	}
+
+	playlist, err := get_playlist(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	listed := false
+	for _, trackId := range playlist.TrackIds {
+		if trackId == args[1] {
+			listed = true
+			break
+		}
+	}
+	if !listed {
+		return nil, errors.New("Track " + args[1] + " is not on playlist " + playlist.Id)
+	}
+
+	return t.record_track_play(stub, args[1:], &playlist)
+}
+
+//==============================================================================================================================
+//		Query Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) query_playlist(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			1
+	//		playlistId
+
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting playlistId")
+	}
+
+	playlist, err := get_playlist(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	playlistBytes, _ := json.Marshal(playlist)
+
+	return playlistBytes, nil
+}
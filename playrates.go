@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"strconv"
+	"time"
+)
+
+//==============================================================================================================================
+//	 Play Rate Limits - Stream farms play the same tracks over and over from a few accounts. The configuration caps how
+//						often an account plays within an hour: maxPlaysPerTrackPerHour plays of one track and
+//						maxPlaysPerHour plays overall, 0 is no limit. Plays are counted by the hour they happened in,
+//						in counters spread over metricShards keys like the metrics. A play over a limit is rejected,
+//						or with playRateAction "flag" recorded as flagged without charging anything, so the pattern
+//						stays on the ledger for investigation without earning the farm royalties. Flagged plays do
+//						not count towards the charts, royalty pools or subscription pools.
+//==============================================================================================================================
+var playRateIndexStr = "play_rate"
+var playRateAllTracks = "*"
+var playRateReject = "reject"
+var playRateFlag = "flag"
+
+// Counter keys are "play_rate~<accountId>~<trackId>~<hour>~<shard>", the track is * for the plays of all tracks
+func play_rate_counter_str(accountId string, trackId string, playedAt time.Time) string {
+	return index_key(index_key(index_key(playRateIndexStr, accountId), trackId), playedAt.UTC().Format("2006010215"))
+}
+
+// The plays counted in a counter, over all its shards
+func read_play_rate(stub shim.ChaincodeStubInterface, counter string) (int64, error) {
+
+	prefix := index_key(counter, "")
+	keysIter, err := range_query_state(stub, prefix, prefix+"\xff")
+	if err != nil {
+		return 0, errors.New("Failed to range query play rate " + counter)
+	}
+	defer keysIter.Close()
+
+	var count int64
+	for keysIter.HasNext() {
+		_, value, err := keysIter.Next()
+		if err != nil {
+			return 0, errors.New("Failed to iterate play rate " + counter)
+		}
+		shard, _ := strconv.ParseInt(string(value), 10, 64)
+		count += shard
+	}
+
+	return count, nil
+}
+
+// Counts a play of the track by the account and checks it against the limits. Returns the limit the play exceeds
+// when it is to be flagged, an error when it is to be rejected.
+func check_play_rate(stub shim.ChaincodeStubInterface, cfg Config, accountId string, trackId string, playedAt time.Time) (string, error) {
+
+	limits := map[string]int{trackId: cfg.MaxPlaysPerTrackPerHour, playRateAllTracks: cfg.MaxPlaysPerHour}
+	names := map[string]string{trackId: "maxPlaysPerTrackPerHour", playRateAllTracks: "maxPlaysPerHour"}
+
+	var exceeded string
+	shard := strconv.Itoa(metric_shard(stub))
+	for _, counted := range []string{trackId, playRateAllTracks} {
+		if limits[counted] <= 0 {
+			continue
+		}
+		counter := play_rate_counter_str(accountId, counted, playedAt)
+		plays, err := read_play_rate(stub, counter)
+		if err != nil {
+			return "", err
+		}
+		if plays >= int64(limits[counted]) && exceeded == "" {
+			exceeded = names[counted]
+		}
+		err = increment_counter(stub, index_key(counter, shard))
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if exceeded != "" && cfg.PlayRateAction != playRateFlag {
+		return "", errors.New("Account " + accountId + " exceeded " + exceeded + " playing track " + trackId)
+	}
+
+	return exceeded, nil
+}
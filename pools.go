@@ -0,0 +1,422 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"strconv"
+	"time"
+)
+
+//==============================================================================================================================
+//	 Royalty Pools - Revenue that is not priced per play, ad revenue or a flat deal with a partner, is paid into a pool
+//					 for a settlement period. fund_pool moves the amount from the funder's balance to the holding
+//					 account of the pool, in the platform currency. Once the period has ended an admin closes the
+//					 pool: close_pool divides what it holds over the tracks by their plays in the period, splits each
+//					 track's share like the price of a play, platform fee first, and pays it from the holding account
+//					 to the beneficiaries as settled payments. A payment that cannot be settled, to a frozen account
+//					 or one whose payouts are held, is left open on the holding account for a later settle_account.
+//					 A pool closed over a period without plays is paid back to its funders.
+//==============================================================================================================================
+type PoolFunding struct {
+	AccountId			string		`json:"accountId"`
+	Amount				int64		`json:"amount"`
+	FundedAt			string		`json:"fundedAt"`
+}
+
+type RoyaltyPool struct {
+	Id					string			`json:"id"`
+	Period				string			`json:"period"`
+	HoldingAccount		string			`json:"holdingAccount"`			// holds the funds until the pool is closed
+	Currency			string			`json:"currency"`				// ISO 4217 code, the platform currency
+	Funded				int64			`json:"funded"`
+	Funders				[]PoolFunding	`json:"funders"`
+	Status				string			`json:"status"`					// open or closed
+	Plays				int				`json:"plays"`					// plays of the period the pool was divided over
+	Distributed			int64			`json:"distributed"`			// paid out when closing
+	Unsettled			int				`json:"unsettled"`				// payments left open on the holding account
+	CreatedAt			string			`json:"createdAt"`
+	ClosedBy			string			`json:"closedBy,omitempty"`
+	ClosedAt			string			`json:"closedAt,omitempty"`
+}
+
+var royaltyPoolIndexStr = "_royalty_pools"
+var periodPoolsIndexStr = "period_pools"
+
+// Per-period index of the pools of the period, "period_pools~<periodId>~<poolId>"
+func period_pools_index_str(periodId string) string {
+	return index_key(periodPoolsIndexStr, periodId)
+}
+
+func get_royalty_pool(stub shim.ChaincodeStubInterface, poolId string) (RoyaltyPool, error) {
+
+	var pool RoyaltyPool
+
+	bytes, err := get_state(stub, poolId)
+	if err != nil || len(bytes) == 0 {
+		return pool, errors.New("Could not fetch royalty pool " + poolId)
+	}
+	err = json.Unmarshal(bytes, &pool)
+	if err != nil {
+		return pool, errors.New("Could not unmarshal royalty pool " + poolId)
+	}
+
+	return pool, nil
+}
+
+func put_royalty_pool(stub shim.ChaincodeStubInterface, pool RoyaltyPool) error {
+
+	poolBytes, _ := json.Marshal(pool)
+	err := put_state(stub, pool.Id, poolBytes)
+	if err != nil {
+		return errors.New("Error putting royalty pool " + pool.Id + " on ledger")
+	}
+
+	return nil
+}
+
+// Pool payouts of the period's plays: each track's share split like the price of a play, in track order. Returns
+// the tracks with their shares next to the payouts.
+func pool_payouts(stub shim.ChaincodeStubInterface, cfg Config, pool *RoyaltyPool) ([]Payment, []string, map[string]int64, error) {
+
+	playIds, err := get_index_ids(stub, period_plays_index_str(pool.Period))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	counts := map[string]int64{}
+	for _, playId := range playIds {
+		bytes, err := get_state(stub, playId)
+		if err != nil || len(bytes) == 0 {
+			return nil, nil, nil, errors.New("Could not fetch play " + playId)
+		}
+		var play Play
+		json.Unmarshal(bytes, &play)
+
+		if play.TrackId == "" || play.Flagged != "" {
+			continue
+		}
+		counts[play.TrackId]++
+		pool.Plays++
+	}
+
+	// without plays the funders get their funding back
+	var payouts []Payment
+	if pool.Plays == 0 {
+		for _, funding := range pool.Funders {
+			payouts = append(payouts, Payment{RecipientId: funding.AccountId, Amount: funding.Amount})
+		}
+		return payouts, nil, nil, nil
+	}
+
+	trackIds, shares := pool_track_shares(pool.Funded, counts)
+	for _, trackId := range trackIds {
+		tr, err := fetch_track(stub, trackId)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		platformFee, platformLine := platform_fee_line(cfg, shares[trackId])
+		if platformLine != nil {
+			payouts = append(payouts, *platformLine)
+		}
+		payouts = append(payouts, rights_payouts(cfg, tr, shares[trackId]-platformFee)...)
+	}
+
+	return payouts, trackIds, shares, nil
+}
+
+// Adds up the payouts to the same recipient for the same rights or fee, the platform and artists of several tracks
+// get one payout each
+func merge_payouts(payouts []Payment) []Payment {
+
+	var merged []Payment
+	at := map[string]int{}
+	for _, payout := range payouts {
+		key := payout.RecipientId + "~" + payout.Rights + "~" + payout.Fee
+		if i, ok := at[key]; ok {
+			merged[i].Amount += payout.Amount
+			continue
+		}
+		at[key] = len(merged)
+		merged = append(merged, payout)
+	}
+
+	return merged
+}
+
+//==============================================================================================================================
+//  Invoke Functions
+//==============================================================================================================================
+
+// Pays into a pool of a period from the invoker's balance, creating the pool when no poolId is given
+func (t *SimpleChaincode) fund_pool(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0				1			2
+	//		periodId		amount		poolId (optional)
+
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting periodId and amount")
+	}
+	amount, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil || amount <= 0 {
+		return nil, errors.New("Invalid amount " + args[1] + ", expecting a positive amount")
+	}
+
+	funderId, err := t.get_caller_username(stub)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := get_tx_time(stub, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var pool RoyaltyPool
+	if len(args) > 2 && args[2] != "" {
+		pool, err = get_royalty_pool(stub, args[2])
+		if err != nil {
+			return nil, err
+		}
+		if pool.Period != args[0] {
+			return nil, errors.New("Royalty pool " + pool.Id + " is for period " + pool.Period + ", not " + args[0])
+		}
+		if pool.Status != "open" {
+			return nil, errors.New("Royalty pool " + pool.Id + " is already closed")
+		}
+	} else {
+		// pools are for the current period or one that ended
+		current, err := resolve_period(cfg, now)
+		if err != nil {
+			return nil, err
+		}
+		if current.Id != args[0] {
+			_, err = find_ended_period(cfg, args[0], now)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		poolId, err := append_id(stub, royaltyPoolIndexStr, "pool", true)
+		if err != nil {
+			return nil, errors.New("Error creating new id for royalty pool")
+		}
+		err = add_to_index(stub, period_pools_index_str(args[0]), string(poolId))
+		if err != nil {
+			return nil, err
+		}
+		pool = RoyaltyPool{Id: string(poolId), Period: args[0], HoldingAccount: holding_account_id(string(poolId)), Currency: cfg.Currency, Funders: []PoolFunding{}, Status: "open", CreatedAt: now.Format(time.RFC3339)}
+		err = put_account(stub, Account{Id: pool.HoldingAccount, Name: "Royalty pool " + pool.Id, Type: holdingAccountType, Currency: pool.Currency})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	funder, err := get_account(stub, funderId)
+	if err != nil {
+		return nil, err
+	}
+	err = check_account_not_frozen(funder)
+	if err != nil {
+		return nil, err
+	}
+	external := settles_through_chaincode(stub, cfg)
+	if !external && balance_in(cfg, funder, pool.Currency) < amount {
+		return nil, errors.New("Insufficient " + pool.Currency + " balance to fund " + args[1])
+	}
+	holding, err := get_account(stub, pool.HoldingAccount)
+	if err != nil {
+		return nil, err
+	}
+
+	var funding Payment
+	funding.SenderId	= funder.Id
+	funding.RecipientId	= holding.Id
+	funding.Amount		= amount
+	funding.Currency	= pool.Currency
+	funding.Completed	= true
+	funding.CreatedAt	= now.Format(time.RFC3339)
+	funding.UpdatedAt	= funding.CreatedAt
+	funding.DueDate		= funding.CreatedAt
+	funding.Period		= pool.Period
+	funding.Reference	= pool.Id
+
+	// on the settlement chaincode the holding account holds the funds there, close_pool settles from it
+	if external {
+		funding.TransferRef, err = transfer_through_chaincode(stub, cfg, funder.Id, holding.Id, amount, pool.Currency, pool.Id)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		adjust_balance(cfg, &funder, pool.Currency, -amount)
+		adjust_balance(cfg, &holding, pool.Currency, amount)
+	}
+	funder.PendingPayments = append(funder.PendingPayments, funding)
+	holding.PendingPayments = append(holding.PendingPayments, funding)
+	err = put_account(stub, funder)
+	if err != nil {
+		return nil, err
+	}
+	err = put_account(stub, holding)
+	if err != nil {
+		return nil, err
+	}
+
+	pool.Funded += amount
+	pool.Funders = append(pool.Funders, PoolFunding{AccountId: funder.Id, Amount: amount, FundedAt: funding.CreatedAt})
+	err = put_royalty_pool(stub, pool)
+	if err != nil {
+		return nil, err
+	}
+	err = emit_event(stub, "PaymentSettled", funding)
+	if err != nil {
+		return nil, err
+	}
+
+	poolBytes, _ := json.Marshal(pool)
+
+	return poolBytes, nil
+}
+
+// Distributes a pool over the plays of its period once the period has ended
+func (t *SimpleChaincode) close_pool(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		poolId
+
+	if len(args) < 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting poolId")
+	}
+
+	err := t.check_caller_role(stub, adminRole)
+	if err != nil {
+		return nil, err
+	}
+	caller, err := t.get_caller_username(stub)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := get_tx_time(stub, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err := get_royalty_pool(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if pool.Status != "open" {
+		return nil, errors.New("Royalty pool " + pool.Id + " is already closed")
+	}
+	_, err = find_ended_period(cfg, pool.Period, now)
+	if err != nil {
+		return nil, err
+	}
+
+	payouts, trackIds, shares, err := pool_payouts(stub, cfg, &pool)
+	if err != nil {
+		return nil, err
+	}
+
+	// the payouts are created as open payments from the holding account and settled right away
+	var payments []Payment
+	for _, payout := range merge_payouts(payouts) {
+		if payout.Amount == 0 {
+			continue
+		}
+		payout.SenderId		= pool.HoldingAccount
+		payout.Currency		= pool.Currency
+		payout.CreatedAt	= now.Format(time.RFC3339)
+		payout.DueDate		= payout.CreatedAt
+		payout.Period		= pool.Period
+		payout.Reference	= pool.Id
+
+		payments = append(payments, payout)
+	}
+	err = append_to_recipients(stub, payments)
+	if err != nil {
+		return nil, err
+	}
+	holding, err := get_account(stub, pool.HoldingAccount)
+	if err != nil {
+		return nil, err
+	}
+	holding.PendingPayments = append(holding.PendingPayments, payments...)
+	err = put_account(stub, holding)
+	if err != nil {
+		return nil, err
+	}
+	err = emit_payments_created(stub, payments)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, payment := range payments {
+		_, err = settle_open_payment(stub, cfg, &holding, pool.Id, payment.RecipientId, payment.Rights, now, false)
+		if err != nil {
+			pool.Unsettled++
+			continue
+		}
+		pool.Distributed += payment.Amount
+	}
+
+	for _, trackId := range trackIds {
+		err = add_track_earnings(stub, trackId, pool.Period, shares[trackId])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	pool.Status		= "closed"
+	pool.ClosedBy	= caller
+	pool.ClosedAt	= now.Format(time.RFC3339)
+	err = put_royalty_pool(stub, pool)
+	if err != nil {
+		return nil, err
+	}
+
+	poolBytes, _ := json.Marshal(pool)
+
+	return poolBytes, emit_event(stub, "RoyaltyPoolClosed", pool)
+}
+
+//==============================================================================================================================
+//		Query Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) get_royalty_pools(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			1
+	//		periodId
+
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting periodId")
+	}
+
+	poolIds, err := get_index_ids(stub, period_pools_index_str(args[1]))
+	if err != nil {
+		return nil, err
+	}
+
+	pools := []RoyaltyPool{}
+	for _, poolId := range poolIds {
+		pool, err := get_royalty_pool(stub, poolId)
+		if err != nil {
+			return nil, err
+		}
+		pools = append(pools, pool)
+	}
+
+	poolsBytes, _ := json.Marshal(pools)
+
+	return poolsBytes, nil
+}
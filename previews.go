@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"strconv"
+	"time"
+)
+
+//==============================================================================================================================
+//	 Previews - Every listener gets a free preview allowance per track: at most PreviewPlaysPerTrack previews and at most
+//				PreviewSecondsPerTrack seconds of listening in total. Previews generate no payments. Once the allowance
+//				is used up a preview is refused and further listening goes through a paid play.
+//==============================================================================================================================
+type PreviewUsage struct {
+	ListenerId			string		`json:"listenerId"`
+	TrackId				string		`json:"trackId"`
+	Plays				int			`json:"plays"`
+	Seconds				int			`json:"seconds"`
+	LastPreviewAt		string		`json:"lastPreviewAt,omitempty"`
+}
+
+type PreviewAllowance struct {
+	PreviewUsage
+	PlaysLeft			int			`json:"playsLeft"`			// -1 when previews are not limited by count
+	SecondsLeft			int			`json:"secondsLeft"`		// -1 when previews are not limited by time
+}
+
+func preview_usage_key(listenerId string, trackId string) string {
+	return "_preview_" + listenerId + "_" + trackId
+}
+
+func get_preview_usage(stub shim.ChaincodeStubInterface, listenerId string, trackId string) (PreviewUsage, error) {
+
+	usage := PreviewUsage{ListenerId: listenerId, TrackId: trackId}
+
+	bytes, err := get_state(stub, preview_usage_key(listenerId, trackId))
+	if err != nil {
+		return usage, errors.New("Failed to get preview usage of " + listenerId + " for track " + trackId)
+	}
+	if len(bytes) > 0 {
+		json.Unmarshal(bytes, &usage)
+	}
+
+	return usage, nil
+}
+
+// What is left of the allowance, a limit of 0 means previews are not limited that way
+func preview_allowance(cfg Config, usage PreviewUsage) PreviewAllowance {
+
+	allowance := PreviewAllowance{PreviewUsage: usage, PlaysLeft: -1, SecondsLeft: -1}
+	if cfg.PreviewPlaysPerTrack > 0 {
+		allowance.PlaysLeft = cfg.PreviewPlaysPerTrack - usage.Plays
+		if allowance.PlaysLeft < 0 {
+			allowance.PlaysLeft = 0
+		}
+	}
+	if cfg.PreviewSecondsPerTrack > 0 {
+		allowance.SecondsLeft = cfg.PreviewSecondsPerTrack - usage.Seconds
+		if allowance.SecondsLeft < 0 {
+			allowance.SecondsLeft = 0
+		}
+	}
+
+	return allowance
+}
+
+//==============================================================================================================================
+//  Invoke Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) register_preview(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0			1			2
+	//		trackId		listener	seconds listened
+
+	if len(args) < 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting trackId, listener and seconds")
+	}
+	seconds, err := strconv.Atoi(args[2])
+	if err != nil || seconds <= 0 {
+		return nil, errors.New("Invalid seconds " + args[2])
+	}
+	err = t.check_play_submitter(stub, args[1], "")
+	if err != nil {
+		return nil, err
+	}
+
+	tr, err := fetch_track(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if !is_track_active(tr) {
+		return nil, errors.New("Track " + args[0] + " is inactive and cannot be previewed")
+	}
+	_, err = get_account(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := get_tx_time(stub, cfg)
+	if err != nil {
+		return nil, err
+	}
+	err = check_track_released(stub, &tr, args[0], now)
+	if err != nil {
+		return nil, err
+	}
+
+	usage, err := get_preview_usage(stub, args[1], args[0])
+	if err != nil {
+		return nil, err
+	}
+	allowance := preview_allowance(cfg, usage)
+	if allowance.PlaysLeft == 0 || (allowance.SecondsLeft >= 0 && seconds > allowance.SecondsLeft) {
+		return nil, errors.New("Free preview allowance of " + args[1] + " for track " + args[0] + " is used up, register a play to keep listening")
+	}
+
+	usage.Plays++
+	usage.Seconds		+= seconds
+	usage.LastPreviewAt	= now.Format(time.RFC3339)
+
+	usageBytes, _ := json.Marshal(usage)
+	err = put_state(stub, preview_usage_key(args[1], args[0]), usageBytes)
+	if err != nil {
+		return nil, errors.New("Error putting preview usage on ledger")
+	}
+
+	allowanceBytes, _ := json.Marshal(preview_allowance(cfg, usage))
+
+	return allowanceBytes, nil
+}
+
+//==============================================================================================================================
+//		Query Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) get_preview_allowance(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			1			2
+	//		trackId		listener
+
+	if len(args) < 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting trackId and listener")
+	}
+
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	usage, err := get_preview_usage(stub, args[2], args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	allowanceBytes, _ := json.Marshal(preview_allowance(cfg, usage))
+
+	return allowanceBytes, nil
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+//==============================================================================================================================
+//	 Private Deal Terms - Everyone on the channel reads the public state, competitors included. With privateCollection
+//						  configured the commercially sensitive terms move into that private data collection: the
+//						  beneficiary percentages of a track with its scheduled and past splits, the share a label
+//						  takes of its artists' tracks, the fee of a license and the invoice lines of a play or license,
+//						  whose amounts per beneficiary give the split away. The public record keeps everything else and
+//						  the SHA-256 of its terms, so members of the collection can show their terms are the ones on the
+//						  channel. The terms are hashed with a salt kept next to them in the collection, as a percentage
+//						  or a fee has few enough values to try them all against an unsalted hash. The salt is derived
+//						  from the "termsSalt" field of the transient data and the key, so the client sealing terms
+//						  passes random bytes there. Terms written back keep the salt they were sealed with, terms sealed
+//						  for the first time without it are refused. An account without a label share has no terms to
+//						  keep and writes nothing to the collection. fetch_track, get_account and get_license fill the
+//						  terms back in on peers of members, the put functions seal them away again. A record read some
+//						  other way keeps the terms it has in the collection when it is written back. Queries showing a
+//						  track read it with read_track, which leaves the terms out on peers of other organizations.
+//
+//						  Transactions touching terms can only be endorsed by peers of collection members. The
+//						  collection is set once, set_config does not allow changing it afterwards.
+//==============================================================================================================================
+type TrackTerms struct {
+	Beneficiaries				[]Beneficiary		`json:"beneficiaries"`
+	RecordingBeneficiaries		[]Beneficiary		`json:"recordingBeneficiaries,omitempty"`
+	CompositionBeneficiaries	[]Beneficiary		`json:"compositionBeneficiaries,omitempty"`
+	SplitSchedules				[]SplitSchedule		`json:"splitSchedules,omitempty"`
+	SplitHistory				[]PastSplit			`json:"splitHistory,omitempty"`
+}
+
+type AccountTerms struct {
+	LabelShare			int64		`json:"labelShare"`
+}
+
+type LicenseTerms struct {
+	Fee					int64		`json:"fee"`
+	Payments			[]Payment	`json:"payments,omitempty"`
+}
+
+type PlayTerms struct {
+	Payments			[]Payment	`json:"payments"`
+}
+
+// What the collection keeps under a terms key, the hash on the channel is the SHA-256 of this document
+type SaltedTerms struct {
+	Salt				string				`json:"salt"`
+	Terms				json.RawMessage		`json:"terms"`
+}
+
+var termsSaltField = "termsSalt"
+
+// Salt of the terms under a key, hex encoded. Terms already in the collection keep their salt.
+func terms_salt(stub shim.ChaincodeStubInterface, collection string, key string) (string, error) {
+
+	seed, ok, err := transient_value(stub, termsSaltField)
+	if err != nil {
+		return "", err
+	}
+	if ok && len(seed) > 0 {
+		digest := sha256.Sum256(append(append(seed, 0x00), []byte(key)...))
+		return hex.EncodeToString(digest[:]), nil
+	}
+
+	var sealed SaltedTerms
+	termsBytes, err := get_private_state(stub, collection, key)
+	if err == nil && len(termsBytes) > 0 {
+		json.Unmarshal(termsBytes, &sealed)
+	}
+	if sealed.Salt == "" {
+		return "", new_error("bad_arguments", "terms.salt_missing", map[string]string{"key": key, "field": termsSaltField})
+	}
+
+	return sealed.Salt, nil
+}
+
+// Whether this peer holds the terms under a key, only peers of collection members do
+func terms_on_peer(stub shim.ChaincodeStubInterface, key string) bool {
+
+	cfg, err := get_config(stub)
+	if err != nil || cfg.PrivateCollection == "" {
+		return false
+	}
+	termsBytes, err := get_private_state(stub, cfg.PrivateCollection, key)
+
+	return err == nil && len(termsBytes) > 0
+}
+
+// Collection keys are "<kind>_terms~<id>", tracks, accounts and licenses have ids of their own
+func terms_key(kind string, id string) string {
+	return index_key(kind+"_terms", id)
+}
+
+// Writes terms to the collection, returns the hash the public record keeps of them
+func put_private_terms(stub shim.ChaincodeStubInterface, collection string, key string, terms interface{}) (string, error) {
+
+	salt, err := terms_salt(stub, collection, key)
+	if err != nil {
+		return "", err
+	}
+	rawTerms, _ := json.Marshal(terms)
+	termsBytes, _ := json.Marshal(SaltedTerms{Salt: salt, Terms: rawTerms})
+	err = put_private_state(stub, collection, key, termsBytes)
+	if err != nil {
+		return "", errors.New("Error putting " + key + " in collection " + collection)
+	}
+	digest := sha256.Sum256(termsBytes)
+
+	return hex.EncodeToString(digest[:]), nil
+}
+
+// Reads terms from the collection, they must be the ones the public record has the hash of
+func get_private_terms(stub shim.ChaincodeStubInterface, collection string, key string, hash string, terms interface{}) error {
+
+	termsBytes, err := get_private_state(stub, collection, key)
+	if err != nil || len(termsBytes) == 0 {
+		return errors.New("The terms " + key + " are not available on this peer, it is not a member of collection " + collection)
+	}
+	digest := sha256.Sum256(termsBytes)
+	if hex.EncodeToString(digest[:]) != hash {
+		return errors.New("The terms " + key + " in collection " + collection + " do not match the hash on the channel")
+	}
+	// terms sealed before they were salted are kept as they are
+	var salted SaltedTerms
+	json.Unmarshal(termsBytes, &salted)
+	if len(salted.Terms) > 0 {
+		termsBytes = salted.Terms
+	}
+	err = json.Unmarshal(termsBytes, terms)
+	if err != nil {
+		return errors.New("Could not unmarshal " + key)
+	}
+
+	return nil
+}
+
+// Moves the terms of a track into the collection, leaving their hash on the track
+func seal_track_terms(stub shim.ChaincodeStubInterface, cfg Config, trackId string, tr *Track) error {
+
+	if cfg.PrivateCollection == "" || (tr.TermsHash != "" && !tr.termsLoaded) {
+		return nil
+	}
+
+	terms := TrackTerms{Beneficiaries: tr.Beneficiaries, SplitSchedules: tr.SplitSchedules, SplitHistory: tr.SplitHistory}
+	if tr.Recording != nil {
+		recording := *tr.Recording
+		terms.RecordingBeneficiaries, recording.Beneficiaries = recording.Beneficiaries, nil
+		tr.Recording = &recording
+	}
+	if tr.Composition != nil {
+		composition := *tr.Composition
+		terms.CompositionBeneficiaries, composition.Beneficiaries = composition.Beneficiaries, nil
+		tr.Composition = &composition
+	}
+
+	hash, err := put_private_terms(stub, cfg.PrivateCollection, terms_key("track", trackId), terms)
+	if err != nil {
+		return err
+	}
+	tr.TermsHash		= hash
+	tr.Beneficiaries	= nil
+	tr.SplitSchedules	= nil
+	tr.SplitHistory		= nil
+
+	return nil
+}
+
+// Fills the terms of a track back in from the collection
+func open_track_terms(stub shim.ChaincodeStubInterface, trackId string, tr *Track) error {
+
+	if tr.TermsHash == "" {
+		return nil
+	}
+	cfg, err := get_config(stub)
+	if err != nil {
+		return err
+	}
+
+	var terms TrackTerms
+	err = get_private_terms(stub, cfg.PrivateCollection, terms_key("track", trackId), tr.TermsHash, &terms)
+	if err != nil {
+		return err
+	}
+	tr.Beneficiaries	= terms.Beneficiaries
+	tr.SplitSchedules	= terms.SplitSchedules
+	tr.SplitHistory		= terms.SplitHistory
+	if tr.Recording != nil {
+		tr.Recording.Beneficiaries = terms.RecordingBeneficiaries
+	}
+	if tr.Composition != nil {
+		tr.Composition.Beneficiaries = terms.CompositionBeneficiaries
+	}
+	tr.termsLoaded = true
+
+	return nil
+}
+
+func seal_account_terms(stub shim.ChaincodeStubInterface, cfg Config, account *Account) error {
+
+	if cfg.PrivateCollection == "" || (account.TermsHash != "" && !account.termsLoaded) {
+		return nil
+	}
+	if account.LabelShare == 0 {
+		if account.TermsHash != "" {
+			err := del_private_state(stub, cfg.PrivateCollection, terms_key("account", account.Id))
+			if err != nil {
+				return errors.New("Error removing the terms of " + account.Id + " from collection " + cfg.PrivateCollection)
+			}
+		}
+		account.TermsHash = ""
+		return nil
+	}
+
+	hash, err := put_private_terms(stub, cfg.PrivateCollection, terms_key("account", account.Id), AccountTerms{LabelShare: account.LabelShare})
+	if err != nil {
+		return err
+	}
+	account.TermsHash	= hash
+	account.LabelShare	= 0
+
+	return nil
+}
+
+func open_account_terms(stub shim.ChaincodeStubInterface, account *Account) error {
+
+	if account.TermsHash == "" {
+		return nil
+	}
+	cfg, err := get_config(stub)
+	if err != nil {
+		return err
+	}
+
+	var terms AccountTerms
+	err = get_private_terms(stub, cfg.PrivateCollection, terms_key("account", account.Id), account.TermsHash, &terms)
+	if err != nil {
+		return err
+	}
+	account.LabelShare	= terms.LabelShare
+	account.termsLoaded	= true
+
+	return nil
+}
+
+func seal_license_terms(stub shim.ChaincodeStubInterface, cfg Config, license *License) error {
+
+	if cfg.PrivateCollection == "" || (license.TermsHash != "" && !license.termsLoaded) {
+		return nil
+	}
+
+	hash, err := put_private_terms(stub, cfg.PrivateCollection, terms_key("license", license.Id), LicenseTerms{Fee: license.Fee, Payments: license.Payments})
+	if err != nil {
+		return err
+	}
+	license.TermsHash	= hash
+	license.Fee			= 0
+	license.Payments	= nil
+
+	return nil
+}
+
+func open_license_terms(stub shim.ChaincodeStubInterface, license *License) error {
+
+	if license.TermsHash == "" {
+		return nil
+	}
+	cfg, err := get_config(stub)
+	if err != nil {
+		return err
+	}
+
+	var terms LicenseTerms
+	err = get_private_terms(stub, cfg.PrivateCollection, terms_key("license", license.Id), license.TermsHash, &terms)
+	if err != nil {
+		return err
+	}
+	license.Fee			= terms.Fee
+	license.Payments	= terms.Payments
+	license.termsLoaded	= true
+
+	return nil
+}
+
+func seal_play_terms(stub shim.ChaincodeStubInterface, cfg Config, play *Play) error {
+
+	if cfg.PrivateCollection == "" || (play.TermsHash != "" && !play.termsLoaded) {
+		return nil
+	}
+
+	hash, err := put_private_terms(stub, cfg.PrivateCollection, terms_key("play", play.Id), PlayTerms{Payments: play.Payments})
+	if err != nil {
+		return err
+	}
+	play.TermsHash	= hash
+	play.Payments	= nil
+
+	return nil
+}
+
+func open_play_terms(stub shim.ChaincodeStubInterface, play *Play) error {
+
+	if play.TermsHash == "" {
+		return nil
+	}
+	cfg, err := get_config(stub)
+	if err != nil {
+		return err
+	}
+
+	var terms PlayTerms
+	err = get_private_terms(stub, cfg.PrivateCollection, terms_key("play", play.Id), play.TermsHash, &terms)
+	if err != nil {
+		return err
+	}
+	play.Payments		= terms.Payments
+	play.termsLoaded	= true
+
+	return nil
+}
+
+// Reads a track to show it: on peers of collection members with its terms, on other peers without them
+func read_track(stub shim.ChaincodeStubInterface, trackId string) (Track, error) {
+
+	tr, err := get_public_track(stub, trackId)
+	if err != nil {
+		return tr, err
+	}
+	if tr.TermsHash == "" || !terms_on_peer(stub, terms_key("track", trackId)) {
+		return tr, nil
+	}
+	err = open_track_terms(stub, trackId, &tr)
+	if err != nil {
+		return tr, err
+	}
+
+	return tr, nil
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"time"
+)
+
+//==============================================================================================================================
+//	 Promotions - A promotion discounts plays by a share of their price, and records who bears the discount: the
+//				  distributor running it, the platform or the artist of the track. A discounted play is invoiced at
+//				  its full price as usual, so the beneficiaries still get their full share, and the funder owes the
+//				  discount to the account that played: a funding payment from the funder to the payer, referring to
+//				  the play. Funding payments are open payments of the period like any other, close_period settles
+//				  them with the rest of the period's payouts. The funding of every promotion is totalled per period.
+//
+//				  Who may create a promotion follows from its funder: a distributor for its own plays, the platform
+//				  account, or the owner of the tracks for an artist funded promotion, which must list its tracks.
+//==============================================================================================================================
+type Promotion struct {
+	Id					string		`json:"id"`
+	Name				string		`json:"name"`
+	DiscountBps			int64		`json:"discountBps"`			// part of the price of a play taken off, in basis points
+	Funder				string		`json:"funder"`					// distributor, platform or artist
+	DistributorId		string		`json:"distributorId,omitempty"`	// for distributor funded promotions, only plays submitted through it
+	TrackIds			[]string	`json:"trackIds,omitempty"`		// tracks the promotion applies to, empty for every track
+	StartsAt			string		`json:"startsAt"`				// RFC3339, inclusive
+	EndsAt				string		`json:"endsAt"`					// RFC3339, exclusive
+	CreatedBy			string		`json:"createdBy"`
+	CreatedAt			string		`json:"createdAt"`
+}
+
+type PromotionFunding struct {
+	PromotionId			string				`json:"promotionId"`
+	Period				string				`json:"period"`
+	Plays				int					`json:"plays"`
+	DiscountByCurrency	map[string]int64	`json:"discountByCurrency"`
+	ByFunder			map[string]int64	`json:"byFunder"`		// discount owed by each funding account, artist funded promotions have one per artist
+}
+
+var PromotionFunders = map[string]bool{
+	"distributor":	true,
+	"platform":		true,
+	"artist":		true,
+}
+
+var promotionIndexStr = "_promotions"
+
+func promotion_funding_key(promotionId string, periodId string) string {
+	return "_promotion_funding_" + promotionId + "_" + periodId
+}
+
+func get_promotion(stub shim.ChaincodeStubInterface, promotionId string) (Promotion, error) {
+
+	var promotion Promotion
+
+	bytes, err := get_state(stub, promotionId)
+	if err != nil || len(bytes) == 0 {
+		return promotion, errors.New("Could not fetch promotion " + promotionId)
+	}
+	err = json.Unmarshal(bytes, &promotion)
+	if err != nil {
+		return promotion, errors.New("Could not unmarshal promotion " + promotionId)
+	}
+
+	return promotion, nil
+}
+
+func get_promotion_funding(stub shim.ChaincodeStubInterface, promotionId string, periodId string) (PromotionFunding, error) {
+
+	funding := PromotionFunding{PromotionId: promotionId, Period: periodId, DiscountByCurrency: map[string]int64{}, ByFunder: map[string]int64{}}
+
+	bytes, err := get_state(stub, promotion_funding_key(promotionId, periodId))
+	if err != nil {
+		return funding, errors.New("Failed to get funding of promotion " + promotionId)
+	}
+	if len(bytes) > 0 {
+		err = json.Unmarshal(bytes, &funding)
+		if err != nil {
+			return funding, errors.New("Could not unmarshal funding of promotion " + promotionId)
+		}
+	}
+
+	return funding, nil
+}
+
+// Checks the promotion applies to a play of the track submitted through the distributor at the time, returns the account funding it
+func promotion_funder(cfg Config, promotion Promotion, tr Track, trackId string, distributorId string, now time.Time) (string, error) {
+
+	startsAt, _ := time.Parse(time.RFC3339, promotion.StartsAt)
+	endsAt, _ := time.Parse(time.RFC3339, promotion.EndsAt)
+	if now.Before(startsAt) || !now.Before(endsAt) {
+		return "", errors.New("Promotion " + promotion.Id + " runs from " + promotion.StartsAt + " until " + promotion.EndsAt)
+	}
+	if len(promotion.TrackIds) > 0 {
+		listed := false
+		for _, id := range promotion.TrackIds {
+			if id == trackId {
+				listed = true
+				break
+			}
+		}
+		if !listed {
+			return "", errors.New("Promotion " + promotion.Id + " does not apply to track " + trackId)
+		}
+	}
+
+	switch promotion.Funder {
+	case "distributor":
+		if distributorId != promotion.DistributorId {
+			return "", errors.New("Promotion " + promotion.Id + " only applies to plays submitted through " + promotion.DistributorId)
+		}
+		return promotion.DistributorId, nil
+	case "platform":
+		if cfg.PlatformAccountId == "" {
+			return "", errors.New("Promotion " + promotion.Id + " is funded by the platform, but no platform account is configured")
+		}
+		return cfg.PlatformAccountId, nil
+	}
+	if tr.Artist != "" {
+		return tr.Artist, nil
+	}
+
+	return tr.Owner, nil
+}
+
+// Records the discount the funder of a promotion owes for a play in the funding totals of the period
+func add_promotion_funding(stub shim.ChaincodeStubInterface, promotionId string, periodId string, funderId string, currency string, discount int64) error {
+
+	funding, err := get_promotion_funding(stub, promotionId, periodId)
+	if err != nil {
+		return err
+	}
+	funding.Plays++
+	funding.DiscountByCurrency[currency] += discount
+	funding.ByFunder[funderId] += discount
+
+	fundingBytes, _ := json.Marshal(funding)
+	err = put_state(stub, promotion_funding_key(promotionId, periodId), fundingBytes)
+	if err != nil {
+		return errors.New("Error putting funding of promotion " + promotionId + " on ledger")
+	}
+
+	return nil
+}
+
+//==============================================================================================================================
+//  Invoke Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) create_promotion(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		promotion JSON object (as string, name, discountBps, funder, trackIds, startsAt and endsAt)
+
+	if len(args) < 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting promotion JSON")
+	}
+
+	var promotion Promotion
+	err := json.Unmarshal([]byte(args[0]), &promotion)
+	if err != nil {
+		return nil, errors.New("Could not unmarshal promotion: " + err.Error())
+	}
+	if promotion.DiscountBps <= 0 || promotion.DiscountBps > 10000 {
+		return nil, errors.New("discountBps must be between 1 and 10000")
+	}
+	if !PromotionFunders[promotion.Funder] {
+		return nil, errors.New("Promotion funder not recognized: " + promotion.Funder)
+	}
+	startsAt, err := time.Parse(time.RFC3339, promotion.StartsAt)
+	if err != nil {
+		return nil, errors.New("Invalid startsAt " + promotion.StartsAt + ", expecting RFC3339")
+	}
+	endsAt, err := time.Parse(time.RFC3339, promotion.EndsAt)
+	if err != nil {
+		return nil, errors.New("Invalid endsAt " + promotion.EndsAt + ", expecting RFC3339")
+	}
+	if !startsAt.Before(endsAt) {
+		return nil, errors.New("A promotion must start before it ends")
+	}
+
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := get_tx_time(stub, cfg)
+	if err != nil {
+		return nil, err
+	}
+	caller, err := t.get_caller_username(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	// the funder agrees to bear the discount by creating the promotion
+	promotion.DistributorId = ""
+	switch promotion.Funder {
+	case "distributor":
+		_, found, err := get_distributor_config(stub, caller)
+		if err != nil {
+			return nil, err
+		}
+		if !found {
+			return nil, errors.New("Only distributors can create distributor funded promotions")
+		}
+		promotion.DistributorId = caller
+	case "platform":
+		if cfg.PlatformAccountId == "" || caller != cfg.PlatformAccountId {
+			return nil, errors.New("Only the platform account can create platform funded promotions")
+		}
+	case "artist":
+		if len(promotion.TrackIds) == 0 {
+			return nil, errors.New("Invalid promotion, an artist funded promotion must list its tracks")
+		}
+		for _, trackId := range promotion.TrackIds {
+			tr, err := fetch_track(stub, trackId)
+			if err != nil {
+				return nil, err
+			}
+			err = t.check_track_owner(stub, tr, trackId)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	promotionId, err := append_id(stub, promotionIndexStr, "promo", true)
+	if err != nil {
+		return nil, errors.New("Error creating new id for promotion")
+	}
+	promotion.Id		= string(promotionId)
+	promotion.CreatedBy	= caller
+	promotion.CreatedAt	= now.Format(time.RFC3339)
+
+	promotionBytes, _ := json.Marshal(promotion)
+	err = put_state(stub, promotion.Id, promotionBytes)
+	if err != nil {
+		return nil, errors.New("Error putting promotion " + promotion.Id + " on ledger")
+	}
+
+	return promotionBytes, nil
+}
+
+//==============================================================================================================================
+//		Query Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) query_promotion(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			1
+	//		promotionId
+
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting promotionId")
+	}
+
+	promotion, err := get_promotion(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	promotionBytes, _ := json.Marshal(promotion)
+
+	return promotionBytes, nil
+}
+
+func (t *SimpleChaincode) query_promotion_funding(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			1				2
+	//		promotionId		periodId
+
+	if len(args) < 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting promotionId and periodId")
+	}
+
+	funding, err := get_promotion_funding(stub, args[1], args[2])
+	if err != nil {
+		return nil, err
+	}
+
+	fundingBytes, _ := json.Marshal(funding)
+
+	return fundingBytes, nil
+}
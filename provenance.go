@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+//==============================================================================================================================
+//	 Provenance - A public summary of a track's registration that external sites can show as a provenance badge: when
+//				  and in which transaction it was registered, its content digest, its rights holders and the chain of
+//				  ownership transfers. Only public fields are returned and no caller identity is needed. Placeholder
+//				  rights holders are shown without their identifier.
+//==============================================================================================================================
+type ProvenanceHolder struct {
+	AccountId			string		`json:"accountId,omitempty"`
+	Name				string		`json:"name,omitempty"`
+	Rights				string		`json:"rights,omitempty"`		// master or publishing, for tracks with separate rights
+	Percentage			int64		`json:"percentage"`
+	Placeholder			bool		`json:"placeholder,omitempty"`	// not claimed yet
+}
+
+type OwnershipTransfer struct {
+	From				string		`json:"from"`
+	To					string		`json:"to"`
+	TxId				string		`json:"txId"`
+	Timestamp			string		`json:"timestamp"`
+}
+
+type TrackProvenance struct {
+	TrackId				string				`json:"trackId"`
+	Title				string				`json:"title"`
+	Isrc				string				`json:"isrc"`
+	Iswc				string				`json:"iswc"`
+	Content				string				`json:"content"`			// SHA-256 of the content, hex encoded
+	RegisteredAt		string				`json:"registeredAt"`
+	RegisteredTxId		string				`json:"registeredTxId"`
+	Owner				string				`json:"owner"`
+	Status				string				`json:"status"`
+	RightsHolders		[]ProvenanceHolder	`json:"rightsHolders"`
+	Transfers			[]OwnershipTransfer	`json:"transfers"`
+}
+
+func provenance_holders(stub shim.ChaincodeStubInterface, beneficiaries []Beneficiary, rights string) []ProvenanceHolder {
+
+	holders := []ProvenanceHolder{}
+	for _, b := range beneficiaries {
+		holder := ProvenanceHolder{Rights: rights, Percentage: b.Percentage}
+		if b.Placeholder != "" {
+			holder.Placeholder = true
+		} else {
+			holder.AccountId = b.AccountId
+			account, err := get_account(stub, b.AccountId)
+			if err == nil {
+				holder.Name = account.Name
+			}
+		}
+		holders = append(holders, holder)
+	}
+
+	return holders
+}
+
+//==============================================================================================================================
+//		Query Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) verify_track_provenance(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			1
+	//		trackId
+
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting trackId")
+	}
+
+	tr, err := read_track(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	versions, err := get_track_versions(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	var provenance TrackProvenance
+	provenance.TrackId		= args[1]
+	provenance.Title		= tr.Title
+	provenance.Isrc			= tr.Isrc
+	provenance.Iswc			= tr.Iswc
+	provenance.Content		= tr.Content
+	provenance.RegisteredAt	= tr.CreatedAt
+	provenance.Owner		= tr.Owner
+	provenance.Status		= string(tr.Status)
+	if provenance.Status == "" {
+		provenance.Status = string(TrackActive)
+	}
+
+	if tr.Recording != nil || tr.Composition != nil {
+		provenance.RightsHolders = []ProvenanceHolder{}
+		if tr.Recording != nil {
+			provenance.RightsHolders = append(provenance.RightsHolders, provenance_holders(stub, tr.Recording.Beneficiaries, "master")...)
+		}
+		if tr.Composition != nil {
+			provenance.RightsHolders = append(provenance.RightsHolders, provenance_holders(stub, tr.Composition.Beneficiaries, "publishing")...)
+		}
+	} else {
+		provenance.RightsHolders = provenance_holders(stub, tr.Beneficiaries, "")
+	}
+
+	// the first version is the registration, every change of owner after it a transfer
+	provenance.Transfers = []OwnershipTransfer{}
+	for i, version := range versions {
+		if i == 0 {
+			provenance.RegisteredAt		= version.Timestamp
+			provenance.RegisteredTxId	= version.TxId
+			continue
+		}
+		previous := versions[i-1].Track.Owner
+		if version.Track.Owner != previous {
+			provenance.Transfers = append(provenance.Transfers, OwnershipTransfer{From: previous, To: version.Track.Owner, TxId: version.TxId, Timestamp: version.Timestamp})
+		}
+	}
+
+	provenanceBytes, _ := json.Marshal(provenance)
+
+	return provenanceBytes, nil
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"time"
+)
+
+//==============================================================================================================================
+//	 Purchases - A listener buys a track outright instead of paying per play. buy_track charges the price of the track in
+//				 the territory from the buyer's balance on the spot: the platform fee and the splits of the track are
+//				 created as payments from the buyer and settled in the same transaction, so the purchase fails as a
+//				 whole when the buyer cannot pay or a beneficiary cannot be paid. The Purchase is the receipt, download
+//				 services check get_purchases before serving the file. A track is only bought once per account.
+//==============================================================================================================================
+type Purchase struct {
+	Id					string		`json:"id"`
+	TrackId				string		`json:"trackId"`
+	Buyer				string		`json:"buyer"`
+	Price				int64		`json:"price"`
+	Currency			string		`json:"currency"`				// ISO 4217 code, that of the track
+	Territory			string		`json:"territory,omitempty"`
+	Period				string		`json:"period"`
+	Payments			[]Payment	`json:"payments"`				// the proceeds, settled
+	PurchasedAt			string		`json:"purchasedAt"`
+}
+
+var purchaseIndexStr = "_purchases"
+var accountPurchasesIndexStr = "account_purchases"
+
+// Per-account index of the purchases of the account, "account_purchases~<accountId>~<purchaseId>"
+func account_purchases_index_str(accountId string) string {
+	return index_key(accountPurchasesIndexStr, accountId)
+}
+
+// Holds the id of the purchase of a track by an account
+func purchase_key(accountId string, trackId string) string {
+	return "_purchase_" + accountId + "_" + trackId
+}
+
+func get_purchase(stub shim.ChaincodeStubInterface, purchaseId string) (Purchase, error) {
+
+	var purchase Purchase
+
+	bytes, err := get_state(stub, purchaseId)
+	if err != nil || len(bytes) == 0 {
+		return purchase, errors.New("Could not fetch purchase " + purchaseId)
+	}
+	err = json.Unmarshal(bytes, &purchase)
+	if err != nil {
+		return purchase, errors.New("Could not unmarshal purchase " + purchaseId)
+	}
+
+	return purchase, nil
+}
+
+//==============================================================================================================================
+//  Invoke Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) buy_track(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0			1					2
+	//		trackId		buyerAccountId		territory (optional)
+
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting trackId and buyerAccountId")
+	}
+
+	caller, err := t.get_caller_username(stub)
+	if err != nil {
+		return nil, err
+	}
+	if caller != args[1] && t.check_caller_role(stub, adminRole) != nil {
+		return nil, errors.New("Only " + args[1] + " or an admin can buy tracks for account " + args[1])
+	}
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := get_tx_time(stub, cfg)
+	if err != nil {
+		return nil, err
+	}
+	period, err := resolve_period(cfg, now)
+	if err != nil {
+		return nil, err
+	}
+
+	tr, err := fetch_track(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if !is_track_active(tr) {
+		return nil, errors.New("Track " + args[0] + " is inactive and cannot be bought")
+	}
+	err = check_track_released(stub, &tr, args[0], now)
+	if err != nil {
+		return nil, err
+	}
+
+	buyer, err := get_account(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	err = check_account_not_frozen(buyer)
+	if err != nil {
+		return nil, err
+	}
+	owned, err := get_state(stub, purchase_key(buyer.Id, args[0]))
+	if err != nil {
+		return nil, errors.New("Failed to get purchases of " + buyer.Id)
+	}
+	if len(owned) > 0 {
+		return nil, errors.New("Account " + buyer.Id + " already bought track " + args[0] + " in purchase " + string(owned))
+	}
+
+	territory := cfg.DefaultTerritory
+	if len(args) > 2 && args[2] != "" {
+		territory = args[2]
+	}
+	if territory != "" {
+		err = validate_territory(territory)
+		if err != nil {
+			return nil, err
+		}
+	}
+	price := track_price(tr, territory, now)
+	currency := track_currency(cfg, tr)
+	if price <= 0 {
+		return nil, errors.New("Track " + args[0] + " has no price and cannot be bought")
+	}
+	if balance_in(cfg, buyer, currency) < price {
+		return nil, errors.New("Insufficient " + currency + " balance to buy track " + args[0])
+	}
+	dueDate, err := payment_due_date(cfg, buyer, now)
+	if err != nil {
+		return nil, err
+	}
+
+	purchaseId, err := append_id(stub, purchaseIndexStr, "pu", true)
+	if err != nil {
+		return nil, errors.New("Error creating new id for purchase")
+	}
+
+	var purchase Purchase
+	purchase.Id				= string(purchaseId)
+	purchase.TrackId		= args[0]
+	purchase.Buyer			= buyer.Id
+	purchase.Price			= price
+	purchase.Currency		= currency
+	purchase.Territory		= territory
+	purchase.Period			= period.Id
+	purchase.PurchasedAt	= now.Format(time.RFC3339)
+
+	// the platform takes its fee off the price, the beneficiaries share the rest
+	platformFee, platformLine := platform_fee_line(cfg, price)
+	lines := rights_payouts(cfg, track_split_at(tr, now), price-platformFee)
+	if platformLine != nil {
+		lines = append([]Payment{*platformLine}, lines...)
+	}
+
+	var payments []Payment
+	for _, line := range lines {
+		line.SenderId	= buyer.Id
+		line.Currency	= currency
+		line.CreatedAt	= now.Format(time.RFC3339)
+		line.DueDate	= dueDate
+		line.Period		= period.Id
+		line.Reference	= purchase.Id
+
+		payments = append(payments, line)
+	}
+
+	// recipients first and each once, a buyer that is also one is read back with its copies as recipient
+	err = append_to_recipients(stub, payments)
+	if err != nil {
+		return nil, err
+	}
+	err = append_pending_payments(stub, buyer.Id, payments)
+	if err != nil {
+		return nil, err
+	}
+	err = emit_payments_created(stub, payments)
+	if err != nil {
+		return nil, err
+	}
+
+	// the buyer pays on the spot, read back with the payments it now owes
+	buyer, err = get_account(stub, buyer.Id)
+	if err != nil {
+		return nil, err
+	}
+	purchase.Payments = []Payment{}
+	for _, payment := range payments {
+		settled, err := settle_open_payment(stub, cfg, &buyer, purchase.Id, payment.RecipientId, payment.Rights, now, false)
+		if err != nil {
+			return nil, err
+		}
+		purchase.Payments = append(purchase.Payments, settled)
+	}
+
+	err = add_track_earnings(stub, args[0], period.Id, price)
+	if err != nil {
+		return nil, err
+	}
+	err = add_to_index(stub, account_purchases_index_str(buyer.Id), purchase.Id)
+	if err != nil {
+		return nil, err
+	}
+	err = put_state(stub, purchase_key(buyer.Id, args[0]), purchaseId)
+	if err != nil {
+		return nil, errors.New("Error recording purchase of track " + args[0] + " by " + buyer.Id)
+	}
+
+	purchaseBytes, _ := json.Marshal(purchase)
+	err = put_state(stub, purchase.Id, purchaseBytes)
+	if err != nil {
+		return nil, errors.New("Error putting purchase " + purchase.Id + " on ledger")
+	}
+	err = emit_event(stub, "TrackPurchased", purchase)
+	if err != nil {
+		return nil, err
+	}
+
+	return purchaseBytes, nil
+}
+
+//==============================================================================================================================
+//		Query Functions
+//==============================================================================================================================
+
+// The receipts of an account, or its receipt for one track, an account without one does not own the track
+func (t *SimpleChaincode) get_purchases(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			1				2
+	//		accountId		trackId (optional)
+
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting accountId")
+	}
+
+	var purchaseIds []string
+	if len(args) > 2 && args[2] != "" {
+		purchaseId, err := get_state(stub, purchase_key(args[1], args[2]))
+		if err != nil {
+			return nil, errors.New("Failed to get purchases of " + args[1])
+		}
+		if len(purchaseId) > 0 {
+			purchaseIds = append(purchaseIds, string(purchaseId))
+		}
+	} else {
+		ids, err := get_index_ids(stub, account_purchases_index_str(args[1]))
+		if err != nil {
+			return nil, err
+		}
+		purchaseIds = ids
+	}
+
+	purchases := []Purchase{}
+	for _, purchaseId := range purchaseIds {
+		purchase, err := get_purchase(stub, purchaseId)
+		if err != nil {
+			return nil, err
+		}
+		purchases = append(purchases, purchase)
+	}
+
+	purchasesBytes, _ := json.Marshal(purchases)
+
+	return purchasesBytes, nil
+}
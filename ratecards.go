@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"sort"
+)
+
+//==============================================================================================================================
+//	 Rate Cards - The price of a play depends on how the track is used. Every usage type has a rate card with a multiplier
+//				  applied to the track's price, a usage type without a stored rate card is charged the plain price.
+//==============================================================================================================================
+type RateCard struct {
+	UsageType			string		`json:"usageType"`
+	MultiplierBps		int64		`json:"multiplierBps"`		// 10000 charges the track's price as is
+}
+
+var UsageTypes = map[string]bool{
+	"stream":		true,
+	"download":		true,
+	"sync":			true,
+	"radio":		true,
+}
+
+var defaultUsageType = "stream"
+
+func rate_card_key(usageType string) string {
+	return "_rate_card_" + usageType
+}
+
+func get_rate_card(stub shim.ChaincodeStubInterface, usageType string) (RateCard, error) {
+
+	card := RateCard{UsageType: usageType, MultiplierBps: 10000}
+
+	bytes, err := get_state(stub, rate_card_key(usageType))
+	if err != nil {
+		return card, errors.New("Failed to get rate card for " + usageType)
+	}
+	if len(bytes) > 0 {
+		err = json.Unmarshal(bytes, &card)
+		if err != nil {
+			return card, errors.New("Could not unmarshal rate card for " + usageType)
+		}
+	}
+
+	return card, nil
+}
+
+func apply_rate_card(card RateCard, price int64) int64 {
+	return price * card.MultiplierBps / 10000
+}
+
+//==============================================================================================================================
+//  Invoke Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) set_rate_card(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		rate card JSON object (as string)
+
+	if len(args) < 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting rate card JSON")
+	}
+
+	var card RateCard
+	err := json.Unmarshal([]byte(args[0]), &card)
+	if err != nil {
+		return nil, errors.New("Could not unmarshal rate card: " + err.Error())
+	}
+	if !UsageTypes[card.UsageType] {
+		return nil, errors.New("Usage type not recognized: " + card.UsageType)
+	}
+	if card.MultiplierBps < 0 {
+		return nil, errors.New("multiplierBps cannot be negative")
+	}
+
+	cardBytes, _ := json.Marshal(card)
+	err = put_state(stub, rate_card_key(card.UsageType), cardBytes)
+	if err != nil {
+		return nil, errors.New("Error putting rate card on ledger")
+	}
+
+	return nil, nil
+}
+
+//==============================================================================================================================
+//		Query Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) get_rate_cards(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	var usageTypes []string
+	for usageType := range UsageTypes {
+		usageTypes = append(usageTypes, usageType)
+	}
+	sort.Strings(usageTypes)
+
+	cards := []RateCard{}
+	for _, usageType := range usageTypes {
+		card, err := get_rate_card(stub, usageType)
+		if err != nil {
+			return nil, err
+		}
+		cards = append(cards, card)
+	}
+
+	cardsBytes, _ := json.Marshal(cards)
+
+	return cardsBytes, nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"time"
+)
+
+//==============================================================================================================================
+//	 Coordinated Releases - The owner of a track schedules its release at a UTC instant, for a global drop the embargo
+//							lifts at that instant in every territory at once. Until then the track cannot be played,
+//							previewed or licensed: the checks compare the transaction time with the instant, so every
+//							peer agrees on which side of the release a transaction falls. The first transaction on the
+//							track after the instant records the release and emits TrackReleased.
+//==============================================================================================================================
+type TrackRelease struct {
+	TrackId				string		`json:"trackId"`
+	ReleaseAt			string		`json:"releaseAt"`
+	ReleasedAt			string		`json:"releasedAt"`		// time of the first transaction after the release
+	TxId				string		`json:"txId"`
+}
+
+// Parses a release instant, normalized to UTC
+func parse_release_at(releaseAt string) (string, error) {
+
+	instant, err := time.Parse(time.RFC3339, releaseAt)
+	if err != nil {
+		return "", errors.New("Invalid release instant " + releaseAt + ", expecting RFC3339")
+	}
+
+	return instant.UTC().Format(time.RFC3339), nil
+}
+
+// Verifies the embargo of a track has lifted, the first transaction after it did records the release on the track
+func check_track_released(stub shim.ChaincodeStubInterface, tr *Track, trackId string, now time.Time) error {
+
+	if tr.ReleaseAt == "" || tr.ReleasedAt != "" {
+		return nil
+	}
+
+	releaseAt, err := time.Parse(time.RFC3339, tr.ReleaseAt)
+	if err != nil {
+		return errors.New("Invalid release instant " + tr.ReleaseAt + " on track " + trackId)
+	}
+	if now.Before(releaseAt) {
+		return errors.New("Track " + trackId + " is embargoed until " + tr.ReleaseAt)
+	}
+
+	tr.ReleasedAt = now.UTC().Format(time.RFC3339)
+	err = put_track(stub, trackId, *tr)
+	if err != nil {
+		return err
+	}
+
+	return emit_event(stub, "TrackReleased", TrackRelease{TrackId: trackId, ReleaseAt: tr.ReleaseAt, ReleasedAt: tr.ReleasedAt, TxId: stub.GetTxID()})
+}
+
+//==============================================================================================================================
+//  Invoke Functions
+//==============================================================================================================================
+
+// Schedules, moves or, with an empty instant, lifts the embargo of a track that has not been released yet
+func (t *SimpleChaincode) schedule_release(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0				1
+	//		trackId		release instant (RFC3339, empty to release now)
+
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting trackId and release instant")
+	}
+
+	tr, err := fetch_track(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	err = t.check_track_owner(stub, tr, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if tr.ReleasedAt != "" {
+		return nil, errors.New("Track " + args[0] + " was already released at " + tr.ReleasedAt)
+	}
+
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := get_tx_time(stub, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	tr.ReleaseAt = ""
+	if args[1] != "" {
+		tr.ReleaseAt, err = parse_release_at(args[1])
+		if err != nil {
+			return nil, err
+		}
+		releaseAt, _ := time.Parse(time.RFC3339, tr.ReleaseAt)
+		if !releaseAt.After(now) {
+			return nil, errors.New("Invalid release instant " + args[1] + ", it must be in the future")
+		}
+	}
+
+	return nil, put_track(stub, args[0], tr)
+}
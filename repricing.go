@@ -0,0 +1,404 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//==============================================================================================================================
+//	 Bulk Re-pricing - An admin, or a label for its own catalog, re-prices many tracks at once: the tracks of a label's
+//					   catalog, every track, or either narrowed down with a filter, get a new price or a change in basis
+//					   points from an effective date. The new price is scheduled on each track next to the current one, a
+//					   play happening before the effective date is still priced at the old price, also when it is synced
+//					   late. Once the date has passed the next re-pricing or price update moves the old price into the
+//					   track's price history, so it stays available for plays attributed before it.
+//
+//					   A re-pricing touches every track, so like close_period each invoke scans a batch of tracks and
+//					   records where it stopped. continue_repricing picks it up from there until it is done.
+//==============================================================================================================================
+type ScheduledPrice struct {
+	EffectiveAt			string				`json:"effectiveAt"`				// RFC3339 UTC, the price applies to plays from then on
+	Price				int64				`json:"price"`
+	TerritoryPrices		map[string]int64	`json:"territoryPrices,omitempty"`
+	RepricingId			string				`json:"repricingId"`
+}
+
+// A price that applied to plays until a re-pricing took effect
+type PastPrice struct {
+	Until				string				`json:"until"`						// RFC3339 UTC, exclusive
+	Price				int64				`json:"price"`
+	TerritoryPrices		map[string]int64	`json:"territoryPrices,omitempty"`
+}
+
+type RepricingFilter struct {
+	Artist				string		`json:"artist,omitempty"`
+	Owner				string		`json:"owner,omitempty"`
+	Currency			string		`json:"currency,omitempty"`
+	TitlePrefix			string		`json:"titlePrefix,omitempty"`		// case-insensitive
+}
+
+type Repricing struct {
+	Id					string				`json:"id"`
+	LabelId				string				`json:"labelId,omitempty"`			// re-prices the label's catalog, empty for every track (admin only)
+	Filter				RepricingFilter		`json:"filter"`
+	Price				*int64				`json:"price,omitempty"`			// new price, or
+	ChangeBps			int64				`json:"changeBps,omitempty"`		// change of the current prices in basis points, -1000 is 10% off
+	TerritoryPrices		map[string]int64	`json:"territoryPrices,omitempty"`	// with price, the new territory prices
+	EffectiveAt			string				`json:"effectiveAt"`
+	Status				string				`json:"status"`					// running or done
+	Cursor				string				`json:"cursor,omitempty"`			// last track scanned
+	Scanned				int					`json:"scanned"`
+	Repriced			int					`json:"repriced"`
+	CreatedBy			string				`json:"createdBy"`
+	CreatedAt			string				`json:"createdAt"`
+	UpdatedAt			string				`json:"updatedAt"`
+	CompletedAt			string				`json:"completedAt,omitempty"`
+}
+
+var repricingIndexStr = "_repricings"
+var defaultRepricingBatch = 100
+
+func get_repricing(stub shim.ChaincodeStubInterface, repricingId string) (Repricing, error) {
+
+	var repricing Repricing
+
+	bytes, err := get_state(stub, repricingId)
+	if err != nil || len(bytes) == 0 {
+		return repricing, errors.New("Could not fetch re-pricing " + repricingId)
+	}
+	err = json.Unmarshal(bytes, &repricing)
+	if err != nil {
+		return repricing, errors.New("Could not unmarshal re-pricing " + repricingId)
+	}
+
+	return repricing, nil
+}
+
+// Moves a scheduled price that has taken effect into the current price, keeping the old one in the price history
+func apply_scheduled_price(tr *Track, now time.Time) {
+
+	if tr.ScheduledPrice == nil {
+		return
+	}
+	effectiveAt, _ := time.Parse(time.RFC3339, tr.ScheduledPrice.EffectiveAt)
+	if now.Before(effectiveAt) {
+		return
+	}
+
+	tr.PriceHistory = append(tr.PriceHistory, PastPrice{Until: tr.ScheduledPrice.EffectiveAt, Price: tr.Price, TerritoryPrices: tr.TerritoryPrices})
+	tr.Price			= tr.ScheduledPrice.Price
+	tr.TerritoryPrices	= tr.ScheduledPrice.TerritoryPrices
+	tr.ScheduledPrice	= nil
+}
+
+// Checks a track falls under the filter of a re-pricing
+func repricing_matches(filter RepricingFilter, tr Track) bool {
+
+	if filter.Artist != "" && tr.Artist != filter.Artist {
+		return false
+	}
+	if filter.Owner != "" && tr.Owner != filter.Owner {
+		return false
+	}
+	if filter.Currency != "" && tr.Currency != filter.Currency {
+		return false
+	}
+	if filter.TitlePrefix != "" && !strings.HasPrefix(strings.ToLower(tr.Title), strings.ToLower(filter.TitlePrefix)) {
+		return false
+	}
+
+	return true
+}
+
+// Schedules the price of a re-pricing on a track, replacing a re-pricing scheduled earlier that has not taken effect
+func reprice_track(stub shim.ChaincodeStubInterface, repricing Repricing, tr Track, trackId string, now time.Time) error {
+
+	apply_scheduled_price(&tr, now)
+
+	scheduled := ScheduledPrice{EffectiveAt: repricing.EffectiveAt, RepricingId: repricing.Id}
+	if repricing.Price != nil {
+		scheduled.Price				= *repricing.Price
+		scheduled.TerritoryPrices	= repricing.TerritoryPrices
+	} else {
+		scheduled.Price = tr.Price + tr.Price*repricing.ChangeBps/10000
+		if len(tr.TerritoryPrices) > 0 {
+			scheduled.TerritoryPrices = map[string]int64{}
+			for territory, price := range tr.TerritoryPrices {
+				scheduled.TerritoryPrices[territory] = price + price*repricing.ChangeBps/10000
+			}
+		}
+	}
+	tr.ScheduledPrice = &scheduled
+
+	cfg, err := get_config(stub)
+	if err != nil {
+		return err
+	}
+	err = check_price_policy(stub, cfg, tr.Currency, scheduled.Price, scheduled.TerritoryPrices)
+	if err != nil {
+		return wrap_error("Track "+trackId+": ", err)
+	}
+
+	return put_track(stub, trackId, tr)
+}
+
+// Scans the next batch of tracks after the cursor of the re-pricing
+func run_repricing(stub shim.ChaincodeStubInterface, repricing *Repricing, batch int, now time.Time) error {
+
+	// a label's catalog is the tracks of the artists on its roster
+	var roster map[string]bool
+	if repricing.LabelId != "" {
+		artistIds, err := get_index_ids(stub, roster_index_str(repricing.LabelId))
+		if err != nil {
+			return err
+		}
+		roster = map[string]bool{}
+		for _, artistId := range artistIds {
+			roster[artistId] = true
+		}
+	}
+
+	keysIter, err := index_iterator_after(stub, trackIndexStr, repricing.Cursor)
+	if err != nil {
+		return err
+	}
+	defer keysIter.Close()
+
+	prefix := index_key(trackIndexStr, "")
+	var trackIds []string
+	for keysIter.HasNext() && len(trackIds) <= batch {
+		key, _, err := keysIter.Next()
+		if err != nil {
+			return errors.New("Failed to iterate " + trackIndexStr + " index")
+		}
+		trackIds = append(trackIds, key[len(prefix):])
+	}
+
+	// one track more than the batch was read to learn whether the re-pricing is done
+	done := len(trackIds) <= batch
+	if !done {
+		trackIds = trackIds[:batch]
+	}
+	for _, trackId := range trackIds {
+		tr, err := fetch_track(stub, trackId)
+		if err != nil {
+			return err
+		}
+		repricing.Cursor = trackId
+		repricing.Scanned++
+		if roster != nil && !roster[tr.Artist] {
+			continue
+		}
+		if !repricing_matches(repricing.Filter, tr) {
+			continue
+		}
+		err = reprice_track(stub, *repricing, tr, trackId, now)
+		if err != nil {
+			return err
+		}
+		repricing.Repriced++
+	}
+	if done {
+		repricing.Status		= "done"
+		repricing.CompletedAt	= now.Format(time.RFC3339)
+	}
+	repricing.UpdatedAt = now.Format(time.RFC3339)
+
+	repricingBytes, _ := json.Marshal(repricing)
+	err = put_state(stub, repricing.Id, repricingBytes)
+	if err != nil {
+		return errors.New("Error putting re-pricing " + repricing.Id + " on ledger")
+	}
+
+	return nil
+}
+
+// Parses an optional batch size argument
+func repricing_batch_arg(args []string, i int) (int, error) {
+
+	if len(args) <= i || args[i] == "" {
+		return defaultRepricingBatch, nil
+	}
+	size, err := strconv.Atoi(args[i])
+	if err != nil || size < 1 {
+		return 0, errors.New("Invalid batch size " + args[i])
+	}
+
+	return size, nil
+}
+
+//==============================================================================================================================
+//  Invoke Functions
+//==============================================================================================================================
+
+// Starts a re-pricing and runs its first batch
+func (t *SimpleChaincode) reprice_tracks(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0																		1
+	//		re-pricing JSON object (as string, labelId, filter, price and			batch size (optional, tracks per call)
+	//		territoryPrices or changeBps, and effectiveAt)
+
+	if len(args) < 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting re-pricing JSON")
+	}
+	batch, err := repricing_batch_arg(args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	var repricing Repricing
+	err = json.Unmarshal([]byte(args[0]), &repricing)
+	if err != nil {
+		return nil, errors.New("Could not unmarshal re-pricing: " + err.Error())
+	}
+	if (repricing.Price == nil) == (repricing.ChangeBps == 0) {
+		return nil, errors.New("A re-pricing sets either a price or a changeBps")
+	}
+	if repricing.Price != nil {
+		if *repricing.Price < 0 {
+			return nil, errors.New("Price cannot be negative")
+		}
+		err = validate_territory_prices(repricing.TerritoryPrices)
+		if err != nil {
+			return nil, err
+		}
+	} else if len(repricing.TerritoryPrices) > 0 {
+		return nil, errors.New("territoryPrices can only be set together with a price")
+	}
+	if repricing.ChangeBps < -10000 {
+		return nil, errors.New("changeBps cannot take more than the whole price off")
+	}
+
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := get_tx_time(stub, cfg)
+	if err != nil {
+		return nil, err
+	}
+	effectiveAt, err := time.Parse(time.RFC3339, repricing.EffectiveAt)
+	if err != nil {
+		return nil, errors.New("Invalid effectiveAt " + repricing.EffectiveAt + ", expecting RFC3339")
+	}
+	if effectiveAt.Before(now) {
+		return nil, errors.New("Invalid effectiveAt " + repricing.EffectiveAt + ", plays that already happened cannot be re-priced")
+	}
+
+	// an admin re-prices any tracks, a label only its own catalog
+	caller, err := t.get_caller_username(stub)
+	if err != nil {
+		return nil, err
+	}
+	if t.check_caller_role(stub, adminRole) != nil {
+		label, err := get_account(stub, caller)
+		if err != nil {
+			return nil, err
+		}
+		if label.Type != "label" {
+			return nil, errors.New("Only admins and labels can re-price tracks")
+		}
+		if repricing.LabelId != caller {
+			return nil, errors.New("A label can only re-price its own catalog")
+		}
+	}
+
+	repricingId, err := append_id(stub, repricingIndexStr, "rp", true)
+	if err != nil {
+		return nil, errors.New("Error creating new id for re-pricing")
+	}
+	repricing.Id			= string(repricingId)
+	repricing.EffectiveAt	= effectiveAt.UTC().Format(time.RFC3339)
+	repricing.Status		= "running"
+	repricing.Cursor		= ""
+	repricing.Scanned		= 0
+	repricing.Repriced		= 0
+	repricing.CreatedBy		= caller
+	repricing.CreatedAt		= now.Format(time.RFC3339)
+	repricing.CompletedAt	= ""
+
+	err = run_repricing(stub, &repricing, batch, now)
+	if err != nil {
+		return nil, err
+	}
+
+	repricingBytes, _ := json.Marshal(repricing)
+
+	return repricingBytes, nil
+}
+
+// Runs the next batch of a re-pricing, a call on a finished re-pricing just returns it
+func (t *SimpleChaincode) continue_repricing(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0				1
+	//		repricingId		batch size (optional, tracks per call)
+
+	if len(args) < 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting repricingId")
+	}
+	batch, err := repricing_batch_arg(args, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	repricing, err := get_repricing(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	caller, err := t.get_caller_username(stub)
+	if err != nil {
+		return nil, err
+	}
+	if caller != repricing.CreatedBy && t.check_caller_role(stub, adminRole) != nil {
+		return nil, errors.New("Only " + repricing.CreatedBy + " or an admin can continue re-pricing " + repricing.Id)
+	}
+
+	if repricing.Status != "done" {
+		cfg, err := get_config(stub)
+		if err != nil {
+			return nil, err
+		}
+		now, err := get_tx_time(stub, cfg)
+		if err != nil {
+			return nil, err
+		}
+		err = run_repricing(stub, &repricing, batch, now)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	repricingBytes, _ := json.Marshal(repricing)
+
+	return repricingBytes, nil
+}
+
+//==============================================================================================================================
+//		Query Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) query_repricing(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			1
+	//		repricingId
+
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting repricingId")
+	}
+
+	repricing, err := get_repricing(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	repricingBytes, _ := json.Marshal(repricing)
+
+	return repricingBytes, nil
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+)
+
+//==============================================================================================================================
+//	 Research Exports - The operator shares play and revenue data of a period with research partners without exposing
+//						who played or earned what: every account id in the export is replaced by a pseudonym, the
+//						HMAC-SHA256 of the id keyed with the operator's research key. The same key gives the same
+//						pseudonyms in every export, so partners can follow an account across periods without learning
+//						who it is. Tracks and albums are public catalog entries and are exported as they are.
+//
+//						The shim has no private data, anything stored on the ledger is readable by every peer, so the
+//						key itself is never stored: the admin passes it with the query, which is not recorded on the
+//						ledger, and the configuration holds its SHA-256 as researchKeyHash. An export with another key
+//						is refused, it would silently give different pseudonyms.
+//==============================================================================================================================
+type ResearchPlay struct {
+	PlayId				string		`json:"playId"`
+	TrackId				string		`json:"trackId,omitempty"`
+	AlbumId				string		`json:"albumId,omitempty"`
+	Listener			string		`json:"listener"`					// pseudonym of the account that played
+	Distributor			string		`json:"distributor,omitempty"`		// pseudonym of the distributor it was submitted through
+	Timestamp			string		`json:"timestamp"`
+	Territory			string		`json:"territory,omitempty"`
+	UsageType			string		`json:"usageType,omitempty"`
+	Source				string		`json:"source"`
+	Amount				int64		`json:"amount"`
+	Currency			string		`json:"currency"`
+	Late				bool		`json:"late"`
+}
+
+type ResearchRevenue struct {
+	PlayId				string		`json:"playId"`
+	TrackId				string		`json:"trackId,omitempty"`
+	AlbumId				string		`json:"albumId,omitempty"`
+	Payer				string		`json:"payer"`						// pseudonym
+	Payee				string		`json:"payee"`						// pseudonym
+	Rights				string		`json:"rights,omitempty"`
+	Fee					string		`json:"fee,omitempty"`
+	Amount				int64		`json:"amount"`
+	Currency			string		`json:"currency"`
+}
+
+type ResearchExport struct {
+	Period				string				`json:"period"`
+	Dataset				string				`json:"dataset"`
+	Plays				[]ResearchPlay		`json:"plays,omitempty"`
+	Revenue				[]ResearchRevenue	`json:"revenue,omitempty"`
+	Bookmark			string				`json:"bookmark"`		// pass back to get the next page, empty on the last page
+}
+
+var ResearchDatasets = map[string]bool{
+	"plays":	true,
+	"revenue":	true,
+}
+
+// Stable pseudonym of an account id under the research key
+func pseudonym(key []byte, accountId string) string {
+
+	if accountId == "" {
+		return ""
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(accountId))
+
+	return hex.EncodeToString(mac.Sum(nil))[:32]
+}
+
+// Checks the key is the one registered as researchKeyHash
+func check_research_key(cfg Config, key string) error {
+
+	if cfg.ResearchKeyHash == "" {
+		return errors.New("No researchKeyHash is configured, set the hash of the research key before exporting")
+	}
+	hash := sha256.Sum256([]byte(key))
+	if !hmac.Equal([]byte(hex.EncodeToString(hash[:])), []byte(cfg.ResearchKeyHash)) {
+		return errors.New("Invalid research key, it does not match researchKeyHash")
+	}
+
+	return nil
+}
+
+//==============================================================================================================================
+//		Query Functions
+//==============================================================================================================================
+
+// Pages through the plays of a period, or the payments they created, with every account pseudonymized
+func (t *SimpleChaincode) get_research_export(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			1				2							3				4				5
+	//		periodId		dataset (plays or revenue)	research key	bookmark		page size (optional)
+
+	if len(args) < 4 {
+		return nil, errors.New("Incorrect number of arguments. Expecting periodId, dataset and research key")
+	}
+	if !ResearchDatasets[args[2]] {
+		return nil, errors.New("Research dataset not recognized: " + args[2])
+	}
+	var bookmark string
+	if len(args) > 4 {
+		bookmark = args[4]
+	}
+	pageSize, err := page_size_arg(args, 5)
+	if err != nil {
+		return nil, err
+	}
+
+	err = t.check_caller_role(stub, adminRole)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	err = check_research_key(cfg, args[3])
+	if err != nil {
+		return nil, err
+	}
+	key := []byte(args[3])
+
+	indexStr := period_plays_index_str(args[1])
+	keysIter, err := index_iterator_after(stub, indexStr, bookmark)
+	if err != nil {
+		return nil, err
+	}
+	defer keysIter.Close()
+
+	export := ResearchExport{Period: args[1], Dataset: args[2]}
+	prefix := index_key(indexStr, "")
+	count := 0
+	more := false
+
+	for keysIter.HasNext() {
+		indexKey, _, err := keysIter.Next()
+		if err != nil {
+			return nil, errors.New("Failed to iterate " + indexStr + " index")
+		}
+		if count == pageSize {
+			more = true
+			break
+		}
+		playId := indexKey[len(prefix):]
+		export.Bookmark = playId
+		count++
+
+		playBytes, err := get_state(stub, playId)
+		if err != nil || len(playBytes) == 0 {
+			return nil, errors.New("Could not fetch play " + playId)
+		}
+		var play Play
+		err = json.Unmarshal(playBytes, &play)
+		if err != nil {
+			return nil, errors.New("Could not unmarshal play " + playId)
+		}
+
+		if export.Dataset == "plays" {
+			export.Plays = append(export.Plays, ResearchPlay{
+				PlayId:			play.Id,
+				TrackId:		play.TrackId,
+				AlbumId:		play.AlbumId,
+				Listener:		pseudonym(key, play.PlayedBy),
+				Distributor:	pseudonym(key, play.Distributor),
+				Timestamp:		play.Timestamp,
+				Territory:		play.Territory,
+				UsageType:		play.UsageType,
+				Source:			play.Source,
+				Amount:			play.Amount,
+				Currency:		currency_or_default(cfg, play.Currency),
+				Late:			play.Late,
+			})
+			continue
+		}
+		err = open_play_terms(stub, &play)
+		if err != nil {
+			return nil, err
+		}
+		for _, payment := range play.Payments {
+			export.Revenue = append(export.Revenue, ResearchRevenue{
+				PlayId:		play.Id,
+				TrackId:	play.TrackId,
+				AlbumId:	play.AlbumId,
+				Payer:		pseudonym(key, payment.SenderId),
+				Payee:		pseudonym(key, payment.RecipientId),
+				Rights:		payment.Rights,
+				Fee:		payment.Fee,
+				Amount:		payment.Amount,
+				Currency:	payment_currency(cfg, payment),
+			})
+		}
+	}
+	if !more {
+		export.Bookmark = ""
+	}
+
+	exportBytes, _ := json.Marshal(export)
+
+	return exportBytes, nil
+}
+
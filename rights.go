@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"regexp"
+	"strings"
+)
+
+//==============================================================================================================================
+//	 Rights - A track can carry its two rights separately: the Recording (master rights, identified by the ISRC) and the
+//			  Composition (publishing rights, identified by the ISWC), each with its own beneficiaries. A play of such a
+//			  track is split between the two sides by the configured master share before each side is divided among
+//			  its beneficiaries. Tracks without separate rights keep paying their flat beneficiary list.
+//			  Works are indexed by ISWC so publishing administrators can find the tracks of a work in their catalog.
+//==============================================================================================================================
+type Recording struct {
+	Isrc				string			`json:"isrc"`
+	Beneficiaries		[]Beneficiary	`json:"beneficiaries"`
+}
+
+type Composition struct {
+	Iswc				string			`json:"iswc"`
+	Ipi					string			`json:"ipi"`
+	Title				string			`json:"title,omitempty"`
+	Writers				[]Writer		`json:"writers,omitempty"`		// for works registered through import_works
+	Beneficiaries		[]Beneficiary	`json:"beneficiaries"`
+}
+
+var iswcIndexStr = "iswc"
+
+var iswcPattern = regexp.MustCompile(`^T[0-9]{10}$`)
+
+// Per-work index of the tracks recording it, "iswc~<iswc>~<trackId>"
+func iswc_tracks_index_str(iswc string) string {
+	return index_key(iswcIndexStr, iswc)
+}
+
+// An ISWC is T-DDD.DDD.DDD-C, nine digits identifying the work and a check digit. The normalized form is upper case
+// without separators, e.g. T0345246801.
+func normalize_iswc(iswc string) (string, error) {
+
+	normalized := strings.ToUpper(strings.NewReplacer("-", "", ".", "", " ", "").Replace(iswc))
+	if !iswcPattern.MatchString(normalized) {
+		return "", new_error("bad_arguments", "iswc.invalid", map[string]string{"iswc": iswc})
+	}
+
+	sum := 1
+	for i := 1; i <= 9; i++ {
+		sum += i * int(normalized[i]-'0')
+	}
+	if int(normalized[10]-'0') != (10-sum%10)%10 {
+		return "", new_error("bad_arguments", "iswc.check_digit", map[string]string{"iswc": iswc})
+	}
+
+	return normalized, nil
+}
+
+// ISWC of the work a track records, the composition's when the track has separate rights
+func work_iswc(tr Track) string {
+
+	if tr.Composition != nil && tr.Composition.Iswc != "" {
+		return tr.Composition.Iswc
+	}
+
+	return tr.Iswc
+}
+
+func index_track_work(stub shim.ChaincodeStubInterface, trackId string, oldIswc string, newIswc string) error {
+
+	if oldIswc == newIswc {
+		return nil
+	}
+	if oldIswc != "" {
+		normalized, err := normalize_iswc(oldIswc)
+		if err == nil {
+			err = remove_from_index(stub, iswc_tracks_index_str(normalized), trackId)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	normalized, err := normalize_iswc(newIswc)
+	if err != nil {
+		return err
+	}
+
+	return add_to_index(stub, iswc_tracks_index_str(normalized), trackId)
+}
+
+func has_separate_rights(tr Track) bool {
+	return tr.Recording != nil || tr.Composition != nil
+}
+
+// Validates the beneficiaries of a track, either the flat list or both rights
+func validate_track_rights(tr Track) error {
+
+	err := check_work_for_hire_splits(tr)
+	if err != nil {
+		return err
+	}
+
+	if !has_separate_rights(tr) {
+		return validate_beneficiaries(tr.Beneficiaries)
+	}
+
+	if tr.Recording == nil || tr.Composition == nil {
+		return errors.New("A track with separate rights needs both a recording and a composition")
+	}
+	if len(tr.Beneficiaries) > 0 {
+		return errors.New("A track with separate rights cannot also have a flat beneficiary list")
+	}
+	err = validate_beneficiaries(tr.Recording.Beneficiaries)
+	if err != nil {
+		return wrap_error("Recording: ", err)
+	}
+	err = validate_beneficiaries(tr.Composition.Beneficiaries)
+	if err != nil {
+		return wrap_error("Composition: ", err)
+	}
+
+	return nil
+}
+
+// The beneficiary standing in for the track when no other account is given, the main master rights holder
+func main_beneficiary(tr Track) string {
+
+	if tr.Recording != nil && len(tr.Recording.Beneficiaries) > 0 {
+		return tr.Recording.Beneficiaries[0].AccountId
+	}
+	if len(tr.Beneficiaries) > 0 {
+		return tr.Beneficiaries[0].AccountId
+	}
+
+	return ""
+}
+
+// Splits an amount between master and publishing rights, publishing gets what the master share leaves
+func split_rights(cfg Config, amount int64) (int64, int64) {
+
+	master := amount * cfg.MasterShareBps / 10000
+
+	return master, amount - master
+}
+
+// Divides an amount among beneficiaries by their percentages, the rounding remainder goes to the first beneficiary so
+// the payouts always add up to the amount
+func beneficiary_payouts(beneficiaries []Beneficiary, amount int64, rights string) []Payment {
+
+	var payouts []Payment
+	var allocated int64
+	for _, b := range beneficiaries {
+		share := (b.Percentage * amount) / 100
+		payouts = append(payouts, Payment{RecipientId: b.AccountId, Amount: share, Rights: rights})
+		allocated += share
+	}
+	if len(payouts) > 0 {
+		payouts[0].Amount += amount - allocated
+	}
+
+	return payouts
+}
+
+// Divides the amount a play of the track pays out among its beneficiaries, only recipient, amount and rights are set
+func rights_payouts(cfg Config, tr Track, amount int64) []Payment {
+
+	if !has_separate_rights(tr) {
+		return beneficiary_payouts(tr.Beneficiaries, amount, "")
+	}
+
+	master, publishing := split_rights(cfg, amount)
+
+	return append(beneficiary_payouts(tr.Recording.Beneficiaries, master, "master"),
+		beneficiary_payouts(tr.Composition.Beneficiaries, publishing, "publishing")...)
+}
+
+// Percentage of the track's earnings going to the account, over both rights
+func beneficiary_share(cfg Config, tr Track, accountId string) int64 {
+
+	share_of := func(beneficiaries []Beneficiary) int64 {
+		var share int64
+		for _, b := range beneficiaries {
+			if b.AccountId == accountId {
+				share += b.Percentage
+			}
+		}
+		return share
+	}
+
+	if !has_separate_rights(tr) {
+		return share_of(tr.Beneficiaries)
+	}
+
+	return (share_of(tr.Recording.Beneficiaries)*cfg.MasterShareBps +
+		share_of(tr.Composition.Beneficiaries)*(10000-cfg.MasterShareBps)) / 10000
+}
+
+//==============================================================================================================================
+//		Query Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) get_tracks_by_iswc(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			1
+	//		iswc
+
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting iswc")
+	}
+
+	iswc, err := normalize_iswc(args[1])
+	if err != nil {
+		return nil, err
+	}
+	trackIds, err := get_index_ids(stub, iswc_tracks_index_str(iswc))
+	if err != nil {
+		return nil, err
+	}
+
+	tracks := []Track{}
+	for _, trackId := range trackIds {
+		tr, err := fetch_track(stub, trackId)
+		if err != nil {
+			return nil, err
+		}
+		tracks = append(tracks, tr)
+	}
+
+	tracksBytes, _ := json.Marshal(tracks)
+
+	return tracksBytes, nil
+}
@@ -0,0 +1,446 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"strconv"
+	"time"
+)
+
+//==============================================================================================================================
+//	 Label Rosters - A label invites an artist to its roster and the artist accepts the invitation on-chain. Membership
+//					 applies the label's default contract to the artist's new tracks, groups the artist's tracks in the
+//					 label catalog and includes the artist in the label's reports.
+//==============================================================================================================================
+type Invitation struct {
+	Id					string		`json:"id"`
+	LabelId				string		`json:"labelId"`
+	ArtistId			string		`json:"artistId"`
+	LabelShare			int64		`json:"labelShare"`			// default contract: percentage of every new track going to the label
+	Status				string		`json:"status"`
+	CreatedAt			string		`json:"createdAt"`
+	RespondedAt			string		`json:"respondedAt,omitempty"`
+}
+
+type LabelReport struct {
+	LabelId				string				`json:"labelId"`
+	Artists				[]ArtistSummary		`json:"artists"`
+	TopTracks			[]DashboardTrack	`json:"topTracks"`
+	TotalEarnings		int64				`json:"totalEarnings"`
+	TotalTips			int64				`json:"totalTips"`
+	PendingPayouts		int64				`json:"pendingPayouts"`
+}
+
+var InvitationStatus = map[string]bool{
+	"pending":	true,
+	"accepted":	true,
+	"declined":	true,
+}
+
+var invitationIndexStr = "_invitations"
+var rosterIndexStr = "roster"
+
+// Per-label index of the artists on its roster
+func roster_index_str(labelId string) string {
+	return index_key(rosterIndexStr, labelId)
+}
+
+// Per-artist index of the invitations sent to the artist
+func artist_invitations_index_str(artistId string) string {
+	return invitationIndexStr + "_" + artistId
+}
+
+// Applies a label's default contract to a split: the label gets its share and the listed beneficiaries
+// share the rest in their original proportions, the rounding remainder goes to the first beneficiary
+func apply_label_contract(beneficiaries []Beneficiary, labelId string, labelShare int64) []Beneficiary {
+
+	if labelId == "" || labelShare <= 0 {
+		return beneficiaries
+	}
+
+	result := []Beneficiary{}
+	var allocated int64
+	for _, b := range beneficiaries {
+		scaled := b.Percentage * (100 - labelShare) / 100
+		allocated += scaled
+		result = append(result, Beneficiary{AccountId: b.AccountId, Percentage: scaled})
+	}
+	if len(result) > 0 {
+		result[0].Percentage += (100 - labelShare) - allocated
+	}
+
+	return append(result, Beneficiary{AccountId: labelId, Percentage: labelShare})
+}
+
+func get_invitation(stub shim.ChaincodeStubInterface, invitationId string) (Invitation, error) {
+
+	var invitation Invitation
+
+	bytes, err := get_state(stub, invitationId)
+	if err != nil || len(bytes) == 0 {
+		return invitation, errors.New("Could not fetch invitation " + invitationId)
+	}
+	err = json.Unmarshal(bytes, &invitation)
+	if err != nil {
+		return invitation, errors.New("Could not unmarshal invitation " + invitationId)
+	}
+
+	return invitation, nil
+}
+
+// Settles a pending invitation addressed to the invoker as accepted or declined
+func (t *SimpleChaincode) respond_to_invitation(stub shim.ChaincodeStubInterface, invitationId string, status string) (Invitation, error) {
+
+	invitation, err := get_invitation(stub, invitationId)
+	if err != nil {
+		return invitation, err
+	}
+
+	caller, err := t.get_caller_username(stub)
+	if err != nil {
+		return invitation, err
+	}
+	if caller != invitation.ArtistId {
+		return invitation, errors.New("Only artist " + invitation.ArtistId + " can respond to invitation " + invitationId)
+	}
+	if invitation.Status != "pending" {
+		return invitation, errors.New("Invitation " + invitationId + " is already " + invitation.Status)
+	}
+
+	cfg, err := get_config(stub)
+	if err != nil {
+		return invitation, err
+	}
+	now, err := get_tx_time(stub, cfg)
+	if err != nil {
+		return invitation, err
+	}
+
+	invitation.Status		= status
+	invitation.RespondedAt	= now.Format(time.RFC3339)
+
+	invitationBytes, _ := json.Marshal(invitation)
+	err = put_state(stub, invitation.Id, invitationBytes)
+	if err != nil {
+		return invitation, errors.New("Error putting invitation " + invitation.Id + " back on ledger")
+	}
+
+	return invitation, nil
+}
+
+//==============================================================================================================================
+//  Invoke Functions
+//==============================================================================================================================
+
+// Invites an artist to the roster of the invoking label, the artist joins once the invitation is accepted
+func (t *SimpleChaincode) add_artist_to_roster(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0				1
+	//		artistId		label share percentage of the default contract
+
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting artistId and label share")
+	}
+	labelShare, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil || labelShare < 0 || labelShare >= 100 {
+		return nil, errors.New("Label share must be a percentage between 0 and 99")
+	}
+
+	labelId, err := t.get_caller_username(stub)
+	if err != nil {
+		return nil, err
+	}
+	label, err := get_account(stub, labelId)
+	if err != nil {
+		return nil, err
+	}
+	if label.Type != "label" {
+		return nil, errors.New("Only label accounts can add artists to a roster")
+	}
+	artist, err := get_account(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if artist.Type != "artist" {
+		return nil, errors.New("Account " + args[0] + " is not an artist account")
+	}
+
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := get_tx_time(stub, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	invitationId, err := append_id(stub, invitationIndexStr, "inv", true)
+	if err != nil {
+		return nil, errors.New("Error creating new id for invitation")
+	}
+	_, err = append_id(stub, artist_invitations_index_str(args[0]), string(invitationId), false)
+	if err != nil {
+		return nil, errors.New("Error indexing invitation for artist " + args[0])
+	}
+
+	var invitation Invitation
+	invitation.Id			= string(invitationId)
+	invitation.LabelId		= labelId
+	invitation.ArtistId		= args[0]
+	invitation.LabelShare	= labelShare
+	invitation.Status		= "pending"
+	invitation.CreatedAt	= now.Format(time.RFC3339)
+
+	invitationBytes, _ := json.Marshal(invitation)
+	err = put_state(stub, invitation.Id, invitationBytes)
+	if err != nil {
+		return nil, errors.New("Error putting invitation on ledger")
+	}
+
+	return invitationId, nil
+}
+
+func (t *SimpleChaincode) accept_invitation(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		invitationId
+
+	if len(args) < 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting invitationId")
+	}
+
+	invitation, err := t.respond_to_invitation(stub, args[0], "accepted")
+	if err != nil {
+		return nil, err
+	}
+
+	artist, err := get_account(stub, invitation.ArtistId)
+	if err != nil {
+		return nil, err
+	}
+
+	// An artist is on one roster at a time, joining a new label leaves the old one
+	if artist.LabelId != "" && artist.LabelId != invitation.LabelId {
+		err = remove_from_index(stub, roster_index_str(artist.LabelId), artist.Id)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = add_to_index(stub, roster_index_str(invitation.LabelId), artist.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	artist.LabelId		= invitation.LabelId
+	artist.LabelShare	= invitation.LabelShare
+
+	err = put_account(stub, artist)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// Takes an artist off a label roster, either the label or the artist can do this
+func (t *SimpleChaincode) remove_artist_from_roster(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		artistId
+
+	if len(args) < 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting artistId")
+	}
+
+	artist, err := get_account(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if artist.LabelId == "" {
+		return nil, errors.New("Artist " + artist.Id + " is not on a roster")
+	}
+
+	caller, err := t.get_caller_username(stub)
+	if err != nil {
+		return nil, err
+	}
+	if caller != artist.LabelId && caller != artist.Id {
+		return nil, errors.New("Only label " + artist.LabelId + " or the artist can remove " + artist.Id + " from the roster")
+	}
+
+	err = remove_from_index(stub, roster_index_str(artist.LabelId), artist.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	artist.LabelId		= ""
+	artist.LabelShare	= 0
+
+	err = put_account(stub, artist)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+func (t *SimpleChaincode) decline_invitation(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		invitationId
+
+	if len(args) < 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting invitationId")
+	}
+
+	_, err := t.respond_to_invitation(stub, args[0], "declined")
+
+	return nil, err
+}
+
+//==============================================================================================================================
+//		Query Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) get_invitations(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			1
+	//		artistId
+
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting artistId")
+	}
+
+	invitationIndex, err := get_appended_ids(stub, artist_invitations_index_str(args[1]))
+	if err != nil {
+		return nil, errors.New("Failed to get invitations for artist " + args[1])
+	}
+
+	invitations := []Invitation{}
+	for _, invitationId := range invitationIndex {
+		invitation, err := get_invitation(stub, invitationId)
+		if err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, invitation)
+	}
+
+	invitationsBytes, _ := json.Marshal(invitations)
+
+	return invitationsBytes, nil
+}
+
+func (t *SimpleChaincode) get_roster(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			1
+	//		labelId
+
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting labelId")
+	}
+
+	artistIds, err := get_index_ids(stub, roster_index_str(args[1]))
+	if err != nil {
+		return nil, err
+	}
+
+	artists := []Account{}
+	for _, artistId := range artistIds {
+		artist, err := get_account(stub, artistId)
+		if err != nil {
+			return nil, err
+		}
+		artists = append(artists, artist)
+	}
+
+	artistsBytes, _ := json.Marshal(artists)
+
+	return artistsBytes, nil
+}
+
+func (t *SimpleChaincode) get_label_catalog(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			1
+	//		labelId
+
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting labelId")
+	}
+
+	artistIds, err := get_index_ids(stub, roster_index_str(args[1]))
+	if err != nil {
+		return nil, err
+	}
+
+	catalog := map[string][]Track{}
+	for _, artistId := range artistIds {
+
+		trackIds, err := get_index_ids(stub, artist_tracks_index_str(artistId))
+		if err != nil {
+			return nil, err
+		}
+
+		catalog[artistId] = []Track{}
+		for _, trackId := range trackIds {
+			bytes, err := get_state(stub, trackId)
+			if err != nil {
+				return nil, errors.New("Unable to get track with ID: " + trackId)
+			}
+			var tr Track
+			json.Unmarshal(bytes, &tr)
+			catalog[artistId] = append(catalog[artistId], tr)
+		}
+	}
+
+	catalogBytes, _ := json.Marshal(catalog)
+
+	return catalogBytes, nil
+}
+
+func (t *SimpleChaincode) get_label_report(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			1
+	//		labelId
+
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting labelId")
+	}
+
+	artistIds, err := get_index_ids(stub, roster_index_str(args[1]))
+	if err != nil {
+		return nil, err
+	}
+
+	var report LabelReport
+	report.LabelId = args[1]
+	report.Artists = []ArtistSummary{}
+	tracks := []DashboardTrack{}
+
+	for _, artistId := range artistIds {
+
+		summary, artistTracks, err := artist_summary(stub, artistId)
+		if err != nil {
+			return nil, err
+		}
+
+		report.TotalEarnings += summary.TotalEarnings
+		report.TotalTips += summary.TotalTips
+		report.PendingPayouts += summary.PendingPayouts
+		report.Artists = append(report.Artists, summary)
+		tracks = append(tracks, artistTracks...)
+	}
+	report.TopTracks = top_tracks(tracks, dashboardTopTracks)
+
+	reportBytes, _ := json.Marshal(report)
+
+	return reportBytes, nil
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//==============================================================================================================================
+//	 Sandbox - Integrators test against a real channel without financial risk. Transactions of a sandbox tenant, a
+//			   distributor whose configuration has sandbox set or any submitter when the platform configuration has,
+//			   run against the tenant's own copy of the state: every key they read or write is prefixed with
+//			   "sandbox~<tenant>~", the platform itself is tenant "platform". The platform configuration, distributor
+//			   configurations and rate cards are shared: a sandbox reads them from the platform state until it writes
+//			   its own. Money in a sandbox is synthetic, test credits are minted freely, and its events are marked as
+//			   sandbox events. The platform purges a tenant's sandbox wholesale with purge_sandbox.
+//==============================================================================================================================
+type SandboxPurge struct {
+	Tenant				string		`json:"tenant"`
+	Deleted				int			`json:"deleted"`
+	Done				bool		`json:"done"`				// false when keys are left, call again to continue
+}
+
+var sandboxIndexStr = "sandbox"
+var platformSandboxTenant = "platform"
+var defaultSandboxPurgeBatch = 500
+
+// Keys a sandbox reads from the platform state until it writes its own
+var sandboxSharedKeyPrefixes = []string{configKey, distributorKeyPrefix, "_rate_card_", exchangeRateKeyPrefix}
+
+// Key prefixes of the sandboxed transactions in flight, by tx id
+var txSandboxes = struct {
+	sync.Mutex
+	prefixes map[string]string
+}{prefixes: map[string]string{}}
+
+func sandbox_prefix(tenant string) string {
+	return index_key(index_key(sandboxIndexStr, tenant), "")
+}
+
+// Runs the current transaction in the sandbox of its submitter when that is a sandbox tenant
+func (t *SimpleChaincode) begin_sandbox(stub shim.ChaincodeStubInterface, cfg Config) error {
+
+	tenant := ""
+	if cfg.Sandbox {
+		tenant = platformSandboxTenant
+	}
+
+	caller, err := t.get_caller_username(stub)
+	if err == nil {
+		dist, found, err := get_distributor_config(stub, caller)
+		if err != nil {
+			return err
+		}
+		if found && dist.Sandbox {
+			tenant = dist.DistributorId
+		}
+	}
+
+	if tenant != "" {
+		txSandboxes.Lock()
+		txSandboxes.prefixes[stub.GetTxID()] = sandbox_prefix(tenant)
+		txSandboxes.Unlock()
+	}
+
+	return nil
+}
+
+func end_sandbox(stub shim.ChaincodeStubInterface) {
+	txSandboxes.Lock()
+	delete(txSandboxes.prefixes, stub.GetTxID())
+	txSandboxes.Unlock()
+}
+
+// Returns the key prefix of the sandbox the current transaction runs in, empty outside a sandbox
+func current_sandbox(stub shim.ChaincodeStubInterface) string {
+	txSandboxes.Lock()
+	defer txSandboxes.Unlock()
+	return txSandboxes.prefixes[stub.GetTxID()]
+}
+
+func is_sandbox_shared_key(key string) bool {
+	for _, prefix := range sandboxSharedKeyPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Range iterator handing out the keys of a sandbox without its prefix
+type sandboxRangeIterator struct {
+	stateRangeIterator
+	prefix				string
+}
+
+func (it sandboxRangeIterator) Next() (string, []byte, error) {
+
+	key, value, err := it.stateRangeIterator.Next()
+	if err != nil {
+		return "", nil, err
+	}
+
+	return strings.TrimPrefix(key, it.prefix), value, nil
+}
+
+//==============================================================================================================================
+//  Invoke Functions
+//==============================================================================================================================
+
+// Credits an account with synthetic funds, only in a sandbox
+func (t *SimpleChaincode) mint_sandbox_credits(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0			1			2
+	//		accountId	amount		currency (optional, defaults to the platform currency)
+
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting accountId and amount")
+	}
+	if current_sandbox(stub) == "" {
+		return nil, errors.New("Only sandbox tenants can mint test credits")
+	}
+	amount, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil || amount <= 0 {
+		return nil, errors.New("Invalid amount " + args[1])
+	}
+
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	currency := cfg.Currency
+	if len(args) > 2 && args[2] != "" {
+		if !is_currency_code(args[2]) {
+			return nil, errors.New("Invalid currency " + args[2] + ", expecting an ISO 4217 code")
+		}
+		currency = args[2]
+	}
+
+	account, err := get_account(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	adjust_balance(cfg, &account, currency, amount)
+
+	err = put_account(stub, account)
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(strconv.FormatInt(balance_in(cfg, account, currency), 10)), nil
+}
+
+// Deletes the state of a tenant's sandbox, a batch of keys at a time
+func (t *SimpleChaincode) purge_sandbox(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0				1
+	//		tenant		batch size (optional)
+
+	if len(args) < 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting tenant")
+	}
+	if current_sandbox(stub) != "" {
+		return nil, errors.New("Only the platform account can purge sandboxes, from outside a sandbox")
+	}
+	batch := defaultSandboxPurgeBatch
+	if len(args) > 1 && args[1] != "" {
+		size, err := strconv.Atoi(args[1])
+		if err != nil || size < 1 {
+			return nil, errors.New("Invalid batch size " + args[1])
+		}
+		batch = size
+	}
+
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	err = t.check_platform_access(stub, cfg, "purge sandboxes")
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := sandbox_prefix(args[0])
+	keysIter, err := range_query_state(stub, prefix, prefix+"\xff")
+	if err != nil {
+		return nil, errors.New("Failed to range query sandbox " + args[0])
+	}
+	defer keysIter.Close()
+
+	purge := SandboxPurge{Tenant: args[0], Done: true}
+	var keys []string
+	for keysIter.HasNext() {
+		key, _, err := keysIter.Next()
+		if err != nil {
+			return nil, errors.New("Failed to iterate sandbox " + args[0])
+		}
+		if len(keys) == batch {
+			purge.Done = false
+			break
+		}
+		keys = append(keys, key)
+	}
+	for _, key := range keys {
+		err = del_state(stub, key)
+		if err != nil {
+			return nil, errors.New("Error deleting " + key + " from sandbox " + args[0])
+		}
+	}
+	purge.Deleted = len(keys)
+
+	purgeBytes, _ := json.Marshal(purge)
+
+	return purgeBytes, nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+//==============================================================================================================================
+//	 Payload Schemas - add_account and create_track take a JSON document. It is decoded into the typed struct and the
+//					   fields the platform relies on are checked before anything is written, so a malformed document is
+//					   rejected with an error naming the offending field instead of being stored and failing later.
+//					   Field errors carry the object and the field, with the index for beneficiaries, as parameters.
+//==============================================================================================================================
+
+// Decodes a payload, a value of the wrong type is reported for its field
+func decode_payload(object string, payload string, v interface{}) error {
+
+	err := json.Unmarshal([]byte(payload), v)
+	if typeErr, ok := err.(*json.UnmarshalTypeError); ok {
+		return new_error("bad_arguments", "field.type", map[string]string{"object": object, "field": typeErr.Field, "expected": typeErr.Type.String()})
+	}
+	if err != nil {
+		return new_error("bad_arguments", "payload.malformed", map[string]string{"object": object, "detail": err.Error()})
+	}
+
+	return nil
+}
+
+func field_required(object string, field string) error {
+	return new_error("bad_arguments", "field.required", map[string]string{"object": object, "field": field})
+}
+
+func field_negative(object string, field string) error {
+	return new_error("bad_arguments", "field.negative", map[string]string{"object": object, "field": field})
+}
+
+func field_not_allowed(object string, field string) error {
+	return new_error("bad_arguments", "field.not_allowed", map[string]string{"object": object, "field": field})
+}
+
+// Fields the ledger keeps for an account, a new account cannot bring its own money, debts, label or terms
+func validate_new_account_payload(account Account) error {
+
+	if account.Balance != 0 {
+		return field_not_allowed("account", "balance")
+	}
+	if len(account.Balances) > 0 {
+		return field_not_allowed("account", "balances")
+	}
+	if len(account.PendingPayments) > 0 {
+		return field_not_allowed("account", "pendingPayments")
+	}
+	if account.Frozen || account.FrozenReason != "" || account.FrozenAt != "" {
+		return field_not_allowed("account", "frozen")
+	}
+	if account.LabelId != "" {
+		return field_not_allowed("account", "labelId")
+	}
+	if account.TermsHash != "" {
+		return field_not_allowed("account", "termsHash")
+	}
+
+	return nil
+}
+
+func validate_account_payload(account Account) error {
+
+	if account.Id == "" {
+		return field_required("account", "id")
+	}
+	err := check_record_id("account", account.Id)
+	if err != nil {
+		return err
+	}
+	if account.Name == "" {
+		return field_required("account", "name")
+	}
+	if account.Balance < 0 {
+		return field_negative("account", "balance")
+	}
+	if account.Currency != "" && !is_currency_code(account.Currency) {
+		return new_error("bad_arguments", "field.invalid", map[string]string{"object": "account", "field": "currency", "value": account.Currency})
+	}
+	if account.PreferredCurrency != "" && !is_currency_code(account.PreferredCurrency) {
+		return new_error("bad_arguments", "field.invalid", map[string]string{"object": "account", "field": "preferredCurrency", "value": account.PreferredCurrency})
+	}
+	if account.LabelShare < 0 || account.LabelShare > 100 {
+		return new_error("bad_arguments", "field.invalid", map[string]string{"object": "account", "field": "labelShare", "value": strconv.FormatInt(account.LabelShare, 10)})
+	}
+	if account.PayoutThreshold < 0 {
+		return field_negative("account", "payoutThreshold")
+	}
+
+	return nil
+}
+
+func validate_track_payload(tr Track) error {
+
+	if tr.Iswc == "" {
+		return field_required("track", "iswc")
+	}
+	err := check_record_id("track", tr.Iswc)
+	if err != nil {
+		return err
+	}
+	if tr.Isrc == "" {
+		return field_required("track", "isrc")
+	}
+	if tr.Title == "" {
+		return field_required("track", "title")
+	}
+	if tr.Content == "" {
+		return field_required("track", "content")
+	}
+	if tr.Price < 0 {
+		return field_negative("track", "price")
+	}
+
+	splits := map[string][]Beneficiary{"beneficiaries": tr.Beneficiaries}
+	if tr.Recording != nil {
+		splits["recording.beneficiaries"] = tr.Recording.Beneficiaries
+	}
+	if tr.Composition != nil {
+		splits["composition.beneficiaries"] = tr.Composition.Beneficiaries
+	}
+	for _, field := range []string{"beneficiaries", "recording.beneficiaries", "composition.beneficiaries"} {
+		for i, b := range splits[field] {
+			at := field + "[" + strconv.Itoa(i) + "]"
+			if b.AccountId == "" && b.Placeholder == "" {
+				return field_required("track", at+".accountId")
+			}
+			if b.Percentage <= 0 {
+				return new_error("bad_arguments", "field.not_positive", map[string]string{"object": "track", "field": at + ".percentage"})
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"strconv"
+	"time"
+)
+
+//==============================================================================================================================
+//	 Schema Upgrades - The ledger records the schema version its tracks and accounts are in. A chaincode upgrade that
+//					   changes how they are stored raises currentSchemaVersion and adds the rewrite of a record from the
+//					   version before, and an admin then runs migrate until it reports done. Like a re-pricing each call
+//					   rewrites a batch of tracks, then of accounts, and records where it stopped. Once every record is
+//					   rewritten the ledger's schema version is raised. A ledger without a recorded version is at
+//					   version 1, a bootstrapped ledger starts at the current version.
+//
+//					   Version 2: empty track statuses become active, tracks registered before the content index get
+//					   their content indexed, and accounts without a type get the one their records show: a label
+//					   has a roster, an artist has tracks or a label. An account showing neither keeps an empty type
+//					   for an admin to set, nothing tells a listener apart from an artist who has not released yet.
+//					   New accounts need a type once the ledger is at version 2.
+//==============================================================================================================================
+type SchemaMigration struct {
+	FromVersion			int			`json:"fromVersion"`
+	ToVersion			int			`json:"toVersion"`
+	Phase				string		`json:"phase"`					// tracks, accounts or done
+	Cursor				string		`json:"cursor,omitempty"`		// last record rewritten in the phase
+	Tracks				int			`json:"tracks"`					// records scanned
+	Accounts			int			`json:"accounts"`
+	Rewritten			int			`json:"rewritten"`				// records that changed
+	StartedBy			string		`json:"startedBy"`
+	StartedAt			string		`json:"startedAt"`
+	UpdatedAt			string		`json:"updatedAt"`
+	CompletedAt			string		`json:"completedAt,omitempty"`
+}
+
+type SchemaStatus struct {
+	Version				int					`json:"version"`			// the version the ledger is in
+	Current				int					`json:"current"`			// the version this chaincode writes
+	Migration			*SchemaMigration	`json:"migration,omitempty"`	// the last migration run
+}
+
+var currentSchemaVersion = 2
+var schemaVersionKey = "_schema_version"
+var schemaMigrationKey = "_schema_migration"
+var defaultMigrationBatch = 100
+
+func get_schema_version(stub shim.ChaincodeStubInterface) (int, error) {
+
+	bytes, err := get_state(stub, schemaVersionKey)
+	if err != nil {
+		return 0, errors.New("Failed to get " + schemaVersionKey)
+	}
+	if len(bytes) == 0 {
+		return 1, nil
+	}
+
+	return strconv.Atoi(string(bytes))
+}
+
+func put_schema_version(stub shim.ChaincodeStubInterface, version int) error {
+
+	err := put_state(stub, schemaVersionKey, []byte(strconv.Itoa(version)))
+	if err != nil {
+		return errors.New("Error putting " + schemaVersionKey + " on ledger")
+	}
+
+	return nil
+}
+
+// Returns the last migration run, nil when none ever ran
+func get_schema_migration(stub shim.ChaincodeStubInterface) (*SchemaMigration, error) {
+
+	bytes, err := get_state(stub, schemaMigrationKey)
+	if err != nil {
+		return nil, errors.New("Failed to get " + schemaMigrationKey)
+	}
+	if len(bytes) == 0 {
+		return nil, nil
+	}
+	var migration SchemaMigration
+	err = json.Unmarshal(bytes, &migration)
+	if err != nil {
+		return nil, errors.New("Could not unmarshal " + schemaMigrationKey)
+	}
+
+	return &migration, nil
+}
+
+// Rewrites a track from the schema version before to the next one, reports whether it changed
+func upgrade_track(stub shim.ChaincodeStubInterface, trackId string, tr *Track, to int) (bool, error) {
+
+	changed := false
+	if to == 2 {
+		if tr.Status == "" {
+			tr.Status, changed = TrackActive, true
+		}
+		if tr.Content != "" {
+			indexed, err := get_state(stub, index_key(contentIndexStr, tr.Content))
+			if err != nil {
+				return false, errors.New("Failed to get content index")
+			}
+			if len(indexed) == 0 {
+				err = index_track_content(stub, trackId, "", tr.Content)
+				if err != nil {
+					return false, err
+				}
+			}
+		}
+	}
+
+	return changed, nil
+}
+
+// True when an index has at least one id
+func index_has_ids(stub shim.ChaincodeStubInterface, indexStr string) (bool, error) {
+
+	prefix := index_key(indexStr, "")
+	keysIter, err := range_query_state(stub, prefix, prefix+"\xff")
+	if err != nil {
+		return false, errors.New("Failed to range query " + indexStr + " index")
+	}
+	defer keysIter.Close()
+
+	return keysIter.HasNext(), nil
+}
+
+// Rewrites an account from the schema version before to the next one, reports whether it changed
+func upgrade_account(stub shim.ChaincodeStubInterface, account *Account, to int) (bool, error) {
+
+	changed := false
+	if to == 2 && account.Type == "" {
+		label, err := index_has_ids(stub, roster_index_str(account.Id))
+		if err != nil {
+			return false, err
+		}
+		artist, err := index_has_ids(stub, artist_tracks_index_str(account.Id))
+		if err != nil {
+			return false, err
+		}
+		if label {
+			account.Type, changed = "label", true
+		} else if artist || account.LabelId != "" {
+			account.Type, changed = "artist", true
+		}
+	}
+
+	return changed, nil
+}
+
+// Rewrites the next batch of records of the migration through every version it spans
+func run_schema_migration(stub shim.ChaincodeStubInterface, migration *SchemaMigration, batch int, now time.Time) error {
+
+	indexStr := trackIndexStr
+	if migration.Phase == "accounts" {
+		indexStr = accountIndexStr
+	}
+
+	keysIter, err := index_iterator_after(stub, indexStr, migration.Cursor)
+	if err != nil {
+		return err
+	}
+	prefix := index_key(indexStr, "")
+	var ids []string
+	for keysIter.HasNext() && len(ids) <= batch {
+		key, _, err := keysIter.Next()
+		if err != nil {
+			keysIter.Close()
+			return errors.New("Failed to iterate " + indexStr + " index")
+		}
+		ids = append(ids, key[len(prefix):])
+	}
+	keysIter.Close()
+
+	// one record more than the batch was read to learn whether the phase is done
+	done := len(ids) <= batch
+	if !done {
+		ids = ids[:batch]
+	}
+	for _, id := range ids {
+		migration.Cursor = id
+		if migration.Phase == "accounts" {
+			account, err := get_account(stub, id)
+			if err != nil {
+				return err
+			}
+			migration.Accounts++
+			changed := false
+			for version := migration.FromVersion + 1; version <= migration.ToVersion; version++ {
+				upgraded, err := upgrade_account(stub, &account, version)
+				if err != nil {
+					return err
+				}
+				changed = upgraded || changed
+			}
+			if changed {
+				err = put_account(stub, account)
+				if err != nil {
+					return err
+				}
+				migration.Rewritten++
+			}
+			continue
+		}
+
+		tr, err := fetch_track(stub, id)
+		if err != nil {
+			return err
+		}
+		migration.Tracks++
+		changed := false
+		for version := migration.FromVersion + 1; version <= migration.ToVersion; version++ {
+			upgraded, err := upgrade_track(stub, id, &tr, version)
+			if err != nil {
+				return err
+			}
+			changed = upgraded || changed
+		}
+		if changed {
+			err = put_track(stub, id, tr)
+			if err != nil {
+				return err
+			}
+			migration.Rewritten++
+		}
+	}
+
+	if done && migration.Phase == "tracks" {
+		migration.Phase, migration.Cursor = "accounts", ""
+	} else if done {
+		migration.Phase, migration.Cursor = "done", ""
+		migration.CompletedAt = now.Format(time.RFC3339)
+		err = put_schema_version(stub, migration.ToVersion)
+		if err != nil {
+			return err
+		}
+	}
+	migration.UpdatedAt = now.Format(time.RFC3339)
+
+	migrationBytes, _ := json.Marshal(migration)
+	err = put_state(stub, schemaMigrationKey, migrationBytes)
+	if err != nil {
+		return errors.New("Error putting " + schemaMigrationKey + " on ledger")
+	}
+
+	return nil
+}
+
+//==============================================================================================================================
+//  Invoke Functions
+//==============================================================================================================================
+
+// Starts or continues the migration of the ledger to the current schema version
+func (t *SimpleChaincode) migrate(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		batch size (optional, records per call)
+
+	batch := defaultMigrationBatch
+	if len(args) > 0 && args[0] != "" {
+		size, err := strconv.Atoi(args[0])
+		if err != nil || size < 1 {
+			return nil, errors.New("Invalid batch size " + args[0])
+		}
+		batch = size
+	}
+
+	err := t.check_caller_role(stub, adminRole)
+	if err != nil {
+		return nil, err
+	}
+	caller, err := t.get_caller_username(stub)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := get_tx_time(stub, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := get_schema_version(stub)
+	if err != nil {
+		return nil, err
+	}
+	migration, err := get_schema_migration(stub)
+	if err != nil {
+		return nil, err
+	}
+	if version > currentSchemaVersion {
+		return nil, errors.New("The ledger is at schema version " + strconv.Itoa(version) + ", newer than this chaincode's " + strconv.Itoa(currentSchemaVersion))
+	}
+	if migration == nil || (migration.Phase == "done" && version < currentSchemaVersion) {
+		migration = &SchemaMigration{FromVersion: version, ToVersion: currentSchemaVersion, Phase: "tracks", StartedBy: caller, StartedAt: now.Format(time.RFC3339)}
+	}
+
+	if migration.Phase != "done" {
+		err = run_schema_migration(stub, migration, batch, now)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	status := SchemaStatus{Version: version, Current: currentSchemaVersion, Migration: migration}
+	if migration.Phase == "done" {
+		status.Version = migration.ToVersion
+	}
+	statusBytes, _ := json.Marshal(status)
+
+	return statusBytes, nil
+}
+
+//==============================================================================================================================
+//		Query Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) query_schema_version(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	version, err := get_schema_version(stub)
+	if err != nil {
+		return nil, err
+	}
+	migration, err := get_schema_migration(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	statusBytes, _ := json.Marshal(SchemaStatus{Version: version, Current: currentSchemaVersion, Migration: migration})
+
+	return statusBytes, nil
+}
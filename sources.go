@@ -0,0 +1,301 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"strconv"
+	"time"
+)
+
+//==============================================================================================================================
+//	 Play Sources - Every play is tagged with the source it came from: the platform's own app, a partner DSP, a
+//					broadcaster or a venue. Sources are registered by the platform with the payer accounts allowed to
+//					submit plays under them and only those identities can submit plays tagged with it. Plays and the payments
+//					they create carry the source, the totals per source and period are kept up to date and statements
+//					break down what an account received by source.
+//==============================================================================================================================
+type PlaySource struct {
+	Id					string		`json:"id"`
+	Kind				string		`json:"kind"`
+	Name				string		`json:"name"`
+	Payers				[]string	`json:"payers"`				// accounts allowed to submit plays from this source
+	RegisteredAt		string		`json:"registeredAt"`
+}
+
+type SourceTotals struct {
+	SourceId			string		`json:"sourceId"`
+	Period				string		`json:"period"`
+	Plays				int64		`json:"plays"`
+	Amount				int64		`json:"amount"`
+}
+
+var PlaySourceKinds = map[string]bool{
+	"app":			true,
+	"dsp":			true,
+	"broadcast":	true,
+	"venue":		true,
+}
+
+var playSourceIndexStr = "play_source"
+var sourceTotalsKeyPrefix = "_source_totals_"
+
+func play_source_key(sourceId string) string {
+	return "_source_" + sourceId
+}
+
+func source_totals_key(sourceId string, periodId string) string {
+	return sourceTotalsKeyPrefix + sourceId + "_" + periodId
+}
+
+// Plays add to one of metricShards shards of the totals, so plays of a source in the same block do not conflict
+func source_totals_shard_key(sourceId string, periodId string, shard int) string {
+	return source_totals_key(sourceId, periodId) + "~" + strconv.Itoa(shard)
+}
+
+func get_play_source(stub shim.ChaincodeStubInterface, sourceId string) (PlaySource, error) {
+
+	var source PlaySource
+
+	bytes, err := get_state(stub, play_source_key(sourceId))
+	if err != nil || len(bytes) == 0 {
+		return source, errors.New("Could not fetch play source " + sourceId)
+	}
+	err = json.Unmarshal(bytes, &source)
+	if err != nil {
+		return source, errors.New("Could not unmarshal play source " + sourceId)
+	}
+
+	return source, nil
+}
+
+// Validates the source tag of a play against the identity submitting it, which has to be one of the source's payers
+func (t *SimpleChaincode) check_play_source(stub shim.ChaincodeStubInterface, sourceId string) error {
+
+	if sourceId == "" {
+		return errors.New("Every play needs a source tag")
+	}
+	source, err := get_play_source(stub, sourceId)
+	if err != nil {
+		return err
+	}
+	if len(source.Payers) == 0 {
+		return errors.New("Play source " + sourceId + " has no payers registered and takes no plays")
+	}
+	caller, err := t.get_caller_username(stub)
+	if err != nil {
+		return err
+	}
+	for _, payer := range source.Payers {
+		if payer == caller {
+			return nil
+		}
+	}
+
+	return errors.New("Only the payers registered for source " + sourceId + " can submit plays from it")
+}
+
+// Verifies the invoker may submit a play or preview for the account: the account itself, a payer registered for
+// the source or an admin. Previews carry no source, only the listener or an admin registers them.
+func (t *SimpleChaincode) check_play_submitter(stub shim.ChaincodeStubInterface, accountId string, sourceId string) error {
+
+	caller, err := t.get_caller_username(stub)
+	if err != nil {
+		return err
+	}
+	if caller == accountId {
+		return nil
+	}
+	if sourceId != "" {
+		source, err := get_play_source(stub, sourceId)
+		if err != nil {
+			return err
+		}
+		for _, payer := range source.Payers {
+			if payer == caller {
+				return nil
+			}
+		}
+	}
+	if t.check_caller_role(stub, adminRole) == nil {
+		return nil
+	}
+
+	return errors.New("Only " + accountId + ", a payer registered for the source or an admin can submit this for " + accountId)
+}
+
+func get_source_totals_shard(stub shim.ChaincodeStubInterface, key string, sourceId string, periodId string) (SourceTotals, error) {
+
+	totals := SourceTotals{SourceId: sourceId, Period: periodId}
+
+	bytes, err := get_state(stub, key)
+	if err != nil {
+		return totals, errors.New("Failed to get totals of source " + sourceId + " for period " + periodId)
+	}
+	if len(bytes) > 0 {
+		json.Unmarshal(bytes, &totals)
+	}
+
+	return totals, nil
+}
+
+// Adds up the shards of the totals, and the totals kept before they were sharded
+func get_source_totals(stub shim.ChaincodeStubInterface, sourceId string, periodId string) (SourceTotals, error) {
+
+	totals, err := get_source_totals_shard(stub, source_totals_key(sourceId, periodId), sourceId, periodId)
+	if err != nil {
+		return totals, err
+	}
+	for shard := 0; shard < metricShards; shard++ {
+		shardTotals, err := get_source_totals_shard(stub, source_totals_shard_key(sourceId, periodId, shard), sourceId, periodId)
+		if err != nil {
+			return totals, err
+		}
+		totals.Plays += shardTotals.Plays
+		totals.Amount += shardTotals.Amount
+	}
+
+	return totals, nil
+}
+
+func add_source_play(stub shim.ChaincodeStubInterface, sourceId string, periodId string, amount int64) error {
+
+	key := source_totals_shard_key(sourceId, periodId, metric_shard(stub))
+	totals, err := get_source_totals_shard(stub, key, sourceId, periodId)
+	if err != nil {
+		return err
+	}
+	totals.Plays++
+	totals.Amount += amount
+
+	totalsBytes, _ := json.Marshal(totals)
+	err = put_state(stub, key, totalsBytes)
+	if err != nil {
+		return errors.New("Error storing totals of source " + sourceId + " for period " + periodId)
+	}
+
+	return nil
+}
+
+//==============================================================================================================================
+//  Invoke Functions
+//==============================================================================================================================
+
+// Registers a play source or replaces the kind, name and payers of a registered one
+func (t *SimpleChaincode) register_play_source(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0			1		2		3
+	//		sourceId	kind	name	payers (JSON array of account ids)
+
+	if len(args) < 4 {
+		return nil, errors.New("Incorrect number of arguments. Expecting sourceId, kind, name and payers")
+	}
+	if args[0] == "" {
+		return nil, errors.New("Invalid source id")
+	}
+	if !PlaySourceKinds[args[1]] {
+		return nil, errors.New("Play source kind not recognized: " + args[1])
+	}
+
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	err = t.check_platform_access(stub, cfg, "register play sources")
+	if err != nil {
+		return nil, err
+	}
+	now, err := get_tx_time(stub, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	source, err := get_play_source(stub, args[0])
+	if err != nil {
+		source = PlaySource{Id: args[0], RegisteredAt: now.Format(time.RFC3339)}
+		err = add_to_index(stub, playSourceIndexStr, args[0])
+		if err != nil {
+			return nil, err
+		}
+	}
+	source.Kind		= args[1]
+	source.Name		= args[2]
+	source.Payers	= []string{}
+	err = json.Unmarshal([]byte(args[3]), &source.Payers)
+	if err != nil {
+		return nil, errors.New("Could not unmarshal payers: " + err.Error())
+	}
+	if len(source.Payers) == 0 {
+		return nil, errors.New("A play source needs at least one payer")
+	}
+	for _, payer := range source.Payers {
+		_, err = get_account(stub, payer)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sourceBytes, _ := json.Marshal(source)
+	err = put_state(stub, play_source_key(source.Id), sourceBytes)
+	if err != nil {
+		return nil, errors.New("Error putting play source " + source.Id + " on ledger")
+	}
+
+	return nil, nil
+}
+
+//==============================================================================================================================
+//		Query Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) get_play_sources(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	sourceIds, err := get_index_ids(stub, playSourceIndexStr)
+	if err != nil {
+		return nil, err
+	}
+
+	sources := []PlaySource{}
+	for _, sourceId := range sourceIds {
+		source, err := get_play_source(stub, sourceId)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, source)
+	}
+
+	sourcesBytes, _ := json.Marshal(sources)
+
+	return sourcesBytes, nil
+}
+
+// Returns the plays and amount of every source for a period
+func (t *SimpleChaincode) query_source_totals(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			1
+	//		periodId
+
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting periodId")
+	}
+
+	sourceIds, err := get_index_ids(stub, playSourceIndexStr)
+	if err != nil {
+		return nil, err
+	}
+
+	totals := []SourceTotals{}
+	for _, sourceId := range sourceIds {
+		sourceTotals, err := get_source_totals(stub, sourceId, args[1])
+		if err != nil {
+			return nil, err
+		}
+		totals = append(totals, sourceTotals)
+	}
+
+	totalsBytes, _ := json.Marshal(totals)
+
+	return totalsBytes, nil
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"time"
+)
+
+//==============================================================================================================================
+//	 Split Proposals - The owner of a track cannot write a split for others on their own. A split change made by
+//					   update_track becomes a pending proposal that every account named in the new split or in the split
+//					   in force has to approve with approve_split, from the certificate it registered with via
+//					   register_me. Once the last one approved, the change takes effect like an update would have: from
+//					   its effectiveFrom, or from the approval when that has passed. Any of them can reject the proposal
+//					   instead. Placeholders approve nothing, they are not on the platform yet, and a change of a track
+//					   whose old and new split name no one but the owner applies right away.
+//==============================================================================================================================
+type SplitProposal struct {
+	Id					string			`json:"id"`
+	TrackId				string			`json:"trackId"`
+	Proposer			string			`json:"proposer"`
+	Beneficiaries		[]Beneficiary	`json:"beneficiaries,omitempty"`	// the change as proposed, only the parts it replaces
+	Recording			*Recording		`json:"recording,omitempty"`
+	Composition			*Composition	`json:"composition,omitempty"`
+	EffectiveFrom		string			`json:"effectiveFrom,omitempty"`	// RFC3339 UTC, empty takes effect on approval
+	Approvers			[]string		`json:"approvers"`
+	ApprovedBy			[]string		`json:"approvedBy"`
+	Status				string			`json:"status"`					// pending, approved or rejected
+	ProposedAt			string			`json:"proposedAt"`
+	DecidedAt			string			`json:"decidedAt,omitempty"`
+}
+
+var splitProposalIndexStr = "_split_proposals"
+var trackSplitProposalsIndexStr = "split_proposals"
+
+// Per-track index of the split proposals made for the track, "split_proposals~<trackId>~<proposalId>"
+func track_split_proposals_index_str(trackId string) string {
+	return index_key(trackSplitProposalsIndexStr, trackId)
+}
+
+func get_split_proposal(stub shim.ChaincodeStubInterface, proposalId string) (SplitProposal, error) {
+
+	var proposal SplitProposal
+
+	bytes, err := get_state(stub, proposalId)
+	if err != nil || len(bytes) == 0 {
+		return proposal, errors.New("Could not fetch split proposal " + proposalId)
+	}
+	err = json.Unmarshal(bytes, &proposal)
+	if err != nil {
+		return proposal, errors.New("Could not unmarshal split proposal " + proposalId)
+	}
+
+	return proposal, nil
+}
+
+func put_split_proposal(stub shim.ChaincodeStubInterface, proposal SplitProposal) error {
+
+	proposalBytes, _ := json.Marshal(proposal)
+	err := put_state(stub, proposal.Id, proposalBytes)
+	if err != nil {
+		return errors.New("Error putting split proposal " + proposal.Id + " on ledger")
+	}
+
+	return nil
+}
+
+func contains_id(ids []string, id string) bool {
+	for _, i := range ids {
+		if i == id {
+			return true
+		}
+	}
+	return false
+}
+
+// The accounts named in the splits of a schedule, each once, without placeholders
+func split_change_approvers(change SplitSchedule) []string {
+
+	beneficiaries := append([]Beneficiary{}, change.Beneficiaries...)
+	if change.Recording != nil {
+		beneficiaries = append(beneficiaries, change.Recording.Beneficiaries...)
+	}
+	if change.Composition != nil {
+		beneficiaries = append(beneficiaries, change.Composition.Beneficiaries...)
+	}
+
+	approvers := []string{}
+	for _, b := range beneficiaries {
+		if b.Placeholder == "" && b.AccountId != "" && !contains_id(approvers, b.AccountId) {
+			approvers = append(approvers, b.AccountId)
+		}
+	}
+
+	return approvers
+}
+
+// Applies a split change of the track owner right away when no one else is named in it or in the split in force, otherwise holds it as a
+// pending proposal the owner already approved and returns its id
+func (t *SimpleChaincode) propose_split_change(stub shim.ChaincodeStubInterface, trackId string, tr *Track, change SplitSchedule, effectiveFrom *string, now time.Time) (string, error) {
+
+	proposer, err := t.get_caller_username(stub)
+	if err != nil {
+		return "", err
+	}
+	at, err := split_effective_from(effectiveFrom, now)
+	if err != nil {
+		return "", err
+	}
+
+	// everyone paid under the split in force loses out by a change, so they approve it as well as those it names
+	dryRun := *tr
+	dryRun.SplitSchedules = append([]SplitSchedule{}, tr.SplitSchedules...)
+	apply_split_schedules(&dryRun, now)
+	approvers := split_change_approvers(change)
+	for _, accountId := range split_change_approvers(SplitSchedule{Beneficiaries: dryRun.Beneficiaries, Recording: dryRun.Recording, Composition: dryRun.Composition}) {
+		if !contains_id(approvers, accountId) {
+			approvers = append(approvers, accountId)
+		}
+	}
+	if len(approvers) == 0 || (len(approvers) == 1 && approvers[0] == proposer) {
+		return "", apply_split_change(stub, trackId, tr, change, at, now)
+	}
+
+	// a change that could never be applied is not put to the beneficiaries
+	updated := track_split_at(dryRun, at)
+	if change.Beneficiaries != nil {
+		updated.Beneficiaries = change.Beneficiaries
+	}
+	if change.Recording != nil {
+		updated.Recording = change.Recording
+	}
+	if change.Composition != nil {
+		updated.Composition = change.Composition
+	}
+	err = validate_track_rights(updated)
+	if err != nil {
+		return "", err
+	}
+
+	proposalId, err := append_id(stub, splitProposalIndexStr, "sp", true)
+	if err != nil {
+		return "", errors.New("Error creating new id for split proposal")
+	}
+	err = add_to_index(stub, track_split_proposals_index_str(trackId), string(proposalId))
+	if err != nil {
+		return "", err
+	}
+
+	var proposal SplitProposal
+	proposal.Id				= string(proposalId)
+	proposal.TrackId		= trackId
+	proposal.Proposer		= proposer
+	proposal.Beneficiaries	= change.Beneficiaries
+	proposal.Recording		= change.Recording
+	proposal.Composition	= change.Composition
+	proposal.Approvers		= approvers
+	proposal.ApprovedBy		= []string{}
+	proposal.Status			= "pending"
+	proposal.ProposedAt		= now.Format(time.RFC3339)
+	if effectiveFrom != nil && *effectiveFrom != "" {
+		proposal.EffectiveFrom = at.Format(time.RFC3339)
+	}
+	if contains_id(approvers, proposer) {
+		proposal.ApprovedBy = append(proposal.ApprovedBy, proposer)
+	}
+
+	err = put_split_proposal(stub, proposal)
+	if err != nil {
+		return "", err
+	}
+	err = emit_event(stub, "SplitProposed", proposal)
+	if err != nil {
+		return "", err
+	}
+
+	return proposal.Id, nil
+}
+
+// Fetches a pending proposal the invoker has to decide on, returns the invoker as well. The invoker has to act from
+// the certificate its account is bound to.
+func (t *SimpleChaincode) get_proposal_to_decide(stub shim.ChaincodeStubInterface, proposalId string) (SplitProposal, string, error) {
+
+	proposal, err := get_split_proposal(stub, proposalId)
+	if err != nil {
+		return proposal, "", err
+	}
+	if proposal.Status != "pending" {
+		return proposal, "", errors.New("Split proposal " + proposalId + " is already " + proposal.Status)
+	}
+
+	caller, err := t.get_caller_username(stub)
+	if err != nil {
+		return proposal, "", err
+	}
+	if !contains_id(proposal.Approvers, caller) {
+		return proposal, "", errors.New("Only the accounts named in split proposal " + proposalId + " can decide on it")
+	}
+	account, err := get_account(stub, caller)
+	if err != nil {
+		return proposal, "", err
+	}
+	if account.CertFingerprint == "" {
+		return proposal, "", errors.New("Account " + caller + " has to register its certificate with register_me before it can approve splits")
+	}
+
+	return proposal, caller, nil
+}
+
+//==============================================================================================================================
+//  Invoke Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) approve_split(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		proposalId
+
+	if len(args) < 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting proposalId")
+	}
+
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := get_tx_time(stub, cfg)
+	if err != nil {
+		return nil, err
+	}
+	proposal, caller, err := t.get_proposal_to_decide(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	if !contains_id(proposal.ApprovedBy, caller) {
+		proposal.ApprovedBy = append(proposal.ApprovedBy, caller)
+	}
+	if len(proposal.ApprovedBy) < len(proposal.Approvers) {
+		return nil, put_split_proposal(stub, proposal)
+	}
+
+	// everyone named approved, the change takes effect, from the approval when its effectiveFrom has passed
+	effectiveFrom := now
+	if proposal.EffectiveFrom != "" {
+		at, _ := time.Parse(time.RFC3339, proposal.EffectiveFrom)
+		if at.After(now) {
+			effectiveFrom = at
+		}
+	}
+	tr, err := fetch_track(stub, proposal.TrackId)
+	if err != nil {
+		return nil, err
+	}
+	change := SplitSchedule{Beneficiaries: proposal.Beneficiaries, Recording: proposal.Recording, Composition: proposal.Composition}
+	err = apply_split_change(stub, proposal.TrackId, &tr, change, effectiveFrom, now)
+	if err != nil {
+		return nil, err
+	}
+	err = put_track(stub, proposal.TrackId, tr)
+	if err != nil {
+		return nil, err
+	}
+
+	proposal.Status		= "approved"
+	proposal.DecidedAt	= now.Format(time.RFC3339)
+
+	err = put_split_proposal(stub, proposal)
+	if err != nil {
+		return nil, err
+	}
+	err = emit_event(stub, "SplitApproved", proposal)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+func (t *SimpleChaincode) reject_split(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		proposalId
+
+	if len(args) < 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting proposalId")
+	}
+
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := get_tx_time(stub, cfg)
+	if err != nil {
+		return nil, err
+	}
+	proposal, _, err := t.get_proposal_to_decide(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	proposal.Status		= "rejected"
+	proposal.DecidedAt	= now.Format(time.RFC3339)
+
+	return nil, put_split_proposal(stub, proposal)
+}
+
+//==============================================================================================================================
+//		Query Functions
+//==============================================================================================================================
+
+// Returns the split proposals made for a track in the order they were made
+func (t *SimpleChaincode) get_split_proposals(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			1
+	//		trackId
+
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting trackId")
+	}
+
+	proposalIds, err := get_index_ids(stub, track_split_proposals_index_str(args[1]))
+	if err != nil {
+		return nil, err
+	}
+
+	proposals := []SplitProposal{}
+	for _, proposalId := range proposalIds {
+		proposal, err := get_split_proposal(stub, proposalId)
+		if err != nil {
+			return nil, err
+		}
+		proposals = append(proposals, proposal)
+	}
+
+	proposalsBytes, _ := json.Marshal(proposals)
+
+	return proposalsBytes, nil
+}
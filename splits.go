@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"sort"
+	"time"
+)
+
+//==============================================================================================================================
+//	 Effective-dated Splits - Splits change when deals are renegotiated, but a change must never alter what plays from
+//							  before it paid out, also when they are synced late. A split change made by update_track
+//							  takes effect from its effectiveFrom, which cannot be in the past, or from the time of the
+//							  update when it has none. A track carries the splits scheduled to take effect next to the
+//							  one in force, and once a schedule takes effect the next update moves the split it
+//							  replaces into the track's split history. A play is split by the schedule in force at the
+//							  time it happened.
+//==============================================================================================================================
+type SplitSchedule struct {
+	EffectiveFrom		string			`json:"effectiveFrom"`				// RFC3339 UTC, the split applies to plays from then on
+	Beneficiaries		[]Beneficiary	`json:"beneficiaries"`
+	Recording			*Recording		`json:"recording,omitempty"`
+	Composition			*Composition	`json:"composition,omitempty"`
+}
+
+// A split that applied to plays until a later one took effect
+type PastSplit struct {
+	Until				string			`json:"until"`						// RFC3339 UTC, exclusive
+	Beneficiaries		[]Beneficiary	`json:"beneficiaries"`
+	Recording			*Recording		`json:"recording,omitempty"`
+	Composition			*Composition	`json:"composition,omitempty"`
+}
+
+// Replaces the split of a track, keeping the one it replaces in the split history
+func replace_split(tr *Track, schedule SplitSchedule) {
+
+	tr.SplitHistory = append(tr.SplitHistory, PastSplit{Until: schedule.EffectiveFrom, Beneficiaries: tr.Beneficiaries, Recording: tr.Recording, Composition: tr.Composition})
+	tr.Beneficiaries	= schedule.Beneficiaries
+	tr.Recording		= schedule.Recording
+	tr.Composition		= schedule.Composition
+}
+
+// Moves the scheduled splits that have taken effect into the split in force
+func apply_split_schedules(tr *Track, now time.Time) {
+
+	for len(tr.SplitSchedules) > 0 {
+		effectiveFrom, _ := time.Parse(time.RFC3339, tr.SplitSchedules[0].EffectiveFrom)
+		if now.Before(effectiveFrom) {
+			return
+		}
+		replace_split(tr, tr.SplitSchedules[0])
+		tr.SplitSchedules = tr.SplitSchedules[1:]
+	}
+	tr.SplitSchedules = nil
+}
+
+// Schedules a split on a track, replacing a split scheduled from the same time, in order of taking effect
+func schedule_split(tr *Track, schedule SplitSchedule) {
+
+	schedules := []SplitSchedule{}
+	for _, scheduled := range tr.SplitSchedules {
+		if scheduled.EffectiveFrom != schedule.EffectiveFrom {
+			schedules = append(schedules, scheduled)
+		}
+	}
+	schedules = append(schedules, schedule)
+	sort.SliceStable(schedules, func(i, j int) bool { return schedules[i].EffectiveFrom < schedules[j].EffectiveFrom })
+
+	tr.SplitSchedules = schedules
+}
+
+// The track with the split in force at the time a play happened
+func track_split_at(tr Track, playedAt time.Time) Track {
+
+	for _, scheduled := range tr.SplitSchedules {
+		effectiveFrom, _ := time.Parse(time.RFC3339, scheduled.EffectiveFrom)
+		if playedAt.Before(effectiveFrom) {
+			break
+		}
+		tr.Beneficiaries, tr.Recording, tr.Composition = scheduled.Beneficiaries, scheduled.Recording, scheduled.Composition
+	}
+	for _, past := range tr.SplitHistory {
+		until, _ := time.Parse(time.RFC3339, past.Until)
+		if playedAt.Before(until) {
+			tr.Beneficiaries, tr.Recording, tr.Composition = past.Beneficiaries, past.Recording, past.Composition
+			break
+		}
+	}
+
+	return tr
+}
+
+// Parses the effectiveFrom of a split change, now when it has none. A split cannot change retroactively.
+func split_effective_from(effectiveFrom *string, now time.Time) (time.Time, error) {
+
+	if effectiveFrom == nil || *effectiveFrom == "" {
+		return now, nil
+	}
+	at, err := time.Parse(time.RFC3339, *effectiveFrom)
+	if err != nil {
+		return at, errors.New("Invalid effectiveFrom " + *effectiveFrom + ", expecting RFC3339")
+	}
+	if at.Before(now) {
+		return at, errors.New("effectiveFrom " + *effectiveFrom + " is in the past, splits cannot change retroactively")
+	}
+
+	return at.UTC(), nil
+}
+
+// Applies a change of the split of a track from effectiveFrom: scheduled when it lies ahead, in force right away when
+// it does not. Only the parts of the split the change carries are replaced.
+func apply_split_change(stub shim.ChaincodeStubInterface, trackId string, tr *Track, change SplitSchedule, effectiveFrom time.Time, now time.Time) error {
+
+	apply_split_schedules(tr, now)
+
+	// the change applies to the split in force when it takes effect
+	updated := track_split_at(*tr, effectiveFrom)
+	if change.Beneficiaries != nil {
+		updated.Beneficiaries = change.Beneficiaries
+	}
+	if change.Recording != nil {
+		updated.Recording = change.Recording
+	}
+	if change.Composition != nil {
+		updated.Composition = change.Composition
+	}
+	err := validate_track_rights(updated)
+	if err != nil {
+		return err
+	}
+	err = resolve_track_placeholders(stub, trackId, updated)
+	if err != nil {
+		return err
+	}
+
+	schedule := SplitSchedule{EffectiveFrom: effectiveFrom.UTC().Format(time.RFC3339), Beneficiaries: updated.Beneficiaries, Recording: updated.Recording, Composition: updated.Composition}
+	if effectiveFrom.After(now) {
+		if work_iswc(updated) != work_iswc(*tr) {
+			return errors.New("A change of the work a track records cannot be scheduled, leave out effectiveFrom")
+		}
+		schedule_split(tr, schedule)
+	} else {
+		err = index_track_work(stub, trackId, work_iswc(*tr), work_iswc(updated))
+		if err != nil {
+			return err
+		}
+		replace_split(tr, schedule)
+	}
+	tr.DefaultSplit = false
+
+	return nil
+}
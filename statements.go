@@ -0,0 +1,387 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"strconv"
+)
+
+//==============================================================================================================================
+//	 Late Accruals - Plays that are synced after their period closed (but within the grace window) are recorded as
+//					 late accruals against the closed period. They are reported on that period's supplemental statement
+//					 rather than being mixed into the next period.
+//==============================================================================================================================
+type LateAccrual struct {
+	Id					string		`json:"id"`
+	PlayId				string		`json:"playId"`
+	TrackId				string		`json:"trackId"`
+	PlayedBy			string		`json:"playedBy"`
+	Period				string		`json:"period"`
+	PlayedAt			string		`json:"playedAt"`
+	SubmittedAt			string		`json:"submittedAt"`
+	Payments			[]Payment	`json:"payments"`
+}
+
+type SupplementalStatement struct {
+	Period				string				`json:"period"`
+	Accruals			[]LateAccrual		`json:"accruals"`
+	TotalsByRecipient	map[string]int64	`json:"totalsByRecipient"`
+	Total				int64				`json:"total"`
+}
+
+// A statement lists every payment an account took part in during a period. Credit note lines carry negative
+// amounts and refer back to the invoice they credit.
+type StatementLine struct {
+	Kind				string		`json:"kind"`					// invoice or credit_note
+	Reference			string		`json:"reference"`
+	CreditsInvoice		string		`json:"creditsInvoice,omitempty"`
+	Direction			string		`json:"direction"`			// in when the account receives, out when it pays
+	Counterparty		string		`json:"counterparty"`
+	Amount				int64		`json:"amount"`
+	CreatedAt			string		`json:"createdAt"`
+	Completed			bool		`json:"completed"`
+	Status				PaymentStatus	`json:"status"`			// open, completed or netted
+	Source				string		`json:"source,omitempty"`		// for play payments, the source the play came from
+	Currency			string		`json:"currency"`
+}
+
+type Statement struct {
+	AccountId			string				`json:"accountId"`
+	Period				string				`json:"period"`
+	Lines				[]StatementLine		`json:"lines"`
+	TotalIn				int64				`json:"totalIn"`
+	TotalOut			int64				`json:"totalOut"`
+	Net					int64				`json:"net"`
+	InBySource			map[string]int64	`json:"inBySource"`		// what the account received, by the source of the plays
+	Currency			string				`json:"currency"`		// of the totals, lines in other currencies are only in NetByCurrency
+	NetByCurrency		map[string]int64	`json:"netByCurrency"`
+}
+
+// Export schema for accounting software. Field names and column order are part of the contract, only ever
+// add fields at the end and bump StatementSchemaVersion when doing so.
+type ExportStatementLine struct {
+	Date				string		`json:"date"`
+	Kind				string		`json:"kind"`
+	Reference			string		`json:"reference"`
+	CreditsInvoice		string		`json:"creditsInvoice"`
+	Direction			string		`json:"direction"`
+	Counterparty		string		`json:"counterparty"`
+	Currency			string		`json:"currency"`
+	Gross				int64		`json:"gross"`
+	Tax					int64		`json:"tax"`
+	Net					int64		`json:"net"`
+	Completed			bool		`json:"completed"`
+}
+
+type ExportStatement struct {
+	SchemaVersion		string					`json:"schemaVersion"`
+	AccountId			string					`json:"accountId"`
+	Period				string					`json:"period"`
+	Currency			string					`json:"currency"`
+	TaxRateBps			int64					`json:"taxRateBps"`
+	Lines				[]ExportStatementLine	`json:"lines"`
+	TotalGrossIn		int64					`json:"totalGrossIn"`
+	TotalGrossOut		int64					`json:"totalGrossOut"`
+	TotalTaxIn			int64					`json:"totalTaxIn"`
+	TotalTaxOut			int64					`json:"totalTaxOut"`
+	Net					int64					`json:"net"`
+}
+
+var StatementSchemaVersion = "1"
+
+var StatementCsvHeader = []string{"date", "kind", "reference", "credits_invoice", "direction", "counterparty", "currency", "gross", "tax", "net", "completed"}
+
+var StatementFormats = map[string]bool{
+	"json":		true,
+	"json_v1":	true,
+	"csv":		true,
+}
+
+var lateAccrualIndexStr = "_late_accruals"
+
+// Per-period index of the late accruals attributed to that period
+func late_accrual_index_str(period string) string {
+	return lateAccrualIndexStr + "_" + period
+}
+
+func add_late_accrual(stub shim.ChaincodeStubInterface, play Play, payments []Payment) error {
+
+	var accrual LateAccrual
+	accrual.Id			= "la" + play.Id
+	accrual.PlayId		= play.Id
+	accrual.TrackId		= play.TrackId
+	accrual.PlayedBy	= play.PlayedBy
+	accrual.Period		= play.Period
+	accrual.PlayedAt	= play.Timestamp
+	accrual.SubmittedAt	= play.SubmittedAt
+	accrual.Payments	= payments
+
+	_, err := append_id(stub, late_accrual_index_str(play.Period), accrual.Id, false)
+	if err != nil {
+		return errors.New("Error indexing late accrual for period " + play.Period)
+	}
+
+	accrualBytes, _ := json.Marshal(accrual)
+	err = put_state(stub, accrual.Id, accrualBytes)
+	if err != nil {
+		return errors.New("Error putting late accrual on ledger")
+	}
+
+	return nil
+}
+
+func statement_line(accountId string, payment Payment) StatementLine {
+
+	var line StatementLine
+	line.Kind			= "invoice"
+	line.Reference		= payment.Reference
+	line.Amount			= payment.Amount
+	line.CreatedAt		= payment.CreatedAt
+	line.Completed		= payment.Completed
+	line.Status			= payment_status(payment)
+	line.Source			= payment.Source
+	if payment.CreditsInvoice != "" {
+		line.Kind			= "credit_note"
+		line.CreditsInvoice	= payment.CreditsInvoice
+	}
+	if payment.RecipientId == accountId {
+		line.Direction		= "in"
+		line.Counterparty	= payment.SenderId
+	} else {
+		line.Direction		= "out"
+		line.Counterparty	= payment.RecipientId
+	}
+
+	return line
+}
+
+// Builds the statement of an account for a settlement period
+func build_statement(stub shim.ChaincodeStubInterface, accountId string, periodId string) (Statement, error) {
+
+	var statement Statement
+	statement.AccountId = accountId
+	statement.Period = periodId
+	statement.Lines = []StatementLine{}
+	statement.InBySource = map[string]int64{}
+	statement.NetByCurrency = map[string]int64{}
+
+	cfg, err := get_config(stub)
+	if err != nil {
+		return statement, err
+	}
+	statement.Currency = cfg.Currency
+
+	bytes, err := get_state(stub, accountId)
+	if err != nil || len(bytes) == 0 {
+		return statement, errors.New("Could not fetch account " + accountId)
+	}
+	var account Account
+	err = json.Unmarshal(bytes, &account)
+	if err != nil {
+		return statement, errors.New("Could not unmarshal account " + accountId)
+	}
+
+	for _, payment := range account.PendingPayments {
+		if payment.Period != periodId {
+			continue
+		}
+		line := statement_line(accountId, payment)
+		line.Currency = payment_currency(cfg, payment)
+		statement.Lines = append(statement.Lines, line)
+		if line.Direction == "in" {
+			statement.NetByCurrency[line.Currency] += line.Amount
+		} else {
+			statement.NetByCurrency[line.Currency] -= line.Amount
+		}
+		if line.Currency != statement.Currency {
+			continue
+		}
+		if line.Direction == "in" {
+			statement.TotalIn += line.Amount
+			if line.Source != "" {
+				statement.InBySource[line.Source] += line.Amount
+			}
+		} else {
+			statement.TotalOut += line.Amount
+		}
+	}
+	statement.Net = statement.TotalIn - statement.TotalOut
+
+	return statement, nil
+}
+
+// Splits a tax inclusive gross amount into tax and net
+func tax_breakdown(gross int64, taxRateBps int64) (int64, int64) {
+	net := gross * 10000 / (10000 + taxRateBps)
+	return gross - net, net
+}
+
+func export_statement(cfg Config, statement Statement) ExportStatement {
+
+	var export ExportStatement
+	export.SchemaVersion	= StatementSchemaVersion
+	export.AccountId		= statement.AccountId
+	export.Period			= statement.Period
+	export.Currency			= cfg.Currency
+	export.TaxRateBps		= cfg.TaxRateBps
+	export.Lines			= []ExportStatementLine{}
+
+	for _, line := range statement.Lines {
+		var exportLine ExportStatementLine
+		exportLine.Date				= line.CreatedAt
+		exportLine.Kind				= line.Kind
+		exportLine.Reference		= line.Reference
+		exportLine.CreditsInvoice	= line.CreditsInvoice
+		exportLine.Direction		= line.Direction
+		exportLine.Counterparty		= line.Counterparty
+		exportLine.Currency			= currency_or_default(cfg, line.Currency)
+		exportLine.Gross			= line.Amount
+		exportLine.Tax, exportLine.Net = tax_breakdown(line.Amount, cfg.TaxRateBps)
+		exportLine.Completed		= line.Completed
+		export.Lines = append(export.Lines, exportLine)
+
+		// the totals are in the export currency
+		if exportLine.Currency != export.Currency {
+			continue
+		}
+		if line.Direction == "in" {
+			export.TotalGrossIn += exportLine.Gross
+			export.TotalTaxIn += exportLine.Tax
+		} else {
+			export.TotalGrossOut += exportLine.Gross
+			export.TotalTaxOut += exportLine.Tax
+		}
+	}
+	export.Net = (export.TotalGrossIn - export.TotalTaxIn) - (export.TotalGrossOut - export.TotalTaxOut)
+
+	return export
+}
+
+func statement_csv(export ExportStatement) ([]byte, error) {
+
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+
+	w.Write(StatementCsvHeader)
+	for _, line := range export.Lines {
+		w.Write([]string{
+			line.Date,
+			line.Kind,
+			line.Reference,
+			line.CreditsInvoice,
+			line.Direction,
+			line.Counterparty,
+			line.Currency,
+			strconv.FormatInt(line.Gross, 10),
+			strconv.FormatInt(line.Tax, 10),
+			strconv.FormatInt(line.Net, 10),
+			strconv.FormatBool(line.Completed),
+		})
+	}
+	w.Flush()
+
+	if err := w.Error(); err != nil {
+		return nil, errors.New("Could not render statement as CSV")
+	}
+
+	return buf.Bytes(), nil
+}
+
+//==============================================================================================================================
+//		Query Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) get_statement(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			1				2				3
+	//		accountId		periodId		format (optional - json, json_v1 or csv)
+
+	if len(args) < 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting accountId and periodId")
+	}
+	format := "json"
+	if len(args) > 3 && args[3] != "" {
+		format = args[3]
+	}
+	if !StatementFormats[format] {
+		return nil, errors.New("Statement format not recognized: " + format)
+	}
+
+	// the statement of a closed period is the one stored when it was closed
+	statement, closed, err := get_closed_statement(stub, args[1], args[2])
+	if err != nil {
+		return nil, err
+	}
+	if !closed {
+		statement, err = build_statement(stub, args[1], args[2])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if format == "json" {
+		statementBytes, _ := json.Marshal(statement)
+		return statementBytes, nil
+	}
+
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	export := export_statement(cfg, statement)
+
+	if format == "csv" {
+		return statement_csv(export)
+	}
+
+	exportBytes, _ := json.Marshal(export)
+
+	return exportBytes, nil
+}
+
+func (t *SimpleChaincode) get_supplemental_statement(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			1
+	//		periodId
+
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting periodId")
+	}
+	periodId := args[1]
+
+	accrualIndex, err := get_appended_ids(stub, late_accrual_index_str(periodId))
+	if err != nil {
+		return nil, errors.New("Failed to get late accruals for period " + periodId)
+	}
+
+	var statement SupplementalStatement
+	statement.Period = periodId
+	statement.Accruals = []LateAccrual{}
+	statement.TotalsByRecipient = map[string]int64{}
+
+	for _, accrualId := range accrualIndex {
+
+		bytes, err := get_state(stub, accrualId)
+		if err != nil {
+			return nil, errors.New("Unable to get late accrual with ID: " + accrualId)
+		}
+
+		var accrual LateAccrual
+		json.Unmarshal(bytes, &accrual)
+
+		for _, payment := range accrual.Payments {
+			statement.TotalsByRecipient[payment.RecipientId] += payment.Amount
+			statement.Total += payment.Amount
+		}
+		statement.Accruals = append(statement.Accruals, accrual)
+	}
+
+	statementBytes, _ := json.Marshal(statement)
+
+	return statementBytes, nil
+}
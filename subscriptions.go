@@ -0,0 +1,366 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"sort"
+	"time"
+)
+
+//==============================================================================================================================
+//	 Subscriptions - A listener subscribes to a tier instead of paying per play. Track plays of a subscriber are
+//					 recorded without charging anything, and once a period has ended distribute_subscription_pool
+//					 splits the fee of each subscriber's tier across the tracks the subscriber played in that
+//					 period, pro rata to the number of plays. Every track's share is split like the price of a play:
+//					 the platform fee first, then the beneficiaries of the track, as open payments from the
+//					 subscriber. A subscriber that played nothing in the period is not charged. The tiers and their
+//					 fees are set in the configuration as subscriptionTiers, subscribing to tier none cancels.
+//					 Album plays are priced on their own and are not covered.
+//==============================================================================================================================
+type Subscription struct {
+	AccountId			string		`json:"accountId"`
+	Tier				string		`json:"tier"`
+	Fee					int64		`json:"fee"`					// per period, in the platform currency, fixed when subscribing
+	SubscribedAt		string		`json:"subscribedAt"`
+	CancelledAt			string		`json:"cancelledAt,omitempty"`
+}
+
+type SubscriptionPool struct {
+	Period				string		`json:"period"`
+	Subscribers			int			`json:"subscribers"`			// subscribers charged, those that played in the period
+	Plays				int			`json:"plays"`					// covered plays the pool was split over
+	Distributed			int64		`json:"distributed"`			// total of the fees, in the platform currency
+	Payments			int			`json:"payments"`				// open payments created
+	DistributedBy		string		`json:"distributedBy"`
+	DistributedAt		string		`json:"distributedAt"`
+}
+
+var subscriptionIndexStr = "_subscriptions"
+var cancelSubscriptionTier = "none"
+
+func subscription_key(accountId string) string {
+	return "_subscription_" + accountId
+}
+
+func subscription_pool_key(periodId string) string {
+	return "_subscription_pool_" + periodId
+}
+
+// Returns the subscription of the account, empty when it never subscribed
+func get_subscription(stub shim.ChaincodeStubInterface, accountId string) (Subscription, error) {
+
+	var subscription Subscription
+
+	bytes, err := get_state(stub, subscription_key(accountId))
+	if err != nil {
+		return subscription, errors.New("Failed to get subscription of " + accountId)
+	}
+	if len(bytes) > 0 {
+		err = json.Unmarshal(bytes, &subscription)
+		if err != nil {
+			return subscription, errors.New("Could not unmarshal subscription of " + accountId)
+		}
+	}
+
+	return subscription, nil
+}
+
+// Whether the subscription covered plays at the time
+func is_subscribed(subscription Subscription, at time.Time) bool {
+
+	if subscription.Tier == "" || subscription.CancelledAt != "" {
+		return false
+	}
+	subscribedAt, err := time.Parse(time.RFC3339, subscription.SubscribedAt)
+
+	return err == nil && !at.Before(subscribedAt)
+}
+
+// Divides a fee over the tracks by their play counts, the rounding remainder goes to the most played track
+func pool_track_shares(fee int64, counts map[string]int64) ([]string, map[string]int64) {
+
+	trackIds := []string{}
+	var plays int64
+	for trackId, count := range counts {
+		trackIds = append(trackIds, trackId)
+		plays += count
+	}
+	sort.Strings(trackIds)
+
+	shares := map[string]int64{}
+	if plays == 0 {
+		return trackIds, shares
+	}
+	var top string
+	var shared int64
+	for _, trackId := range trackIds {
+		shares[trackId] = fee * counts[trackId] / plays
+		shared += shares[trackId]
+		if top == "" || counts[trackId] > counts[top] {
+			top = trackId
+		}
+	}
+	shares[top] += fee - shared
+
+	return trackIds, shares
+}
+
+//==============================================================================================================================
+//  Invoke Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) subscribe(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0				1
+	//		accountId		tier (none cancels)
+
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting accountId and tier")
+	}
+
+	caller, err := t.get_caller_username(stub)
+	if err != nil {
+		return nil, err
+	}
+	if caller != args[0] && t.check_caller_role(stub, adminRole) != nil {
+		return nil, errors.New("Only " + args[0] + " or an admin can change the subscription of " + args[0])
+	}
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := get_tx_time(stub, cfg)
+	if err != nil {
+		return nil, err
+	}
+	account, err := get_account(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	err = check_account_not_frozen(account)
+	if err != nil {
+		return nil, err
+	}
+
+	subscription, err := get_subscription(stub, account.Id)
+	if err != nil {
+		return nil, err
+	}
+	if args[1] == cancelSubscriptionTier {
+		if !is_subscribed(subscription, now) {
+			return nil, errors.New("Account " + account.Id + " has no subscription to cancel")
+		}
+		subscription.CancelledAt = now.Format(time.RFC3339)
+	} else {
+		fee, ok := cfg.SubscriptionTiers[args[1]]
+		if !ok {
+			return nil, errors.New("Subscription tier not recognized: " + args[1])
+		}
+		if subscription.Tier == "" {
+			err = add_to_index(stub, subscriptionIndexStr, account.Id)
+			if err != nil {
+				return nil, err
+			}
+		}
+		subscription = Subscription{AccountId: account.Id, Tier: args[1], Fee: fee, SubscribedAt: now.Format(time.RFC3339)}
+	}
+
+	subscriptionBytes, _ := json.Marshal(subscription)
+	err = put_state(stub, subscription_key(account.Id), subscriptionBytes)
+	if err != nil {
+		return nil, errors.New("Error putting subscription of " + account.Id + " on ledger")
+	}
+
+	return subscriptionBytes, nil
+}
+
+// Splits the fees of the subscribers over the tracks they played in an ended period, once per period
+func (t *SimpleChaincode) distribute_subscription_pool(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		periodId
+
+	if len(args) < 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting periodId")
+	}
+
+	err := t.check_caller_role(stub, adminRole)
+	if err != nil {
+		return nil, err
+	}
+	caller, err := t.get_caller_username(stub)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := get_tx_time(stub, cfg)
+	if err != nil {
+		return nil, err
+	}
+	_, err = find_ended_period(cfg, args[0], now)
+	if err != nil {
+		return nil, err
+	}
+	distributed, err := get_state(stub, subscription_pool_key(args[0]))
+	if err != nil {
+		return nil, errors.New("Failed to get subscription pool of period " + args[0])
+	}
+	if len(distributed) > 0 {
+		return nil, errors.New("Subscription pool of period " + args[0] + " has already been distributed")
+	}
+
+	// covered plays per subscriber per track
+	playIds, err := get_index_ids(stub, period_plays_index_str(args[0]))
+	if err != nil {
+		return nil, err
+	}
+	counts := map[string]map[string]int64{}
+	pool := SubscriptionPool{Period: args[0], DistributedBy: caller, DistributedAt: now.Format(time.RFC3339)}
+	for _, playId := range playIds {
+		bytes, err := get_state(stub, playId)
+		if err != nil || len(bytes) == 0 {
+			return nil, errors.New("Could not fetch play " + playId)
+		}
+		var play Play
+		json.Unmarshal(bytes, &play)
+
+		if play.Subscription == "" || play.TrackId == "" || play.Flagged != "" {
+			continue
+		}
+		if counts[play.PlayedBy] == nil {
+			counts[play.PlayedBy] = map[string]int64{}
+		}
+		counts[play.PlayedBy][play.TrackId]++
+		pool.Plays++
+	}
+
+	subscriberIds := []string{}
+	for subscriberId := range counts {
+		subscriberIds = append(subscriberIds, subscriberId)
+	}
+	sort.Strings(subscriberIds)
+
+	tracks := map[string]Track{}
+	var payments []Payment
+	owedBy := map[string][]Payment{}
+	for _, subscriberId := range subscriberIds {
+		subscription, err := get_subscription(stub, subscriberId)
+		if err != nil {
+			return nil, err
+		}
+		subscriber, err := get_account(stub, subscriberId)
+		if err != nil {
+			return nil, err
+		}
+		dueDate, err := payment_due_date(cfg, subscriber, now)
+		if err != nil {
+			return nil, err
+		}
+
+		trackIds, shares := pool_track_shares(subscription.Fee, counts[subscriberId])
+		var owed []Payment
+		for _, trackId := range trackIds {
+			tr, ok := tracks[trackId]
+			if !ok {
+				tr, err = fetch_track(stub, trackId)
+				if err != nil {
+					return nil, err
+				}
+				tracks[trackId] = tr
+			}
+
+			// a track's share is split like the price of a play
+			share := shares[trackId]
+			platformFee, platformLine := platform_fee_line(cfg, share)
+			lines := rights_payouts(cfg, tr, share-platformFee)
+			if platformLine != nil {
+				lines = append([]Payment{*platformLine}, lines...)
+			}
+			for _, line := range lines {
+				if line.Amount == 0 {
+					continue
+				}
+				line.SenderId	= subscriber.Id
+				line.Currency	= cfg.Currency
+				line.CreatedAt	= now.Format(time.RFC3339)
+				line.DueDate	= dueDate
+				line.Period		= args[0]
+				line.Reference	= subscription_pool_key(args[0])
+
+				owed = append(owed, line)
+			}
+
+			err = add_track_earnings(stub, trackId, args[0], share)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		owedBy[subscriber.Id] = owed
+		payments = append(payments, owed...)
+		pool.Subscribers++
+		pool.Distributed += subscription.Fee
+	}
+	pool.Payments = len(payments)
+
+	// the platform and artists of several played tracks get all their lines in one write, recipients first so a
+	// subscriber that is also one is read back with its copies as recipient
+	err = append_to_recipients(stub, payments)
+	if err != nil {
+		return nil, err
+	}
+	for _, subscriberId := range subscriberIds {
+		if len(owedBy[subscriberId]) == 0 {
+			continue
+		}
+		err = append_pending_payments(stub, subscriberId, owedBy[subscriberId])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	poolBytes, _ := json.Marshal(pool)
+	err = put_state(stub, subscription_pool_key(pool.Period), poolBytes)
+	if err != nil {
+		return nil, errors.New("Error putting subscription pool of period " + pool.Period + " on ledger")
+	}
+	err = emit_payments_created(stub, payments)
+	if err != nil {
+		return nil, err
+	}
+
+	return poolBytes, nil
+}
+
+//==============================================================================================================================
+//		Query Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) query_subscription(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			1
+	//		accountId
+
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting accountId")
+	}
+
+	subscription, err := get_subscription(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	if subscription.Tier == "" {
+		return nil, errors.New("Account " + args[1] + " has no subscription")
+	}
+
+	subscriptionBytes, _ := json.Marshal(subscription)
+
+	return subscriptionBytes, nil
+}
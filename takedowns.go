@@ -0,0 +1,272 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"time"
+)
+
+//==============================================================================================================================
+//	 Takedowns - Anyone with an account can request a track to be taken down, with a reason code, details and optionally
+//				 the hash of supporting documents. An admin processes the request: a granted takedown marks the track
+//				 taken_down, after which it refuses plays, purchases and licenses like an inactive track, a denied one
+//				 leaves the track as it is. Requests are never deleted, the claimant, the decision and when each was
+//				 made stay on the ledger for audit. A track has one request waiting at a time.
+//==============================================================================================================================
+type Takedown struct {
+	Id					string			`json:"id"`
+	TrackId				string			`json:"trackId"`
+	Claimant			string			`json:"claimant"`
+	Reason				TakedownReason	`json:"reason"`
+	Details				string			`json:"details"`
+	Evidence			string			`json:"evidence,omitempty"`		// SHA-256 of the supporting documents
+	Status				TakedownStatus	`json:"status"`
+	Decision			string			`json:"decision,omitempty"`		// why the admin granted or denied it
+	DecidedBy			string			`json:"decidedBy,omitempty"`
+	RequestedAt			string			`json:"requestedAt"`
+	DecidedAt			string			`json:"decidedAt,omitempty"`
+}
+
+var takedownIndexStr = "_takedowns"
+var trackTakedownsIndexStr = "track_takedowns"
+
+// Per-track index of the takedown requests for the track, "track_takedowns~<trackId>~<takedownId>"
+func track_takedowns_index_str(trackId string) string {
+	return index_key(trackTakedownsIndexStr, trackId)
+}
+
+// Holds the id of the request for the track waiting to be processed
+func requested_takedown_key(trackId string) string {
+	return "_takedown_requested_" + trackId
+}
+
+func get_takedown(stub shim.ChaincodeStubInterface, takedownId string) (Takedown, error) {
+
+	var takedown Takedown
+
+	bytes, err := get_state(stub, takedownId)
+	if err != nil || len(bytes) == 0 {
+		return takedown, errors.New("Could not fetch takedown request " + takedownId)
+	}
+	err = json.Unmarshal(bytes, &takedown)
+	if err != nil {
+		return takedown, errors.New("Could not unmarshal takedown request " + takedownId)
+	}
+
+	return takedown, nil
+}
+
+func put_takedown(stub shim.ChaincodeStubInterface, takedown Takedown) error {
+
+	err := validate_takedown_enums(takedown)
+	if err != nil {
+		return err
+	}
+	takedownBytes, _ := json.Marshal(takedown)
+	err = put_state(stub, takedown.Id, takedownBytes)
+	if err != nil {
+		return errors.New("Error putting takedown request " + takedown.Id + " on ledger")
+	}
+
+	return nil
+}
+
+//==============================================================================================================================
+//  Invoke Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) request_takedown(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0			1				2			3
+	//		trackId		reason code		details		evidence hash (optional)
+
+	if len(args) < 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting trackId, reason code and details")
+	}
+	if args[2] == "" {
+		return nil, errors.New("A takedown request needs details")
+	}
+
+	claimant, err := t.get_caller_username(stub)
+	if err != nil {
+		return nil, err
+	}
+	_, err = get_account(stub, claimant)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := get_tx_time(stub, cfg)
+	if err != nil {
+		return nil, err
+	}
+	tr, err := fetch_track(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if tr.Status == TrackTakenDown {
+		return nil, errors.New("Track " + args[0] + " has already been taken down by " + tr.TakenDownBy)
+	}
+	waiting, err := get_state(stub, requested_takedown_key(args[0]))
+	if err != nil {
+		return nil, errors.New("Failed to get takedown requests of " + args[0])
+	}
+	if len(waiting) > 0 {
+		return nil, errors.New("Track " + args[0] + " already has takedown request " + string(waiting) + " waiting")
+	}
+
+	takedownId, err := append_id(stub, takedownIndexStr, "td", true)
+	if err != nil {
+		return nil, errors.New("Error creating new id for takedown request")
+	}
+
+	var takedown Takedown
+	takedown.Id				= string(takedownId)
+	takedown.TrackId		= args[0]
+	takedown.Claimant		= claimant
+	takedown.Reason			= TakedownReason(args[1])
+	takedown.Details		= args[2]
+	takedown.Status			= TakedownRequested
+	takedown.RequestedAt	= now.Format(time.RFC3339)
+	if len(args) > 3 && args[3] != "" {
+		takedown.Evidence, err = normalize_content_hash(args[3])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = put_takedown(stub, takedown)
+	if err != nil {
+		return nil, err
+	}
+	err = add_to_index(stub, track_takedowns_index_str(args[0]), takedown.Id)
+	if err != nil {
+		return nil, err
+	}
+	err = put_state(stub, requested_takedown_key(args[0]), takedownId)
+	if err != nil {
+		return nil, errors.New("Error recording takedown request of " + args[0])
+	}
+	err = emit_event(stub, "TakedownRequested", takedown)
+	if err != nil {
+		return nil, err
+	}
+
+	return takedownId, nil
+}
+
+// An admin grants or denies a takedown request, a granted one takes the track down
+func (t *SimpleChaincode) process_takedown(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0				1						2
+	//		takedownId		decision (grant|deny)		reasoning
+
+	if len(args) < 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting takedownId, decision and reasoning")
+	}
+	if args[1] != "grant" && args[1] != "deny" {
+		return nil, errors.New("Decision not recognized: " + args[1] + ", expecting grant or deny")
+	}
+
+	err := t.check_caller_role(stub, adminRole)
+	if err != nil {
+		return nil, err
+	}
+	caller, err := t.get_caller_username(stub)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := get_tx_time(stub, cfg)
+	if err != nil {
+		return nil, err
+	}
+	takedown, err := get_takedown(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if takedown.Status != TakedownRequested {
+		return nil, errors.New("Takedown request " + args[0] + " has already been " + string(takedown.Status))
+	}
+
+	takedown.Status		= TakedownDenied
+	takedown.Decision	= args[2]
+	takedown.DecidedBy	= caller
+	takedown.DecidedAt	= now.Format(time.RFC3339)
+
+	if args[1] == "grant" {
+		takedown.Status = TakedownGranted
+
+		tr, err := fetch_track(stub, takedown.TrackId)
+		if err != nil {
+			return nil, err
+		}
+		tr.Status		= TrackTakenDown
+		tr.TakenDownBy	= takedown.Id
+		if tr.DeactivatedAt == "" {
+			tr.DeactivatedAt = takedown.DecidedAt
+		}
+		err = put_track(stub, takedown.TrackId, tr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = put_takedown(stub, takedown)
+	if err != nil {
+		return nil, err
+	}
+	err = del_state(stub, requested_takedown_key(takedown.TrackId))
+	if err != nil {
+		return nil, errors.New("Error clearing takedown request of " + takedown.TrackId)
+	}
+	err = emit_event(stub, "TakedownProcessed", takedown)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+//==============================================================================================================================
+//		Query Functions
+//==============================================================================================================================
+
+// Returns the takedown requests for a track in the order they were made
+func (t *SimpleChaincode) get_takedowns(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			1
+	//		trackId
+
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting trackId")
+	}
+
+	takedownIds, err := get_index_ids(stub, track_takedowns_index_str(args[1]))
+	if err != nil {
+		return nil, err
+	}
+
+	takedowns := []Takedown{}
+	for _, takedownId := range takedownIds {
+		takedown, err := get_takedown(stub, takedownId)
+		if err != nil {
+			return nil, err
+		}
+		takedowns = append(takedowns, takedown)
+	}
+
+	takedownsBytes, _ := json.Marshal(takedowns)
+
+	return takedownsBytes, nil
+}
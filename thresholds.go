@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"strconv"
+	"time"
+)
+
+//==============================================================================================================================
+//	 Payout Thresholds - Paying out every small royalty costs more in transfer fees than it is worth, so an account can
+//						 set a minimum payout in its own currency. Payments settled to it in that currency are credited
+//						 to its AccruedBalance instead of its balance, and marked accrued. Once the accrued balance
+//						 reaches the threshold it is released in one payout payment, from the account to itself, that
+//						 moves it into the balance. Payments in other currencies are credited as usual.
+//==============================================================================================================================
+type AccruedPayouts struct {
+	AccountId			string		`json:"accountId"`
+	Currency			string		`json:"currency"`
+	Threshold			int64		`json:"threshold"`
+	Accrued				int64		`json:"accrued"`
+	Remaining			int64		`json:"remaining"`		// still to accrue before the next payout
+	Payments			[]Payment	`json:"payments"`		// payments accrued since the last payout
+}
+
+// Whether a credit in the currency accrues on the account instead of going to its balance
+func accrues_payout(cfg Config, account Account, currency string) bool {
+	return account.PayoutThreshold > 0 && currency == currency_or_default(cfg, account.Currency)
+}
+
+// Releases the accrued balance of an account in a payout payment when it reached the threshold, or always when forced
+func release_accrued_payout(stub shim.ChaincodeStubInterface, cfg Config, account *Account, period string, now time.Time, force bool) error {
+
+	if account.AccruedBalance == 0 || (!force && account.AccruedBalance < account.PayoutThreshold) {
+		return nil
+	}
+
+	var payout Payment
+	payout.RecipientId	= account.Id
+	payout.SenderId		= account.Id
+	payout.Amount		= account.AccruedBalance
+	payout.Currency		= currency_or_default(cfg, account.Currency)
+	payout.Completed	= true
+	payout.CreatedAt	= now.Format(time.RFC3339)
+	payout.UpdatedAt	= payout.CreatedAt
+	payout.DueDate		= payout.CreatedAt
+	payout.Period		= period
+	payout.Reference	= stub.GetTxID()
+	payout.Payout		= true
+
+	account.PendingPayments = append(account.PendingPayments, payout)
+	account.Balance += account.AccruedBalance
+	account.AccruedBalance = 0
+
+	return emit_event(stub, "PaymentSettled", payout)
+}
+
+// Credits a settled payment to the recipient, accrued when it is below its payout threshold
+func credit_payout(stub shim.ChaincodeStubInterface, cfg Config, recipient *Account, period string, currency string, amount int64, now time.Time) error {
+
+	if !accrues_payout(cfg, *recipient, currency) {
+		adjust_balance(cfg, recipient, currency, amount)
+		return nil
+	}
+
+	recipient.AccruedBalance += amount
+
+	return release_accrued_payout(stub, cfg, recipient, period, now, false)
+}
+
+//==============================================================================================================================
+//  Invoke Functions
+//==============================================================================================================================
+
+// Sets the minimum payout of the invoker's own account, 0 pays out everything, including what has accrued
+func (t *SimpleChaincode) set_payout_threshold(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		threshold (in the account's currency)
+
+	if len(args) < 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting threshold")
+	}
+	threshold, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil || threshold < 0 {
+		return nil, errors.New("Invalid threshold " + args[0])
+	}
+
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := get_tx_time(stub, cfg)
+	if err != nil {
+		return nil, err
+	}
+	period, err := resolve_period(cfg, now)
+	if err != nil {
+		return nil, err
+	}
+	accountId, err := t.get_caller_username(stub)
+	if err != nil {
+		return nil, err
+	}
+	account, err := get_account(stub, accountId)
+	if err != nil {
+		return nil, err
+	}
+
+	account.PayoutThreshold = threshold
+	err = release_accrued_payout(stub, cfg, &account, period.Id, now, threshold == 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, put_account(stub, account)
+}
+
+//==============================================================================================================================
+//		Query Functions
+//==============================================================================================================================
+
+// Shows what has accrued on an account but not been paid out yet
+func (t *SimpleChaincode) get_accrued_payouts(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			1
+	//		accountId
+
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting accountId")
+	}
+
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	account, err := get_account(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+
+	accrued := AccruedPayouts{AccountId: account.Id, Currency: currency_or_default(cfg, account.Currency), Threshold: account.PayoutThreshold, Accrued: account.AccruedBalance}
+	if accrued.Threshold > accrued.Accrued {
+		accrued.Remaining = accrued.Threshold - accrued.Accrued
+	}
+
+	// payments are appended in the order they settle, a payout releases everything accrued before it
+	accrued.Payments = []Payment{}
+	for _, payment := range account.PendingPayments {
+		if payment.Payout && payment.RecipientId == account.Id {
+			accrued.Payments = []Payment{}
+		} else if payment.Accrued && payment.RecipientId == account.Id {
+			accrued.Payments = append(accrued.Payments, payment)
+		}
+	}
+
+	accruedBytes, _ := json.Marshal(accrued)
+
+	return accruedBytes, nil
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"strconv"
+	"time"
+)
+
+//==============================================================================================================================
+//	 Tips - A listener tips an artist directly, optionally for a track. A tip is not a royalty: it moves the amount from
+//			the balance of the listener to that of the artist at once, without a payment to settle, splits, fees or
+//			withholding, and is kept apart from royalty income. Every tip is recorded as a Tip, and the lifetime
+//			total of the tips an account received, in the platform currency, is kept under its own key so the
+//			dashboards can show it next to the earnings of the catalog.
+//==============================================================================================================================
+type Tip struct {
+	Id					string		`json:"id"`
+	From				string		`json:"from"`
+	To					string		`json:"to"`
+	Amount				int64		`json:"amount"`
+	Currency			string		`json:"currency"`				// ISO 4217 code, that of the tipping account
+	TrackId				string		`json:"trackId,omitempty"`
+	Period				string		`json:"period"`
+	CreatedAt			string		`json:"createdAt"`
+	TransferRef			string		`json:"transferRef,omitempty"`	// the transfer that moved the funds, when tipped through the settlement chaincode
+}
+
+var tipIndexStr = "_tips"
+var accountTipsIndexStr = "account_tips"
+var accountTipsTotalKeyPrefix = "_tips_total_"
+
+// Per-account index of the tips the account received, "account_tips~<accountId>~<tipId>"
+func account_tips_index_str(accountId string) string {
+	return index_key(accountTipsIndexStr, accountId)
+}
+
+// Lifetime total of the tips an account received, in the platform currency
+func get_account_tips_total(stub shim.ChaincodeStubInterface, accountId string) (int64, error) {
+
+	bytes, err := get_state(stub, accountTipsTotalKeyPrefix + accountId)
+	if err != nil {
+		return 0, errors.New("Failed to get tips of account " + accountId)
+	}
+	var total int64
+	json.Unmarshal(bytes, &total)
+
+	return total, nil
+}
+
+func add_account_tips(stub shim.ChaincodeStubInterface, accountId string, amount int64) error {
+
+	total, err := get_account_tips_total(stub, accountId)
+	if err != nil {
+		return err
+	}
+	total += amount
+
+	totalBytes, _ := json.Marshal(total)
+	err = put_state(stub, accountTipsTotalKeyPrefix+accountId, totalBytes)
+	if err != nil {
+		return errors.New("Error storing tips of account " + accountId)
+	}
+
+	return nil
+}
+
+func get_tip(stub shim.ChaincodeStubInterface, tipId string) (Tip, error) {
+
+	var tip Tip
+
+	bytes, err := get_state(stub, tipId)
+	if err != nil || len(bytes) == 0 {
+		return tip, errors.New("Could not fetch tip " + tipId)
+	}
+	err = json.Unmarshal(bytes, &tip)
+	if err != nil {
+		return tip, errors.New("Could not unmarshal tip " + tipId)
+	}
+
+	return tip, nil
+}
+
+//==============================================================================================================================
+//  Invoke Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) tip_artist(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0				1			2			3
+	//		fromAccount		toAccount	amount		trackId (optional)
+
+	if len(args) < 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting fromAccount, toAccount and amount")
+	}
+	if args[0] == args[1] {
+		return nil, errors.New("Invalid accounts, an account cannot tip itself")
+	}
+	amount, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil || amount <= 0 {
+		return nil, errors.New("Invalid amount " + args[2] + ", expecting a positive amount")
+	}
+
+	caller, err := t.get_caller_username(stub)
+	if err != nil {
+		return nil, err
+	}
+	if caller != args[0] && t.check_caller_role(stub, adminRole) != nil {
+		return nil, errors.New("Only " + args[0] + " or an admin can tip from account " + args[0])
+	}
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := get_tx_time(stub, cfg)
+	if err != nil {
+		return nil, err
+	}
+	period, err := resolve_period(cfg, now)
+	if err != nil {
+		return nil, err
+	}
+
+	from, err := get_account(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	to, err := get_account(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	err = check_account_not_frozen(from)
+	if err != nil {
+		return nil, err
+	}
+	err = check_account_not_frozen(to)
+	if err != nil {
+		return nil, err
+	}
+
+	// a tip for a track goes to its artist or owner
+	var trackId string
+	if len(args) > 3 && args[3] != "" {
+		tr, err := fetch_track(stub, args[3])
+		if err != nil {
+			return nil, err
+		}
+		if tr.Artist != to.Id && tr.Owner != to.Id {
+			return nil, errors.New("Account " + to.Id + " is not the artist or owner of track " + args[3])
+		}
+		trackId = args[3]
+	}
+
+	currency := currency_or_default(cfg, from.Currency)
+	external := settles_through_chaincode(stub, cfg)
+	if !external && balance_in(cfg, from, currency) < amount {
+		return nil, errors.New("Insufficient " + currency + " balance to tip " + args[2])
+	}
+
+	tipId, err := append_id(stub, tipIndexStr, "tip", true)
+	if err != nil {
+		return nil, errors.New("Error creating new id for tip")
+	}
+	tip := Tip{Id: string(tipId), From: from.Id, To: to.Id, Amount: amount, Currency: currency, TrackId: trackId, Period: period.Id, CreatedAt: now.Format(time.RFC3339)}
+
+	if external {
+		tip.TransferRef, err = transfer_through_chaincode(stub, cfg, from.Id, to.Id, amount, currency, tip.Id)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		adjust_balance(cfg, &from, currency, -amount)
+		adjust_balance(cfg, &to, currency, amount)
+		err = put_account(stub, from)
+		if err != nil {
+			return nil, err
+		}
+		err = put_account(stub, to)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	tipBytes, _ := json.Marshal(tip)
+	err = put_state(stub, tip.Id, tipBytes)
+	if err != nil {
+		return nil, errors.New("Error putting tip " + tip.Id + " on ledger")
+	}
+	err = add_to_index(stub, account_tips_index_str(to.Id), tip.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	// the lifetime total adds up tips in every currency, so it is kept in the platform currency
+	converted, err := convert_amount(stub, amount, currency, cfg.Currency)
+	if err != nil {
+		return nil, err
+	}
+	err = add_account_tips(stub, to.Id, converted)
+	if err != nil {
+		return nil, err
+	}
+
+	err = emit_event(stub, "TipReceived", tip)
+	if err != nil {
+		return nil, err
+	}
+
+	return tipBytes, nil
+}
+
+//==============================================================================================================================
+//		Query Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) get_tips(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			1
+	//		accountId
+
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting accountId")
+	}
+
+	tipIds, err := get_index_ids(stub, account_tips_index_str(args[1]))
+	if err != nil {
+		return nil, err
+	}
+
+	tips := []Tip{}
+	for _, tipId := range tipIds {
+		tip, err := get_tip(stub, tipId)
+		if err != nil {
+			return nil, err
+		}
+		tips = append(tips, tip)
+	}
+
+	tipsBytes, _ := json.Marshal(tips)
+
+	return tipsBytes, nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"strconv"
+)
+
+//==============================================================================================================================
+//	 Token Settlement - Balances on this ledger are bookkeeping. With settlementChaincode configured a settlement moves
+//						the funds on that chaincode instead: every credit of a settlement, to the recipient and to the
+//						tax account and labels it withholds and recoups for, is a call of settlementFunction with the
+//						arguments from, to, amount, currency and the reference of the payment. The chaincode answers
+//						with the reference of its transfer, which is kept on the payment. Account balances are not
+//						touched and nothing accrues below payout thresholds, the funds are with the token chaincode.
+//						Tips, advances and pool fundings move their funds there too, the pool's holding account
+//						holding them until close_pool settles from it. Payments are settled in their own currency,
+//						converting them or a balance is up to the token chaincode.
+//
+//						The call is part of the settling transaction, so a failed transfer fails the settlement. A
+//						chaincode on another channel only answers, it cannot write, so settlementChannel has to be
+//						empty or this channel. Sandboxed transactions keep using the synthetic balances. A dry run
+//						of validate_invoke does not call the chaincode and reports an empty reference.
+//==============================================================================================================================
+
+// True when settlements of the transaction move funds on the settlement chaincode
+func settles_through_chaincode(stub shim.ChaincodeStubInterface, cfg Config) bool {
+	return cfg.SettlementChaincode != "" && current_sandbox(stub) == ""
+}
+
+// Moves an amount on the settlement chaincode, returns the reference of the transfer
+func transfer_through_chaincode(stub shim.ChaincodeStubInterface, cfg Config, from string, to string, amount int64, currency string, reference string) (string, error) {
+
+	// a payment withheld or recouped in full leaves nothing to move to the recipient
+	if amount <= 0 {
+		return "", nil
+	}
+	if cfg.SettlementChannel != "" && cfg.SettlementChannel != stub.GetChannelID() {
+		return "", errors.New("settlementChannel " + cfg.SettlementChannel + " is not this channel, its chaincodes cannot move funds in this transaction")
+	}
+	if in_dry_run(stub) {
+		return "", nil
+	}
+
+	args := [][]byte{[]byte(cfg.SettlementFunction), []byte(from), []byte(to), []byte(strconv.FormatInt(amount, 10)), []byte(currency), []byte(reference)}
+	response := stub.InvokeChaincode(cfg.SettlementChaincode, args, cfg.SettlementChannel)
+	if response.Status != shim.OK {
+		return "", errors.New("Transfer of " + strconv.FormatInt(amount, 10) + " " + currency + " from " + from + " to " + to + " failed on " + cfg.SettlementChaincode + ": " + response.Message)
+	}
+	if len(response.Payload) == 0 {
+		return "", errors.New("Transfer from " + from + " to " + to + " on " + cfg.SettlementChaincode + " returned no reference")
+	}
+
+	return string(response.Payload), nil
+}
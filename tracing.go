@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"strings"
+	"sync"
+	"time"
+)
+
+//==============================================================================================================================
+//	 Request Tracing - Any invoke can carry a client supplied correlation id as its last argument, written as
+//					   "correlationId=<id>". The id is echoed in the response envelope, put on emitted events and recorded
+//					   in an audit entry indexed by correlation id, so support can follow a reported problem end to end.
+//					   Correlation ids are also indexed per day to find the recent ones.
+//==============================================================================================================================
+type AuditEntry struct {
+	TxId				string		`json:"txId"`
+	CorrelationId		string		`json:"correlationId"`
+	Function			string		`json:"function"`
+	Caller				string		`json:"caller"`
+	Timestamp			string		`json:"timestamp"`
+}
+
+type EventEnvelope struct {
+	Name				string		`json:"name"`
+	TxId				string		`json:"txId"`
+	CorrelationId		string		`json:"correlationId,omitempty"`
+	Sandbox				bool		`json:"sandbox,omitempty"`		// emitted in a sandbox, the money involved is synthetic
+	Payload				interface{}	`json:"payload"`
+}
+
+var correlationArgPrefix = "correlationId="
+var correlationIndexStr = "correlation"
+var correlationDayIndexStr = "correlation_day"
+
+// Per-day index of the correlation ids seen that day, one key per id so traced transactions never conflict
+func correlation_day_index_str(day string) string {
+	return index_key(correlationDayIndexStr, day)
+}
+
+// Correlation ids of the transactions in flight, by tx id
+var txCorrelationIds = struct {
+	sync.Mutex
+	ids map[string]string
+}{ids: map[string]string{}}
+
+// Splits a trailing correlation id argument off the arguments of an invoke
+func extract_correlation_id(args []string) (string, []string) {
+
+	if len(args) == 0 || !strings.HasPrefix(args[len(args)-1], correlationArgPrefix) {
+		return "", args
+	}
+
+	return strings.TrimPrefix(args[len(args)-1], correlationArgPrefix), args[:len(args)-1]
+}
+
+func set_correlation_id(stub shim.ChaincodeStubInterface, correlationId string) {
+	txCorrelationIds.Lock()
+	txCorrelationIds.ids[stub.GetTxID()] = correlationId
+	txCorrelationIds.Unlock()
+}
+
+func clear_correlation_id(stub shim.ChaincodeStubInterface) {
+	txCorrelationIds.Lock()
+	delete(txCorrelationIds.ids, stub.GetTxID())
+	txCorrelationIds.Unlock()
+}
+
+// Returns the correlation id of the current transaction, empty when the client did not supply one
+func correlation_id(stub shim.ChaincodeStubInterface) string {
+	txCorrelationIds.Lock()
+	defer txCorrelationIds.Unlock()
+	return txCorrelationIds.ids[stub.GetTxID()]
+}
+
+// Events emitted by the transactions in flight, by tx id
+var txEvents = struct {
+	sync.Mutex
+	events map[string][]EventEnvelope
+}{events: map[string][]EventEnvelope{}}
+
+// Emits a chaincode event. Every payload is wrapped with the tx id and correlation id of the transaction. The event is
+// queued and only set on the transaction by flush_events once the invoke succeeded.
+func emit_event(stub shim.ChaincodeStubInterface, name string, payload interface{}) error {
+
+	var event EventEnvelope
+	event.Name			= name
+	event.TxId			= stub.GetTxID()
+	event.CorrelationId	= correlation_id(stub)
+	event.Sandbox		= current_sandbox(stub) != ""
+	event.Payload		= payload
+
+	txEvents.Lock()
+	txEvents.events[event.TxId] = append(txEvents.events[event.TxId], event)
+	txEvents.Unlock()
+
+	return nil
+}
+
+// Emits a PaymentCreated event for each of the payments
+func emit_payments_created(stub shim.ChaincodeStubInterface, payments []Payment) error {
+
+	for _, payment := range payments {
+		err := emit_event(stub, "PaymentCreated", payment)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Drops the events queued by the current transaction
+func discard_events(stub shim.ChaincodeStubInterface) {
+	txEvents.Lock()
+	delete(txEvents.events, stub.GetTxID())
+	txEvents.Unlock()
+}
+
+// Sets the events queued by the current transaction on it. A transaction carries a single event, so a single event is
+// set under its own name and several are set together as an "EventBatch" event whose payload lists them in order.
+func flush_events(stub shim.ChaincodeStubInterface) error {
+
+	txEvents.Lock()
+	events := txEvents.events[stub.GetTxID()]
+	delete(txEvents.events, stub.GetTxID())
+	txEvents.Unlock()
+
+	if len(events) == 0 {
+		return nil
+	}
+
+	event := events[0]
+	if len(events) > 1 {
+		event = EventEnvelope{Name: "EventBatch", TxId: event.TxId, CorrelationId: event.CorrelationId, Sandbox: event.Sandbox, Payload: events}
+	}
+
+	eventBytes, _ := json.Marshal(event)
+	err := stub.SetEvent(event.Name, eventBytes)
+	if err != nil {
+		return errors.New("Error emitting " + event.Name + " event")
+	}
+
+	return nil
+}
+
+// Puts the correlation id on the response, legacy routes already answer with an envelope
+func envelope_with_correlation_id(function string, result []byte, correlationId string) ([]byte, error) {
+
+	var envelope ResponseEnvelope
+	if _, legacy := legacyInvokeRoutes[function]; legacy {
+		json.Unmarshal(result, &envelope)
+	} else {
+		envelope = new_envelope(result)
+	}
+	envelope.CorrelationId = correlationId
+
+	return json.Marshal(envelope)
+}
+
+// Records an audit entry for the current transaction under its correlation id and keeps the id among the recent ones
+func (t *SimpleChaincode) record_audit_entry(stub shim.ChaincodeStubInterface, function string, correlationId string) error {
+
+	cfg, err := get_config(stub)
+	if err != nil {
+		return err
+	}
+	now, err := get_tx_time(stub, cfg)
+	if err != nil {
+		return err
+	}
+
+	var entry AuditEntry
+	entry.TxId			= stub.GetTxID()
+	entry.CorrelationId	= correlationId
+	entry.Function		= function
+	entry.Timestamp		= now.Format(time.RFC3339)
+	entry.Caller, _		= t.get_caller_username(stub)
+
+	entryBytes, _ := json.Marshal(entry)
+	err = put_state(stub, index_key(index_key(correlationIndexStr, correlationId), entry.TxId), entryBytes)
+	if err != nil {
+		return errors.New("Error storing audit entry for correlation id " + correlationId)
+	}
+
+	return add_to_index(stub, correlation_day_index_str(now.Format("2006-01-02")), correlationId)
+}
+
+//==============================================================================================================================
+//		Query Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) get_correlation_trace(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			1
+	//		correlationId
+
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting correlationId")
+	}
+
+	prefix := index_key(index_key(correlationIndexStr, args[1]), "")
+	keysIter, err := range_query_state(stub, prefix, prefix+"\xff")
+	if err != nil {
+		return nil, errors.New("Failed to range query audit entries of " + args[1])
+	}
+	defer keysIter.Close()
+
+	entries := []AuditEntry{}
+	for keysIter.HasNext() {
+		_, value, err := keysIter.Next()
+		if err != nil {
+			return nil, errors.New("Failed to iterate audit entries of " + args[1])
+		}
+		var entry AuditEntry
+		json.Unmarshal(value, &entry)
+		entries = append(entries, entry)
+	}
+
+	entriesBytes, _ := json.Marshal(entries)
+
+	return entriesBytes, nil
+}
+
+func (t *SimpleChaincode) get_recent_correlation_ids(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			1
+	//		day (YYYY-MM-DD in the platform timezone, optional - defaults to the day of the transaction)
+
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	var day string
+	if len(args) > 1 && args[1] != "" {
+		_, err = time.Parse("2006-01-02", args[1])
+		if err != nil {
+			return nil, errors.New("Invalid day " + args[1] + ", expecting YYYY-MM-DD")
+		}
+		day = args[1]
+	} else {
+		now, err := get_tx_time(stub, cfg)
+		if err != nil {
+			return nil, err
+		}
+		day = now.Format("2006-01-02")
+	}
+
+	ids, err := get_index_ids(stub, correlation_day_index_str(day))
+	if err != nil {
+		return nil, err
+	}
+
+	idsBytes, _ := json.Marshal(ids)
+
+	return idsBytes, nil
+}
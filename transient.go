@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//==============================================================================================================================
+//	 Transient Arguments - Invoke arguments are part of the proposal and end up in the block for every member of the
+//						   channel to read. An argument written as "transient:<field>" is taken from that field of the
+//						   proposal's transient data instead, which is not kept in the block, so sensitive payloads
+//						   can be passed to any invoke without showing up in its arguments. The handler sees the value
+//						   as if it had been passed as the argument.
+//
+//						   That keeps the value out of the proposal, not off the channel. Whatever the handler writes to
+//						   the public state is in the block's write set, and most handlers write their arguments. Only
+//						   the deal terms stay off the channel, and only with privateCollection configured: the
+//						   beneficiaries of the track payload of register_track, create_track and update_track, the
+//						   labelShare of the account payload of add_account and the fee of request_license. An idempotency
+//						   record keeps the transient references rather than the values in its hash of the arguments,
+//						   and keeps the result in the private collection, or not at all without one.
+//==============================================================================================================================
+var transientArgPrefix = "transient:"
+
+// Transient fields the arguments of the transactions in flight referred to, by tx id and argument index
+var txTransientArgs = struct {
+	sync.Mutex
+	fields map[string]map[int]string
+}{fields: map[string]map[int]string{}}
+
+func clear_transient_args(stub shim.ChaincodeStubInterface) {
+	txTransientArgs.Lock()
+	delete(txTransientArgs.fields, stub.GetTxID())
+	txTransientArgs.Unlock()
+}
+
+// True when an argument of the transaction was taken from the transient data
+func uses_transient_args(stub shim.ChaincodeStubInterface) bool {
+	txTransientArgs.Lock()
+	defer txTransientArgs.Unlock()
+	return len(txTransientArgs.fields[stub.GetTxID()]) > 0
+}
+
+// Returns the arguments with the values taken from the transient data put back as the references to them
+func unresolved_transient_args(stub shim.ChaincodeStubInterface, args []string) []string {
+
+	txTransientArgs.Lock()
+	fields := txTransientArgs.fields[stub.GetTxID()]
+	txTransientArgs.Unlock()
+
+	unresolved := append([]string{}, args...)
+	for i, field := range fields {
+		if i < len(unresolved) {
+			unresolved[i] = transientArgPrefix + field
+		}
+	}
+
+	return unresolved
+}
+
+// Reads a field of the transient data of the proposal, reports whether it was there
+func transient_value(stub shim.ChaincodeStubInterface, field string) ([]byte, bool, error) {
+
+	transient, err := stub.GetTransient()
+	if err != nil {
+		return nil, false, errors.New("Could not read the transient data of the proposal")
+	}
+	value, ok := transient[field]
+
+	return value, ok, nil
+}
+
+// Replaces the arguments referring to transient fields with the values of those fields
+func resolve_transient_args(stub shim.ChaincodeStubInterface, function string, args []string) ([]string, error) {
+
+	resolved := append([]string{}, args...)
+	fields := map[int]string{}
+	for i, arg := range args {
+		if !strings.HasPrefix(arg, transientArgPrefix) {
+			continue
+		}
+		field := strings.TrimPrefix(arg, transientArgPrefix)
+		value, ok, err := transient_value(stub, field)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, new_error("bad_arguments", "transient.missing", map[string]string{"argument": strconv.Itoa(i), "function": function, "field": field})
+		}
+		resolved[i] = string(value)
+		fields[i] = field
+	}
+	if len(fields) > 0 {
+		txTransientArgs.Lock()
+		txTransientArgs.fields[stub.GetTxID()] = fields
+		txTransientArgs.Unlock()
+	}
+
+	return resolved, nil
+}
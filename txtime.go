@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"time"
+)
+
+//==============================================================================================================================
+//	 Transaction Time - All time based logic (period bucketing, expiry checks, scheduling) is derived from the
+//						transaction timestamp so every endorsing peer computes the same result. Never use time.Now.
+//						Periods themselves are resolved through the settlement calendar, see calendar.go
+//==============================================================================================================================
+
+// The platform timezone as a fixed offset, so no peer depends on its local tz database
+func platform_location(cfg Config) *time.Location {
+	return time.FixedZone("platform", cfg.UtcOffsetMinutes*60)
+}
+
+// Returns the transaction timestamp in the platform timezone
+func get_tx_time(stub shim.ChaincodeStubInterface, cfg Config) (time.Time, error) {
+
+	ts, err := stub.GetTxTimestamp()
+	if err != nil || ts == nil {
+		return time.Time{}, errors.New("Could not get transaction timestamp")
+	}
+
+	return time.Unix(ts.Seconds, int64(ts.Nanos)).In(platform_location(cfg)), nil
+}
+
+// True when the RFC3339 instant expiresAt lies at or before the transaction time. An empty expiresAt never expires.
+func is_expired(stub shim.ChaincodeStubInterface, cfg Config, expiresAt string) (bool, error) {
+
+	if expiresAt == "" {
+		return false, nil
+	}
+
+	expiry, err := time.Parse(time.RFC3339, expiresAt)
+	if err != nil {
+		return false, errors.New("Invalid timestamp " + expiresAt + ", expecting RFC3339")
+	}
+
+	now, err := get_tx_time(stub, cfg)
+	if err != nil {
+		return false, err
+	}
+
+	return !now.Before(expiry), nil
+}
+
+// Formats the transaction time plus d as RFC3339, for scheduling something relative to now
+func tx_time_plus(stub shim.ChaincodeStubInterface, cfg Config, d time.Duration) (string, error) {
+
+	now, err := get_tx_time(stub, cfg)
+	if err != nil {
+		return "", err
+	}
+
+	return now.Add(d).Format(time.RFC3339), nil
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"strconv"
+	"time"
+)
+
+//==============================================================================================================================
+//	 Chunked Uploads - Invoke arguments are limited to MaxPayloadBytes. A larger document, e.g. a track with a long
+//					   credits list, is sent in chunks over several transactions: begin_upload names the function the
+//					   document is for, append_upload adds the chunks in order and commit_upload calls the function with
+//					   the reassembled document. Only the invoker that began an upload can append to or commit it.
+//==============================================================================================================================
+type Upload struct {
+	Id					string		`json:"id"`
+	Owner				string		`json:"owner"`
+	Function			string		`json:"function"`
+	Chunks				int			`json:"chunks"`
+	Size				int			`json:"size"`
+	Status				string		`json:"status"`				// open or committed
+	CreatedAt			string		`json:"createdAt"`
+}
+
+var uploadIndexStr = "_uploads"
+
+// Functions taking a document, with the position of the document in their arguments
+var UploadTargets = map[string]int{
+	"create_track":				0,
+	"update_track":				1,
+	"add_account":				1,
+	"add_album":				0,
+	"add_playlist":				0,
+	"set_distributor_config":	0,
+	"import_works":				0,
+}
+
+func upload_chunk_key(uploadId string, n int) string {
+	return "_upload_" + uploadId + "_" + strconv.Itoa(n)
+}
+
+// Rejects arguments over the configured payload size, 0 is no limit
+func check_payload_sizes(cfg Config, function string, args []string) error {
+
+	if cfg.MaxPayloadBytes == 0 {
+		return nil
+	}
+	for i, arg := range args {
+		if len(arg) > cfg.MaxPayloadBytes {
+			return new_error("bad_arguments", "payload.too_large", map[string]string{
+				"argument":	strconv.Itoa(i),
+				"function":	function,
+				"size":		strconv.Itoa(len(arg)),
+				"max":		strconv.Itoa(cfg.MaxPayloadBytes),
+			})
+		}
+	}
+
+	return nil
+}
+
+func get_upload(stub shim.ChaincodeStubInterface, uploadId string) (Upload, error) {
+
+	var upload Upload
+
+	bytes, err := get_state(stub, uploadId)
+	if err != nil || len(bytes) == 0 {
+		return upload, errors.New("Could not fetch upload " + uploadId)
+	}
+	err = json.Unmarshal(bytes, &upload)
+	if err != nil {
+		return upload, errors.New("Could not unmarshal upload " + uploadId)
+	}
+
+	return upload, nil
+}
+
+func put_upload(stub shim.ChaincodeStubInterface, upload Upload) error {
+
+	uploadBytes, _ := json.Marshal(upload)
+	err := put_state(stub, upload.Id, uploadBytes)
+	if err != nil {
+		return errors.New("Error putting upload " + upload.Id + " on ledger")
+	}
+
+	return nil
+}
+
+// Fetches an open upload of the invoker
+func (t *SimpleChaincode) get_own_upload(stub shim.ChaincodeStubInterface, uploadId string) (Upload, error) {
+
+	upload, err := get_upload(stub, uploadId)
+	if err != nil {
+		return upload, err
+	}
+	caller, err := t.get_caller_username(stub)
+	if err != nil {
+		return upload, err
+	}
+	if caller != upload.Owner {
+		return upload, errors.New("Only " + upload.Owner + " can change upload " + uploadId)
+	}
+	if upload.Status != "open" {
+		return upload, errors.New("Upload " + uploadId + " is " + upload.Status)
+	}
+
+	return upload, nil
+}
+
+//==============================================================================================================================
+//  Invoke Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) begin_upload(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		function the document is for
+
+	if len(args) < 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting function")
+	}
+	if _, ok := UploadTargets[args[0]]; !ok {
+		return nil, errors.New("Function " + args[0] + " does not take an uploaded document")
+	}
+
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := get_tx_time(stub, cfg)
+	if err != nil {
+		return nil, err
+	}
+	owner, err := t.get_caller_username(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	uploadId, err := append_id(stub, uploadIndexStr, "up", true)
+	if err != nil {
+		return nil, errors.New("Error creating new id for upload")
+	}
+
+	var upload Upload
+	upload.Id			= string(uploadId)
+	upload.Owner		= owner
+	upload.Function		= args[0]
+	upload.Status		= "open"
+	upload.CreatedAt	= now.Format(time.RFC3339)
+
+	err = put_upload(stub, upload)
+	if err != nil {
+		return nil, err
+	}
+
+	return uploadId, nil
+}
+
+func (t *SimpleChaincode) append_upload(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0			1			2
+	//		uploadId	chunk index		chunk
+
+	if len(args) < 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting uploadId, chunk index and chunk")
+	}
+
+	upload, err := t.get_own_upload(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	// chunks are appended in order, a retried chunk is rejected instead of stored twice
+	n, err := strconv.Atoi(args[1])
+	if err != nil || n != upload.Chunks {
+		return nil, errors.New("Invalid chunk index " + args[1] + ", expecting " + strconv.Itoa(upload.Chunks))
+	}
+
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.MaxUploadBytes > 0 && upload.Size+len(args[2]) > cfg.MaxUploadBytes {
+		return nil, errors.New("Upload " + upload.Id + " would exceed maxUploadBytes (" + strconv.Itoa(cfg.MaxUploadBytes) + ")")
+	}
+
+	err = put_state(stub, upload_chunk_key(upload.Id, n), []byte(args[2]))
+	if err != nil {
+		return nil, errors.New("Error putting chunk of upload " + upload.Id + " on ledger")
+	}
+
+	upload.Chunks++
+	upload.Size += len(args[2])
+
+	return nil, put_upload(stub, upload)
+}
+
+func (t *SimpleChaincode) commit_upload(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0			1..
+	//		uploadId	the other arguments of the function, the document takes its place among them
+
+	if len(args) < 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting uploadId")
+	}
+
+	upload, err := t.get_own_upload(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if upload.Chunks == 0 {
+		return nil, errors.New("Upload " + upload.Id + " has no chunks")
+	}
+
+	var document []byte
+	for n := 0; n < upload.Chunks; n++ {
+		chunk, err := get_state(stub, upload_chunk_key(upload.Id, n))
+		if err != nil {
+			return nil, errors.New("Could not fetch chunk " + strconv.Itoa(n) + " of upload " + upload.Id)
+		}
+		document = append(document, chunk...)
+	}
+
+	position := UploadTargets[upload.Function]
+	otherArgs := args[1:]
+	if len(otherArgs) < position {
+		return nil, errors.New("Incorrect number of arguments. " + upload.Function + " expects the document at position " + strconv.Itoa(position))
+	}
+	functionArgs := append([]string{}, otherArgs[:position]...)
+	functionArgs = append(functionArgs, string(document))
+	functionArgs = append(functionArgs, otherArgs[position:]...)
+
+	result, err := t.invoke_function(stub, upload.Function, functionArgs)
+	if err != nil {
+		return nil, err
+	}
+
+	for n := 0; n < upload.Chunks; n++ {
+		err = del_state(stub, upload_chunk_key(upload.Id, n))
+		if err != nil {
+			return nil, errors.New("Error deleting chunk of upload " + upload.Id)
+		}
+	}
+	upload.Status = "committed"
+
+	err = put_upload(stub, upload)
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
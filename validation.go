@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"sync"
+)
+
+//==============================================================================================================================
+//	 Invoke Validation - validate_invoke runs a prospective invoke as a dry run and reports the error it would fail with,
+//						 so apps can show the precise problem before the user submits the transaction. The dry run goes
+//						 through everything a real invoke does: payload limits, the ACL, the key budget and the handler
+//						 with all its validation and balance checks. Its writes are kept like those of any transaction
+//						 but never reach the ledger, nothing is written and no event is emitted. Transfers on a
+//						 settlement chaincode are not made, its writes would not be seen.
+//
+//						 Fabric does not show a transaction its own writes, GetState answers with the committed value
+//						 until the block is committed. Every transaction therefore keeps what it wrote and reads it
+//						 back from there, a dry run and a real invoke read alike. Range queries see committed state
+//						 only.
+//==============================================================================================================================
+type InvokeValidation struct {
+	Function			string				`json:"function"`
+	Valid				bool				`json:"valid"`
+	Error				*ChaincodeError		`json:"error,omitempty"`
+}
+
+// Writes of the transactions in flight and which of them are dry runs, by tx id. A deleted key maps to nil.
+var txWrites = struct {
+	sync.Mutex
+	writes	map[string]map[string][]byte
+	dryRuns	map[string]bool
+}{writes: map[string]map[string][]byte{}, dryRuns: map[string]bool{}}
+
+func begin_dry_run(stub shim.ChaincodeStubInterface) {
+	txWrites.Lock()
+	txWrites.dryRuns[stub.GetTxID()] = true
+	txWrites.Unlock()
+}
+
+// Ends a dry run, dropping what it wrote
+func end_dry_run(stub shim.ChaincodeStubInterface) {
+	txWrites.Lock()
+	delete(txWrites.dryRuns, stub.GetTxID())
+	delete(txWrites.writes, stub.GetTxID())
+	txWrites.Unlock()
+}
+
+// Forgets the writes of the transaction once it is done
+func end_tx_writes(stub shim.ChaincodeStubInterface) {
+	txWrites.Lock()
+	delete(txWrites.writes, stub.GetTxID())
+	txWrites.Unlock()
+}
+
+// True when the transaction is a dry run
+func in_dry_run(stub shim.ChaincodeStubInterface) bool {
+
+	txWrites.Lock()
+	dryRun := txWrites.dryRuns[stub.GetTxID()]
+	txWrites.Unlock()
+
+	return dryRun
+}
+
+// Returns what the transaction wrote to a key, reports whether it wrote it
+func tx_written(stub shim.ChaincodeStubInterface, key string) ([]byte, bool) {
+
+	txWrites.Lock()
+	value, written := txWrites.writes[stub.GetTxID()][key]
+	txWrites.Unlock()
+
+	return value, written
+}
+
+// Keeps a write of the transaction, reports whether it is a dry run that must not reach the ledger
+func keep_tx_write(stub shim.ChaincodeStubInterface, key string, value []byte) bool {
+
+	txWrites.Lock()
+	defer txWrites.Unlock()
+
+	writes, ok := txWrites.writes[stub.GetTxID()]
+	if !ok {
+		writes = map[string][]byte{}
+		txWrites.writes[stub.GetTxID()] = writes
+	}
+	writes[key] = value
+
+	return txWrites.dryRuns[stub.GetTxID()]
+}
+
+// Reads a ledger key, what the transaction wrote to it if it did
+func ledger_get(stub shim.ChaincodeStubInterface, key string) ([]byte, error) {
+
+	value, written := tx_written(stub, key)
+	if written {
+		return value, nil
+	}
+
+	return stub.GetState(key)
+}
+
+// Writes a ledger key, a dry run only keeps the write
+func ledger_put(stub shim.ChaincodeStubInterface, key string, value []byte) error {
+
+	if keep_tx_write(stub, key, value) {
+		return nil
+	}
+
+	return stub.PutState(key, value)
+}
+
+// Deletes a ledger key, a dry run only keeps the deletion
+func ledger_del(stub shim.ChaincodeStubInterface, key string) error {
+
+	if keep_tx_write(stub, key, nil) {
+		return nil
+	}
+
+	return stub.DelState(key)
+}
+
+// Sets the endorsement policy of a ledger key, a dry run leaves it alone
+func ledger_set_validation(stub shim.ChaincodeStubInterface, key string, policy []byte) error {
+
+	if in_dry_run(stub) {
+		return nil
+	}
+
+	return stub.SetStateValidationParameter(key, policy)
+}
+
+// Key the writes to a private data collection are kept under, apart from the public keys
+func private_write_key(collection string, key string) string {
+	return "\x00" + collection + "\x00" + key
+}
+
+// Reads a key of a private data collection, what the transaction wrote to it if it did
+func ledger_private_get(stub shim.ChaincodeStubInterface, collection string, key string) ([]byte, error) {
+
+	value, written := tx_written(stub, private_write_key(collection, key))
+	if written {
+		return value, nil
+	}
+
+	return stub.GetPrivateData(collection, key)
+}
+
+// Writes a key of a private data collection, a dry run only keeps the write
+func ledger_private_put(stub shim.ChaincodeStubInterface, collection string, key string, value []byte) error {
+
+	if keep_tx_write(stub, private_write_key(collection, key), value) {
+		return nil
+	}
+
+	return stub.PutPrivateData(collection, key, value)
+}
+
+// Deletes a key of a private data collection, a dry run only keeps the deletion
+func ledger_private_del(stub shim.ChaincodeStubInterface, collection string, key string) error {
+
+	if keep_tx_write(stub, private_write_key(collection, key), nil) {
+		return nil
+	}
+
+	return stub.DelPrivateData(collection, key)
+}
+
+//==============================================================================================================================
+//		Query Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) validate_invoke(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			1				2
+	//		function		args of the invoke (JSON array of strings)
+
+	if len(args) < 3 {
+		return nil, errors.New("Incorrect number of arguments. Expecting function and args")
+	}
+	var invokeArgs []string
+	err := json.Unmarshal([]byte(args[2]), &invokeArgs)
+	if err != nil {
+		return nil, errors.New("Could not unmarshal args: " + err.Error())
+	}
+
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+
+	begin_dry_run(stub)
+	defer end_dry_run(stub)
+
+	err = begin_key_budget(stub)
+	if err != nil {
+		return nil, err
+	}
+	err = check_payload_sizes(cfg, args[1], invokeArgs)
+	if err == nil {
+		_, err = t.traced_invoke(stub, args[1], invokeArgs)
+	}
+	if budgetErr := end_key_budget(stub); budgetErr != nil {
+		err = budgetErr
+	}
+	clear_tx_ids(stub)
+	discard_events(stub)
+
+	validation := InvokeValidation{Function: args[1], Valid: err == nil}
+	if err != nil {
+		validation.Error = coded_error(err)
+	}
+
+	validationBytes, _ := json.Marshal(validation)
+
+	return validationBytes, nil
+}
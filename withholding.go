@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"strconv"
+	"time"
+)
+
+//==============================================================================================================================
+//	 Withholding Tax - Payouts to beneficiaries in some countries are subject to tax withheld at source. An admin sets
+//					   the withholding rate and jurisdiction of such accounts, and settlement withholds the rate of what
+//					   the beneficiary is credited: the beneficiary is credited the net amount, and a separate withholding
+//					   payment from the beneficiary to the tax account, configured as taxAccountId, carries the tax. The
+//					   settled payment records what was withheld and the net next to its gross amount.
+//==============================================================================================================================
+type TaxWithholding struct {
+	RateBps				int64		`json:"rateBps"`				// part of every payout withheld, in basis points
+	Jurisdiction		string		`json:"jurisdiction"`			// ISO 3166 code of the country the tax is withheld for
+}
+
+// The tax withheld from what the recipient of a payment is credited
+func tax_withheld(cfg Config, recipient Account, credited int64) (int64, error) {
+
+	if recipient.TaxWithholding == nil || recipient.TaxWithholding.RateBps == 0 || recipient.Id == cfg.TaxAccountId {
+		return 0, nil
+	}
+	if cfg.TaxAccountId == "" {
+		return 0, errors.New("Payouts to " + recipient.Id + " are subject to withholding tax, but no tax account is configured")
+	}
+
+	return credited * recipient.TaxWithholding.RateBps / 10000, nil
+}
+
+// Pays the tax withheld from a settled payment to the tax account with a withholding payment from the recipient,
+// recorded on both accounts. The tax account is read unless it is the sender.
+func record_withholding(stub shim.ChaincodeStubInterface, cfg Config, payment Payment, sender *Account, recipient *Account, withheld int64, currency string, now time.Time) error {
+
+	var withholding Payment
+	withholding.RecipientId		= cfg.TaxAccountId
+	withholding.SenderId		= recipient.Id
+	withholding.Amount			= withheld
+	withholding.Currency		= currency
+	withholding.Completed		= true
+	withholding.CreatedAt		= now.Format(time.RFC3339)
+	withholding.UpdatedAt		= withholding.CreatedAt
+	withholding.DueDate			= withholding.CreatedAt
+	withholding.Period			= payment.Period
+	withholding.Reference		= payment.Reference
+	withholding.Withholding		= recipient.TaxWithholding.Jurisdiction
+
+	// the tax is withheld before the funds reach the recipient, on the settlement chaincode it moves from the sender
+	external := settles_through_chaincode(stub, cfg)
+	if external {
+		ref, err := transfer_through_chaincode(stub, cfg, payment.SenderId, cfg.TaxAccountId, withheld, currency, payment.Reference)
+		if err != nil {
+			return err
+		}
+		withholding.TransferRef = ref
+	}
+
+	recipient.PendingPayments = append(recipient.PendingPayments, withholding)
+
+	if cfg.TaxAccountId == sender.Id {
+		sender.PendingPayments = append(sender.PendingPayments, withholding)
+		if !external {
+			adjust_balance(cfg, sender, currency, withheld)
+		}
+	} else {
+		taxAccount, err := get_account(stub, cfg.TaxAccountId)
+		if err != nil {
+			return err
+		}
+		taxAccount.PendingPayments = append(taxAccount.PendingPayments, withholding)
+		if !external {
+			adjust_balance(cfg, &taxAccount, currency, withheld)
+		}
+		err = put_account(stub, taxAccount)
+		if err != nil {
+			return err
+		}
+	}
+
+	return emit_event(stub, "PaymentSettled", withholding)
+}
+
+//==============================================================================================================================
+//  Invoke Functions
+//==============================================================================================================================
+
+// Sets, or with a rate of 0 clears, the withholding tax of an account
+func (t *SimpleChaincode) set_tax_withholding(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0				1				2
+	//		accountId		rate (bps)		jurisdiction (ISO 3166)
+
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting accountId, rate and jurisdiction")
+	}
+	rateBps, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil || rateBps < 0 || rateBps > 10000 {
+		return nil, errors.New("Invalid rate " + args[1] + ", expecting basis points between 0 and 10000")
+	}
+	var jurisdiction string
+	if len(args) > 2 {
+		jurisdiction = args[2]
+	}
+	if rateBps > 0 && len(jurisdiction) != 2 {
+		return nil, errors.New("Invalid jurisdiction " + jurisdiction + ", expecting a 2 letter ISO 3166 code")
+	}
+
+	err = t.check_caller_role(stub, adminRole)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	if rateBps > 0 && cfg.TaxAccountId == "" {
+		return nil, errors.New("Configure a taxAccountId before withholding tax")
+	}
+
+	account, err := get_account(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	account.TaxWithholding = nil
+	if rateBps > 0 {
+		account.TaxWithholding = &TaxWithholding{RateBps: rateBps, Jurisdiction: jurisdiction}
+	}
+
+	return nil, put_account(stub, account)
+}
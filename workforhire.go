@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"strconv"
+	"time"
+)
+
+//==============================================================================================================================
+//	 Work for Hire - A contributor paid a flat fee for their work on a track (session player, engineer, producer on a
+//					 buyout) is recorded on the track as work for hire, with the fee and the hash of the signed agreement.
+//					 The record documents the contributor has no ongoing royalty claim: the contributor cannot be put in
+//					 any split of the track, and a later claim from the contributor on the track is rejected unless it
+//					 brings evidence that is not on the record yet.
+//==============================================================================================================================
+type WorkForHire struct {
+	AccountId			string		`json:"accountId"`
+	Role				string		`json:"role"`				// what the contributor did, e.g. session guitarist
+	Fee					int64		`json:"fee"`				// the flat fee paid, in the smallest unit of Currency
+	Currency			string		`json:"currency"`
+	Evidence			[]string	`json:"evidence"`			// SHA-256 of the signed agreement and other supporting documents
+	RecordedBy			string		`json:"recordedBy"`
+	RecordedAt			string		`json:"recordedAt"`
+}
+
+// Returns the work for hire record of the contributor on the track, nil when there is none
+func work_for_hire_of(tr Track, accountId string) *WorkForHire {
+
+	for i, contributor := range tr.WorkForHire {
+		if contributor.AccountId == accountId {
+			return &tr.WorkForHire[i]
+		}
+	}
+
+	return nil
+}
+
+// A work for hire contributor cannot share in the royalties of the track
+func check_work_for_hire_splits(tr Track) error {
+
+	splits := [][]Beneficiary{tr.Beneficiaries}
+	if tr.Recording != nil {
+		splits = append(splits, tr.Recording.Beneficiaries)
+	}
+	if tr.Composition != nil {
+		splits = append(splits, tr.Composition.Beneficiaries)
+	}
+	for _, split := range splits {
+		for _, b := range split {
+			if b.AccountId != "" && work_for_hire_of(tr, b.AccountId) != nil {
+				return errors.New("Account " + b.AccountId + " contributed as work for hire and cannot be a beneficiary")
+			}
+		}
+	}
+
+	return nil
+}
+
+// Rejects a royalty claim on the track from a work for hire contributor, unless the claim brings new evidence
+func check_work_for_hire_claim(tr Track, trackId string, accountId string, evidence []string) error {
+
+	contributor := work_for_hire_of(tr, accountId)
+	if contributor == nil {
+		return nil
+	}
+
+	for _, hash := range evidence {
+		known := false
+		for _, recorded := range contributor.Evidence {
+			known = known || recorded == hash
+		}
+		if !known {
+			return nil
+		}
+	}
+
+	return errors.New("Account " + accountId + " contributed to track " + trackId + " as work for hire, a claim needs evidence that is not on the record")
+}
+
+//==============================================================================================================================
+//  Invoke Functions
+//==============================================================================================================================
+
+// The owner of the track records a contributor as work for hire
+func (t *SimpleChaincode) record_work_for_hire(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0			1			2		3					4
+	//		trackId		accountId		fee		agreement hash		role (optional)
+
+	if len(args) < 4 {
+		return nil, errors.New("Incorrect number of arguments. Expecting trackId, accountId, fee and agreement hash")
+	}
+	fee, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil || fee < 0 {
+		return nil, errors.New("Invalid fee " + args[2])
+	}
+	agreement, err := normalize_content_hash(args[3])
+	if err != nil {
+		return nil, err
+	}
+
+	tr, err := fetch_track(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	err = t.check_track_owner(stub, tr, args[0])
+	if err != nil {
+		return nil, err
+	}
+	_, err = get_account(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	if work_for_hire_of(tr, args[1]) != nil {
+		return nil, errors.New("Account " + args[1] + " is already recorded as work for hire on track " + args[0])
+	}
+
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	now, err := get_tx_time(stub, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var contributor WorkForHire
+	contributor.AccountId	= args[1]
+	contributor.Fee			= fee
+	contributor.Currency	= cfg.Currency
+	contributor.Evidence	= []string{agreement}
+	contributor.RecordedBy	= tr.Owner
+	contributor.RecordedAt	= now.Format(time.RFC3339)
+	if len(args) > 4 {
+		contributor.Role = args[4]
+	}
+
+	tr.WorkForHire = append(tr.WorkForHire, contributor)
+	err = check_work_for_hire_splits(tr)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, put_track(stub, args[0], tr)
+}
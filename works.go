@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"strconv"
+)
+
+//==============================================================================================================================
+//	 Works - Compositions registered on their own, before or apart from any recording, as a PRO would send them in a
+//			 CWR registration. Each writer's share becomes a beneficiary of the composition. A writer is linked to an
+//			 account by accountId or, for data that only knows the writer's IPI, by an alias in the "ipi" namespace.
+//			 A track with separate rights registered without a composition takes the work registered for its ISWC.
+//==============================================================================================================================
+type Writer struct {
+	Name				string		`json:"name"`
+	Ipi					string		`json:"ipi"`
+	Role				string		`json:"role"`				// CWR writer designation, e.g. CA (composer/author)
+	AccountId			string		`json:"accountId"`
+	Share				int64		`json:"share"`				// percentage of the publishing rights
+}
+
+type WorkRegistration struct {
+	Iswc				string		`json:"iswc"`
+	Title				string		`json:"title"`
+	Writers				[]Writer	`json:"writers"`
+}
+
+type WorksImport struct {
+	Sender				string				`json:"sender"`			// the PRO or publisher the registrations come from
+	Works				[]WorkRegistration	`json:"works"`
+}
+
+var workIndexStr = "work"
+var ipiAliasNamespace = "ipi"
+
+// Works are kept as "_work_<iswc>" under their normalized ISWC
+func work_key(iswc string) string {
+	return "_work_" + iswc
+}
+
+// Returns the composition registered for the ISWC, nil when there is none
+func get_work(stub shim.ChaincodeStubInterface, iswc string) (*Composition, error) {
+
+	normalized, err := normalize_iswc(iswc)
+	if err != nil {
+		return nil, err
+	}
+
+	bytes, err := get_state(stub, work_key(normalized))
+	if err != nil {
+		return nil, errors.New("Failed to get work " + iswc)
+	}
+	if len(bytes) == 0 {
+		return nil, nil
+	}
+	var work Composition
+	err = json.Unmarshal(bytes, &work)
+	if err != nil {
+		return nil, errors.New("Could not unmarshal work " + iswc)
+	}
+
+	return &work, nil
+}
+
+// Links a writer to the account that receives its share
+func writer_account(stub shim.ChaincodeStubInterface, writer Writer) (string, error) {
+
+	if writer.AccountId != "" {
+		return writer.AccountId, nil
+	}
+	if writer.Ipi == "" {
+		return "", errors.New("Writer " + writer.Name + " needs an accountId or an ipi")
+	}
+
+	alias, found, err := get_alias(stub, ipiAliasNamespace, writer.Ipi)
+	if err != nil {
+		return "", err
+	}
+	if !found {
+		return "", errors.New("No account found for writer " + writer.Name + " with ipi " + writer.Ipi)
+	}
+
+	return alias.Id, nil
+}
+
+//==============================================================================================================================
+//  Invoke Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) import_works(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			0
+	//		works import JSON object (as string)
+
+	if len(args) < 1 {
+		return nil, errors.New("Incorrect number of arguments. Expecting works import JSON")
+	}
+
+	cfg, err := get_config(stub)
+	if err != nil {
+		return nil, err
+	}
+	err = t.check_platform_access(stub, cfg, "import works")
+	if err != nil {
+		return nil, err
+	}
+
+	var batch WorksImport
+	err = json.Unmarshal([]byte(args[0]), &batch)
+	if err != nil {
+		return nil, errors.New("Could not unmarshal works import: " + err.Error())
+	}
+
+	for i, registration := range batch.Works {
+		iswc, err := normalize_iswc(registration.Iswc)
+		if err != nil {
+			return nil, wrap_error("Work "+strconv.Itoa(i)+": ", err)
+		}
+		existing, err := get_work(stub, iswc)
+		if err != nil {
+			return nil, err
+		}
+		if existing != nil {
+			return nil, errors.New("Work " + registration.Iswc + " is already registered")
+		}
+
+		var work Composition
+		work.Iswc		= registration.Iswc
+		work.Title		= registration.Title
+		work.Writers	= registration.Writers
+		for _, writer := range registration.Writers {
+			accountId, err := writer_account(stub, writer)
+			if err != nil {
+				return nil, err
+			}
+			_, err = get_account(stub, accountId)
+			if err != nil {
+				return nil, err
+			}
+			work.Beneficiaries = append(work.Beneficiaries, Beneficiary{AccountId: accountId, Percentage: writer.Share})
+		}
+		if len(registration.Writers) > 0 {
+			work.Ipi = registration.Writers[0].Ipi
+		}
+		err = validate_beneficiaries(work.Beneficiaries)
+		if err != nil {
+			return nil, wrap_error("Work "+registration.Iswc+": ", err)
+		}
+
+		workBytes, _ := json.Marshal(work)
+		err = put_state(stub, work_key(iswc), workBytes)
+		if err != nil {
+			return nil, errors.New("Error putting work " + registration.Iswc + " on ledger")
+		}
+		err = add_to_index(stub, workIndexStr, iswc)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return []byte(strconv.Itoa(len(batch.Works))), nil
+}
+
+//==============================================================================================================================
+//		Query Functions
+//==============================================================================================================================
+
+func (t *SimpleChaincode) query_work(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+
+	//Args
+	//			1
+	//		iswc
+
+	if len(args) < 2 {
+		return nil, errors.New("Incorrect number of arguments. Expecting iswc")
+	}
+
+	work, err := get_work(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	if work == nil {
+		return nil, errors.New("No work found for iswc " + args[1])
+	}
+
+	workBytes, _ := json.Marshal(work)
+
+	return workBytes, nil
+}